@@ -0,0 +1,195 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An AccessLogFormat represents the format of the access log entries.
+type AccessLogFormat int
+
+// Various values for AccessLogFormat.
+const (
+	// AccessLogFormatJSON writes one JSON object per line.
+	AccessLogFormatJSON AccessLogFormat = iota
+
+	// AccessLogFormatCLF writes the entries in the Common Log Format,
+	// followed by the user agent, the request ID, the identity,
+	// the operation and the latency in milliseconds.
+	AccessLogFormatCLF
+)
+
+// clfTimeFormat is the time format used by the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogEntry represents an entry of the access log.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Protocol  string    `json:"protocol"`
+	Identity  string    `json:"identity,omitempty"`
+	Operation string    `json:"operation,omitempty"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Latency   float64   `json:"latency"`
+	ClientIP  string    `json:"clientIP,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	RequestID string    `json:"requestID,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+}
+
+// newAccessLogEntry returns a new accessLogEntry for the given http.Request.
+func newAccessLogEntry(req *http.Request, start time.Time, end time.Time, status int, bytes int) *accessLogEntry {
+
+	return &accessLogEntry{
+		Time:      start,
+		Method:    req.Method,
+		Path:      sanitizeURL(req.URL.Path),
+		Protocol:  req.Proto,
+		Status:    status,
+		Bytes:     bytes,
+		Latency:   end.Sub(start).Seconds() * 1000,
+		UserAgent: req.UserAgent(),
+	}
+}
+
+func (e *accessLogEntry) clf() string {
+
+	return fmt.Sprintf(
+		"%s - %s [%s] %q %d %d %q %s %s %s %.3f\n",
+		clfValue(e.ClientIP),
+		clfValue(e.Subject),
+		e.Time.Format(clfTimeFormat),
+		fmt.Sprintf("%s %s %s", e.Method, e.Path, e.Protocol),
+		e.Status,
+		e.Bytes,
+		e.UserAgent,
+		clfValue(e.RequestID),
+		clfValue(e.Identity),
+		clfValue(e.Operation),
+		e.Latency,
+	)
+}
+
+func clfValue(v string) string {
+
+	if v == "" {
+		return "-"
+	}
+
+	return strings.Replace(v, " ", "_", -1)
+}
+
+// syncWriter serializes the writes to the underlying io.Writer.
+type syncWriter struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+func (w *syncWriter) Write(data []byte) (int, error) {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.w.Write(data)
+}
+
+// an accessLogger writes the access log entries
+// according to the configured rules.
+type accessLogger struct {
+	writer                io.Writer
+	format                AccessLogFormat
+	sampling              map[int]float64
+	identitySampling      map[string]float64
+	excludedIdentities    map[string]struct{}
+	excludedStatusClasses map[int]struct{}
+	random                func() float64
+}
+
+// newAccessLogger returns a new accessLogger from the
+// given config, or nil if the access log is disabled.
+func newAccessLogger(cfg config) *accessLogger {
+
+	if cfg.accessLog.writer == nil {
+		return nil
+	}
+
+	return &accessLogger{
+		writer:                cfg.accessLog.writer,
+		format:                cfg.accessLog.format,
+		sampling:              cfg.accessLog.sampling,
+		identitySampling:      cfg.accessLog.identitySampling,
+		excludedIdentities:    cfg.accessLog.excludedIdentities,
+		excludedStatusClasses: cfg.accessLog.excludedStatusClasses,
+		random:                rand.Float64,
+	}
+}
+
+// shouldLog returns true if the given entry must be logged.
+func (l *accessLogger) shouldLog(e *accessLogEntry) bool {
+
+	if _, ok := l.excludedIdentities[e.Identity]; ok && e.Identity != "" {
+		return false
+	}
+
+	class := e.Status / 100
+	if _, ok := l.excludedStatusClasses[class]; ok {
+		return false
+	}
+
+	if rate, ok := l.identitySampling[e.Identity]; ok && e.Identity != "" {
+		return l.random() < rate
+	}
+
+	rate, ok := l.sampling[class]
+	if !ok {
+		if rate, ok = l.sampling[0]; !ok {
+			return true
+		}
+	}
+
+	return l.random() < rate
+}
+
+// log writes the given entry if it must be logged.
+func (l *accessLogger) log(e *accessLogEntry) {
+
+	if l == nil || !l.shouldLog(e) {
+		return
+	}
+
+	var data []byte
+
+	switch l.format {
+
+	case AccessLogFormatCLF:
+		data = []byte(e.clf())
+
+	default:
+		var err error
+		if data, err = json.Marshal(e); err != nil {
+			return
+		}
+		data = append(data, '\n')
+	}
+
+	l.writer.Write(data) // nolint: errcheck
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+func TestAccessLog_newAccessLogger(t *testing.T) {
+
+	Convey("Given I have a config without access log", t, func() {
+
+		Convey("Then the access logger should be nil", func() {
+			So(newAccessLogger(config{}), ShouldBeNil)
+		})
+	})
+
+	Convey("Given I have a config with access log", t, func() {
+
+		cfg := config{}
+		OptAccessLog(&bytes.Buffer{}, AccessLogFormatCLF)(&cfg)
+
+		Convey("Then the access logger should be created", func() {
+			l := newAccessLogger(cfg)
+			So(l, ShouldNotBeNil)
+			So(l.format, ShouldEqual, AccessLogFormatCLF)
+		})
+	})
+}
+
+func TestAccessLog_shouldLog(t *testing.T) {
+
+	Convey("Given I have an access logger with rules", t, func() {
+
+		cfg := config{}
+		OptAccessLog(&bytes.Buffer{}, AccessLogFormatJSON)(&cfg)
+		OptAccessLogSampling(0.5)(&cfg)
+		OptAccessLogSampling(0.1, 2)(&cfg)
+		OptAccessLogIdentitySampling(map[elemental.Identity]float64{elemental.MakeIdentity("health", "healths"): 0.01})(&cfg)
+		OptAccessLogExclusions([]elemental.Identity{elemental.MakeIdentity("ping", "pings")}, 3)(&cfg)
+
+		l := newAccessLogger(cfg)
+
+		var random float64
+		l.random = func() float64 { return random }
+
+		Convey("Then excluded identities should not be logged", func() {
+			random = 0
+			So(l.shouldLog(&accessLogEntry{Identity: "ping", Status: 500}), ShouldBeFalse)
+			So(l.shouldLog(&accessLogEntry{Identity: "list", Status: 500}), ShouldBeTrue)
+		})
+
+		Convey("Then excluded status classes should not be logged", func() {
+			random = 0
+			So(l.shouldLog(&accessLogEntry{Status: 302}), ShouldBeFalse)
+		})
+
+		Convey("Then the sampling of the status class should be used", func() {
+			random = 0.2
+			So(l.shouldLog(&accessLogEntry{Status: 200}), ShouldBeFalse)
+			random = 0.05
+			So(l.shouldLog(&accessLogEntry{Status: 200}), ShouldBeTrue)
+		})
+
+		Convey("Then the default sampling should be used for other classes", func() {
+			random = 0.4
+			So(l.shouldLog(&accessLogEntry{Status: 404}), ShouldBeTrue)
+			random = 0.6
+			So(l.shouldLog(&accessLogEntry{Status: 404}), ShouldBeFalse)
+		})
+
+		Convey("Then the sampling of the identity should take precedence", func() {
+			random = 0.05
+			So(l.shouldLog(&accessLogEntry{Identity: "health", Status: 200}), ShouldBeFalse)
+			So(l.shouldLog(&accessLogEntry{Identity: "health", Status: 404}), ShouldBeFalse)
+			random = 0.005
+			So(l.shouldLog(&accessLogEntry{Identity: "health", Status: 200}), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given I have an access logger without rules", t, func() {
+
+		cfg := config{}
+		OptAccessLog(&bytes.Buffer{}, AccessLogFormatJSON)(&cfg)
+		l := newAccessLogger(cfg)
+
+		Convey("Then everything should be logged", func() {
+			So(l.shouldLog(&accessLogEntry{Status: 200}), ShouldBeTrue)
+			So(l.shouldLog(&accessLogEntry{Status: 500}), ShouldBeTrue)
+		})
+	})
+}
+
+func TestAccessLog_log(t *testing.T) {
+
+	u, _ := url.Parse("http://localhost/v/1/lists/xxx?q=1")
+	req := &http.Request{Method: http.MethodGet, URL: u, Proto: "HTTP/1.1", Header: http.Header{"User-Agent": []string{"test agent"}}}
+	start := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	makeEntry := func() *accessLogEntry {
+		e := newAccessLogEntry(req, start, start.Add(1500*time.Microsecond), 200, 42)
+		e.Identity = "list"
+		e.Operation = "retrieve"
+		e.ClientIP = "10.0.0.1"
+		e.RequestID = "rid"
+		e.Subject = "bob"
+		return e
+	}
+
+	Convey("Given I have a JSON access logger", t, func() {
+
+		buf := &bytes.Buffer{}
+		cfg := config{}
+		OptAccessLog(buf, AccessLogFormatJSON)(&cfg)
+		l := newAccessLogger(cfg)
+
+		Convey("When I log an entry", func() {
+
+			l.log(makeEntry())
+
+			Convey("Then it should be written as JSON", func() {
+
+				So(strings.HasSuffix(buf.String(), "\n"), ShouldBeTrue)
+
+				e := map[string]interface{}{}
+				So(json.Unmarshal(buf.Bytes(), &e), ShouldBeNil)
+				So(e["method"], ShouldEqual, "GET")
+				So(e["path"], ShouldEqual, "/lists/:id")
+				So(e["identity"], ShouldEqual, "list")
+				So(e["operation"], ShouldEqual, "retrieve")
+				So(e["status"], ShouldEqual, 200)
+				So(e["bytes"], ShouldEqual, 42)
+				So(e["clientIP"], ShouldEqual, "10.0.0.1")
+				So(e["userAgent"], ShouldEqual, "test agent")
+				So(e["requestID"], ShouldEqual, "rid")
+				So(e["subject"], ShouldEqual, "bob")
+			})
+		})
+	})
+
+	Convey("Given I have a CLF access logger", t, func() {
+
+		buf := &bytes.Buffer{}
+		cfg := config{}
+		OptAccessLog(buf, AccessLogFormatCLF)(&cfg)
+		l := newAccessLogger(cfg)
+
+		Convey("When I log an entry", func() {
+
+			e := makeEntry()
+			e.Latency = 1.5
+			l.log(e)
+
+			Convey("Then it should be written in the common log format", func() {
+				So(buf.String(), ShouldEqual, `10.0.0.1 - bob [02/Jan/2019:03:04:05 +0000] "GET /lists/:id HTTP/1.1" 200 42 "test agent" rid list retrieve 1.500`+"\n")
+			})
+		})
+	})
+
+	Convey("Given I have a nil access logger", t, func() {
+
+		var l *accessLogger
+
+		Convey("Then log should not panic", func() {
+			So(func() { l.log(makeEntry()) }, ShouldNotPanic)
+		})
+	})
+}
+
+func TestAccessLog_restServer(t *testing.T) {
+
+	Convey("Given I have a rest server with an access log", t, func() {
+
+		buf := &bytes.Buffer{}
+		cfg := config{}
+		OptAccessLog(buf, AccessLogFormatJSON)(&cfg)
+		s := newRestServer(cfg, nil, nil, nil)
+
+		request := elemental.NewRequest()
+		request.Identity = elemental.MakeIdentity("list", "lists")
+		request.RequestID = "rid"
+		ctx := newContext(context.Background(), request)
+		ctx.SetClaims([]string{"@auth:subject=bob"})
+
+		u, _ := url.Parse("http://localhost/lists")
+		req := &http.Request{Method: http.MethodPost, URL: u, Header: http.Header{}}
+
+		Convey("When I complete a request", func() {
+
+			s.completeRequest(req, ctx, time.Now(), 403, nil)
+
+			Convey("Then the entry should be written", func() {
+				e := map[string]interface{}{}
+				So(json.Unmarshal(buf.Bytes(), &e), ShouldBeNil)
+				So(e["identity"], ShouldEqual, "list")
+				So(e["status"], ShouldEqual, 403)
+				So(e["requestID"], ShouldEqual, "rid")
+				So(e["subject"], ShouldEqual, "bob")
+			})
+		})
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"go.aporeto.io/elemental"
+)
+
+// An AttributeChange represents the modification of one attribute
+// of an object. The values of secret and redacted attributes are never set.
+type AttributeChange struct {
+	Attribute string      `msgpack:"attribute" json:"attribute"`
+	Previous  interface{} `msgpack:"previous,omitempty" json:"previous,omitempty"`
+	Current   interface{} `msgpack:"current,omitempty" json:"current,omitempty"`
+	Secret    bool        `msgpack:"secret,omitempty" json:"secret,omitempty"`
+	Redacted  bool        `msgpack:"redacted,omitempty" json:"redacted,omitempty"`
+}
+
+// AttributeChanges is a list of AttributeChange.
+type AttributeChanges []AttributeChange
+
+type attributeChangesContextKey struct{}
+
+// contextWithAttributeChanges returns a copy of the given context holding
+// the given changes, or the given context if there are no changes.
+func contextWithAttributeChanges(ctx context.Context, changes AttributeChanges) context.Context {
+
+	if len(changes) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, attributeChangesContextKey{}, changes)
+}
+
+// attributeChangesFromContext returns the
+// changes stored in the given context, if any.
+func attributeChangesFromContext(ctx context.Context) AttributeChanges {
+
+	if ctx == nil {
+		return nil
+	}
+
+	changes, _ := ctx.Value(attributeChangesContextKey{}).(AttributeChanges)
+
+	return changes
+}
+
+// computeAttributeChanges returns the attributes that differ between
+// the given previous and current objects, using the attribute
+// specifications of the current one. It returns nil if any of them
+// is not an elemental.AttributeSpecifiable.
+func computeAttributeChanges(previous interface{}, current interface{}) AttributeChanges {
+
+	prev, ok := previous.(elemental.AttributeSpecifiable)
+	if !ok {
+		return nil
+	}
+
+	curr, ok := current.(elemental.AttributeSpecifiable)
+	if !ok {
+		return nil
+	}
+
+	specs := curr.AttributeSpecifications()
+
+	sensitive := map[string]struct{}{}
+	if specifier, ok := current.(SensitiveAttributesSpecifier); ok {
+		for _, name := range specifier.SensitiveAttributes() {
+			sensitive[name] = struct{}{}
+		}
+	}
+
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changes AttributeChanges
+
+	for _, name := range names {
+
+		pv := prev.ValueForAttribute(name)
+		cv := curr.ValueForAttribute(name)
+
+		if reflect.DeepEqual(pv, cv) {
+			continue
+		}
+
+		change := AttributeChange{Attribute: name}
+
+		_, isSensitive := sensitive[specs[name].Name]
+
+		if specs[name].Secret {
+			change.Secret = true
+		} else if isSensitive {
+			change.Redacted = true
+		} else {
+			change.Previous = pv
+			change.Current = cv
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
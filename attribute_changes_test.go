@@ -0,0 +1,129 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+type specifiableObject struct {
+	values map[string]interface{}
+}
+
+func (o *specifiableObject) SpecificationForAttribute(name string) elemental.AttributeSpecification {
+	return o.AttributeSpecifications()[name]
+}
+
+func (o *specifiableObject) AttributeSpecifications() map[string]elemental.AttributeSpecification {
+	return map[string]elemental.AttributeSpecification{
+		"name":     {Name: "name"},
+		"tags":     {Name: "tags"},
+		"password": {Name: "password", Secret: true},
+		"size":     {Name: "size"},
+	}
+}
+
+func (o *specifiableObject) ValueForAttribute(name string) interface{} {
+	return o.values[name]
+}
+
+func TestAttributeChanges_computeAttributeChanges(t *testing.T) {
+
+	Convey("Given I have a previous and a current object", t, func() {
+
+		previous := &specifiableObject{values: map[string]interface{}{
+			"name":     "a",
+			"tags":     []string{"x"},
+			"password": "old",
+			"size":     1,
+		}}
+
+		current := &specifiableObject{values: map[string]interface{}{
+			"name":     "b",
+			"tags":     []string{"x"},
+			"password": "new",
+			"size":     1,
+		}}
+
+		Convey("When I compute the changes", func() {
+
+			changes := computeAttributeChanges(previous, current)
+
+			Convey("Then only the modified attributes should be returned, with secrets masked", func() {
+				So(changes, ShouldResemble, AttributeChanges{
+					{Attribute: "name", Previous: "a", Current: "b"},
+					{Attribute: "password", Secret: true},
+				})
+			})
+		})
+
+		Convey("When I compute the changes with identical objects", func() {
+
+			changes := computeAttributeChanges(previous, previous)
+
+			Convey("Then there should be no change", func() {
+				So(changes, ShouldBeNil)
+			})
+		})
+
+		Convey("When I compute the changes with a sensitive attributes specifier", func() {
+
+			changes := computeAttributeChanges(
+				&sensitiveObject{*previous},
+				&sensitiveObject{*current},
+			)
+
+			Convey("Then the sensitive attributes should be redacted", func() {
+				So(changes, ShouldResemble, AttributeChanges{
+					{Attribute: "name", Redacted: true},
+					{Attribute: "password", Secret: true},
+				})
+			})
+		})
+
+		Convey("When I compute the changes with objects that are not specifiable", func() {
+
+			Convey("Then there should be no change", func() {
+				So(computeAttributeChanges("a", current), ShouldBeNil)
+				So(computeAttributeChanges(previous, "b"), ShouldBeNil)
+				So(computeAttributeChanges(previous, nil), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestAttributeChanges_context(t *testing.T) {
+
+	Convey("Given I have a context without changes", t, func() {
+
+		ctx := contextWithAttributeChanges(context.Background(), nil)
+
+		Convey("Then the context should not be modified", func() {
+			So(ctx, ShouldEqual, context.Background())
+			So(attributeChangesFromContext(ctx), ShouldBeNil)
+		})
+	})
+
+	Convey("Given I have a context with changes", t, func() {
+
+		changes := AttributeChanges{{Attribute: "name", Previous: "a", Current: "b"}}
+		ctx := contextWithAttributeChanges(context.Background(), changes)
+
+		Convey("Then I should retrieve them", func() {
+			So(attributeChangesFromContext(ctx), ShouldResemble, changes)
+		})
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+)
+
+type mockRecorder struct {
+	records []*bahamut.AuditRecord
+	block   chan struct{}
+
+	sync.Mutex
+}
+
+func (r *mockRecorder) Record(record *bahamut.AuditRecord) {
+
+	if r.block != nil {
+		<-r.block
+	}
+
+	r.Lock()
+	r.records = append(r.records, record)
+	r.Unlock()
+}
+
+func (r *mockRecorder) count() int {
+
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.records)
+}
+
+func readLines(path string) []bahamut.AuditRecord {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close() // nolint: errcheck
+
+	var out []bahamut.AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r bahamut.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			panic(err)
+		}
+		out = append(out, r)
+	}
+
+	return out
+}
+
+func TestFileRecorder(t *testing.T) {
+
+	Convey("Given I have a file recorder", t, func() {
+
+		dir, _ := ioutil.TempDir("", "audit")
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		path := filepath.Join(dir, "audit.log")
+
+		r, err := NewFileRecorder(path, 200, 2)
+		So(err, ShouldBeNil)
+		defer r.Close() // nolint: errcheck
+
+		Convey("When I record a few records", func() {
+
+			r.Record(&bahamut.AuditRecord{RequestID: "1", StatusCode: 200})
+			r.Record(&bahamut.AuditRecord{RequestID: "2", StatusCode: 403})
+
+			Convey("Then they should be written as JSON lines", func() {
+				lines := readLines(path)
+				So(len(lines), ShouldEqual, 2)
+				So(lines[0].RequestID, ShouldEqual, "1")
+				So(lines[1].RequestID, ShouldEqual, "2")
+				So(lines[1].StatusCode, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When I record more records than the max size", func() {
+
+			for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+				r.Record(&bahamut.AuditRecord{RequestID: id, Time: time.Unix(0, 0).UTC()})
+			}
+
+			Convey("Then the files should be rotated", func() {
+
+				current := readLines(path)
+				backup1 := readLines(path + ".1")
+				backup2 := readLines(path + ".2")
+
+				So(len(current), ShouldBeGreaterThan, 0)
+				So(len(backup1), ShouldBeGreaterThan, 0)
+				So(len(backup2), ShouldBeGreaterThan, 0)
+				So(current[len(current)-1].RequestID, ShouldEqual, "8")
+				So(backup1[len(backup1)-1].RequestID, ShouldBeLessThan, current[0].RequestID)
+
+				_, err := os.Stat(path + ".3")
+				So(os.IsNotExist(err), ShouldBeTrue)
+
+				info, _ := os.Stat(path)
+				So(info.Size(), ShouldBeLessThanOrEqualTo, 200)
+			})
+		})
+
+		Convey("When I close it and record", func() {
+
+			So(r.Close(), ShouldBeNil)
+			r.Record(&bahamut.AuditRecord{RequestID: "1"})
+
+			Convey("Then nothing should be written", func() {
+				So(len(readLines(path)), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given I create a file recorder in a missing directory", t, func() {
+
+		r, err := NewFileRecorder("/not/a/dir/audit.log", 0, 0)
+
+		Convey("Then it should fail", func() {
+			So(r, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPubSubRecorder(t *testing.T) {
+
+	Convey("Given I have a pubsub recorder", t, func() {
+
+		client := bahamut.NewLocalPubSubClient()
+		client.Connect().Wait(time.Second)
+		defer client.Disconnect() // nolint: errcheck
+
+		pubs := make(chan *bahamut.Publication, 1)
+		errs := make(chan error, 1)
+		unsubscribe := client.Subscribe(pubs, errs, "audit")
+		defer unsubscribe()
+
+		r := NewPubSubRecorder(client, "audit", elemental.EncodingTypeJSON)
+
+		Convey("When I record a record", func() {
+
+			r.Record(&bahamut.AuditRecord{RequestID: "1"})
+
+			Convey("Then it should be published", func() {
+				select {
+				case p := <-pubs:
+					So(p.Topic, ShouldEqual, "audit")
+				case <-time.After(time.Second):
+					So("no publication received", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+}
+
+func TestQueue(t *testing.T) {
+
+	Convey("Given I have a queue", t, func() {
+
+		recorder := &mockRecorder{}
+		q := NewQueue(recorder, 10)
+
+		Convey("When I record some records and close it", func() {
+
+			for i := 0; i < 5; i++ {
+				q.Record(&bahamut.AuditRecord{})
+			}
+			q.Close()
+
+			Convey("Then all the records should be sent", func() {
+				So(recorder.count(), ShouldEqual, 5)
+				So(q.Dropped(), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given I have a full queue", t, func() {
+
+		recorder := &mockRecorder{block: make(chan struct{})}
+		q := NewQueue(recorder, 2)
+
+		Convey("When I record more records than it can hold", func() {
+
+			for i := 0; i < 10; i++ {
+				q.Record(&bahamut.AuditRecord{})
+			}
+			dropped := q.Dropped()
+
+			close(recorder.block)
+			q.Close()
+
+			Convey("Then the extra records should be dropped", func() {
+				So(dropped, ShouldBeBetweenOrEqual, 7, 8)
+				So(uint64(recorder.count())+dropped, ShouldEqual, 10)
+			})
+		})
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.aporeto.io/bahamut"
+	"go.uber.org/zap"
+)
+
+// A FileRecorder is a bahamut.AuditRecorder writing the records
+// as JSON lines in a file. When the file reaches its maximum size,
+// it is renamed to path.1, the previous path.1 is renamed to path.2
+// and so on, keeping at most the configured number of backups.
+type FileRecorder struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+	lock sync.Mutex
+}
+
+// NewFileRecorder returns a new *FileRecorder writing in the file at the
+// given path. If maxSize is 0, the file is never rotated.
+func NewFileRecorder(path string, maxSize int64, maxBackups int) (*FileRecorder, error) {
+
+	r := &FileRecorder{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Record implements bahamut.AuditRecorder.
+func (r *FileRecorder) Record(record *bahamut.AuditRecord) {
+
+	if err := r.write(record); err != nil {
+		zap.L().Error("Unable to write audit record", zap.String("path", r.path), zap.Error(err))
+	}
+}
+
+// Close closes the underlying file.
+func (r *FileRecorder) Close() error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+func (r *FileRecorder) write(record *bahamut.AuditRecord) error {
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit record: %s", err)
+	}
+	data = append(data, '\n')
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return fmt.Errorf("recorder is closed")
+	}
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(data)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+
+	return err
+}
+
+func (r *FileRecorder) open() error {
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open audit file: %s", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close() // nolint: errcheck
+		return fmt.Errorf("unable to stat audit file: %s", err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+
+	return nil
+}
+
+func (r *FileRecorder) rotate() error {
+
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("unable to close audit file: %s", err)
+	}
+	r.file = nil
+
+	if r.maxBackups <= 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove audit file: %s", err)
+		}
+		return r.open()
+	}
+
+	for i := r.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(r.backupPath(i), r.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to rotate audit file: %s", err)
+		}
+	}
+
+	if err := os.Rename(r.path, r.backupPath(1)); err != nil {
+		return fmt.Errorf("unable to rotate audit file: %s", err)
+	}
+
+	return r.open()
+}
+
+func (r *FileRecorder) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// A PubSubRecorder is a bahamut.AuditRecorder publishing
+// the records in a topic of a bahamut.PubSubClient.
+type PubSubRecorder struct {
+	client   bahamut.PubSubClient
+	topic    string
+	encoding elemental.EncodingType
+}
+
+// NewPubSubRecorder returns a new *PubSubRecorder publishing the
+// records in the given topic using the given encoding.
+func NewPubSubRecorder(client bahamut.PubSubClient, topic string, encoding elemental.EncodingType) *PubSubRecorder {
+
+	return &PubSubRecorder{
+		client:   client,
+		topic:    topic,
+		encoding: encoding,
+	}
+}
+
+// Record implements bahamut.AuditRecorder.
+func (r *PubSubRecorder) Record(record *bahamut.AuditRecord) {
+
+	publication := bahamut.NewPublication(r.topic)
+
+	if err := publication.EncodeWithEncoding(record, r.encoding); err != nil {
+		zap.L().Error("Unable to encode audit record", zap.Error(err))
+		return
+	}
+
+	if err := r.client.Publish(publication); err != nil {
+		zap.L().Error("Unable to publish audit record", zap.String("topic", r.topic), zap.Error(err))
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.aporeto.io/bahamut"
+)
+
+// A Queue is a bahamut.AuditRecorder sending the records to another
+// bahamut.AuditRecorder from a background goroutine. When the queue is
+// full, the records are dropped and counted.
+type Queue struct {
+	recorder bahamut.AuditRecorder
+	records  chan *bahamut.AuditRecord
+	dropped  uint64
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewQueue returns a new *Queue holding at most
+// size records to send to the given recorder.
+func NewQueue(recorder bahamut.AuditRecorder, size int) *Queue {
+
+	q := &Queue{
+		recorder: recorder,
+		records:  make(chan *bahamut.AuditRecord, size),
+		done:     make(chan struct{}),
+	}
+
+	go q.run()
+
+	return q
+}
+
+// Record implements bahamut.AuditRecorder. It never blocks.
+func (q *Queue) Record(record *bahamut.AuditRecord) {
+
+	select {
+	case q.records <- record:
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped
+// because the queue was full.
+func (q *Queue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// Close stops the queue once all the pending records are sent.
+// Record must not be called after Close.
+func (q *Queue) Close() {
+
+	q.once.Do(func() { close(q.records) })
+
+	<-q.done
+}
+
+func (q *Queue) run() {
+
+	defer close(q.done)
+
+	for record := range q.records {
+		q.recorder.Record(record)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"time"
+)
+
+// An AuditRecord contains the information about a request
+// once it has been fully processed.
+type AuditRecord struct {
+	RequestID      string           `json:"requestID"`
+	Time           time.Time        `json:"time"`
+	ClientIP       string           `json:"clientIP,omitempty"`
+	Claims         []string         `json:"claims,omitempty"`
+	Namespace      string           `json:"namespace,omitempty"`
+	Identity       string           `json:"identity,omitempty"`
+	Operation      string           `json:"operation,omitempty"`
+	ObjectID       string           `json:"objectID,omitempty"`
+	ParentIdentity string           `json:"parentIdentity,omitempty"`
+	ParentID       string           `json:"parentID,omitempty"`
+	StatusCode     int              `json:"statusCode"`
+	Latency        time.Duration    `json:"latency"`
+	ResponseSize   int              `json:"responseSize"`
+	AuthDecisions  []string         `json:"authDecisions,omitempty"`
+	Changes        AttributeChanges `json:"changes,omitempty"`
+	Error          string           `json:"error,omitempty"`
+}
+
+// An AuditRecorder is the interface an object must implement
+// in order to receive the AuditRecord of every request handled
+// by the api server.
+//
+// Record is called synchronously once the response has been
+// sent to the client, so it must not block. Slow recorders
+// should be wrapped in an audit.Queue.
+type AuditRecorder interface {
+	Record(*AuditRecord)
+}
+
+// newAuditRecord returns a new *AuditRecord from the given
+// context, time of the response, status code and response size.
+func newAuditRecord(ctx *bcontext, start time.Time, end time.Time, code int, size int) *AuditRecord {
+
+	record := &AuditRecord{
+		RequestID:    ctx.id,
+		Time:         start,
+		Claims:       ctx.claims,
+		StatusCode:   code,
+		Latency:      end.Sub(start),
+		ResponseSize: size,
+	}
+
+	if req := ctx.request; req != nil {
+
+		if req.RequestID != "" {
+			record.RequestID = req.RequestID
+		}
+
+		record.ClientIP = req.ClientIP
+		record.Namespace = req.Namespace
+		record.Identity = req.Identity.Name
+		record.Operation = string(req.Operation)
+		record.ObjectID = req.ObjectID
+		record.ParentIdentity = req.ParentIdentity.Name
+		record.ParentID = req.ParentID
+	}
+
+	if len(ctx.authDecisions) > 0 {
+		record.AuthDecisions = make([]string, len(ctx.authDecisions))
+		for i, d := range ctx.authDecisions {
+			record.AuthDecisions[i] = d.String()
+		}
+	}
+
+	record.Changes = ctx.attributeChanges
+
+	if ctx.err != nil {
+		record.Error = ctx.err.Error()
+	}
+
+	return record
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+func TestAuditRecord_newAuditRecord(t *testing.T) {
+
+	Convey("Given I have a context", t, func() {
+
+		req := elemental.NewRequest()
+		req.RequestID = "rid"
+		req.ClientIP = "10.0.0.1"
+		req.Namespace = "/a"
+		req.Identity = elemental.MakeIdentity("list", "lists")
+		req.Operation = elemental.OperationRetrieve
+		req.ObjectID = "xyz"
+		req.ParentIdentity = elemental.MakeIdentity("root", "root")
+
+		ctx := newContext(context.Background(), req)
+		ctx.SetClaims([]string{"a=b"})
+		ctx.recordAuthDecision(AuthDecision{Stage: AuthStageAuthorization, Name: "rbac", Action: AuthActionKO})
+		ctx.err = errors.New("boom")
+		ctx.attributeChanges = AttributeChanges{{Attribute: "name", Previous: "a", Current: "b"}}
+
+		start := time.Now().Add(-time.Second)
+
+		Convey("When I call newAuditRecord", func() {
+
+			r := newAuditRecord(ctx, start, time.Now(), 403, 42)
+
+			Convey("Then the record should be correct", func() {
+				So(r.RequestID, ShouldEqual, "rid")
+				So(r.Time, ShouldEqual, start)
+				So(r.ClientIP, ShouldEqual, "10.0.0.1")
+				So(r.Claims, ShouldResemble, []string{"a=b"})
+				So(r.Namespace, ShouldEqual, "/a")
+				So(r.Identity, ShouldEqual, "list")
+				So(r.Operation, ShouldEqual, string(elemental.OperationRetrieve))
+				So(r.ObjectID, ShouldEqual, "xyz")
+				So(r.ParentIdentity, ShouldEqual, "root")
+				So(r.StatusCode, ShouldEqual, 403)
+				So(r.Latency, ShouldBeGreaterThanOrEqualTo, time.Second)
+				So(r.ResponseSize, ShouldEqual, 42)
+				So(r.AuthDecisions, ShouldResemble, []string{"authorization:rbac=ko"})
+				So(r.Changes, ShouldResemble, AttributeChanges{{Attribute: "name", Previous: "a", Current: "b"}})
+				So(r.Error, ShouldEqual, "boom")
+			})
+		})
+
+		Convey("When the request has no request ID", func() {
+
+			req.RequestID = ""
+			r := newAuditRecord(ctx, start, time.Now(), 200, 0)
+
+			Convey("Then the context identifier should be used", func() {
+				So(r.RequestID, ShouldEqual, ctx.Identifier())
+			})
+		})
+	})
+}
+
+func TestAuditRecord_recordAudit(t *testing.T) {
+
+	Convey("Given I have a rest server with an audit recorder", t, func() {
+
+		recorder := &mockAuditRecorder{}
+
+		cfg := config{}
+		cfg.security.auditRecorder = recorder
+		s := newRestServer(cfg, nil, nil, nil)
+
+		ctx := newContext(context.Background(), elemental.NewRequest())
+		response := elemental.NewResponse(elemental.NewRequest())
+		response.Data = []byte("hello")
+
+		Convey("When I call recordAudit", func() {
+
+			s.recordAudit(ctx, time.Now(), time.Now(), 200, response)
+
+			Convey("Then the record should be sent", func() {
+				So(len(recorder.records), ShouldEqual, 1)
+				So(recorder.records[0].StatusCode, ShouldEqual, 200)
+				So(recorder.records[0].ResponseSize, ShouldEqual, 5)
+			})
+		})
+
+		Convey("When I call recordAudit with no response", func() {
+
+			s.recordAudit(ctx, time.Now(), time.Now(), 0, nil)
+
+			Convey("Then the record should be sent", func() {
+				So(len(recorder.records), ShouldEqual, 1)
+				So(recorder.records[0].ResponseSize, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestAuditRecord_completeRequestWithPendingDispatch(t *testing.T) {
+
+	Convey("Given I have a rest server with an audit recorder and a context still being dispatched", t, func() {
+
+		recorder := &mockAuditRecorder{}
+
+		cfg := config{}
+		cfg.security.auditRecorder = recorder
+		s := newRestServer(cfg, nil, nil, nil)
+
+		ctx := newContext(context.Background(), elemental.NewRequest())
+		ctx.dispatched = make(chan struct{})
+
+		u, _ := url.Parse("http://localhost/lists")
+		req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}}
+
+		Convey("When I complete the request", func() {
+
+			s.completeRequest(req, ctx, time.Now(), 408, nil)
+
+			Convey("Then the record should not be sent", func() {
+				recorder.Lock()
+				defer recorder.Unlock()
+				So(len(recorder.records), ShouldEqual, 0)
+			})
+
+			Convey("When the dispatcher returns", func() {
+
+				ctx.SetClaims([]string{"a=b"})
+				close(ctx.dispatched)
+				time.Sleep(30 * time.Millisecond)
+
+				recorder.Lock()
+				defer recorder.Unlock()
+
+				Convey("Then the record should be sent with the final state of the context", func() {
+					So(len(recorder.records), ShouldEqual, 1)
+					So(recorder.records[0].StatusCode, ShouldEqual, 408)
+					So(recorder.records[0].Claims, ShouldResemble, []string{"a=b"})
+				})
+			})
+		})
+	})
+}
+
+func TestHandlers_makeContextErrorResponse(t *testing.T) {
+
+	Convey("Given I have a context", t, func() {
+
+		ctx := newContext(context.Background(), elemental.NewRequest())
+		err := elemental.NewError("Locked", "locked", "bahamut", 423)
+
+		Convey("When I call makeContextErrorResponse", func() {
+
+			makeContextErrorResponse(ctx, elemental.NewResponse(elemental.NewRequest()), err)
+
+			Convey("Then the error should be kept in the context", func() {
+				So(ctx.err, ShouldResemble, err)
+			})
+		})
+	})
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// AuthDecisionsHeader is the name of the response header holding
+// the auth decisions when enabled by OptAuthDecisionsHeader.
+const AuthDecisionsHeader = "X-Bahamut-Auth-Decisions"
+
+// String returns the string representation of the AuthAction.
+func (a AuthAction) String() string {
+
+	switch a {
+	case AuthActionOK:
+		return "ok"
+	case AuthActionKO:
+		return "ko"
+	case AuthActionContinue:
+		return "continue"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(a))
+	}
+}
+
+// An AuthStage represents the stage of an AuthDecision.
+type AuthStage string
+
+// Various values for AuthStage.
+const (
+	AuthStageAuthentication AuthStage = "authentication"
+	AuthStageAuthorization  AuthStage = "authorization"
+)
+
+// An AuthNamer can be implemented by a RequestAuthenticator
+// or an Authorizer to set its name in the AuthDecisions.
+// Otherwise, the name of its type is used.
+type AuthNamer interface {
+	AuthName() string
+}
+
+// An AuthDecision represents the decision made by one
+// of the RequestAuthenticators or Authorizers.
+type AuthDecision struct {
+	Stage  AuthStage
+	Name   string
+	Action AuthAction
+	Error  error
+}
+
+// String returns the string representation of the AuthDecision.
+func (d AuthDecision) String() string {
+
+	if d.Error != nil {
+		return fmt.Sprintf("%s:%s=error", d.Stage, d.Name)
+	}
+
+	return fmt.Sprintf("%s:%s=%s", d.Stage, d.Name, d.Action)
+}
+
+// AuthDecisions is a list of AuthDecision.
+type AuthDecisions []AuthDecision
+
+// String returns the string representation of the AuthDecisions.
+func (d AuthDecisions) String() string {
+
+	out := make([]string, len(d))
+	for i, decision := range d {
+		out[i] = decision.String()
+	}
+
+	return strings.Join(out, ", ")
+}
+
+// setAuthDecisionsHeader sets the AuthDecisionsHeader
+// of the given http.ResponseWriter.
+func setAuthDecisionsHeader(w http.ResponseWriter, decisions AuthDecisions) {
+
+	if len(decisions) == 0 {
+		return
+	}
+
+	w.Header().Set(AuthDecisionsHeader, decisions.String())
+}
+
+// authDecisionRecorder is implemented by the Contexts
+// able to record the auth decisions.
+type authDecisionRecorder interface {
+	recordAuthDecision(AuthDecision)
+}
+
+func authName(v interface{}) string {
+
+	if n, ok := v.(AuthNamer); ok {
+		return n.AuthName()
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+// recordAuthDecision records the given decision in the
+// given Context and logs it in the current span, if any.
+func recordAuthDecision(ctx Context, stage AuthStage, v interface{}, action AuthAction, err error) {
+
+	decision := AuthDecision{
+		Stage:  stage,
+		Name:   authName(v),
+		Action: action,
+		Error:  err,
+	}
+
+	if r, ok := ctx.(authDecisionRecorder); ok {
+		r.recordAuthDecision(decision)
+	}
+
+	if span := opentracing.SpanFromContext(ctx.Context()); span != nil {
+
+		fields := []log.Field{
+			log.String("auth.stage", string(decision.Stage)),
+			log.String("auth.name", decision.Name),
+			log.String("auth.action", decision.Action.String()),
+		}
+
+		if err != nil {
+			fields = append(fields, log.Error(err))
+		}
+
+		span.LogFields(fields...)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+type namedMockAuth struct {
+	mockAuth
+	name string
+}
+
+func (a *namedMockAuth) AuthName() string { return a.name }
+
+func TestAuthAction_String(t *testing.T) {
+
+	Convey("Given I have some AuthActions", t, func() {
+		So(AuthActionOK.String(), ShouldEqual, "ok")
+		So(AuthActionKO.String(), ShouldEqual, "ko")
+		So(AuthActionContinue.String(), ShouldEqual, "continue")
+		So(AuthAction(42).String(), ShouldEqual, "unknown(42)")
+	})
+}
+
+func TestAuthDecisions(t *testing.T) {
+
+	Convey("Given I have a context and some authenticators and authorizers", t, func() {
+
+		tracer := mocktracer.New()
+		span := tracer.StartSpan("test")
+		ctx := newContext(opentracing.ContextWithSpan(context.Background(), span), elemental.NewRequest())
+
+		authn1 := &mockAuth{action: AuthActionContinue}
+		authn2 := &namedMockAuth{mockAuth: mockAuth{action: AuthActionOK}, name: "token"}
+		authn3 := &mockAuth{action: AuthActionKO}
+
+		authz1 := &namedMockAuth{mockAuth: mockAuth{action: AuthActionContinue}, name: "cidr"}
+		authz2 := &namedMockAuth{mockAuth: mockAuth{action: AuthActionKO}, name: "rbac"}
+
+		Convey("When I check authentication and authorization", func() {
+
+			err1 := CheckAuthentication([]RequestAuthenticator{authn1, authn2, authn3}, ctx)
+			err2 := CheckAuthorization([]Authorizer{authz1, authz2}, ctx)
+
+			Convey("Then the decisions should be recorded in order", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldNotBeNil)
+
+				decisions := ctx.AuthDecisions()
+				So(len(decisions), ShouldEqual, 4)
+				So(decisions[0], ShouldResemble, AuthDecision{Stage: AuthStageAuthentication, Name: "*bahamut.mockAuth", Action: AuthActionContinue})
+				So(decisions[1], ShouldResemble, AuthDecision{Stage: AuthStageAuthentication, Name: "token", Action: AuthActionOK})
+				So(decisions[2], ShouldResemble, AuthDecision{Stage: AuthStageAuthorization, Name: "cidr", Action: AuthActionContinue})
+				So(decisions[3], ShouldResemble, AuthDecision{Stage: AuthStageAuthorization, Name: "rbac", Action: AuthActionKO})
+				So(decisions.String(), ShouldEqual, "authentication:*bahamut.mockAuth=continue, authentication:token=ok, authorization:cidr=continue, authorization:rbac=ko")
+			})
+
+			Convey("Then the decisions should be logged in the span", func() {
+				span.Finish()
+				logs := tracer.FinishedSpans()[0].Logs()
+				So(len(logs), ShouldEqual, 4)
+				So(logs[3].Fields[0].ValueString, ShouldEqual, "authorization")
+				So(logs[3].Fields[1].ValueString, ShouldEqual, "rbac")
+				So(logs[3].Fields[2].ValueString, ShouldEqual, "ko")
+			})
+
+			Convey("Then the duplicated context should have the decisions", func() {
+				So(len(ctx.Duplicate().AuthDecisions()), ShouldEqual, 4)
+			})
+		})
+
+		Convey("When an authorizer returns an error", func() {
+
+			err := errors.New("boom")
+			authz1.err = err
+			authz1.errored = true
+
+			_ = CheckAuthorization([]Authorizer{authz1, authz2}, ctx)
+
+			Convey("Then the error should be recorded", func() {
+				decisions := ctx.AuthDecisions()
+				So(len(decisions), ShouldEqual, 1)
+				So(decisions[0].Error, ShouldEqual, err)
+				So(decisions[0].String(), ShouldEqual, "authorization:cidr=error")
+			})
+		})
+	})
+}
+
+func TestSetAuthDecisionsHeader(t *testing.T) {
+
+	Convey("Given I have a response writer", t, func() {
+
+		w := httptest.NewRecorder()
+
+		Convey("When I set no decisions", func() {
+
+			setAuthDecisionsHeader(w, nil)
+
+			Convey("Then the header should not be set", func() {
+				So(w.Header().Get(AuthDecisionsHeader), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I set some decisions", func() {
+
+			setAuthDecisionsHeader(w, AuthDecisions{{Stage: AuthStageAuthorization, Name: "rbac", Action: AuthActionKO}})
+
+			Convey("Then the header should be set", func() {
+				So(w.Header().Get(AuthDecisionsHeader), ShouldEqual, "authorization:rbac=ko")
+			})
+		})
+	})
+}
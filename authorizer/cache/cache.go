@@ -0,0 +1,207 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"go.aporeto.io/bahamut"
+)
+
+// decisionCache holds the logic shared by the wrappers.
+type decisionCache struct {
+	cfg   config
+	items *lru
+	now   func() time.Time
+}
+
+func newDecisionCache(cfg config) *decisionCache {
+
+	return &decisionCache{
+		cfg:   cfg,
+		items: newLRU(cfg.size),
+		now:   time.Now,
+	}
+}
+
+// decide returns the cached decision for the given context, or calls
+// the given function and caches its decision.
+func (c *decisionCache) decide(ctx bahamut.Context, f func() (bahamut.AuthAction, error)) (entry, error) {
+
+	key := c.key(ctx)
+	if key == "" {
+		action, err := f()
+		return entry{action: action, claims: ctx.Claims()}, err
+	}
+
+	if e, ok := c.items.get(key, c.now()); ok {
+		c.registerLookup(true)
+		return e, nil
+	}
+
+	c.registerLookup(false)
+
+	action, err := f()
+	if err != nil {
+		return entry{action: action}, err
+	}
+
+	ttl := c.cfg.positiveTTL
+	if action == bahamut.AuthActionKO {
+		ttl = c.cfg.negativeTTL
+	}
+
+	e := entry{
+		key:        key,
+		action:     action,
+		claims:     ctx.Claims(),
+		expiration: c.now().Add(ttl),
+	}
+
+	if ttl > 0 {
+		c.items.set(e)
+	}
+
+	return e, nil
+}
+
+func (c *decisionCache) invalidate(ctx bahamut.Context) {
+
+	if key := c.key(ctx); key != "" {
+		c.items.remove(key)
+	}
+}
+
+// key returns the cache key of the given context, or
+// an empty string if its decision must not be cached.
+func (c *decisionCache) key(ctx bahamut.Context) string {
+
+	for _, skip := range c.cfg.skipFuncs {
+		if skip(ctx) {
+			return ""
+		}
+	}
+
+	return c.cfg.keyFunc(ctx)
+}
+
+func (c *decisionCache) registerLookup(hit bool) {
+
+	if c.cfg.metricsManager != nil {
+		c.cfg.metricsManager.RegisterCacheLookup(c.cfg.name, hit)
+	}
+}
+
+// An Authorizer is a bahamut.Authorizer caching
+// the decisions of another bahamut.Authorizer.
+type Authorizer struct {
+	authorizer bahamut.Authorizer
+	cache      *decisionCache
+}
+
+// NewAuthorizer returns a new *Authorizer caching
+// the decisions of the given bahamut.Authorizer.
+func NewAuthorizer(authorizer bahamut.Authorizer, options ...Option) *Authorizer {
+
+	return &Authorizer{
+		authorizer: authorizer,
+		cache:      newDecisionCache(newConfig(DefaultAuthorizerKey, "authorizer", options)),
+	}
+}
+
+// IsAuthorized is the main method that returns whether the API call is authorized or not.
+func (a *Authorizer) IsAuthorized(ctx bahamut.Context) (bahamut.AuthAction, error) {
+
+	e, err := a.cache.decide(ctx, func() (bahamut.AuthAction, error) { return a.authorizer.IsAuthorized(ctx) })
+
+	return e.action, err
+}
+
+// AuthName returns the name of the wrapped authorizer, so
+// its decisions are recorded under its own name.
+func (a *Authorizer) AuthName() string {
+	return wrappedName(a.authorizer)
+}
+
+// Invalidate removes the cached decision for the given bahamut.Context.
+func (a *Authorizer) Invalidate(ctx bahamut.Context) {
+	a.cache.invalidate(ctx)
+}
+
+// Purge removes all the cached decisions.
+func (a *Authorizer) Purge() {
+	a.cache.items.purge()
+}
+
+// A RequestAuthenticator is a bahamut.RequestAuthenticator caching
+// the decisions and claims of another bahamut.RequestAuthenticator.
+type RequestAuthenticator struct {
+	authenticator bahamut.RequestAuthenticator
+	cache         *decisionCache
+}
+
+// NewRequestAuthenticator returns a new *RequestAuthenticator caching
+// the decisions of the given bahamut.RequestAuthenticator. The claims
+// set by the authenticator are cached along with the decision and set
+// back on cache hits.
+//
+// Authenticators protecting against replayed requests, like the one of
+// the authorizer/signature package, must not be cached, as a cache hit
+// skips the replay check. Use OptSkip to exclude their requests.
+func NewRequestAuthenticator(authenticator bahamut.RequestAuthenticator, options ...Option) *RequestAuthenticator {
+
+	return &RequestAuthenticator{
+		authenticator: authenticator,
+		cache:         newDecisionCache(newConfig(DefaultAuthenticatorKey, "authenticator", options)),
+	}
+}
+
+// AuthenticateRequest authenticates the request from the given bahamut.Context.
+func (a *RequestAuthenticator) AuthenticateRequest(ctx bahamut.Context) (bahamut.AuthAction, error) {
+
+	e, err := a.cache.decide(ctx, func() (bahamut.AuthAction, error) { return a.authenticator.AuthenticateRequest(ctx) })
+	if err != nil {
+		return e.action, err
+	}
+
+	if e.action == bahamut.AuthActionOK && e.claims != nil {
+		ctx.SetClaims(append([]string{}, e.claims...))
+	}
+
+	return e.action, nil
+}
+
+// AuthName returns the name of the wrapped authenticator, so
+// its decisions are recorded under its own name.
+func (a *RequestAuthenticator) AuthName() string {
+	return wrappedName(a.authenticator)
+}
+
+// Invalidate removes the cached decision for the given bahamut.Context.
+func (a *RequestAuthenticator) Invalidate(ctx bahamut.Context) {
+	a.cache.invalidate(ctx)
+}
+
+// Purge removes all the cached decisions.
+func (a *RequestAuthenticator) Purge() {
+	a.cache.items.purge()
+}
+
+func wrappedName(v interface{}) string {
+
+	if n, ok := v.(bahamut.AuthNamer); ok {
+		return n.AuthName()
+	}
+
+	return fmt.Sprintf("%T", v)
+}
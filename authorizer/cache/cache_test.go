@@ -0,0 +1,330 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+)
+
+type mockAuthorizer struct {
+	calls  int
+	action bahamut.AuthAction
+	err    error
+}
+
+func (a *mockAuthorizer) IsAuthorized(bahamut.Context) (bahamut.AuthAction, error) {
+	a.calls++
+	return a.action, a.err
+}
+
+type mockAuthenticator struct {
+	calls  int
+	action bahamut.AuthAction
+	claims []string
+	err    error
+}
+
+func (a *mockAuthenticator) AuthenticateRequest(ctx bahamut.Context) (bahamut.AuthAction, error) {
+	a.calls++
+	ctx.SetClaims(a.claims)
+	return a.action, a.err
+}
+
+type mockMetricsManager struct {
+	bahamut.MetricsManager
+	hits   map[string]int
+	misses map[string]int
+}
+
+func (m *mockMetricsManager) RegisterCacheLookup(cache string, hit bool) {
+	if hit {
+		m.hits[cache]++
+	} else {
+		m.misses[cache]++
+	}
+}
+
+func makeContext(authorization string, claims ...string) bahamut.Context {
+
+	req := elemental.NewRequest()
+	req.Headers = http.Header{}
+	req.Operation = elemental.OperationRetrieveMany
+	req.Identity = elemental.MakeIdentity("list", "lists")
+	if authorization != "" {
+		req.Headers.Set("Authorization", authorization)
+	}
+
+	ctx := bahamut.NewContext(context.Background(), req)
+	ctx.SetClaims(claims)
+
+	return ctx
+}
+
+func TestAuthorizer_IsAuthorized(t *testing.T) {
+
+	Convey("Given I have a cached authorizer", t, func() {
+
+		m := &mockAuthorizer{action: bahamut.AuthActionOK}
+		mm := &mockMetricsManager{hits: map[string]int{}, misses: map[string]int{}}
+		a := NewAuthorizer(m, OptTTL(time.Minute, time.Second), OptMetricsManager(mm, "authz"))
+
+		now := time.Now()
+		a.cache.now = func() time.Time { return now }
+
+		Convey("When I call IsAuthorized twice with the same claims", func() {
+
+			action1, err1 := a.IsAuthorized(makeContext("", "a=a", "b=b"))
+			action2, err2 := a.IsAuthorized(makeContext("", "b=b", "a=a"))
+
+			Convey("Then the decision should be cached", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(action1, ShouldEqual, bahamut.AuthActionOK)
+				So(action2, ShouldEqual, bahamut.AuthActionOK)
+				So(m.calls, ShouldEqual, 1)
+				So(mm.hits["authz"], ShouldEqual, 1)
+				So(mm.misses["authz"], ShouldEqual, 1)
+			})
+
+			Convey("When I call it with other claims", func() {
+
+				_, _ = a.IsAuthorized(makeContext("", "a=b"))
+
+				Convey("Then the decision should not be cached", func() {
+					So(m.calls, ShouldEqual, 2)
+				})
+			})
+
+			Convey("When the positive ttl expires", func() {
+
+				now = now.Add(2 * time.Minute)
+				_, _ = a.IsAuthorized(makeContext("", "a=a", "b=b"))
+
+				Convey("Then the authorizer should be called again", func() {
+					So(m.calls, ShouldEqual, 2)
+				})
+			})
+
+			Convey("When I invalidate the decision", func() {
+
+				a.Invalidate(makeContext("", "a=a", "b=b"))
+				_, _ = a.IsAuthorized(makeContext("", "a=a", "b=b"))
+
+				Convey("Then the authorizer should be called again", func() {
+					So(m.calls, ShouldEqual, 2)
+				})
+			})
+
+			Convey("When I purge the cache", func() {
+
+				a.Purge()
+
+				Convey("Then the cache should be empty", func() {
+					So(a.cache.items.len(), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When the authorizer returns KO", func() {
+
+			m.action = bahamut.AuthActionKO
+
+			_, _ = a.IsAuthorized(makeContext("", "a=a"))
+			now = now.Add(500 * time.Millisecond)
+			action, _ := a.IsAuthorized(makeContext("", "a=a"))
+
+			Convey("Then the decision should be cached with the negative ttl", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(m.calls, ShouldEqual, 1)
+			})
+
+			Convey("When the negative ttl expires", func() {
+
+				now = now.Add(time.Second)
+				_, _ = a.IsAuthorized(makeContext("", "a=a"))
+
+				Convey("Then the authorizer should be called again", func() {
+					So(m.calls, ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("When the authorizer returns an error", func() {
+
+			m.err = errors.New("boom")
+
+			_, err1 := a.IsAuthorized(makeContext("", "a=a"))
+			_, err2 := a.IsAuthorized(makeContext("", "a=a"))
+
+			Convey("Then the error should not be cached", func() {
+				So(err1, ShouldNotBeNil)
+				So(err2, ShouldNotBeNil)
+				So(m.calls, ShouldEqual, 2)
+			})
+		})
+	})
+
+	Convey("Given I have a cached authorizer with a custom key func returning nothing", t, func() {
+
+		m := &mockAuthorizer{action: bahamut.AuthActionOK}
+		a := NewAuthorizer(m, OptKeyFunc(func(bahamut.Context) string { return "" }))
+
+		Convey("When I call IsAuthorized twice", func() {
+
+			_, _ = a.IsAuthorized(makeContext("", "a=a"))
+			_, _ = a.IsAuthorized(makeContext("", "a=a"))
+
+			Convey("Then nothing should be cached", func() {
+				So(m.calls, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestRequestAuthenticator_AuthenticateRequest(t *testing.T) {
+
+	Convey("Given I have a cached authenticator", t, func() {
+
+		m := &mockAuthenticator{action: bahamut.AuthActionOK, claims: []string{"user=bob"}}
+		a := NewRequestAuthenticator(m)
+
+		Convey("When I call AuthenticateRequest twice with the same token", func() {
+
+			ctx1 := makeContext("Bearer token")
+			ctx2 := makeContext("Bearer token")
+
+			action1, err1 := a.AuthenticateRequest(ctx1)
+			action2, err2 := a.AuthenticateRequest(ctx2)
+
+			Convey("Then the decision and claims should be cached", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(action1, ShouldEqual, bahamut.AuthActionOK)
+				So(action2, ShouldEqual, bahamut.AuthActionOK)
+				So(m.calls, ShouldEqual, 1)
+				So(ctx2.Claims(), ShouldResemble, []string{"user=bob"})
+			})
+		})
+
+		Convey("When I call AuthenticateRequest twice without credentials", func() {
+
+			_, _ = a.AuthenticateRequest(makeContext(""))
+			_, _ = a.AuthenticateRequest(makeContext(""))
+
+			Convey("Then nothing should be cached", func() {
+				So(m.calls, ShouldEqual, 2)
+			})
+		})
+
+	})
+
+	Convey("Given I have a cached authenticator skipping some requests", t, func() {
+
+		m := &mockAuthenticator{action: bahamut.AuthActionOK, claims: []string{"user=bob"}}
+		a := NewRequestAuthenticator(
+			m,
+			OptSkip(func(ctx bahamut.Context) bool {
+				return strings.HasPrefix(ctx.Request().Headers.Get("Authorization"), "Signed ")
+			}),
+		)
+
+		Convey("When I call AuthenticateRequest twice with the same skipped request", func() {
+
+			_, _ = a.AuthenticateRequest(makeContext("Signed abc"))
+			_, _ = a.AuthenticateRequest(makeContext("Signed abc"))
+
+			Convey("Then nothing should be cached", func() {
+				So(m.calls, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I call AuthenticateRequest twice with the same token", func() {
+
+			_, _ = a.AuthenticateRequest(makeContext("Bearer token"))
+			_, _ = a.AuthenticateRequest(makeContext("Bearer token"))
+
+			Convey("Then the decision should be cached", func() {
+				So(m.calls, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+type namedAuthorizer struct {
+	mockAuthorizer
+}
+
+func (a *namedAuthorizer) AuthName() string {
+	return "named"
+}
+
+func TestWrappers_AuthName(t *testing.T) {
+
+	Convey("Given I have wrappers around a named authorizer and an unnamed authenticator", t, func() {
+
+		authorizer := NewAuthorizer(&namedAuthorizer{})
+		authenticator := NewRequestAuthenticator(&mockAuthenticator{})
+
+		Convey("Then the wrappers should use the name of the wrapped values", func() {
+			So(authorizer.AuthName(), ShouldEqual, "named")
+			So(authenticator.AuthName(), ShouldEqual, "*cache.mockAuthenticator")
+		})
+	})
+}
+
+func TestLRU(t *testing.T) {
+
+	Convey("Given I have a lru of size 2", t, func() {
+
+		now := time.Now()
+		c := newLRU(2)
+
+		Convey("When I add 3 entries after using the first one", func() {
+
+			c.set(entry{key: "1", expiration: now.Add(time.Minute)})
+			c.set(entry{key: "2", expiration: now.Add(time.Minute)})
+			_, _ = c.get("1", now)
+			c.set(entry{key: "3", expiration: now.Add(time.Minute)})
+
+			Convey("Then the least recently used entry should be evicted", func() {
+				So(c.len(), ShouldEqual, 2)
+				for _, k := range []string{"1", "3"} {
+					_, ok := c.get(k, now)
+					So(ok, ShouldBeTrue)
+				}
+				_, ok := c.get("2", now)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I add many entries", func() {
+
+			for i := 0; i < 10; i++ {
+				c.set(entry{key: fmt.Sprintf("%d", i), expiration: now.Add(time.Minute)})
+			}
+
+			Convey("Then the size should be bounded", func() {
+				So(c.len(), ShouldEqual, 2)
+			})
+		})
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"go.aporeto.io/bahamut"
+)
+
+// DefaultAuthorizerKey is the default KeyFunc of the authorizer wrapper.
+// It uses the claims, the namespace, the identity, the operation
+// and the parent of the request.
+func DefaultAuthorizerKey(ctx bahamut.Context) string {
+
+	req := ctx.Request()
+
+	claims := append([]string{}, ctx.Claims()...)
+	sort.Strings(claims)
+
+	parts := append(
+		claims,
+		"",
+		req.Namespace,
+		req.Identity.Name,
+		string(req.Operation),
+		req.ParentIdentity.Name,
+		req.ParentID,
+	)
+
+	return hashParts(parts)
+}
+
+// DefaultAuthenticatorKey is the default KeyFunc of the authenticator wrapper.
+// It uses the Authorization header, the username and password, and the
+// client certificate of the request. If none of them are set, the
+// decision is not cached.
+func DefaultAuthenticatorKey(ctx bahamut.Context) string {
+
+	req := ctx.Request()
+
+	var fingerprint string
+	if req.TLSConnectionState != nil && len(req.TLSConnectionState.PeerCertificates) > 0 {
+		sum := sha256.Sum256(req.TLSConnectionState.PeerCertificates[0].Raw)
+		fingerprint = hex.EncodeToString(sum[:])
+	}
+
+	authorization := req.Headers.Get("Authorization")
+
+	if authorization == "" && req.Username == "" && req.Password == "" && fingerprint == "" {
+		return ""
+	}
+
+	return hashParts([]string{authorization, req.Username, req.Password, fingerprint})
+}
+
+func hashParts(parts []string) string {
+
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p)) // nolint: errcheck
+		h.Write([]byte{0}) // nolint: errcheck
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.aporeto.io/bahamut"
+)
+
+type entry struct {
+	key        string
+	action     bahamut.AuthAction
+	claims     []string
+	expiration time.Time
+}
+
+// an lru is a size bounded cache of decisions
+// evicting the least recently used ones.
+type lru struct {
+	items   map[string]*list.Element
+	order   *list.List
+	maxSize int
+	lock    sync.Mutex
+}
+
+func newLRU(size int) *lru {
+
+	return &lru{
+		items:   map[string]*list.Element{},
+		order:   list.New(),
+		maxSize: size,
+	}
+}
+
+func (c *lru) get(key string, now time.Time) (entry, bool) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return entry{}, false
+	}
+
+	e := elem.Value.(entry)
+	if !e.expiration.After(now) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return entry{}, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return e, true
+}
+
+func (c *lru) set(e entry) {
+
+	if c.maxSize <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[e.key]; ok {
+		elem.Value = e
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[e.key] = c.order.PushFront(e)
+
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(entry).key)
+	}
+}
+
+func (c *lru) remove(key string) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+func (c *lru) purge() {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+}
+
+func (c *lru) len() int {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"time"
+
+	"go.aporeto.io/bahamut"
+)
+
+// A KeyFunc returns the cache key of the given bahamut.Context.
+// If it returns an empty string, the decision is not cached.
+type KeyFunc func(bahamut.Context) string
+
+// A SkipFunc returns true if the decision for
+// the given bahamut.Context must not be cached.
+type SkipFunc func(bahamut.Context) bool
+
+type config struct {
+	keyFunc        KeyFunc
+	skipFuncs      []SkipFunc
+	positiveTTL    time.Duration
+	negativeTTL    time.Duration
+	size           int
+	metricsManager bahamut.CacheMetricsManager
+	name           string
+}
+
+// An Option represents a configuration option
+// for the cache wrappers.
+type Option func(*config)
+
+// OptKeyFunc sets the function used to compute the cache key.
+//
+// The default for authorizers is DefaultAuthorizerKey,
+// and DefaultAuthenticatorKey for authenticators.
+func OptKeyFunc(f KeyFunc) Option {
+	return func(c *config) {
+		c.keyFunc = f
+	}
+}
+
+// OptSkip adds a function deciding which requests must never be cached,
+// like the ones using an authenticator that protects against replays.
+// It can be used several times.
+func OptSkip(f SkipFunc) Option {
+	return func(c *config) {
+		c.skipFuncs = append(c.skipFuncs, f)
+	}
+}
+
+// OptTTL sets how long positive and negative decisions are cached.
+// AuthActionOK and AuthActionContinue are positive decisions and
+// AuthActionKO is a negative one. Errors are never cached.
+// The defaults are 1 minute and 10 seconds.
+func OptTTL(positive time.Duration, negative time.Duration) Option {
+	return func(c *config) {
+		c.positiveTTL = positive
+		c.negativeTTL = negative
+	}
+}
+
+// OptSize sets the maximum number of decisions kept in the cache.
+// When the cache is full, the least recently used decisions are
+// evicted. The default is 10000.
+func OptSize(size int) Option {
+	return func(c *config) {
+		c.size = size
+	}
+}
+
+// OptMetricsManager sets the bahamut.MetricsManager used to report
+// the cache hits and misses under the given cache name. They are only
+// reported if it implements bahamut.CacheMetricsManager.
+func OptMetricsManager(metricsManager bahamut.MetricsManager, name string) Option {
+	return func(c *config) {
+		c.metricsManager, _ = metricsManager.(bahamut.CacheMetricsManager)
+		c.name = name
+	}
+}
+
+func newConfig(defaultKeyFunc KeyFunc, defaultName string, options []Option) config {
+
+	cfg := config{
+		keyFunc:     defaultKeyFunc,
+		positiveTTL: time.Minute,
+		negativeTTL: 10 * time.Second,
+		size:        10000,
+		name:        defaultName,
+	}
+
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	return cfg
+}
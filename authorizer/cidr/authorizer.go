@@ -0,0 +1,197 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cidr
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// An Authorizer is a bahamut.Authorizer and bahamut.SessionAuthenticator
+// compliant structure that denies the access to the clients that are not
+// allowed by the configured Rules.
+//
+// It never authorizes anything by itself: if the client is allowed,
+// the decision is left to the next authorizers.
+type Authorizer struct {
+	rules          []compiledRule
+	rulesFile      string
+	reloadInterval time.Duration
+
+	fileRules     []compiledRule
+	fileSignature string
+	lastCheck     time.Time
+	lock          sync.RWMutex
+	now           func() time.Time
+	logger        *zap.Logger
+}
+
+// NewAuthorizer returns a new *Authorizer using the given rules.
+// It returns an error if any of the rules is invalid, or if the
+// rules file cannot be loaded.
+func NewAuthorizer(rules []Rule, options ...Option) (*Authorizer, error) {
+
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Authorizer{
+		rules:          compiled,
+		reloadInterval: time.Minute,
+		now:            time.Now,
+	}
+
+	for _, opt := range options {
+		opt(a)
+	}
+
+	if a.logger == nil {
+		a.logger = zap.L()
+	}
+
+	if a.rulesFile != "" {
+
+		signature, err := statFile(a.rulesFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if a.fileRules, err = loadRulesFile(a.rulesFile); err != nil {
+			return nil, err
+		}
+
+		a.fileSignature = signature
+		a.lastCheck = a.now()
+	}
+
+	return a, nil
+}
+
+// IsAuthorized is the main method that returns whether the API call is authorized or not.
+func (a *Authorizer) IsAuthorized(ctx bahamut.Context) (bahamut.AuthAction, error) {
+
+	req := ctx.Request()
+
+	if !a.isAllowed(ctx.ClientIP(), req.Identity.Name, req.Operation) {
+		return bahamut.AuthActionKO, nil
+	}
+
+	return bahamut.AuthActionContinue, nil
+}
+
+// AuthenticateSession authenticates the given session.
+// Only the rules applying to all identities are used.
+func (a *Authorizer) AuthenticateSession(session bahamut.Session) (bahamut.AuthAction, error) {
+
+	if !a.isAllowed(session.ClientIP(), "", elemental.OperationEmpty) {
+		return bahamut.AuthActionKO, nil
+	}
+
+	return bahamut.AuthActionContinue, nil
+}
+
+func (a *Authorizer) isAllowed(clientIP string, identity string, operation elemental.Operation) bool {
+
+	a.reloadIfNeeded()
+
+	a.lock.RLock()
+	fileRules := a.fileRules
+	a.lock.RUnlock()
+
+	ip := net.ParseIP(clientIP)
+
+	for _, rules := range [][]compiledRule{a.rules, fileRules} {
+		for _, r := range rules {
+
+			if identity == "" && (r.identity != "" || r.operations != nil) {
+				continue
+			}
+
+			if identity != "" && !r.matches(identity, operation) {
+				continue
+			}
+
+			if ip == nil {
+				return false
+			}
+
+			if contains(r.deny, ip) {
+				return false
+			}
+
+			if len(r.allow) > 0 && !contains(r.allow, ip) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (a *Authorizer) reloadIfNeeded() {
+
+	if a.rulesFile == "" {
+		return
+	}
+
+	a.lock.RLock()
+	lastCheck := a.lastCheck
+	a.lock.RUnlock()
+
+	if a.now().Sub(lastCheck) < a.reloadInterval {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.lastCheck = a.now()
+
+	signature, err := statFile(a.rulesFile)
+	if err != nil {
+		a.logger.Error("Unable to stat cidr rules file. Keeping previous rules", zap.Error(err))
+		return
+	}
+
+	if signature == a.fileSignature {
+		return
+	}
+
+	rules, err := loadRulesFile(a.rulesFile)
+	if err != nil {
+		a.logger.Error("Unable to reload cidr rules file. Keeping previous rules", zap.Error(err))
+		return
+	}
+
+	a.fileRules = rules
+	a.fileSignature = signature
+
+	a.logger.Info("CIDR rules reloaded", zap.String("file", a.rulesFile), zap.Int("rules", len(rules)))
+}
+
+func statFile(path string) (string, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
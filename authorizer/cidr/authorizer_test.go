@@ -0,0 +1,274 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cidr
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+type mockSession struct {
+	clientIP string
+}
+
+func (s *mockSession) Identifier() string                       { return "" }
+func (s *mockSession) Parameter(string) string                  { return "" }
+func (s *mockSession) Header(string) string                     { return "" }
+func (s *mockSession) SetClaims([]string)                       {}
+func (s *mockSession) Claims() []string                         { return nil }
+func (s *mockSession) ClaimsMap() map[string]string             { return nil }
+func (s *mockSession) Token() string                            { return "" }
+func (s *mockSession) TLSConnectionState() *tls.ConnectionState { return nil }
+func (s *mockSession) ClientIP() string                         { return s.clientIP }
+func (s *mockSession) Metadata() interface{}                    { return nil }
+func (s *mockSession) SetMetadata(interface{})                  {}
+func (s *mockSession) Context() context.Context                 { return context.Background() }
+func (s *mockSession) Logger() *zap.Logger                      { return zap.NewNop() }
+
+func makeContext(clientIP string, identity string, operation elemental.Operation) bahamut.Context {
+
+	req := elemental.NewRequest()
+	req.ClientIP = clientIP
+	req.Identity = elemental.Identity{Name: identity}
+	req.Operation = operation
+
+	return bahamut.NewContext(context.Background(), req)
+}
+
+func TestAuthorizer_NewAuthorizer(t *testing.T) {
+
+	Convey("Given I call NewAuthorizer with valid rules", t, func() {
+
+		a, err := NewAuthorizer(
+			[]Rule{
+				{Identity: "*", Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.0.0.1"}},
+				{Identity: "admin", Operations: []elemental.Operation{elemental.OperationCreate}, Allow: []string{"::1"}},
+			},
+			OptReloadInterval(time.Second),
+		)
+
+		Convey("Then it should be correctly initialized", func() {
+			So(err, ShouldBeNil)
+			So(a.reloadInterval, ShouldEqual, time.Second)
+			So(len(a.rules), ShouldEqual, 2)
+			So(a.rules[0].identity, ShouldEqual, "")
+			So(a.rules[0].operations, ShouldBeNil)
+			So(a.rules[0].deny[0].String(), ShouldEqual, "10.0.0.1/32")
+			So(a.rules[1].allow[0].String(), ShouldEqual, "::1/128")
+			So(a.rules[1].operations, ShouldContainKey, elemental.OperationCreate)
+		})
+	})
+
+	Convey("Given I call NewAuthorizer with invalid rules", t, func() {
+
+		_, err1 := NewAuthorizer([]Rule{{Allow: []string{"10.0.0.0/64"}}})
+		_, err2 := NewAuthorizer([]Rule{{Deny: []string{"nope"}}})
+
+		Convey("Then I should get errors", func() {
+			So(err1, ShouldNotBeNil)
+			So(err1.Error(), ShouldEqual, "invalid allow list in rule 0: invalid cidr '10.0.0.0/64': invalid CIDR address: 10.0.0.0/64")
+			So(err2, ShouldNotBeNil)
+			So(err2.Error(), ShouldEqual, "invalid deny list in rule 0: invalid ip 'nope'")
+		})
+	})
+
+	Convey("Given I call NewAuthorizer with a missing rules file", t, func() {
+
+		_, err := NewAuthorizer(nil, OptRulesFile("/not/here.json"))
+
+		Convey("Then I should get an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAuthorizer_IsAuthorized(t *testing.T) {
+
+	Convey("Given I have an authorizer", t, func() {
+
+		a, err := NewAuthorizer([]Rule{
+			{Identity: "admin", Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.66.0.0/16", "2001:db8:dead::/48"}},
+			{Identity: "user", Operations: []elemental.Operation{elemental.OperationDelete}, Allow: []string{"192.168.0.0/16"}},
+			{Deny: []string{"203.0.113.0/24"}},
+		})
+		So(err, ShouldBeNil)
+
+		tests := []struct {
+			ip        string
+			identity  string
+			operation elemental.Operation
+			expected  bahamut.AuthAction
+		}{
+			{"10.1.2.3", "admin", elemental.OperationCreate, bahamut.AuthActionContinue},
+			{"2001:db8::1", "admin", elemental.OperationRetrieve, bahamut.AuthActionContinue},
+			{"10.66.1.2", "admin", elemental.OperationCreate, bahamut.AuthActionKO},
+			{"2001:db8:dead::1", "admin", elemental.OperationCreate, bahamut.AuthActionKO},
+			{"172.16.0.1", "admin", elemental.OperationCreate, bahamut.AuthActionKO},
+			{"2001:db9::1", "admin", elemental.OperationCreate, bahamut.AuthActionKO},
+			{"", "admin", elemental.OperationCreate, bahamut.AuthActionKO},
+			{"172.16.0.1", "user", elemental.OperationCreate, bahamut.AuthActionContinue},
+			{"172.16.0.1", "user", elemental.OperationDelete, bahamut.AuthActionKO},
+			{"192.168.1.1", "user", elemental.OperationDelete, bahamut.AuthActionContinue},
+			{"::ffff:192.168.1.1", "user", elemental.OperationDelete, bahamut.AuthActionContinue},
+			{"203.0.113.5", "user", elemental.OperationCreate, bahamut.AuthActionKO},
+			{"203.0.113.5", "other", elemental.OperationCreate, bahamut.AuthActionKO},
+			{"198.51.100.1", "other", elemental.OperationCreate, bahamut.AuthActionContinue},
+		}
+
+		for _, tt := range tests {
+
+			Convey("When I call IsAuthorized for "+tt.ip+" on "+tt.identity+" "+string(tt.operation), func() {
+
+				action, err := a.IsAuthorized(makeContext(tt.ip, tt.identity, tt.operation))
+
+				Convey("Then the action should be correct", func() {
+					So(err, ShouldBeNil)
+					So(action, ShouldEqual, tt.expected)
+				})
+			})
+		}
+	})
+
+	Convey("Given I have an authorizer with several rules matching the same identity", t, func() {
+
+		a, err := NewAuthorizer([]Rule{
+			{Identity: "admin", Allow: []string{"10.0.0.0/8"}},
+			{Identity: "admin", Operations: []elemental.Operation{elemental.OperationDelete}, Allow: []string{"10.1.0.0/16"}},
+		})
+		So(err, ShouldBeNil)
+
+		tests := []struct {
+			ip        string
+			operation elemental.Operation
+			expected  bahamut.AuthAction
+		}{
+			{"10.2.0.1", elemental.OperationCreate, bahamut.AuthActionContinue},
+			{"10.2.0.1", elemental.OperationDelete, bahamut.AuthActionKO},
+			{"10.1.0.1", elemental.OperationDelete, bahamut.AuthActionContinue},
+			{"172.16.0.1", elemental.OperationDelete, bahamut.AuthActionKO},
+		}
+
+		for _, tt := range tests {
+
+			Convey("When I call IsAuthorized for "+tt.ip+" on admin "+string(tt.operation), func() {
+
+				action, err := a.IsAuthorized(makeContext(tt.ip, "admin", tt.operation))
+
+				Convey("Then all the matching rules should apply", func() {
+					So(err, ShouldBeNil)
+					So(action, ShouldEqual, tt.expected)
+				})
+			})
+		}
+	})
+}
+
+func TestAuthorizer_AuthenticateSession(t *testing.T) {
+
+	Convey("Given I have an authorizer", t, func() {
+
+		a, err := NewAuthorizer([]Rule{
+			{Identity: "admin", Allow: []string{"10.0.0.0/8"}},
+			{Identity: "*", Allow: []string{"10.0.0.0/8", "fd00::/8"}, Deny: []string{"10.0.0.1"}},
+		})
+		So(err, ShouldBeNil)
+
+		tests := []struct {
+			ip       string
+			expected bahamut.AuthAction
+		}{
+			{"10.0.0.2", bahamut.AuthActionContinue},
+			{"fd00::1", bahamut.AuthActionContinue},
+			{"10.0.0.1", bahamut.AuthActionKO},
+			{"192.168.0.1", bahamut.AuthActionKO},
+			{"2001:db8::1", bahamut.AuthActionKO},
+		}
+
+		for _, tt := range tests {
+
+			Convey("When I call AuthenticateSession for "+tt.ip, func() {
+
+				action, err := a.AuthenticateSession(&mockSession{clientIP: tt.ip})
+
+				Convey("Then the action should be correct", func() {
+					So(err, ShouldBeNil)
+					So(action, ShouldEqual, tt.expected)
+				})
+			})
+		}
+	})
+}
+
+func TestAuthorizer_reload(t *testing.T) {
+
+	Convey("Given I have an authorizer using a rules file", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut-cidr")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "rules.json")
+		So(ioutil.WriteFile(path, []byte(`[{"identity":"admin","allow":["10.0.0.0/8"]}]`), 0600), ShouldBeNil)
+
+		now := time.Now()
+		a, err := NewAuthorizer(nil, OptRulesFile(path))
+		So(err, ShouldBeNil)
+		a.now = func() time.Time { return now }
+
+		Convey("Then the rules from the file should be used", func() {
+			action, _ := a.IsAuthorized(makeContext("192.168.0.1", "admin", elemental.OperationCreate))
+			So(action, ShouldEqual, bahamut.AuthActionKO)
+		})
+
+		Convey("When I change the file and the interval elapses", func() {
+
+			So(ioutil.WriteFile(path, []byte(`[{"identity":"admin","allow":["192.168.0.0/16"]}]`), 0600), ShouldBeNil)
+			future := now.Add(time.Hour)
+			So(os.Chtimes(path, future, future), ShouldBeNil)
+
+			Convey("Then the old rules should be used before the interval elapses", func() {
+				action, _ := a.IsAuthorized(makeContext("192.168.0.1", "admin", elemental.OperationCreate))
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+			})
+
+			Convey("Then the new rules should be used after the interval elapses", func() {
+				now = now.Add(2 * time.Minute)
+				action, _ := a.IsAuthorized(makeContext("192.168.0.1", "admin", elemental.OperationCreate))
+				So(action, ShouldEqual, bahamut.AuthActionContinue)
+			})
+		})
+
+		Convey("When I replace the file with an invalid one", func() {
+
+			So(ioutil.WriteFile(path, []byte(`[{"allow":["nope"]}]`), 0600), ShouldBeNil)
+			future := now.Add(time.Hour)
+			So(os.Chtimes(path, future, future), ShouldBeNil)
+			now = now.Add(2 * time.Minute)
+
+			Convey("Then the previous rules should be kept", func() {
+				action, _ := a.IsAuthorized(makeContext("10.0.0.1", "admin", elemental.OperationCreate))
+				So(action, ShouldEqual, bahamut.AuthActionContinue)
+				So(len(a.fileRules), ShouldEqual, 1)
+			})
+		})
+	})
+}
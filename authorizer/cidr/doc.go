@@ -0,0 +1,19 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cidr provides a bahamut.Authorizer and a bahamut.SessionAuthenticator
+// restricting the access to identities and operations based on the client IP
+// using allow and deny lists of CIDRs.
+//
+// The rules are not evaluated in order, and evaluation does not stop at
+// the first matching rule: all the rules matching a request are combined
+// with AND, so the client must be allowed by every one of them.
+package cidr // import "go.aporeto.io/bahamut/authorizer/cidr"
@@ -0,0 +1,47 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cidr
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// An Option represents a configuration option
+// for the cidr Authorizer.
+type Option func(*Authorizer)
+
+// OptRulesFile sets the path of a JSON file containing a list of
+// additional Rules. The file is reloaded when it changes on disk.
+// If the reload fails, the previous rules are kept.
+func OptRulesFile(path string) Option {
+	return func(a *Authorizer) {
+		a.rulesFile = path
+	}
+}
+
+// OptReloadInterval sets how often the rules file is checked
+// for changes. The default is one minute.
+func OptReloadInterval(interval time.Duration) Option {
+	return func(a *Authorizer) {
+		a.reloadInterval = interval
+	}
+}
+
+// OptLogger sets the logger used to report the reloads
+// of the rules file. By default, the global zap logger is used.
+func OptLogger(logger *zap.Logger) Option {
+	return func(a *Authorizer) {
+		a.logger = logger
+	}
+}
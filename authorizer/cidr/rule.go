@@ -0,0 +1,132 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cidr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"go.aporeto.io/bahamut/internal/cidrs"
+	"go.aporeto.io/elemental"
+)
+
+// A Rule restricts the access to an identity and a
+// set of operations to the clients from the given networks.
+type Rule struct {
+
+	// Identity is the name of the identity the rule applies to.
+	// If it is empty or "*", the rule applies to all identities and
+	// to the push sessions.
+	Identity string `json:"identity"`
+
+	// Operations is the list of operations the rule applies to.
+	// If it is empty, the rule applies to all operations.
+	Operations []elemental.Operation `json:"operations"`
+
+	// Allow is the list of CIDRs or IPs allowed to access the identity.
+	// If it is empty, all clients are allowed unless denied.
+	Allow []string `json:"allow"`
+
+	// Deny is the list of CIDRs or IPs denied to access the identity.
+	// Deny always takes precedence over Allow.
+	Deny []string `json:"deny"`
+}
+
+type compiledRule struct {
+	identity   string
+	operations map[elemental.Operation]struct{}
+	allow      []*net.IPNet
+	deny       []*net.IPNet
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+
+	out := make([]compiledRule, len(rules))
+
+	for i, r := range rules {
+
+		allow, err := cidrs.Parse(r.Allow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow list in rule %d: %s", i, err)
+		}
+
+		deny, err := cidrs.Parse(r.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny list in rule %d: %s", i, err)
+		}
+
+		identity := r.Identity
+		if identity == "*" {
+			identity = ""
+		}
+
+		var operations map[elemental.Operation]struct{}
+		if len(r.Operations) > 0 {
+			operations = make(map[elemental.Operation]struct{}, len(r.Operations))
+			for _, op := range r.Operations {
+				operations[op] = struct{}{}
+			}
+		}
+
+		out[i] = compiledRule{
+			identity:   identity,
+			operations: operations,
+			allow:      allow,
+			deny:       deny,
+		}
+	}
+
+	return out, nil
+}
+
+func loadRulesFile(path string) ([]compiledRule, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("unable to decode rules file: %s", err)
+	}
+
+	return compileRules(rules)
+}
+
+func (r compiledRule) matches(identity string, operation elemental.Operation) bool {
+
+	if r.identity != "" && r.identity != identity {
+		return false
+	}
+
+	if r.operations == nil {
+		return true
+	}
+
+	_, ok := r.operations[operation]
+
+	return ok
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,294 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package introspection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// maxResponseSize is the maximum size of
+// the introspection responses.
+const maxResponseSize = 1 << 20
+
+type cacheEntry struct {
+	claims     []string
+	active     bool
+	expiration time.Time
+}
+
+// An Authenticator is a bahamut.RequestAuthenticator and
+// bahamut.SessionAuthenticator compliant structure that validates
+// bearer tokens using an RFC 7662 introspection endpoint.
+type Authenticator struct {
+	endpoint      string
+	client        *http.Client
+	clientID      string
+	clientSecret  string
+	tokenTypeHint string
+	maxTTL        time.Duration
+	negativeTTL   time.Duration
+	cacheSize     int
+	claimsMapper  ClaimsMapperFunc
+	logger        *zap.Logger
+
+	cache     map[string]cacheEntry
+	cacheLock sync.RWMutex
+	group     singleflight.Group
+	now       func() time.Time
+}
+
+// NewAuthenticator returns a new *Authenticator using the given
+// introspection endpoint.
+//
+// Requests and sessions without token are left to the next authenticator.
+// Requests and sessions with an inactive token are rejected. Otherwise,
+// the claims are set from the introspection response.
+func NewAuthenticator(endpoint string, options ...Option) *Authenticator {
+
+	a := &Authenticator{
+		endpoint:     endpoint,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		maxTTL:       5 * time.Minute,
+		negativeTTL:  30 * time.Second,
+		cacheSize:    10000,
+		claimsMapper: DefaultClaimsMapper,
+		cache:        map[string]cacheEntry{},
+		now:          time.Now,
+	}
+
+	for _, opt := range options {
+		opt(a)
+	}
+
+	if a.logger == nil {
+		a.logger = zap.L()
+	}
+
+	return a
+}
+
+// AuthenticateRequest authenticates the request from the given bahamut.Context.
+// The token is read from the Authorization header using the Bearer scheme.
+func (a *Authenticator) AuthenticateRequest(ctx bahamut.Context) (bahamut.AuthAction, error) {
+
+	auth := ctx.Request().Headers.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return bahamut.AuthActionContinue, nil
+	}
+
+	return a.authenticate(strings.TrimSpace(auth[7:]), ctx.SetClaims)
+}
+
+// AuthenticateSession authenticates the given session.
+// The token is read from the session token.
+func (a *Authenticator) AuthenticateSession(session bahamut.Session) (bahamut.AuthAction, error) {
+
+	return a.authenticate(session.Token(), session.SetClaims)
+}
+
+func (a *Authenticator) authenticate(token string, claimSetter func([]string)) (bahamut.AuthAction, error) {
+
+	if token == "" {
+		return bahamut.AuthActionContinue, nil
+	}
+
+	key := tokenKey(token)
+
+	entry, ok := a.cached(key)
+	if !ok {
+
+		// The introspection is shared by all the concurrent callers
+		// using the same token, so it does not use their context.
+		v, err, _ := a.group.Do(key, func() (interface{}, error) {
+
+			if entry, ok := a.cached(key); ok {
+				return entry, nil
+			}
+
+			resp, err := a.introspect(token)
+			if err != nil {
+				return nil, err
+			}
+
+			entry := a.makeEntry(resp)
+			a.store(key, entry)
+
+			return entry, nil
+		})
+
+		if err != nil {
+			// The error may reveal the endpoint and the internal
+			// network, so it is only logged.
+			a.logger.Error("Unable to introspect token", zap.String("endpoint", a.endpoint), zap.Error(err))
+			return bahamut.AuthActionKO, elemental.NewError(
+				"Service Unavailable",
+				"Unable to validate token",
+				"bahamut",
+				http.StatusServiceUnavailable,
+			)
+		}
+
+		entry = v.(cacheEntry)
+	}
+
+	if !entry.active {
+		return bahamut.AuthActionKO, nil
+	}
+
+	claimSetter(append([]string{}, entry.claims...))
+
+	return bahamut.AuthActionOK, nil
+}
+
+func (a *Authenticator) introspect(token string) (*Response, error) {
+
+	form := url.Values{"token": []string{token}}
+	if a.tokenTypeHint != "" {
+		form.Set("token_type_hint", a.tokenTypeHint)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if a.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxResponseSize {
+		return nil, fmt.Errorf("introspection response exceeds %d bytes", maxResponseSize)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned %s", resp.Status)
+	}
+
+	r := &Response{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("unable to decode introspection response: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &r.Extra); err != nil {
+		return nil, fmt.Errorf("unable to decode introspection response: %s", err)
+	}
+
+	return r, nil
+}
+
+func (a *Authenticator) makeEntry(resp *Response) cacheEntry {
+
+	now := a.now()
+
+	active := resp.Active
+	if active && resp.Exp != 0 && !time.Unix(resp.Exp, 0).After(now) {
+		active = false
+	}
+	if active && resp.Nbf != 0 && time.Unix(resp.Nbf, 0).After(now) {
+		active = false
+	}
+
+	if !active {
+		return cacheEntry{expiration: now.Add(a.negativeTTL)}
+	}
+
+	expiration := now.Add(a.maxTTL)
+	if resp.Exp != 0 {
+		if exp := time.Unix(resp.Exp, 0); a.maxTTL == 0 || exp.Before(expiration) {
+			expiration = exp
+		}
+	}
+
+	return cacheEntry{
+		active:     true,
+		claims:     a.claimsMapper(resp),
+		expiration: expiration,
+	}
+}
+
+func (a *Authenticator) cached(key string) (cacheEntry, bool) {
+
+	a.cacheLock.RLock()
+	entry, ok := a.cache[key]
+	a.cacheLock.RUnlock()
+
+	if !ok || !entry.expiration.After(a.now()) {
+		return cacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+func (a *Authenticator) store(key string, entry cacheEntry) {
+
+	if a.cacheSize <= 0 || !entry.expiration.After(a.now()) {
+		return
+	}
+
+	a.cacheLock.Lock()
+	defer a.cacheLock.Unlock()
+
+	if len(a.cache) >= a.cacheSize {
+
+		now := a.now()
+		for k, e := range a.cache {
+			if !e.expiration.After(now) {
+				delete(a.cache, k)
+			}
+		}
+
+		// If the cache is still full, evict a random entry.
+		for k := range a.cache {
+			if len(a.cache) < a.cacheSize {
+				break
+			}
+			delete(a.cache, k)
+		}
+	}
+
+	a.cache[key] = entry
+}
+
+func tokenKey(token string) string {
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,428 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package introspection
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type mockSession struct {
+	token  string
+	claims []string
+}
+
+func (s *mockSession) Identifier() string                       { return "" }
+func (s *mockSession) Parameter(string) string                  { return "" }
+func (s *mockSession) Header(string) string                     { return "" }
+func (s *mockSession) SetClaims(c []string)                     { s.claims = c }
+func (s *mockSession) Claims() []string                         { return s.claims }
+func (s *mockSession) ClaimsMap() map[string]string             { return nil }
+func (s *mockSession) Token() string                            { return s.token }
+func (s *mockSession) TLSConnectionState() *tls.ConnectionState { return nil }
+func (s *mockSession) ClientIP() string                         { return "" }
+func (s *mockSession) Metadata() interface{}                    { return nil }
+func (s *mockSession) SetMetadata(interface{})                  {}
+func (s *mockSession) Context() context.Context                 { return context.Background() }
+func (s *mockSession) Logger() *zap.Logger                      { return zap.NewNop() }
+
+func makeContext(authorization string) bahamut.Context {
+
+	req := elemental.NewRequest()
+	req.Headers = http.Header{}
+	if authorization != "" {
+		req.Headers.Set("Authorization", authorization)
+	}
+
+	return bahamut.NewContext(context.Background(), req)
+}
+
+// makeServer returns an introspection server answering
+// with the given responses indexed by token.
+func makeServer(responses map[string]map[string]interface{}, calls *int64, delay time.Duration) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt64(calls, 1)
+		time.Sleep(delay)
+
+		if user, pass, ok := r.BasicAuth(); !ok || user != "client" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if token == "huge" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"active": true, "padding": "` + strings.Repeat("a", maxResponseSize) + `"}`)) // nolint: errcheck
+			return
+		}
+
+		resp, ok := responses[token]
+		if !ok {
+			resp = map[string]interface{}{"active": false}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp) // nolint: errcheck
+	}))
+}
+
+func TestAuthenticator_AuthenticateRequest(t *testing.T) {
+
+	Convey("Given I have an introspection server and an authenticator", t, func() {
+
+		var calls int64
+		exp := time.Now().Add(time.Hour).Unix()
+
+		server := makeServer(
+			map[string]map[string]interface{}{
+				"good": {
+					"active":    true,
+					"sub":       "user-a",
+					"username":  "alice",
+					"client_id": "cli",
+					"iss":       "https://idp",
+					"scope":     "read write",
+					"aud":       "bahamut",
+					"exp":       exp,
+				},
+				"expired": {
+					"active": true,
+					"sub":    "user-b",
+					"exp":    time.Now().Add(-time.Minute).Unix(),
+				},
+			},
+			&calls,
+			0,
+		)
+		defer server.Close()
+
+		core, logs := observer.New(zap.ErrorLevel)
+		a := NewAuthenticator(server.URL, OptClientCredentials("client", "secret"), OptLogger(zap.New(core)))
+
+		Convey("When I authenticate a request with an active token", func() {
+
+			ctx := makeContext("Bearer good")
+			action, err := a.AuthenticateRequest(ctx)
+
+			Convey("Then the action should be OK", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionOK)
+			})
+
+			Convey("Then the claims should be set", func() {
+				So(ctx.Claims(), ShouldResemble, []string{
+					"@auth:realm=oauth2",
+					"@auth:subject=user-a",
+					"@auth:username=alice",
+					"@auth:clientid=cli",
+					"@auth:issuer=https://idp",
+					"@auth:scope=read",
+					"@auth:scope=write",
+					"@auth:audience=bahamut",
+				})
+			})
+
+			Convey("Then the token should be cached until its expiration", func() {
+				entry, ok := a.cached(tokenKey("good"))
+				So(ok, ShouldBeTrue)
+				So(entry.expiration.Unix(), ShouldEqual, time.Now().Add(5*time.Minute).Unix())
+			})
+
+			Convey("When I authenticate it again", func() {
+
+				ctx := makeContext("bearer good")
+				action, err := a.AuthenticateRequest(ctx)
+
+				Convey("Then the cache should be used", func() {
+					So(err, ShouldBeNil)
+					So(action, ShouldEqual, bahamut.AuthActionOK)
+					So(len(ctx.Claims()), ShouldEqual, 8)
+					So(atomic.LoadInt64(&calls), ShouldEqual, 1)
+				})
+			})
+
+			Convey("When the cache entry expires", func() {
+
+				a.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+				_, err := a.AuthenticateRequest(makeContext("Bearer good"))
+
+				Convey("Then the endpoint should be called again", func() {
+					So(err, ShouldBeNil)
+					So(atomic.LoadInt64(&calls), ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("When I authenticate a request with an inactive token", func() {
+
+			action, err := a.AuthenticateRequest(makeContext("Bearer unknown"))
+
+			Convey("Then the action should be KO", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+			})
+
+			Convey("When I authenticate it again", func() {
+
+				action, _ := a.AuthenticateRequest(makeContext("Bearer unknown"))
+
+				Convey("Then the negative cache should be used", func() {
+					So(action, ShouldEqual, bahamut.AuthActionKO)
+					So(atomic.LoadInt64(&calls), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When I authenticate a request with an active but expired token", func() {
+
+			action, err := a.AuthenticateRequest(makeContext("Bearer expired"))
+
+			Convey("Then the action should be KO", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+			})
+		})
+
+		Convey("When I authenticate a request without token", func() {
+
+			action, err := a.AuthenticateRequest(makeContext("Basic dXNlcjpwYXNz"))
+
+			Convey("Then the action should be continue", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionContinue)
+				So(atomic.LoadInt64(&calls), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the introspection endpoint fails", func() {
+
+			action, err := a.AuthenticateRequest(makeContext("Bearer broken"))
+
+			Convey("Then I should get an error", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Unable to validate token")
+				So(err.Error(), ShouldNotContainSubstring, "500")
+				So(err.Error(), ShouldNotContainSubstring, server.URL)
+			})
+
+			Convey("Then the failure should be logged", func() {
+				So(logs.Len(), ShouldEqual, 1)
+				So(logs.All()[0].ContextMap()["endpoint"], ShouldEqual, server.URL)
+				So(logs.All()[0].ContextMap()["error"], ShouldEqual, "introspection endpoint returned 500 Internal Server Error")
+			})
+
+			Convey("Then the failure should not be cached", func() {
+				_, ok := a.cached(tokenKey("broken"))
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the introspection response is too large", func() {
+
+			action, err := a.AuthenticateRequest(makeContext("Bearer huge"))
+
+			Convey("Then I should get an error", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err, ShouldNotBeNil)
+				So(logs.All()[0].ContextMap()["error"], ShouldEqual, "introspection response exceeds 1048576 bytes")
+			})
+		})
+
+		Convey("When I use the wrong client credentials", func() {
+
+			a := NewAuthenticator(server.URL, OptClientCredentials("client", "nope"))
+			action, err := a.AuthenticateRequest(makeContext("Bearer good"))
+
+			Convey("Then I should get an error", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestAuthenticator_AuthenticateSession(t *testing.T) {
+
+	Convey("Given I have an introspection server and an authenticator", t, func() {
+
+		var calls int64
+		server := makeServer(
+			map[string]map[string]interface{}{
+				"good": {"active": true, "sub": "user-a", "groups": []string{"admins"}},
+			},
+			&calls,
+			0,
+		)
+		defer server.Close()
+
+		a := NewAuthenticator(
+			server.URL,
+			OptClientCredentials("client", "secret"),
+			OptClaimsMapper(func(r *Response) []string {
+				claims := []string{"@auth:subject=" + r.Sub}
+				for _, g := range r.Extra["groups"].([]interface{}) {
+					claims = append(claims, fmt.Sprintf("@auth:group=%s", g))
+				}
+				return claims
+			}),
+		)
+
+		Convey("When I authenticate a session with an active token", func() {
+
+			s := &mockSession{token: "good"}
+			action, err := a.AuthenticateSession(s)
+
+			Convey("Then the custom claims should be set", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionOK)
+				So(s.claims, ShouldResemble, []string{"@auth:subject=user-a", "@auth:group=admins"})
+			})
+		})
+
+		Convey("When I authenticate a session without token", func() {
+
+			action, err := a.AuthenticateSession(&mockSession{})
+
+			Convey("Then the action should be continue", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionContinue)
+			})
+		})
+	})
+}
+
+func TestAuthenticator_singleflight(t *testing.T) {
+
+	Convey("Given I have a slow introspection server and an authenticator", t, func() {
+
+		var calls int64
+		server := makeServer(
+			map[string]map[string]interface{}{"good": {"active": true}},
+			&calls,
+			100*time.Millisecond,
+		)
+		defer server.Close()
+
+		a := NewAuthenticator(server.URL, OptClientCredentials("client", "secret"))
+
+		Convey("When I authenticate the same token concurrently", func() {
+
+			var wg sync.WaitGroup
+			actions := make([]bahamut.AuthAction, 10)
+
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					actions[i], _ = a.AuthenticateRequest(makeContext("Bearer good"))
+				}(i)
+			}
+
+			wg.Wait()
+
+			Convey("Then the endpoint should be called once", func() {
+				So(atomic.LoadInt64(&calls), ShouldEqual, 1)
+				for _, action := range actions {
+					So(action, ShouldEqual, bahamut.AuthActionOK)
+				}
+			})
+		})
+	})
+}
+
+func TestAuthenticator_cache(t *testing.T) {
+
+	Convey("Given I have an authenticator with a small cache", t, func() {
+
+		a := NewAuthenticator("http://nowhere", OptCacheSize(2), OptCacheTTL(time.Minute, 10*time.Second))
+
+		Convey("When I store more entries than the size", func() {
+
+			a.store("a", cacheEntry{active: true, expiration: time.Now().Add(time.Minute)})
+			a.store("b", cacheEntry{active: true, expiration: time.Now().Add(time.Minute)})
+			a.store("c", cacheEntry{active: true, expiration: time.Now().Add(time.Minute)})
+
+			Convey("Then the cache should be bounded", func() {
+				So(len(a.cache), ShouldEqual, 2)
+				_, ok := a.cached("c")
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I make entries from responses", func() {
+
+			now := time.Now()
+			a.now = func() time.Time { return now }
+
+			short := a.makeEntry(&Response{Active: true, Exp: now.Add(30 * time.Second).Unix()})
+			long := a.makeEntry(&Response{Active: true, Exp: now.Add(time.Hour).Unix()})
+			noexp := a.makeEntry(&Response{Active: true})
+			inactive := a.makeEntry(&Response{Active: false})
+			notyet := a.makeEntry(&Response{Active: true, Nbf: now.Add(time.Hour).Unix()})
+
+			Convey("Then the expirations should be correct", func() {
+				So(short.expiration.Unix(), ShouldEqual, now.Add(30*time.Second).Unix())
+				So(long.expiration, ShouldEqual, now.Add(time.Minute))
+				So(noexp.expiration, ShouldEqual, now.Add(time.Minute))
+				So(inactive.active, ShouldBeFalse)
+				So(inactive.expiration, ShouldEqual, now.Add(10*time.Second))
+				So(notyet.active, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestResponse_audience(t *testing.T) {
+
+	Convey("Given I have responses with different audiences", t, func() {
+
+		r1 := &Response{}
+		r2 := &Response{}
+		err1 := json.Unmarshal([]byte(`{"active":true,"aud":"a"}`), r1)
+		err2 := json.Unmarshal([]byte(`{"active":true,"aud":["a","b"]}`), r2)
+
+		Convey("Then they should be decoded", func() {
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+			So(r1.Aud, ShouldResemble, audience{"a"})
+			So(r2.Aud, ShouldResemble, audience{"a", "b"})
+		})
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package introspection
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// An Option represents a configuration option
+// for the introspection Authenticator.
+type Option func(*Authenticator)
+
+// OptClientCredentials sets the client ID and secret used to
+// authenticate against the introspection endpoint using basic auth.
+func OptClientCredentials(clientID string, clientSecret string) Option {
+	return func(a *Authenticator) {
+		a.clientID = clientID
+		a.clientSecret = clientSecret
+	}
+}
+
+// OptHTTPClient sets the http.Client to use to call
+// the introspection endpoint.
+func OptHTTPClient(client *http.Client) Option {
+	return func(a *Authenticator) {
+		a.client = client
+	}
+}
+
+// OptTokenTypeHint sets the token_type_hint sent
+// to the introspection endpoint.
+func OptTokenTypeHint(hint string) Option {
+	return func(a *Authenticator) {
+		a.tokenTypeHint = hint
+	}
+}
+
+// OptCacheTTL sets how long active and inactive tokens are cached.
+//
+// Active tokens are cached until their expiration, or for at most
+// maxTTL if it is not zero. Active tokens without expiration are
+// cached for maxTTL. Inactive tokens are cached for negativeTTL.
+// The defaults are 5 minutes and 30 seconds.
+func OptCacheTTL(maxTTL time.Duration, negativeTTL time.Duration) Option {
+	return func(a *Authenticator) {
+		a.maxTTL = maxTTL
+		a.negativeTTL = negativeTTL
+	}
+}
+
+// OptCacheSize sets the maximum number of tokens kept
+// in the cache. The default is 10000.
+func OptCacheSize(size int) Option {
+	return func(a *Authenticator) {
+		a.cacheSize = size
+	}
+}
+
+// OptClaimsMapper sets the function used to convert an active
+// introspection response into bahamut claims. The default mapper
+// is DefaultClaimsMapper.
+func OptClaimsMapper(mapper ClaimsMapperFunc) Option {
+	return func(a *Authenticator) {
+		a.claimsMapper = mapper
+	}
+}
+
+// OptLogger sets the logger used to report the failures
+// of the introspection endpoint. By default, the global
+// zap logger is used.
+func OptLogger(logger *zap.Logger) Option {
+	return func(a *Authenticator) {
+		a.logger = logger
+	}
+}
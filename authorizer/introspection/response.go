@@ -0,0 +1,98 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package introspection
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// A Response is the response of an introspection
+// endpoint as described in RFC 7662.
+type Response struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       audience `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+
+	// Extra contains all the fields of the response,
+	// including the non standard ones.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// A ClaimsMapperFunc converts an active introspection response to claims.
+type ClaimsMapperFunc func(*Response) []string
+
+// DefaultClaimsMapper is the default ClaimsMapperFunc. It returns
+// the claims @auth:realm=oauth2 and, when they are set, @auth:subject,
+// @auth:username, @auth:clientid, @auth:issuer, one @auth:scope per scope
+// and one @auth:audience per audience.
+func DefaultClaimsMapper(r *Response) []string {
+
+	claims := []string{"@auth:realm=oauth2"}
+
+	if r.Sub != "" {
+		claims = append(claims, "@auth:subject="+r.Sub)
+	}
+
+	if r.Username != "" {
+		claims = append(claims, "@auth:username="+r.Username)
+	}
+
+	if r.ClientID != "" {
+		claims = append(claims, "@auth:clientid="+r.ClientID)
+	}
+
+	if r.Iss != "" {
+		claims = append(claims, "@auth:issuer="+r.Iss)
+	}
+
+	for _, s := range strings.Fields(r.Scope) {
+		claims = append(claims, "@auth:scope="+s)
+	}
+
+	for _, a := range r.Aud {
+		claims = append(claims, "@auth:audience="+a)
+	}
+
+	return claims
+}
+
+// audience handles the aud field that can either
+// be a string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*a = audience(multiple)
+
+	return nil
+}
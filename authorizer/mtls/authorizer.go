@@ -114,6 +114,7 @@ type mtlsVerifier struct {
 	deciderFunc          DeciderFunc
 	verifier             VerifierFunc
 	certificateCheckMode CertificateCheckMode
+	revocationChecker    *RevocationChecker
 }
 
 func newMTLSVerifier(
@@ -122,15 +123,22 @@ func newMTLSVerifier(
 	ignoredIdentities []elemental.Identity,
 	verifier VerifierFunc,
 	certificateCheckMode CertificateCheckMode,
+	options ...Option,
 ) *mtlsVerifier {
 
-	return &mtlsVerifier{
+	v := &mtlsVerifier{
 		verifyOptions:        verifyOptions,
 		ignoredIdentities:    ignoredIdentities,
 		deciderFunc:          deciderFunc,
 		verifier:             verifier,
 		certificateCheckMode: certificateCheckMode,
 	}
+
+	for _, opt := range options {
+		opt(v)
+	}
+
+	return v
 }
 
 // NewMTLSAuthorizer returns a new Authorizer that ensures the client certificate
@@ -138,16 +146,17 @@ func newMTLSVerifier(
 // The Authorizer will not enforce this for identities given by ignoredIdentities.
 //
 // deciderFunc is the DeciderFunc to used return the actual action you want the Authorizer
-// to return.
+// to return. Additional options, like OptRevocationChecker, can be given.
 func NewMTLSAuthorizer(
 	verifyOptions x509.VerifyOptions,
 	deciderFunc DeciderFunc,
 	ignoredIdentities []elemental.Identity,
 	certVerifier VerifierFunc,
 	certificateCheckMode CertificateCheckMode,
+	options ...Option,
 ) bahamut.Authorizer {
 
-	return newMTLSVerifier(verifyOptions, deciderFunc, ignoredIdentities, certVerifier, certificateCheckMode)
+	return newMTLSVerifier(verifyOptions, deciderFunc, ignoredIdentities, certVerifier, certificateCheckMode, options...)
 }
 
 // NewMTLSRequestAuthenticator returns a new Authenticator that ensures the client certificate
@@ -155,15 +164,16 @@ func NewMTLSAuthorizer(
 // The Authenticator will not enforce this for identities given by ignoredIdentities.
 //
 // deciderFunc is the DeciderFunc to used return the actual action you want the RequestAuthenticator
-// to return.
+// to return. Additional options, like OptRevocationChecker, can be given.
 func NewMTLSRequestAuthenticator(
 	verifyOptions x509.VerifyOptions,
 	deciderFunc DeciderFunc,
 	certVerifier VerifierFunc,
 	certificateCheckMode CertificateCheckMode,
+	options ...Option,
 ) bahamut.RequestAuthenticator {
 
-	return newMTLSVerifier(verifyOptions, deciderFunc, nil, certVerifier, certificateCheckMode)
+	return newMTLSVerifier(verifyOptions, deciderFunc, nil, certVerifier, certificateCheckMode, options...)
 }
 
 // NewMTLSSessionAuthenticator returns a new Authenticator that ensures the client certificate are
@@ -171,15 +181,16 @@ func NewMTLSRequestAuthenticator(
 // The Authenticator will not enforce this for identities given by ignoredIdentities.
 //
 // deciderFunc is the DeciderFunc to used return the actual action you want the SessionAuthenticator
-// to return.
+// to return. Additional options, like OptRevocationChecker, can be given.
 func NewMTLSSessionAuthenticator(
 	verifyOptions x509.VerifyOptions,
 	deciderFunc DeciderFunc,
 	certVerifier VerifierFunc,
 	certificateCheckMode CertificateCheckMode,
+	options ...Option,
 ) bahamut.SessionAuthenticator {
 
-	return newMTLSVerifier(verifyOptions, deciderFunc, nil, certVerifier, certificateCheckMode)
+	return newMTLSVerifier(verifyOptions, deciderFunc, nil, certVerifier, certificateCheckMode, options...)
 }
 
 func (a *mtlsVerifier) IsAuthorized(ctx bahamut.Context) (bahamut.AuthAction, error) {
@@ -216,10 +227,8 @@ func (a *mtlsVerifier) IsAuthorized(ctx bahamut.Context) (bahamut.AuthAction, er
 
 	// If we can verify, we return the success auth action.
 	for _, cert := range certs {
-		if _, err := cert.Verify(a.verifyOptions); err == nil {
-			if a.verifier == nil || a.verifier(cert) {
-				return a.deciderFunc(bahamut.AuthActionOK, ctx, nil), nil
-			}
+		if a.verifyCertificate(cert, req.TLSConnectionState) {
+			return a.deciderFunc(bahamut.AuthActionOK, ctx, nil), nil
 		}
 	}
 
@@ -273,11 +282,9 @@ func (a *mtlsVerifier) checkAction(tlsState *tls.ConnectionState, headerCert str
 
 	// If we can verify, we return the success auth action
 	for _, cert := range certs {
-		if _, err := cert.Verify(a.verifyOptions); err == nil {
-			if a.verifier == nil || a.verifier(cert) {
-				claimSetter(makeClaims(cert))
-				return bahamut.AuthActionOK, nil
-			}
+		if a.verifyCertificate(cert, tlsState) {
+			claimSetter(makeClaims(cert))
+			return bahamut.AuthActionOK, nil
 		}
 	}
 
@@ -285,6 +292,31 @@ func (a *mtlsVerifier) checkAction(tlsState *tls.ConnectionState, headerCert str
 	return bahamut.AuthActionKO, nil
 }
 
+func (a *mtlsVerifier) verifyCertificate(cert *x509.Certificate, tlsState *tls.ConnectionState) bool {
+
+	chains, err := cert.Verify(a.verifyOptions)
+	if err != nil {
+		return false
+	}
+
+	if a.revocationChecker != nil {
+
+		// The stapled response of the connection is only relevant
+		// if the certificate is the one presented during the handshake,
+		// and not one taken from the header.
+		var staple []byte
+		if tlsState != nil && len(tlsState.PeerCertificates) > 0 && tlsState.PeerCertificates[0].Equal(cert) {
+			staple = tlsState.OCSPResponse
+		}
+
+		if err := a.revocationChecker.Check(chains, staple); err != nil {
+			return false
+		}
+	}
+
+	return a.verifier == nil || a.verifier(cert)
+}
+
 func decodeCertHeader(header string) ([]*x509.Certificate, error) {
 
 	if len(header) < 54 {
@@ -24,6 +24,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/bahamut"
 	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
 )
 
 func TestBahamut_MTLSAuthorizer(t *testing.T) {
@@ -948,9 +949,11 @@ func (s *mockSession) Claims() []string                         { return s.claim
 func (s *mockSession) ClaimsMap() map[string]string             { return nil }
 func (s *mockSession) Token() string                            { return "" }
 func (s *mockSession) TLSConnectionState() *tls.ConnectionState { return s.state }
+func (s *mockSession) ClientIP() string                         { return "" }
 func (s *mockSession) Metadata() interface{}                    { return nil }
 func (s *mockSession) SetMetadata(interface{})                  {}
 func (s *mockSession) Context() context.Context                 { return context.Background() }
+func (s *mockSession) Logger() *zap.Logger                      { return zap.NewNop() }
 
 func TestBahamut_NewMTLSSessionAuthenticator(t *testing.T) {
 
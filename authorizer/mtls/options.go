@@ -0,0 +1,24 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtls
+
+// An Option represents an option of the mtls Authorizer and Authenticators.
+type Option func(*mtlsVerifier)
+
+// OptRevocationChecker sets the *RevocationChecker to use to verify that
+// the client certificate has not been revoked. Only the client certificate
+// itself is checked, not the intermediate certificates of its chain.
+func OptRevocationChecker(checker *RevocationChecker) Option {
+	return func(v *mtlsVerifier) {
+		v.revocationChecker = checker
+	}
+}
@@ -0,0 +1,498 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtls
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+const maxOCSPCacheSize = 10000
+
+// ErrCertificateRevoked is returned by RevocationChecker.Check when
+// the certificate has been revoked.
+var ErrCertificateRevoked = errors.New("certificate has been revoked")
+
+// RevocationPolicy defines how a RevocationChecker behaves when
+// the revocation status of a certificate cannot be determined.
+type RevocationPolicy int
+
+// Various values for RevocationPolicy.
+const (
+	// RevocationPolicyFailClosed rejects certificates with an unknown revocation status.
+	RevocationPolicyFailClosed RevocationPolicy = iota
+
+	// RevocationPolicyFailOpen accepts certificates with an unknown revocation status.
+	RevocationPolicyFailOpen
+)
+
+// OCSPFetcherFunc is the type of function used to retrieve the raw
+// OCSP response for the given certificate, issued by the given issuer.
+type OCSPFetcherFunc func(cert *x509.Certificate, issuer *x509.Certificate) ([]byte, error)
+
+// NewHTTPOCSPFetcher returns an OCSPFetcherFunc that queries the OCSP servers
+// declared in the certificate using the given *http.Client.
+// If client is nil, a client with a 5 seconds timeout is used.
+func NewHTTPOCSPFetcher(client *http.Client) OCSPFetcherFunc {
+
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	return func(cert *x509.Certificate, issuer *x509.Certificate) ([]byte, error) {
+
+		if len(cert.OCSPServer) == 0 {
+			return nil, errors.New("certificate does not declare any ocsp server")
+		}
+
+		req, err := ocsp.CreateRequest(cert, issuer, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create ocsp request: %s", err)
+		}
+
+		var lastErr error
+		for _, server := range cert.OCSPServer {
+
+			resp, err := client.Post(server, "application/ocsp-request", bytes.NewReader(req))
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+			resp.Body.Close() // nolint: errcheck
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				lastErr = fmt.Errorf("unexpected status code %d from ocsp server %s", resp.StatusCode, server)
+				continue
+			}
+
+			return data, nil
+		}
+
+		return nil, lastErr
+	}
+}
+
+type revocationStatus int
+
+const (
+	revocationStatusUnknown revocationStatus = iota
+	revocationStatusGood
+	revocationStatusRevoked
+)
+
+type ocspCacheEntry struct {
+	status     revocationStatus
+	expiration time.Time
+}
+
+// A RevocationChecker checks if a certificate has been revoked
+// using CRLs loaded from files and/or OCSP.
+//
+// CRLs are consulted first. If they cannot give a definitive answer,
+// OCSP is used, starting with the stapled response if any. If the revocation
+// status is still unknown, the RevocationPolicy decides the outcome.
+//
+// A single RevocationChecker can be shared between several authorizers
+// and authenticators so they use the same caches.
+type RevocationChecker struct {
+	crlFiles          []string
+	crlReloadInterval time.Duration
+	ocspEnabled       bool
+	ocspFetcher       OCSPFetcherFunc
+	cacheTTL          time.Duration
+	failureCacheTTL   time.Duration
+	policy            RevocationPolicy
+
+	crls          map[string][]*x509.RevocationList
+	crlSignatures map[string]string
+	crlLastCheck  time.Time
+	crlLock       sync.RWMutex
+
+	ocspCache     map[string]ocspCacheEntry
+	ocspCacheLock sync.RWMutex
+
+	now func() time.Time
+}
+
+// NewRevocationChecker returns a new *RevocationChecker configured
+// with the given options. It returns an error if the configured CRLs
+// cannot be loaded or if no revocation source is configured.
+func NewRevocationChecker(options ...RevocationOption) (*RevocationChecker, error) {
+
+	c := &RevocationChecker{
+		crlReloadInterval: time.Minute,
+		cacheTTL:          time.Hour,
+		failureCacheTTL:   time.Minute,
+		policy:            RevocationPolicyFailClosed,
+		crls:              map[string][]*x509.RevocationList{},
+		crlSignatures:     map[string]string{},
+		ocspCache:         map[string]ocspCacheEntry{},
+		now:               time.Now,
+	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	if len(c.crlFiles) == 0 && !c.ocspEnabled {
+		return nil, errors.New("no revocation source configured. Use RevocationOptCRLFiles and/or RevocationOptOCSP")
+	}
+
+	if c.ocspEnabled && c.ocspFetcher == nil {
+		c.ocspFetcher = NewHTTPOCSPFetcher(nil)
+	}
+
+	if len(c.crlFiles) > 0 {
+
+		crls, signatures, err := loadCRLs(c.crlFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		c.crls = crls
+		c.crlSignatures = signatures
+		c.crlLastCheck = c.now()
+	}
+
+	return c, nil
+}
+
+// Check verifies the revocation status of the leaf certificate of the given
+// verified chains, as returned by x509.Certificate.Verify. The intermediate
+// certificates of the chains are not checked. The staple is the eventual raw
+// OCSP response provided by the peer for the leaf certificate.
+//
+// It returns ErrCertificateRevoked if the certificate has been revoked. If
+// the status cannot be determined, it returns an error when the policy is
+// RevocationPolicyFailClosed, and nil when the policy is RevocationPolicyFailOpen.
+func (c *RevocationChecker) Check(chains [][]*x509.Certificate, staple []byte) error {
+
+	var cert, issuer *x509.Certificate
+	for _, chain := range chains {
+		if len(chain) >= 2 {
+			cert, issuer = chain[0], chain[1]
+			break
+		}
+	}
+
+	// The certificate is directly trusted.
+	if cert == nil {
+		return nil
+	}
+
+	status := revocationStatusUnknown
+
+	if len(c.crlFiles) > 0 {
+		status = c.crlStatus(cert, issuer)
+	}
+
+	if status == revocationStatusUnknown && c.ocspEnabled {
+		status = c.ocspStatus(cert, issuer, staple)
+	}
+
+	switch status {
+
+	case revocationStatusGood:
+		return nil
+
+	case revocationStatusRevoked:
+		return ErrCertificateRevoked
+
+	default:
+		if c.policy == RevocationPolicyFailOpen {
+			return nil
+		}
+		return fmt.Errorf("unable to determine revocation status of certificate %s", cert.SerialNumber)
+	}
+}
+
+func (c *RevocationChecker) crlStatus(cert *x509.Certificate, issuer *x509.Certificate) revocationStatus {
+
+	c.reloadCRLsIfNeeded()
+
+	c.crlLock.RLock()
+	defer c.crlLock.RUnlock()
+
+	now := c.now()
+	status := revocationStatusUnknown
+
+	for _, crl := range c.crls[string(cert.RawIssuer)] {
+
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+
+		// A stale CRL cannot be trusted to know about recent revocations.
+		if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) {
+			continue
+		}
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return revocationStatusRevoked
+			}
+		}
+
+		status = revocationStatusGood
+	}
+
+	return status
+}
+
+func (c *RevocationChecker) reloadCRLsIfNeeded() {
+
+	c.crlLock.RLock()
+	lastCheck := c.crlLastCheck
+	c.crlLock.RUnlock()
+
+	if c.now().Sub(lastCheck) < c.crlReloadInterval {
+		return
+	}
+
+	c.crlLock.Lock()
+	defer c.crlLock.Unlock()
+
+	c.crlLastCheck = c.now()
+
+	signatures, err := statCRLs(c.crlFiles)
+	if err != nil {
+		zap.L().Error("Unable to stat CRL files. Keeping previous CRLs", zap.Error(err))
+		return
+	}
+
+	if equalSignatures(signatures, c.crlSignatures) {
+		return
+	}
+
+	crls, signatures, err := loadCRLs(c.crlFiles)
+	if err != nil {
+		zap.L().Error("Unable to reload CRL files. Keeping previous CRLs", zap.Error(err))
+		return
+	}
+
+	c.crls = crls
+	c.crlSignatures = signatures
+
+	zap.L().Info("CRLs reloaded", zap.Strings("files", c.crlFiles))
+}
+
+func (c *RevocationChecker) ocspStatus(cert *x509.Certificate, issuer *x509.Certificate, staple []byte) revocationStatus {
+
+	now := c.now()
+
+	if len(staple) > 0 {
+		if status, _, err := parseOCSPResponse(staple, cert, issuer, now); err == nil && status != revocationStatusUnknown {
+			return status
+		}
+	}
+
+	key := string(cert.RawIssuer) + cert.SerialNumber.String()
+
+	c.ocspCacheLock.RLock()
+	entry, ok := c.ocspCache[key]
+	c.ocspCacheLock.RUnlock()
+
+	if ok && now.Before(entry.expiration) {
+		return entry.status
+	}
+
+	status := revocationStatusUnknown
+	expiration := now.Add(c.failureCacheTTL)
+
+	data, err := c.ocspFetcher(cert, issuer)
+	if err == nil {
+		var nextUpdate time.Time
+		status, nextUpdate, err = parseOCSPResponse(data, cert, issuer, now)
+		if err == nil && status != revocationStatusUnknown {
+			expiration = now.Add(c.cacheTTL)
+			if !nextUpdate.IsZero() && nextUpdate.Before(expiration) {
+				expiration = nextUpdate
+			}
+		}
+	}
+
+	if err != nil {
+		zap.L().Debug("Unable to retrieve ocsp status",
+			zap.String("serial", cert.SerialNumber.String()),
+			zap.Error(err),
+		)
+	}
+
+	c.ocspCacheLock.Lock()
+	if _, ok := c.ocspCache[key]; !ok && len(c.ocspCache) >= maxOCSPCacheSize {
+		c.evictOCSPCacheEntries(now)
+	}
+	c.ocspCache[key] = ocspCacheEntry{status: status, expiration: expiration}
+	c.ocspCacheLock.Unlock()
+
+	return status
+}
+
+// evictOCSPCacheEntries removes the expired entries of the ocsp cache.
+// If none has expired, a random entry is removed so the cache never
+// grows over maxOCSPCacheSize. The caller must hold ocspCacheLock.
+func (c *RevocationChecker) evictOCSPCacheEntries(now time.Time) {
+
+	for k, e := range c.ocspCache {
+		if !now.Before(e.expiration) {
+			delete(c.ocspCache, k)
+		}
+	}
+
+	if len(c.ocspCache) < maxOCSPCacheSize {
+		return
+	}
+
+	// Map iteration order is random.
+	for k := range c.ocspCache {
+		delete(c.ocspCache, k)
+		break
+	}
+}
+
+func parseOCSPResponse(data []byte, cert *x509.Certificate, issuer *x509.Certificate, now time.Time) (revocationStatus, time.Time, error) {
+
+	resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+	if err != nil {
+		return revocationStatusUnknown, time.Time{}, err
+	}
+
+	if !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate) {
+		return revocationStatusUnknown, time.Time{}, errors.New("ocsp response has expired")
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return revocationStatusGood, resp.NextUpdate, nil
+	case ocsp.Revoked:
+		return revocationStatusRevoked, resp.NextUpdate, nil
+	default:
+		return revocationStatusUnknown, resp.NextUpdate, nil
+	}
+}
+
+func loadCRLs(paths []string) (map[string][]*x509.RevocationList, map[string]string, error) {
+
+	signatures, err := statCRLs(paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crls := map[string][]*x509.RevocationList{}
+
+	for _, path := range paths {
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read crl file '%s': %s", path, err)
+		}
+
+		lists, err := parseCRLs(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse crl file '%s': %s", path, err)
+		}
+
+		for _, crl := range lists {
+			crls[string(crl.RawIssuer)] = append(crls[string(crl.RawIssuer)], crl)
+		}
+	}
+
+	return crls, signatures, nil
+}
+
+func parseCRLs(data []byte) ([]*x509.RevocationList, error) {
+
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+
+	var crls []*x509.RevocationList
+	var block *pem.Block
+	rest := data
+
+	for {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "X509 CRL" {
+			continue
+		}
+
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+
+	if len(crls) == 0 {
+		return nil, errors.New("no valid crl found")
+	}
+
+	return crls, nil
+}
+
+func statCRLs(paths []string) (map[string]string, error) {
+
+	signatures := make(map[string]string, len(paths))
+
+	for _, path := range paths {
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		signatures[path] = fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	}
+
+	return signatures, nil
+}
+
+func equalSignatures(a map[string]string, b map[string]string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtls
+
+import "time"
+
+// A RevocationOption represents an option of the RevocationChecker.
+type RevocationOption func(*RevocationChecker)
+
+// RevocationOptCRLFiles sets the list of files containing the CRLs
+// to use. Files can contain DER or PEM encoded CRLs. They are
+// reloaded when they change on disk.
+func RevocationOptCRLFiles(paths ...string) RevocationOption {
+	return func(c *RevocationChecker) {
+		c.crlFiles = paths
+	}
+}
+
+// RevocationOptCRLReloadInterval sets the minimum interval between two
+// checks for changes of the CRL files. The default is one minute.
+func RevocationOptCRLReloadInterval(interval time.Duration) RevocationOption {
+	return func(c *RevocationChecker) {
+		c.crlReloadInterval = interval
+	}
+}
+
+// RevocationOptOCSP enables OCSP checking. The given OCSPFetcherFunc
+// will be used to retrieve the OCSP responses when the client did not staple
+// any. If fetcher is nil, the OCSP servers declared in the certificates
+// are queried over HTTP.
+func RevocationOptOCSP(fetcher OCSPFetcherFunc) RevocationOption {
+	return func(c *RevocationChecker) {
+		c.ocspEnabled = true
+		c.ocspFetcher = fetcher
+	}
+}
+
+// RevocationOptCacheTTL sets how long OCSP results are cached.
+//
+// ttl applies to definitive answers and is capped by the NextUpdate of the
+// OCSP response. The default is one hour. failureTTL applies when the status
+// could not be retrieved. The default is one minute.
+func RevocationOptCacheTTL(ttl time.Duration, failureTTL time.Duration) RevocationOption {
+	return func(c *RevocationChecker) {
+		c.cacheTTL = ttl
+		c.failureCacheTTL = failureTTL
+	}
+}
+
+// RevocationOptPolicy sets the RevocationPolicy to apply when the revocation
+// status of a certificate cannot be determined. The default is RevocationPolicyFailClosed.
+func RevocationOptPolicy(policy RevocationPolicy) RevocationOption {
+	return func(c *RevocationChecker) {
+		c.policy = policy
+	}
+}
@@ -0,0 +1,578 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtls
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	"golang.org/x/crypto/ocsp"
+)
+
+func loadCertificate(path string) *x509.Certificate {
+
+	data, _ := ioutil.ReadFile(path)
+	block, _ := pem.Decode(data)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		panic(err)
+	}
+
+	return cert
+}
+
+func loadECKey(path string) crypto.Signer {
+
+	data, _ := ioutil.ReadFile(path)
+	block, _ := pem.Decode(data)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+func makeCRL(issuer *x509.Certificate, key crypto.Signer, nextUpdate time.Time, revoked ...*big.Int) []byte {
+
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	}
+
+	for _, serial := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+
+	// The fixtures have no subject key identifier, which is required to create a crl.
+	signer := *issuer
+	if len(signer.SubjectKeyId) == 0 {
+		signer.SubjectKeyId = []byte{1}
+	}
+
+	data, err := x509.CreateRevocationList(rand.Reader, tmpl, &signer, key)
+	if err != nil {
+		panic(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: data})
+}
+
+func makeOCSPResponse(issuer *x509.Certificate, key crypto.Signer, cert *x509.Certificate, status int) []byte {
+
+	data, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, key)
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+func TestRevocation_NewRevocationChecker(t *testing.T) {
+
+	Convey("Given I create a RevocationChecker with no source", t, func() {
+
+		c, err := NewRevocationChecker()
+
+		Convey("Then err should not be nil", func() {
+			So(err, ShouldNotBeNil)
+			So(c, ShouldBeNil)
+		})
+	})
+
+	Convey("Given I create a RevocationChecker with a missing crl file", t, func() {
+
+		c, err := NewRevocationChecker(RevocationOptCRLFiles("./fixtures/not-here.pem"))
+
+		Convey("Then err should not be nil", func() {
+			So(err, ShouldNotBeNil)
+			So(c, ShouldBeNil)
+		})
+	})
+
+	Convey("Given I create a RevocationChecker with an invalid crl file", t, func() {
+
+		c, err := NewRevocationChecker(RevocationOptCRLFiles("./fixtures/user-a-cert.pem"))
+
+		Convey("Then err should not be nil", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unable to parse crl file './fixtures/user-a-cert.pem': no valid crl found")
+			So(c, ShouldBeNil)
+		})
+	})
+
+	Convey("Given I create a RevocationChecker with ocsp and no fetcher", t, func() {
+
+		c, err := NewRevocationChecker(RevocationOptOCSP(nil))
+
+		Convey("Then err should be nil", func() {
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Then the default fetcher should be set", func() {
+			So(c.ocspFetcher, ShouldNotBeNil)
+			So(c.policy, ShouldEqual, RevocationPolicyFailClosed)
+			So(c.cacheTTL, ShouldEqual, time.Hour)
+			So(c.failureCacheTTL, ShouldEqual, time.Minute)
+		})
+	})
+}
+
+func TestRevocation_CRL(t *testing.T) {
+
+	Convey("Given I have a signer, a user certificate and a crl file", t, func() {
+
+		caChainAData, _ := ioutil.ReadFile("./fixtures/ca-chain-a.pem")
+		certPoolA := x509.NewCertPool()
+		certPoolA.AppendCertsFromPEM(caChainAData)
+
+		signer := loadCertificate("./fixtures/ca-signer-a-cert.pem")
+		signerKey := loadECKey("./fixtures/ca-signer-a-key.pem")
+		userCertA := loadCertificate("./fixtures/user-a-cert.pem")
+		serverCertA := loadCertificate("./fixtures/server-a-cert.pem")
+		userCertB := loadCertificate("./fixtures/user-b-cert.pem")
+
+		chainsA, err := userCertA.Verify(x509.VerifyOptions{Roots: certPoolA, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		if err != nil {
+			panic(err)
+		}
+
+		tmp, _ := ioutil.TempDir("", "crl")
+		defer os.RemoveAll(tmp) // nolint: errcheck
+
+		crlPath := filepath.Join(tmp, "crl.pem")
+		if err := ioutil.WriteFile(crlPath, makeCRL(signer, signerKey, time.Now().Add(time.Hour), serverCertA.SerialNumber), 0600); err != nil {
+			panic(err)
+		}
+
+		c, err := NewRevocationChecker(
+			RevocationOptCRLFiles(crlPath),
+			RevocationOptCRLReloadInterval(0),
+		)
+		if err != nil {
+			panic(err)
+		}
+
+		Convey("When I check a certificate that is not revoked", func() {
+
+			err := c.Check(chainsA, nil)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I check a certificate whose issuer has no crl", func() {
+
+			caChainBData, _ := ioutil.ReadFile("./fixtures/ca-chain-b.pem")
+			certPoolB := x509.NewCertPool()
+			certPoolB.AppendCertsFromPEM(caChainBData)
+
+			chainsB, _ := userCertB.Verify(x509.VerifyOptions{Roots: certPoolB, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+
+			Convey("Then it should fail when the policy is fail closed", func() {
+				So(c.Check(chainsB, nil), ShouldNotBeNil)
+			})
+
+			Convey("Then it should pass when the policy is fail open", func() {
+				c.policy = RevocationPolicyFailOpen
+				So(c.Check(chainsB, nil), ShouldBeNil)
+			})
+		})
+
+		Convey("When I revoke the certificate in the crl file", func() {
+
+			if err := ioutil.WriteFile(crlPath, makeCRL(signer, signerKey, time.Now().Add(time.Hour), userCertA.SerialNumber), 0600); err != nil {
+				panic(err)
+			}
+
+			// make sure the modification time changes.
+			future := time.Now().Add(time.Minute)
+			if err := os.Chtimes(crlPath, future, future); err != nil {
+				panic(err)
+			}
+
+			err := c.Check(chainsA, nil)
+
+			Convey("Then err should be ErrCertificateRevoked", func() {
+				So(err, ShouldEqual, ErrCertificateRevoked)
+			})
+		})
+
+		Convey("When I replace the crl file by an invalid one", func() {
+
+			if err := ioutil.WriteFile(crlPath, []byte("not a crl"), 0600); err != nil {
+				panic(err)
+			}
+
+			future := time.Now().Add(time.Minute)
+			if err := os.Chtimes(crlPath, future, future); err != nil {
+				panic(err)
+			}
+
+			err := c.Check(chainsA, nil)
+
+			Convey("Then the previous crl should still be used", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the crl is stale", func() {
+
+			c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+			err := c.Check(chainsA, nil)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err, ShouldNotEqual, ErrCertificateRevoked)
+			})
+		})
+	})
+}
+
+func TestRevocation_OCSP(t *testing.T) {
+
+	Convey("Given I have a signer and a user certificate", t, func() {
+
+		caChainAData, _ := ioutil.ReadFile("./fixtures/ca-chain-a.pem")
+		certPoolA := x509.NewCertPool()
+		certPoolA.AppendCertsFromPEM(caChainAData)
+
+		signer := loadCertificate("./fixtures/ca-signer-a-cert.pem")
+		signerKey := loadECKey("./fixtures/ca-signer-a-key.pem")
+		userCertA := loadCertificate("./fixtures/user-a-cert.pem")
+
+		chainsA, err := userCertA.Verify(x509.VerifyOptions{Roots: certPoolA, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		if err != nil {
+			panic(err)
+		}
+
+		var calls int
+		var lock sync.Mutex
+		status := ocsp.Good
+		var fetchErr error
+
+		fetcher := func(cert *x509.Certificate, issuer *x509.Certificate) ([]byte, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			calls++
+			if fetchErr != nil {
+				return nil, fetchErr
+			}
+			return makeOCSPResponse(issuer, signerKey, cert, status), nil
+		}
+
+		Convey("When I check a good certificate twice", func() {
+
+			c, _ := NewRevocationChecker(RevocationOptOCSP(fetcher))
+
+			err1 := c.Check(chainsA, nil)
+			err2 := c.Check(chainsA, nil)
+
+			Convey("Then errors should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the fetcher should have been called once", func() {
+				So(calls, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I check a revoked certificate", func() {
+
+			status = ocsp.Revoked
+			c, _ := NewRevocationChecker(RevocationOptOCSP(fetcher))
+
+			err := c.Check(chainsA, nil)
+
+			Convey("Then err should be ErrCertificateRevoked", func() {
+				So(err, ShouldEqual, ErrCertificateRevoked)
+			})
+		})
+
+		Convey("When I check a certificate with a valid stapled response", func() {
+
+			c, _ := NewRevocationChecker(RevocationOptOCSP(fetcher))
+
+			err := c.Check(chainsA, makeOCSPResponse(signer, signerKey, userCertA, ocsp.Revoked))
+
+			Convey("Then err should be ErrCertificateRevoked", func() {
+				So(err, ShouldEqual, ErrCertificateRevoked)
+			})
+
+			Convey("Then the fetcher should not have been called", func() {
+				So(calls, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I check a certificate with an invalid stapled response", func() {
+
+			c, _ := NewRevocationChecker(RevocationOptOCSP(fetcher))
+
+			err := c.Check(chainsA, []byte("nope"))
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the fetcher should have been called", func() {
+				So(calls, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the fetcher fails and the policy is fail closed", func() {
+
+			fetchErr = errors.New("boom")
+			c, _ := NewRevocationChecker(RevocationOptOCSP(fetcher))
+
+			err1 := c.Check(chainsA, nil)
+			err2 := c.Check(chainsA, nil)
+
+			Convey("Then errors should not be nil", func() {
+				So(err1, ShouldNotBeNil)
+				So(err2, ShouldNotBeNil)
+			})
+
+			Convey("Then the failure should have been cached", func() {
+				So(calls, ShouldEqual, 1)
+			})
+
+			Convey("When the failure cache expires", func() {
+
+				fetchErr = nil
+				c.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+				err := c.Check(chainsA, nil)
+
+				Convey("Then err should be nil", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("Then the fetcher should have been called again", func() {
+					So(calls, ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("When the fetcher fails and the policy is fail open", func() {
+
+			fetchErr = errors.New("boom")
+			c, _ := NewRevocationChecker(
+				RevocationOptOCSP(fetcher),
+				RevocationOptPolicy(RevocationPolicyFailOpen),
+			)
+
+			err := c.Check(chainsA, nil)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestRevocation_OCSPCacheSize(t *testing.T) {
+
+	Convey("Given I have a RevocationChecker with a full ocsp cache", t, func() {
+
+		now := time.Now()
+		c := &RevocationChecker{ocspCache: map[string]ocspCacheEntry{}}
+
+		for i := 0; i < maxOCSPCacheSize; i++ {
+			c.ocspCache[fmt.Sprintf("key-%d", i)] = ocspCacheEntry{expiration: now.Add(time.Hour)}
+		}
+
+		Convey("When no entry has expired and I evict entries", func() {
+
+			c.evictOCSPCacheEntries(now)
+
+			Convey("Then one entry should have been removed", func() {
+				So(len(c.ocspCache), ShouldEqual, maxOCSPCacheSize-1)
+			})
+		})
+
+		Convey("When some entries have expired and I evict entries", func() {
+
+			c.ocspCache["key-1"] = ocspCacheEntry{expiration: now.Add(-time.Second)}
+			c.ocspCache["key-2"] = ocspCacheEntry{expiration: now.Add(-time.Second)}
+
+			c.evictOCSPCacheEntries(now)
+
+			Convey("Then only the expired entries should have been removed", func() {
+				So(len(c.ocspCache), ShouldEqual, maxOCSPCacheSize-2)
+				So(c.ocspCache, ShouldNotContainKey, "key-1")
+				So(c.ocspCache, ShouldNotContainKey, "key-2")
+			})
+		})
+	})
+}
+
+func TestRevocation_HTTPOCSPFetcher(t *testing.T) {
+
+	Convey("Given I have a local ocsp responder", t, func() {
+
+		signer := loadCertificate("./fixtures/ca-signer-a-cert.pem")
+		signerKey := loadECKey("./fixtures/ca-signer-a-key.pem")
+		userCertA := loadCertificate("./fixtures/user-a-cert.pem")
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			data, _ := ioutil.ReadAll(r.Body)
+			req, err := ocsp.ParseRequest(data)
+			if err != nil || req.SerialNumber.Cmp(userCertA.SerialNumber) != 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/ocsp-response")
+			w.Write(makeOCSPResponse(signer, signerKey, userCertA, ocsp.Revoked)) // nolint: errcheck
+		}))
+		defer ts.Close()
+
+		fetcher := NewHTTPOCSPFetcher(ts.Client())
+
+		Convey("When I fetch the status of a certificate declaring the responder", func() {
+
+			cert := *userCertA
+			cert.OCSPServer = []string{ts.URL}
+
+			data, err := fetcher(&cert, signer)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the response should say revoked", func() {
+				resp, err := ocsp.ParseResponseForCert(data, &cert, signer)
+				So(err, ShouldBeNil)
+				So(resp.Status, ShouldEqual, ocsp.Revoked)
+			})
+		})
+
+		Convey("When I fetch the status of a certificate declaring no responder", func() {
+
+			cert := *userCertA
+			cert.OCSPServer = nil
+
+			_, err := fetcher(&cert, signer)
+
+			Convey("Then err should not be nil", func() {
+				So(err.Error(), ShouldEqual, "certificate does not declare any ocsp server")
+			})
+		})
+
+		Convey("When the responder returns an error", func() {
+
+			cert := *loadCertificate("./fixtures/user-b-cert.pem")
+			cert.OCSPServer = []string{ts.URL}
+
+			_, err := fetcher(&cert, signer)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestRevocation_Verifier(t *testing.T) {
+
+	Convey("Given I have a revoked certificate and an authorizer with a revocation checker", t, func() {
+
+		caChainAData, _ := ioutil.ReadFile("./fixtures/ca-chain-a.pem")
+		certPoolA := x509.NewCertPool()
+		certPoolA.AppendCertsFromPEM(caChainAData)
+
+		signerKey := loadECKey("./fixtures/ca-signer-a-key.pem")
+		userCertA := loadCertificate("./fixtures/user-a-cert.pem")
+
+		checker, _ := NewRevocationChecker(
+			RevocationOptOCSP(func(cert *x509.Certificate, issuer *x509.Certificate) ([]byte, error) {
+				return makeOCSPResponse(issuer, signerKey, cert, ocsp.Revoked), nil
+			}),
+		)
+
+		opts := x509.VerifyOptions{
+			Roots:     certPoolA,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+
+		decider := func(a bahamut.AuthAction, c bahamut.Context, s bahamut.Session) bahamut.AuthAction { return a }
+
+		tlsState := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{userCertA}}
+
+		Convey("When I call IsAuthorized", func() {
+
+			auth := NewMTLSAuthorizer(opts, decider, nil, nil, CertificateCheckModeTLSStateOnly, OptRevocationChecker(checker))
+			action, err := auth.IsAuthorized(bahamut.NewContext(context.TODO(), &elemental.Request{TLSConnectionState: tlsState}))
+
+			Convey("Then action should be AuthActionKO", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+			})
+		})
+
+		Convey("When I call AuthenticateRequest", func() {
+
+			auth := NewMTLSRequestAuthenticator(opts, decider, nil, CertificateCheckModeTLSStateOnly, OptRevocationChecker(checker))
+			ctx := bahamut.NewContext(context.TODO(), &elemental.Request{TLSConnectionState: tlsState})
+			action, err := auth.AuthenticateRequest(ctx)
+
+			Convey("Then action should be AuthActionKO", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(ctx.Claims(), ShouldBeNil)
+			})
+		})
+
+		Convey("When I call AuthenticateSession", func() {
+
+			auth := NewMTLSSessionAuthenticator(opts, decider, nil, CertificateCheckModeTLSStateOnly, OptRevocationChecker(checker))
+			action, err := auth.AuthenticateSession(&mockSession{state: tlsState})
+
+			Convey("Then action should be AuthActionKO", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+			})
+		})
+	})
+}
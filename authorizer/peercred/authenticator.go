@@ -0,0 +1,81 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package peercred
+
+import (
+	"strconv"
+
+	"go.aporeto.io/bahamut"
+)
+
+// A Authenticator is a bahamut.RequestAuthenticator compliant structure
+// that authenticates requests using the peer credentials of the unix
+// socket connection they have been received from.
+type Authenticator struct {
+	allowedUIDs map[int]struct{}
+	allowedGIDs map[int]struct{}
+}
+
+// NewRequestAuthenticator returns a new *Authenticator.
+//
+// Requests that did not come from a unix socket are left to the
+// next authenticator. Otherwise, the claims are set from the peer
+// credentials, for instance @auth:uid=1000, and the request is
+// authenticated.
+func NewRequestAuthenticator(options ...Option) *Authenticator {
+
+	a := &Authenticator{}
+
+	for _, opt := range options {
+		opt(a)
+	}
+
+	return a
+}
+
+// AuthenticateRequest authenticates the request from the given bahamut.Context.
+func (a *Authenticator) AuthenticateRequest(ctx bahamut.Context) (bahamut.AuthAction, error) {
+
+	creds, ok := bahamut.PeerCredentialsFromRequest(ctx.Request())
+	if !ok {
+		return bahamut.AuthActionContinue, nil
+	}
+
+	if !allowed(a.allowedUIDs, creds.UID) || !allowed(a.allowedGIDs, creds.GID) {
+		return bahamut.AuthActionKO, nil
+	}
+
+	ctx.SetClaims(makeClaims(creds))
+
+	return bahamut.AuthActionOK, nil
+}
+
+func allowed(set map[int]struct{}, v int) bool {
+
+	if set == nil {
+		return true
+	}
+
+	_, ok := set[v]
+
+	return ok
+}
+
+func makeClaims(creds bahamut.PeerCredentials) []string {
+
+	return []string{
+		"@auth:realm=peercredentials",
+		"@auth:uid=" + strconv.Itoa(creds.UID),
+		"@auth:gid=" + strconv.Itoa(creds.GID),
+		"@auth:pid=" + strconv.Itoa(creds.PID),
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package peercred
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+)
+
+func makeContext(creds *bahamut.PeerCredentials) bahamut.Context {
+
+	req := elemental.NewRequest()
+	if creds != nil {
+		req.Metadata = map[string]interface{}{
+			bahamut.PeerCredentialsMetadataKey: *creds,
+		}
+	}
+
+	return bahamut.NewContext(context.Background(), req)
+}
+
+func TestAuthenticator_NewRequestAuthenticator(t *testing.T) {
+
+	Convey("Given I call NewRequestAuthenticator with options", t, func() {
+
+		a := NewRequestAuthenticator(OptAllowedUIDs(0, 1000), OptAllowedGIDs(42))
+
+		Convey("Then it should be correctly initialized", func() {
+			So(a.allowedUIDs, ShouldResemble, map[int]struct{}{0: {}, 1000: {}})
+			So(a.allowedGIDs, ShouldResemble, map[int]struct{}{42: {}})
+		})
+	})
+}
+
+func TestAuthenticator_AuthenticateRequest(t *testing.T) {
+
+	Convey("Given I have an authenticator without restriction", t, func() {
+
+		a := NewRequestAuthenticator()
+
+		Convey("When I authenticate a request without peer credentials", func() {
+
+			ctx := makeContext(nil)
+			action, err := a.AuthenticateRequest(ctx)
+
+			Convey("Then the action should be continue", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionContinue)
+				So(ctx.Claims(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I authenticate a request with peer credentials", func() {
+
+			ctx := makeContext(&bahamut.PeerCredentials{UID: 1000, GID: 100, PID: 4242})
+			action, err := a.AuthenticateRequest(ctx)
+
+			Convey("Then the action should be OK", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionOK)
+			})
+
+			Convey("Then the claims should be set", func() {
+				So(ctx.Claims(), ShouldResemble, []string{
+					"@auth:realm=peercredentials",
+					"@auth:uid=1000",
+					"@auth:gid=100",
+					"@auth:pid=4242",
+				})
+			})
+		})
+	})
+
+	Convey("Given I have an authenticator restricted to some uids and gids", t, func() {
+
+		a := NewRequestAuthenticator(OptAllowedUIDs(0, 1000), OptAllowedGIDs(100))
+
+		Convey("When I authenticate a request with allowed credentials", func() {
+
+			action, err := a.AuthenticateRequest(makeContext(&bahamut.PeerCredentials{UID: 1000, GID: 100}))
+
+			Convey("Then the action should be OK", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionOK)
+			})
+		})
+
+		Convey("When I authenticate a request with a forbidden uid", func() {
+
+			ctx := makeContext(&bahamut.PeerCredentials{UID: 1001, GID: 100})
+			action, err := a.AuthenticateRequest(ctx)
+
+			Convey("Then the action should be KO", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(ctx.Claims(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I authenticate a request with a forbidden gid", func() {
+
+			action, err := a.AuthenticateRequest(makeContext(&bahamut.PeerCredentials{UID: 0, GID: 0}))
+
+			Convey("Then the action should be KO", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+			})
+		})
+	})
+}
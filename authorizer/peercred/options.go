@@ -0,0 +1,42 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package peercred
+
+// An Option represents a configuration option
+// for the peer credentials authenticator.
+type Option func(*Authenticator)
+
+// OptAllowedUIDs restricts the authentication to clients running
+// with one of the given uids. By default, all uids are accepted.
+func OptAllowedUIDs(uids ...int) Option {
+	return func(a *Authenticator) {
+		a.allowedUIDs = makeSet(uids)
+	}
+}
+
+// OptAllowedGIDs restricts the authentication to clients running
+// with one of the given gids. By default, all gids are accepted.
+func OptAllowedGIDs(gids ...int) Option {
+	return func(a *Authenticator) {
+		a.allowedGIDs = makeSet(gids)
+	}
+}
+
+func makeSet(values []int) map[int]struct{} {
+
+	set := make(map[int]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+
+	return set
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+)
+
+// A Key holds the secret of a key ID and
+// the claims given to the requests signed with it.
+type Key struct {
+	Secret []byte
+	Claims []string
+}
+
+// An Authenticator is a bahamut.RequestAuthenticator compliant structure
+// that authenticates requests signed using Sign.
+type Authenticator struct {
+	keys            map[string]Key
+	maxClockSkew    time.Duration
+	nonceCacheSize  int
+	requiredHeaders []string
+	nonces          *nonceCache
+	now             func() time.Time
+}
+
+// NewAuthenticator returns a new *Authenticator using the given keys,
+// indexed by key ID.
+//
+// Requests without an Authorization header using the signature scheme
+// are left to the next authenticator. Requests with an invalid signature,
+// an unknown key ID, a stale timestamp or a reused nonce are rejected.
+// Otherwise, the request gets the claims of the key, in addition to
+// @auth:realm=hmac and @auth:keyid=<id>.
+//
+// It panics if the nonce cache size set with OptNonceCacheSize is lower than 1.
+func NewAuthenticator(keys map[string]Key, options ...Option) *Authenticator {
+
+	a := &Authenticator{
+		keys:           keys,
+		maxClockSkew:   5 * time.Minute,
+		nonceCacheSize: 100000,
+		now:            time.Now,
+	}
+
+	for _, opt := range options {
+		opt(a)
+	}
+
+	if a.nonceCacheSize < 1 {
+		panic(fmt.Sprintf("invalid nonce cache size %d: it must be at least 1", a.nonceCacheSize))
+	}
+
+	a.requiredHeaders = normalizeHeaders(append(append([]string{}, a.requiredHeaders...), DateHeader, NonceHeader))
+	a.nonces = newNonceCache(a.nonceCacheSize)
+
+	return a
+}
+
+// AuthenticateRequest authenticates the request from the given bahamut.Context.
+func (a *Authenticator) AuthenticateRequest(ctx bahamut.Context) (bahamut.AuthAction, error) {
+
+	if !IsSigned(ctx) {
+		return bahamut.AuthActionContinue, nil
+	}
+
+	req := ctx.Request()
+	auth := req.Headers.Get("Authorization")
+
+	keyID, signedHeaders, signature, ok := parseAuthorization(strings.TrimPrefix(auth, Scheme+" "))
+	if !ok {
+		return bahamut.AuthActionKO, makeError("Invalid authorization header")
+	}
+
+	key, ok := a.keys[keyID]
+	if !ok {
+		return bahamut.AuthActionKO, makeError("Unknown key ID")
+	}
+
+	for _, h := range a.requiredHeaders {
+		if !containsString(signedHeaders, h) {
+			return bahamut.AuthActionKO, makeError("Header '" + h + "' must be signed")
+		}
+	}
+
+	date := req.Headers.Get(DateHeader)
+	ts, err := time.Parse(DateFormat, date)
+	if err != nil {
+		return bahamut.AuthActionKO, makeError("Invalid date header")
+	}
+
+	now := a.now()
+	if ts.Before(now.Add(-a.maxClockSkew)) || ts.After(now.Add(a.maxClockSkew)) {
+		return bahamut.AuthActionKO, makeError("Request timestamp is too old or too far in the future")
+	}
+
+	nonce := req.Headers.Get(NonceHeader)
+	if nonce == "" {
+		return bahamut.AuthActionKO, makeError("Missing nonce header")
+	}
+
+	method, _ := req.Metadata[bahamut.HTTPMethodMetadataKey].(string)
+	rawURL, _ := req.Metadata[bahamut.HTTPURLMetadataKey].(string)
+	host, _ := req.Metadata[bahamut.HTTPHostMetadataKey].(string)
+
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil || method == "" {
+		return bahamut.AuthActionKO, makeError("Unable to retrieve request URL")
+	}
+
+	expected := computeSignature(
+		key.Secret,
+		canonicalRequest(method, u, req.Headers, host, signedHeaders, date, nonce, req.Data),
+	)
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return bahamut.AuthActionKO, makeError("Invalid signature")
+	}
+
+	// The nonce is only recorded once the signature is verified, so
+	// unauthenticated clients cannot fill the cache.
+	switch a.nonces.add(keyID+":"+nonce, ts.Add(a.maxClockSkew), now) {
+	case errNonceUsed:
+		return bahamut.AuthActionKO, makeError("Nonce has already been used")
+	case errNonceCacheFull:
+		return bahamut.AuthActionKO, elemental.NewError(
+			"Too Many Requests",
+			"Too many signed requests. Try again later",
+			"bahamut",
+			http.StatusTooManyRequests,
+		)
+	}
+
+	claims := append([]string{"@auth:realm=hmac", "@auth:keyid=" + keyID}, key.Claims...)
+	ctx.SetClaims(claims)
+
+	return bahamut.AuthActionOK, nil
+}
+
+// IsSigned returns true if the request of the given bahamut.Context
+// is signed using the signature scheme. It can be given to the
+// OptSkip option of the authorizer/cache package, so the signed
+// requests are never cached and their nonces are always checked.
+func IsSigned(ctx bahamut.Context) bool {
+
+	return strings.HasPrefix(ctx.Request().Headers.Get("Authorization"), Scheme+" ")
+}
+
+func parseAuthorization(value string) (keyID string, signedHeaders []string, signature string, ok bool) {
+
+	for _, part := range strings.Split(value, ",") {
+
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return "", nil, "", false
+		}
+
+		switch kv[0] {
+		case "KeyID":
+			keyID = kv[1]
+		case "SignedHeaders":
+			signedHeaders = strings.Split(kv[1], ";")
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+
+	if keyID == "" || signature == "" || len(signedHeaders) == 0 {
+		return "", nil, "", false
+	}
+
+	// The signed headers must be given in their canonical form
+	// as it is what the signer used.
+	normalized := normalizeHeaders(signedHeaders)
+	if strings.Join(normalized, ";") != strings.Join(signedHeaders, ";") {
+		return "", nil, "", false
+	}
+
+	return keyID, signedHeaders, signature, true
+}
+
+func containsString(list []string, s string) bool {
+
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func makeError(description string) error {
+	return elemental.NewError("Unauthorized", description, "bahamut", http.StatusUnauthorized)
+}
@@ -0,0 +1,397 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+)
+
+// makeContext builds a bahamut.Context like bahamut does
+// when receiving the given http.Request.
+func makeContext(req *http.Request) bahamut.Context {
+
+	var data []byte
+	if req.Body != nil {
+		data, _ = ioutil.ReadAll(req.Body)
+	}
+
+	r := elemental.NewRequest()
+	r.Headers = req.Header
+	r.Data = data
+	r.Metadata = map[string]interface{}{
+		bahamut.HTTPMethodMetadataKey: req.Method,
+		bahamut.HTTPURLMetadataKey:    req.URL.RequestURI(),
+		bahamut.HTTPHostMetadataKey:   req.Host,
+	}
+
+	return bahamut.NewContext(context.Background(), r)
+}
+
+func makeSignedRequest(method string, target string, body string, keyID string, secret string, headers ...string) *http.Request {
+
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := Sign(req, keyID, []byte(secret), headers...); err != nil {
+		panic(err)
+	}
+
+	return req
+}
+
+func TestSign(t *testing.T) {
+
+	Convey("Given I have a request with a body", t, func() {
+
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/lists?b=2&a=1", bytes.NewBufferString(`{"name":"a"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		Convey("When I sign it", func() {
+
+			err := Sign(req, "ci", []byte("secret"), "Content-Type", "host")
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the headers should be set", func() {
+				So(req.Header.Get(DateHeader), ShouldNotBeEmpty)
+				So(req.Header.Get(NonceHeader), ShouldHaveLength, 32)
+				So(req.Header.Get("Authorization"), ShouldStartWith, "BAHAMUT-HMAC-SHA256 KeyID=ci, SignedHeaders=content-type;host;x-bahamut-date;x-bahamut-nonce, Signature=")
+			})
+
+			Convey("Then the body should be restored", func() {
+				data, _ := ioutil.ReadAll(req.Body)
+				So(string(data), ShouldEqual, `{"name":"a"}`)
+			})
+		})
+	})
+}
+
+func TestAuthenticator_AuthenticateRequest(t *testing.T) {
+
+	Convey("Given I have an authenticator", t, func() {
+
+		a := NewAuthenticator(
+			map[string]Key{
+				"ci":      {Secret: []byte("ci-secret"), Claims: []string{"@auth:role=ci"}},
+				"webhook": {Secret: []byte("webhook-secret")},
+			},
+			OptRequiredSignedHeaders("Content-Type"),
+		)
+
+		Convey("When I authenticate a correctly signed request", func() {
+
+			ctx := makeContext(makeSignedRequest(http.MethodPost, "http://example.com/lists?a=1", `{"name":"a"}`, "ci", "ci-secret", "content-type", "host"))
+
+			action, err := a.AuthenticateRequest(ctx)
+
+			Convey("Then the action should be OK", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionOK)
+			})
+
+			Convey("Then the claims should be set", func() {
+				So(ctx.Claims(), ShouldResemble, []string{"@auth:realm=hmac", "@auth:keyid=ci", "@auth:role=ci"})
+			})
+
+			Convey("Then the body should still be available", func() {
+				So(string(ctx.Request().Data), ShouldEqual, `{"name":"a"}`)
+			})
+
+			Convey("When I replay the same request", func() {
+
+				action, err := a.AuthenticateRequest(ctx)
+
+				Convey("Then it should be rejected", func() {
+					So(action, ShouldEqual, bahamut.AuthActionKO)
+					So(err.Error(), ShouldContainSubstring, "Nonce has already been used")
+				})
+			})
+		})
+
+		Convey("When I authenticate a request without signature", func() {
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/lists", nil)
+			req.Header.Set("Authorization", "Bearer token")
+
+			action, err := a.AuthenticateRequest(makeContext(req))
+
+			Convey("Then the action should be continue", func() {
+				So(err, ShouldBeNil)
+				So(action, ShouldEqual, bahamut.AuthActionContinue)
+			})
+		})
+
+		Convey("When I authenticate a request with a tampered body", func() {
+
+			req := makeSignedRequest(http.MethodPost, "http://example.com/lists", `{"name":"a"}`, "ci", "ci-secret", "content-type")
+			req.Body = ioutil.NopCloser(bytes.NewBufferString(`{"name":"b"}`))
+
+			action, err := a.AuthenticateRequest(makeContext(req))
+
+			Convey("Then it should be rejected", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err.Error(), ShouldContainSubstring, "Invalid signature")
+			})
+		})
+
+		Convey("When I authenticate a request with a tampered query", func() {
+
+			req := makeSignedRequest(http.MethodGet, "http://example.com/lists?a=1", "", "ci", "ci-secret", "content-type")
+			req.URL.RawQuery = "a=2"
+
+			action, err := a.AuthenticateRequest(makeContext(req))
+
+			Convey("Then it should be rejected", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err.Error(), ShouldContainSubstring, "Invalid signature")
+			})
+		})
+
+		Convey("When I authenticate a request with a tampered signed header", func() {
+
+			req := makeSignedRequest(http.MethodGet, "http://example.com/lists", "", "ci", "ci-secret", "content-type")
+			req.Header.Set("Content-Type", "application/msgpack")
+
+			action, err := a.AuthenticateRequest(makeContext(req))
+
+			Convey("Then it should be rejected", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err.Error(), ShouldContainSubstring, "Invalid signature")
+			})
+		})
+
+		Convey("When I authenticate a request signed with the wrong secret", func() {
+
+			action, err := a.AuthenticateRequest(makeContext(makeSignedRequest(http.MethodGet, "http://example.com/lists", "", "webhook", "ci-secret", "content-type")))
+
+			Convey("Then it should be rejected", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err.Error(), ShouldContainSubstring, "Invalid signature")
+			})
+		})
+
+		Convey("When I authenticate a request with an unknown key", func() {
+
+			action, err := a.AuthenticateRequest(makeContext(makeSignedRequest(http.MethodGet, "http://example.com/lists", "", "nope", "secret", "content-type")))
+
+			Convey("Then it should be rejected", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err.Error(), ShouldContainSubstring, "Unknown key ID")
+			})
+		})
+
+		Convey("When I authenticate a request without the required signed headers", func() {
+
+			action, err := a.AuthenticateRequest(makeContext(makeSignedRequest(http.MethodGet, "http://example.com/lists", "", "ci", "ci-secret")))
+
+			Convey("Then it should be rejected", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err.Error(), ShouldContainSubstring, "Header 'content-type' must be signed")
+			})
+		})
+
+		Convey("When I authenticate a request with a stale timestamp", func() {
+
+			ctx := makeContext(makeSignedRequest(http.MethodGet, "http://example.com/lists", "", "ci", "ci-secret", "content-type"))
+			a.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+
+			action, err := a.AuthenticateRequest(ctx)
+
+			Convey("Then it should be rejected", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err.Error(), ShouldContainSubstring, "Request timestamp is too old or too far in the future")
+			})
+		})
+
+		Convey("When I authenticate a request with an invalid authorization header", func() {
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/lists", nil)
+			req.Header.Set("Authorization", "BAHAMUT-HMAC-SHA256 KeyID=ci, SignedHeaders=x-bahamut-nonce;x-bahamut-date, Signature=abc")
+
+			action, err := a.AuthenticateRequest(makeContext(req))
+
+			Convey("Then it should be rejected", func() {
+				So(action, ShouldEqual, bahamut.AuthActionKO)
+				So(err.Error(), ShouldContainSubstring, "Invalid authorization header")
+			})
+		})
+	})
+}
+
+func TestIsSigned(t *testing.T) {
+
+	Convey("Given I have a signed request", t, func() {
+
+		ctx := makeContext(makeSignedRequest(http.MethodGet, "http://example.com/lists", "", "ci", "ci-secret"))
+
+		Convey("Then it should be signed", func() {
+			So(IsSigned(ctx), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given I have a request using a bearer token", t, func() {
+
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/lists", nil)
+		req.Header.Set("Authorization", "Bearer token")
+
+		Convey("Then it should not be signed", func() {
+			So(IsSigned(makeContext(req)), ShouldBeFalse)
+		})
+	})
+}
+
+func TestNonceCache(t *testing.T) {
+
+	Convey("Given I have a nonce cache of size 2", t, func() {
+
+		now := time.Now()
+		c := newNonceCache(2)
+
+		Convey("When I add a nonce twice", func() {
+
+			first := c.add("a", now.Add(time.Minute), now)
+			second := c.add("a", now.Add(time.Minute), now)
+
+			Convey("Then the second add should fail", func() {
+				So(first, ShouldBeNil)
+				So(second, ShouldEqual, errNonceUsed)
+			})
+		})
+
+		Convey("When I add an expired nonce again", func() {
+
+			c.add("a", now.Add(time.Minute), now)
+			err := c.add("a", now.Add(3*time.Minute), now.Add(2*time.Minute))
+
+			Convey("Then it should be accepted", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I add more unexpired nonces than the size", func() {
+
+			c.add("a", now.Add(time.Minute), now)
+			c.add("b", now.Add(time.Minute), now)
+			err := c.add("c", now.Add(time.Minute), now)
+
+			Convey("Then the new nonce should be refused", func() {
+				So(err, ShouldEqual, errNonceCacheFull)
+				So(c.len(), ShouldEqual, 2)
+			})
+
+			Convey("Then the oldest nonce should not have been evicted", func() {
+				So(c.add("a", now.Add(time.Minute), now), ShouldEqual, errNonceUsed)
+			})
+		})
+
+		Convey("When I add more nonces than the size once the oldest has expired", func() {
+
+			c.add("a", now.Add(time.Minute), now)
+			c.add("b", now.Add(3*time.Minute), now)
+			err := c.add("c", now.Add(3*time.Minute), now.Add(2*time.Minute))
+
+			Convey("Then the oldest should be evicted", func() {
+				So(err, ShouldBeNil)
+				So(c.len(), ShouldEqual, 2)
+				So(c.add("b", now.Add(3*time.Minute), now.Add(2*time.Minute)), ShouldEqual, errNonceUsed)
+				So(c.add("c", now.Add(3*time.Minute), now.Add(2*time.Minute)), ShouldEqual, errNonceUsed)
+			})
+		})
+	})
+
+	Convey("Given I have a nonce cache of size 2 holding a long lived nonce", t, func() {
+
+		now := time.Now()
+		c := newNonceCache(2)
+
+		c.add("a", now.Add(time.Hour), now)
+		c.add("b", now.Add(time.Minute), now)
+
+		Convey("When I add a nonce once the other one has expired", func() {
+
+			err := c.add("c", now.Add(3*time.Minute), now.Add(2*time.Minute))
+
+			Convey("Then the expired nonce should be evicted", func() {
+				So(err, ShouldBeNil)
+				So(c.add("a", now.Add(3*time.Minute), now.Add(2*time.Minute)), ShouldEqual, errNonceUsed)
+				So(c.add("c", now.Add(3*time.Minute), now.Add(2*time.Minute)), ShouldEqual, errNonceUsed)
+				So(c.index, ShouldNotContainKey, "b")
+			})
+		})
+	})
+
+	Convey("Given I have a nonce cache sized for 10 requests per second over a minute", t, func() {
+
+		now := time.Now()
+		c := newNonceCache(600)
+
+		// send sends requests at the given rate for
+		// 5 minutes and returns the number of refused ones.
+		send := func(rate int) (refused int) {
+			for i := 0; i < 5*60*rate; i++ {
+				ts := now.Add(time.Duration(i) * time.Second / time.Duration(rate))
+				if c.add(fmt.Sprintf("n%d", i), ts.Add(time.Minute), ts) != nil {
+					refused++
+				}
+			}
+			return refused
+		}
+
+		Convey("When I send 9 requests per second", func() {
+
+			refused := send(9)
+
+			Convey("Then no request should be refused", func() {
+				So(refused, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I send 11 requests per second", func() {
+
+			refused := send(11)
+
+			Convey("Then some requests should be refused", func() {
+				So(refused, ShouldBeGreaterThan, 0)
+			})
+		})
+	})
+}
+
+func TestNewAuthenticator_InvalidNonceCacheSize(t *testing.T) {
+
+	Convey("Given I create an Authenticator with a nonce cache size of 0", t, func() {
+
+		Convey("Then it should panic", func() {
+			So(func() { NewAuthenticator(nil, OptNonceCacheSize(0)) }, ShouldPanicWith, "invalid nonce cache size 0: it must be at least 1")
+		})
+	})
+
+	Convey("Given I create an Authenticator with a negative nonce cache size", t, func() {
+
+		Convey("Then it should panic", func() {
+			So(func() { NewAuthenticator(nil, OptNonceCacheSize(-1)) }, ShouldPanicWith, "invalid nonce cache size -1: it must be at least 1")
+		})
+	})
+}
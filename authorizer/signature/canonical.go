@@ -0,0 +1,129 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// Scheme is the scheme of the Authorization header.
+	Scheme = "BAHAMUT-HMAC-SHA256"
+
+	// DateHeader is the header containing the timestamp of the request.
+	DateHeader = "X-Bahamut-Date"
+
+	// NonceHeader is the header containing the nonce of the request.
+	NonceHeader = "X-Bahamut-Nonce"
+
+	// DateFormat is the format of the DateHeader.
+	DateFormat = "20060102T150405Z"
+)
+
+// canonicalRequest builds the string to sign.
+func canonicalRequest(
+	method string,
+	u *url.URL,
+	headers http.Header,
+	host string,
+	signedHeaders []string,
+	date string,
+	nonce string,
+	body []byte,
+) string {
+
+	digest := sha256.Sum256(body)
+
+	b := &strings.Builder{}
+
+	b.WriteString(strings.ToUpper(method))
+	b.WriteByte('\n')
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path)
+	b.WriteByte('\n')
+
+	b.WriteString(u.Query().Encode())
+	b.WriteByte('\n')
+
+	for _, h := range signedHeaders {
+
+		var value string
+		if h == "host" {
+			value = host
+		} else {
+			value = strings.Join(headers[http.CanonicalHeaderKey(h)], ",")
+		}
+
+		b.WriteString(h)
+		b.WriteByte(':')
+		b.WriteString(strings.TrimSpace(value))
+		b.WriteByte('\n')
+	}
+
+	b.WriteString(strings.Join(signedHeaders, ";"))
+	b.WriteByte('\n')
+
+	b.WriteString(date)
+	b.WriteByte('\n')
+
+	b.WriteString(nonce)
+	b.WriteByte('\n')
+
+	b.WriteString(hex.EncodeToString(digest[:]))
+
+	return b.String()
+}
+
+// computeSignature returns the hex encoded HMAC-SHA256 of the given data.
+func computeSignature(secret []byte, data string) string {
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data)) // nolint: errcheck
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// normalizeHeaders returns the given header names lowercased, sorted and deduplicated.
+func normalizeHeaders(headers []string) []string {
+
+	seen := make(map[string]struct{}, len(headers))
+	out := make([]string, 0, len(headers))
+
+	for _, h := range headers {
+
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+
+		if _, ok := seen[h]; ok {
+			continue
+		}
+
+		seen[h] = struct{}{}
+		out = append(out, h)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
@@ -0,0 +1,29 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signature provides a bahamut.RequestAuthenticator verifying
+// HMAC-SHA256 request signatures, in a way similar to AWS SigV4, and a
+// Sign function to sign outgoing requests.
+//
+// The signature covers the method, the path, the query, a set of signed
+// headers, the SHA256 digest of the body, a timestamp and a nonce. It is sent
+// in the Authorization header:
+//
+//	Authorization: BAHAMUT-HMAC-SHA256 KeyID=<id>, SignedHeaders=<h1;h2>, Signature=<hex>
+//	X-Bahamut-Date: 20190102T150405Z
+//	X-Bahamut-Nonce: <random>
+//
+// The Authenticator must not be wrapped by the authorizer/cache package
+// without skipping the signed requests, as a cached decision bypasses
+// the nonce check and allows replays:
+//
+//	cache.NewRequestAuthenticator(authenticator, cache.OptSkip(signature.IsSigned))
+package signature // import "go.aporeto.io/bahamut/authorizer/signature"
@@ -0,0 +1,121 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errNonceUsed      = errors.New("nonce has already been used")
+	errNonceCacheFull = errors.New("nonce cache is full")
+)
+
+type nonceEntry struct {
+	key        string
+	expiration time.Time
+	index      int
+}
+
+// a nonceHeap is a container/heap.Interface ordering
+// the nonces by expiration.
+type nonceHeap []*nonceEntry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].expiration.Before(h[j].expiration) }
+
+func (h nonceHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *nonceHeap) Push(x interface{}) {
+	e := x.(*nonceEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// a nonceCache is a bounded set of nonces. The expired nonces are
+// evicted first, starting with the one expiring the earliest. When it
+// is full of unexpired nonces, new nonces are refused until one of them
+// expires, so a nonce can never be forgotten while it can still be
+// replayed.
+type nonceCache struct {
+	entries nonceHeap
+	index   map[string]*nonceEntry
+	lock    sync.Mutex
+	maxSize int
+}
+
+func newNonceCache(size int) *nonceCache {
+
+	return &nonceCache{
+		entries: make(nonceHeap, 0, size),
+		index:   make(map[string]*nonceEntry, size),
+		maxSize: size,
+	}
+}
+
+// add adds the given key to the cache until the given expiration.
+// It returns errNonceUsed if the key is already in the cache and not
+// expired, and errNonceCacheFull if the cache is full of unexpired keys.
+func (c *nonceCache) add(key string, expiration time.Time, now time.Time) error {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.index[key]; ok {
+
+		if e.expiration.After(now) {
+			return errNonceUsed
+		}
+
+		heap.Remove(&c.entries, e.index)
+		delete(c.index, key)
+	}
+
+	for len(c.entries) >= c.maxSize {
+
+		if c.entries[0].expiration.After(now) {
+			return errNonceCacheFull
+		}
+
+		delete(c.index, heap.Pop(&c.entries).(*nonceEntry).key)
+	}
+
+	e := &nonceEntry{key: key, expiration: expiration}
+	heap.Push(&c.entries, e)
+	c.index[key] = e
+
+	return nil
+}
+
+func (c *nonceCache) len() int {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.index)
+}
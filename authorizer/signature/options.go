@@ -0,0 +1,50 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature
+
+import "time"
+
+// An Option represents a configuration option
+// for the signature Authenticator.
+type Option func(*Authenticator)
+
+// OptMaxClockSkew sets the maximum difference allowed between the
+// timestamp of a request and the local clock. The default is 5 minutes.
+func OptMaxClockSkew(skew time.Duration) Option {
+	return func(a *Authenticator) {
+		a.maxClockSkew = skew
+	}
+}
+
+// OptNonceCacheSize sets the maximum number of nonces kept in memory
+// to detect replayed requests. The default is 100000.
+//
+// A nonce is kept until the timestamp of its request is older than the
+// max clock skew. When the cache is full of unexpired nonces, new requests
+// are rejected with a 429 error. The cache therefore sustains about size
+// divided by the max clock skew signed requests per second, and half as
+// much if the clocks of the clients are ahead of the local clock. With
+// the defaults, this is about 333 requests per second.
+// The size must be at least 1.
+func OptNonceCacheSize(size int) Option {
+	return func(a *Authenticator) {
+		a.nonceCacheSize = size
+	}
+}
+
+// OptRequiredSignedHeaders sets the list of headers that must
+// be part of the signed headers of every request.
+func OptRequiredSignedHeaders(headers ...string) Option {
+	return func(a *Authenticator) {
+		a.requiredHeaders = headers
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sign signs the given http.Request using the given key ID and secret.
+//
+// SignedHeaders is the list of headers to include in the signature, in
+// addition to the date and nonce headers. The body, if any, is read and
+// restored so the request can still be sent.
+func Sign(req *http.Request, keyID string, secret []byte, signedHeaders ...string) error {
+
+	var body []byte
+	if req.Body != nil {
+
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return fmt.Errorf("unable to read request body: %s", err)
+		}
+		req.Body.Close() // nolint: errcheck
+
+		body = data
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("unable to generate nonce: %s", err)
+	}
+
+	date := time.Now().UTC().Format(DateFormat)
+
+	req.Header.Set(DateHeader, date)
+	req.Header.Set(NonceHeader, hex.EncodeToString(nonce))
+
+	headers := normalizeHeaders(append(append([]string{}, signedHeaders...), DateHeader, NonceHeader))
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	signature := computeSignature(
+		secret,
+		canonicalRequest(
+			req.Method,
+			req.URL,
+			req.Header,
+			host,
+			headers,
+			date,
+			req.Header.Get(NonceHeader),
+			body,
+		),
+	)
+
+	req.Header.Set(
+		"Authorization",
+		fmt.Sprintf("%s KeyID=%s, SignedHeaders=%s, Signature=%s", Scheme, keyID, strings.Join(headers, ";"), signature),
+	)
+
+	return nil
+}
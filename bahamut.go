@@ -20,7 +20,6 @@ import (
 
 	"github.com/go-zoo/bone"
 	"go.aporeto.io/elemental"
-	"go.uber.org/zap"
 )
 
 // CustomUmarshaller is the type of function use to create custom unmarshalling.
@@ -74,15 +73,15 @@ func New(options ...Option) Server {
 	}
 
 	if !c.restServer.enabled && !c.pushServer.enabled {
-		zap.L().Warn("No rest server or push server configured. Use bahamut.OptRestServer() and/or bahamaut.OptPushServer()")
+		c.logger().Warn("No rest server or push server configured. Use bahamut.OptRestServer() and/or bahamaut.OptPushServer()")
 	}
 
 	if c.pushServer.enabled && !c.pushServer.dispatchEnabled && !c.pushServer.publishEnabled {
-		zap.L().Warn("Push server is enabled but neither dispatching or publishing is. Use bahamut.OptPushPublishHandler() and/or bahamut.OptPushDispatchHandler()")
+		c.logger().Warn("Push server is enabled but neither dispatching or publishing is. Use bahamut.OptPushPublishHandler() and/or bahamut.OptPushDispatchHandler()")
 	}
 
 	if len(c.model.modelManagers) == 0 {
-		zap.L().Warn("No elemental.ModelManager is defined. Use bahamut.OptModel()")
+		c.logger().Warn("No elemental.ModelManager is defined. Use bahamut.OptModel()")
 	}
 
 	return NewServer(c)
@@ -95,6 +94,11 @@ func NewServer(cfg config) Server {
 		cfg.model.unmarshallers = map[elemental.Identity]CustomUmarshaller{}
 	}
 
+	// Payloads are only decoded for redaction if there is something to redact.
+	if len(cfg.redaction.fields) > 0 || hasRedactedAttributes(cfg.model.modelManagers) {
+		cfg.redaction.redactor = newRedactor(cfg.model.modelManagers, cfg.redaction.fields)
+	}
+
 	mux := bone.New()
 	srv := &server{
 		multiplexer: mux,
@@ -103,7 +107,7 @@ func NewServer(cfg config) Server {
 	}
 
 	if cfg.restServer.enabled {
-		srv.restServer = newRestServer(cfg, mux, srv.ProcessorForIdentity, srv.Push)
+		srv.restServer = newRestServer(cfg, mux, srv.ProcessorForIdentity, srv.push)
 	}
 
 	if cfg.pushServer.enabled {
@@ -159,11 +163,18 @@ func (b *server) ProcessorsCount() int {
 
 func (b *server) Push(events ...*elemental.Event) {
 
+	b.push(context.Background(), events...)
+}
+
+// push publishes the given events. The publications
+// are traced as part of the trace found in the given context.
+func (b *server) push(ctx context.Context, events ...*elemental.Event) {
+
 	if b.pushServer == nil {
 		return
 	}
 
-	b.pushServer.pushEvents(events...)
+	b.pushServer.pushEvents(ctx, events...)
 }
 
 func (b *server) RoutesInfo() map[int][]RouteInfo {
@@ -204,6 +215,11 @@ func (b *server) Run(ctx context.Context) {
 	}
 
 	if b.healthServer != nil {
+		if p, ok := b.cfg.healthServer.prober.(*healthProber); ok {
+			p.start(ctx, b.cfg.logger())
+		} else if prober := b.cfg.healthServer.prober; prober != nil {
+			prober.Start(ctx)
+		}
 		go b.healthServer.start(ctx)
 	}
 
@@ -211,15 +227,21 @@ func (b *server) Run(ctx context.Context) {
 		hook(b) // nolint
 	}
 
+	// We are ready once the post start hook is done.
+	if b.healthServer != nil {
+		b.healthServer.setStatus(HealthStatusOK)
+	}
+
 	<-ctx.Done()
 
-	if hook := b.cfg.hooks.preStop; hook != nil {
-		hook(b) // nolint
+	// We are not ready anymore while draining, but the health
+	// server is kept running so it can report it.
+	if b.healthServer != nil {
+		b.healthServer.setStatus(HealthStatusDraining)
 	}
 
-	// Stop the health server first so we become unhealthy.
-	if b.healthServer != nil {
-		<-b.healthServer.stop().Done()
+	if hook := b.cfg.hooks.preStop; hook != nil {
+		hook(b) // nolint
 	}
 
 	// Stop the push server to disconnect everybody.
@@ -236,4 +258,9 @@ func (b *server) Run(ctx context.Context) {
 	if b.profilingServer != nil {
 		b.profilingServer.stop()
 	}
+
+	// Stop the health server last.
+	if b.healthServer != nil {
+		<-b.healthServer.stop().Done()
+	}
 }
@@ -12,7 +12,10 @@
 package bahamut
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
@@ -344,3 +347,52 @@ func TestBahamyt_RegistorProcessorOrDie(t *testing.T) {
 		})
 	})
 }
+
+func TestBahamut_RunHealthStatus(t *testing.T) {
+
+	Convey("Given I have a server with a health server and hooks", t, func() {
+
+		statuses := make(chan string, 2)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var b Server
+		b = New(
+			OptHealthServer(fmt.Sprintf("127.0.0.1:%d", freePort()), nil),
+			OptPostStartHook(func(Server) error {
+				statuses <- b.(*server).healthServer.getStatus()
+				return nil
+			}),
+			OptPreStopHook(func(Server) error {
+				statuses <- b.(*server).healthServer.getStatus()
+				return nil
+			}),
+		)
+
+		Convey("When I run it then stop it", func() {
+
+			done := make(chan struct{})
+			go func() {
+				b.Run(ctx)
+				close(done)
+			}()
+
+			postStart := <-statuses
+
+			time.Sleep(100 * time.Millisecond)
+			running := b.(*server).healthServer.getStatus()
+
+			cancel()
+			preStop := <-statuses
+			<-done
+
+			Convey("Then the server should not be ready until the post start hook is done", func() {
+				So(postStart, ShouldEqual, HealthStatusStarting)
+				So(running, ShouldEqual, HealthStatusOK)
+			})
+
+			Convey("Then the server should be draining when the pre stop hook is called", func() {
+				So(preStop, ShouldEqual, HealthStatusDraining)
+			})
+		})
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// resolveClientIP returns the IP of the client that sent a request
+// received from the given remote address with the given headers.
+//
+// The Forwarded and X-Forwarded-For headers are only used when the
+// remote address belongs to one of the trusted networks. They are read
+// from right to left, skipping the trusted proxies, and the first
+// untrusted address is returned.
+func resolveClientIP(remoteAddr string, headers http.Header, trusted []*net.IPNet) string {
+
+	ip := hostIP(remoteAddr)
+
+	if len(trusted) == 0 || !ipInNetworks(ip, trusted) {
+		return ip
+	}
+
+	hops := forwardedHops(headers)
+
+	for i := len(hops) - 1; i >= 0; i-- {
+
+		hop := hostIP(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		ip = hop
+
+		if !ipInNetworks(ip, trusted) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// forwardedHops returns the list of addresses found in the
+// Forwarded header, or in X-Forwarded-For if there is none.
+func forwardedHops(headers http.Header) []string {
+
+	var hops []string
+
+	if values := headers["Forwarded"]; len(values) > 0 {
+
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+
+					kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+					if len(kv) != 2 || !strings.EqualFold(kv[0], "for") {
+						continue
+					}
+
+					hops = append(hops, strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+
+		return hops
+	}
+
+	for _, value := range headers["X-Forwarded-For"] {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	return hops
+}
+
+// hostIP returns the IP part of the given address, which can
+// be a bare IP, an ip:port or a [ipv6]:port.
+func hostIP(addr string) string {
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+func ipInNetworks(addr string, networks []*net.IPNet) bool {
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
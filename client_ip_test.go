@@ -0,0 +1,65 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut/internal/cidrs"
+)
+
+func TestClientIP_resolveClientIP(t *testing.T) {
+
+	trusted, err := cidrs.Parse([]string{"10.0.0.0/8", "fd00::/8", "192.168.1.1"})
+	if err != nil {
+		panic(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    http.Header
+		trusted    bool
+		want       string
+	}{
+		{"no header", "203.0.113.4:1234", nil, true, "203.0.113.4"},
+		{"untrusted remote", "203.0.113.4:1234", http.Header{"X-Forwarded-For": {"1.2.3.4"}}, true, "203.0.113.4"},
+		{"no trusted proxies", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4"}}, false, "10.0.0.1"},
+		{"xff single", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4"}}, true, "1.2.3.4"},
+		{"xff spoofed", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"6.6.6.6, 1.2.3.4, 10.0.0.2"}}, true, "1.2.3.4"},
+		{"xff multiple headers", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"6.6.6.6", "1.2.3.4, 192.168.1.1"}}, true, "1.2.3.4"},
+		{"xff all trusted", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, true, "10.0.0.3"},
+		{"xff garbage", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4, garbage"}}, true, "10.0.0.1"},
+		{"xff ipv6", "[fd00::1]:1234", http.Header{"X-Forwarded-For": {"2001:db8::1"}}, true, "2001:db8::1"},
+		{"forwarded", "10.0.0.1:1234", http.Header{"Forwarded": {`for=192.0.2.60;proto=http;by=203.0.113.43`}}, true, "192.0.2.60"},
+		{"forwarded ipv6", "10.0.0.1:1234", http.Header{"Forwarded": {`for="[2001:db8:cafe::17]:4711", for=10.0.0.2`}}, true, "2001:db8:cafe::17"},
+		{"forwarded wins", "10.0.0.1:1234", http.Header{"Forwarded": {"For=192.0.2.60"}, "X-Forwarded-For": {"1.2.3.4"}}, true, "192.0.2.60"},
+		{"forwarded unknown", "10.0.0.1:1234", http.Header{"Forwarded": {"for=unknown"}}, true, "10.0.0.1"},
+		{"bare ip", "203.0.113.4", nil, true, "203.0.113.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var networks = trusted
+			if !tt.trusted {
+				networks = nil
+			}
+
+			if got := resolveClientIP(tt.remoteAddr, tt.headers, networks); got != tt.want {
+				t.Errorf("resolveClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -14,12 +14,16 @@ package bahamut
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"io"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"go.aporeto.io/elemental"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
 
@@ -38,6 +42,7 @@ type TraceCleaner func(elemental.Identity, []byte) []byte
 type config struct {
 	general struct {
 		panicRecoveryDisabled bool
+		logger                *zap.Logger
 	}
 
 	restServer struct {
@@ -49,6 +54,12 @@ type config struct {
 		enabled               bool
 		customRootHandlerFunc http.HandlerFunc
 		customListener        net.Listener
+		unixSocketPath        string
+		unixSocketMode        os.FileMode
+		unixSocketUID         int
+		unixSocketGID         int
+		proxyProtocolEnabled  bool
+		trustedProxies        []*net.IPNet
 	}
 
 	pushServer struct {
@@ -60,6 +71,11 @@ type config struct {
 		enabled         bool
 		publishEnabled  bool
 		dispatchEnabled bool
+		tokenCookie     string
+		tokenProtocol   string
+		allowedOrigins  []string
+		changesEnabled  bool
+		tracingSampling *pushTracingSampling
 	}
 
 	healthServer struct {
@@ -71,6 +87,9 @@ type config struct {
 		enabled        bool
 		customStats    map[string]HealthStatFunc
 		metricsManager MetricsManager
+		pingers        map[string]Pinger
+		pingTimeout    time.Duration
+		prober         HealthProber
 	}
 
 	profilingServer struct {
@@ -86,6 +105,12 @@ type config struct {
 		authType                        tls.ClientAuthType
 		serverCertificates              []tls.Certificate
 		serverCertificatesRetrieverFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+		reloadCertFile                  string
+		reloadKeyFile                   string
+		reloadClientCAFile              string
+		reloadInterval                  time.Duration
+		expirationWarning               time.Duration
+		profile                         *TLSProfile
 	}
 
 	security struct {
@@ -93,10 +118,13 @@ type config struct {
 		sessionAuthenticators []SessionAuthenticator
 		authorizers           []Authorizer
 		auditer               Auditer
+		auditRecorder         AuditRecorder
+		authDecisionsHeader   bool
 	}
 
 	rateLimiting struct {
-		rateLimiter *rate.Limiter
+		rateLimiter       *rate.Limiter
+		clientRateLimiter *clientRateLimiter
 	}
 
 	model struct {
@@ -113,10 +141,29 @@ type config struct {
 		disableMetaRoute bool
 	}
 
+	accessLog struct {
+		writer                io.Writer
+		format                AccessLogFormat
+		sampling              map[int]float64
+		identitySampling      map[string]float64
+		excludedIdentities    map[string]struct{}
+		excludedStatusClasses map[int]struct{}
+	}
+
+	redaction struct {
+		fields   []string
+		redactor *redactor
+	}
+
+	opentelemetry struct {
+		tracer trace.Tracer
+	}
+
 	opentracing struct {
 		tracer             opentracing.Tracer
 		excludedIdentities map[string]struct{}
 		traceCleaner       TraceCleaner
+		samplingPolicy     *TraceSamplingPolicy
 	}
 
 	hooks struct {
@@ -17,24 +17,32 @@ import (
 
 	"github.com/gofrs/uuid"
 	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
 )
 
 type bcontext struct {
-	claims       []string
-	claimsMap    map[string]string
-	count        int
-	ctx          context.Context
-	events       elemental.Events
-	eventsLock   *sync.Mutex
-	id           string
-	inputData    interface{}
-	messages     []string
-	messagesLock *sync.Mutex
-	metadata     map[interface{}]interface{}
-	outputData   interface{}
-	redirect     string
-	request      *elemental.Request
-	statusCode   int
+	attributeChanges AttributeChanges
+	authDecisions    AuthDecisions
+	claims           []string
+	claimsMap        map[string]string
+	count            int
+	ctx              context.Context
+	dispatched       chan struct{}
+	err              error
+	events           elemental.Events
+	eventsLock       *sync.Mutex
+	id               string
+	inputData        interface{}
+	logger           *zap.Logger
+	messages         []string
+	messagesLock     *sync.Mutex
+	metadata         map[interface{}]interface{}
+	outputData       interface{}
+	previousData     interface{}
+	processorCtx     context.Context
+	redirect         string
+	request          *elemental.Request
+	statusCode       int
 }
 
 // NewContext creates a new *Context.
@@ -48,7 +56,17 @@ func newContext(ctx context.Context, request *elemental.Request) *bcontext {
 		panic("nil context")
 	}
 
+	logger := baseLoggerFromContext(ctx)
+	if logger == nil {
+		logger = zap.L()
+	}
+
+	if request != nil {
+		logger = makeRequestLogger(ctx, logger, request)
+	}
+
 	return &bcontext{
+		logger:       logger,
 		claims:       nil,
 		claimsMap:    map[string]string{},
 		ctx:          ctx,
@@ -59,11 +77,32 @@ func newContext(ctx context.Context, request *elemental.Request) *bcontext {
 	}
 }
 
+// pendingDispatch returns a channel closed once the dispatcher
+// of the context returns, or nil if it is not running.
+func (c *bcontext) pendingDispatch() <-chan struct{} {
+
+	if c.dispatched == nil {
+		return nil
+	}
+
+	select {
+	case <-c.dispatched:
+		return nil
+	default:
+		return c.dispatched
+	}
+}
+
 func (c *bcontext) Identifier() string {
 	return c.id
 }
 
 func (c *bcontext) Context() context.Context {
+
+	if c.processorCtx != nil {
+		return c.processorCtx
+	}
+
 	return c.ctx
 }
 
@@ -71,6 +110,15 @@ func (c *bcontext) Request() *elemental.Request {
 	return c.request
 }
 
+func (c *bcontext) ClientIP() string {
+
+	if c.request == nil {
+		return ""
+	}
+
+	return c.request.ClientIP
+}
+
 func (c *bcontext) Count() int {
 	return c.count
 }
@@ -95,6 +143,18 @@ func (c *bcontext) SetOutputData(data interface{}) {
 	c.outputData = data
 }
 
+func (c *bcontext) PreviousData() interface{} {
+	return c.previousData
+}
+
+func (c *bcontext) SetPreviousData(data interface{}) {
+	c.previousData = data
+}
+
+func (c *bcontext) AttributeChanges() AttributeChanges {
+	return c.attributeChanges
+}
+
 func (c *bcontext) StatusCode() int {
 	return c.statusCode
 }
@@ -149,6 +209,23 @@ func (c *bcontext) ClaimsMap() map[string]string {
 	return c.claimsMap
 }
 
+func (c *bcontext) Logger() *zap.Logger {
+	return withSubject(c.logger, c.claimsMap)
+}
+
+func (c *bcontext) AuthDecisions() AuthDecisions {
+
+	if len(c.authDecisions) == 0 {
+		return nil
+	}
+
+	return append(AuthDecisions{}, c.authDecisions...)
+}
+
+func (c *bcontext) recordAuthDecision(decision AuthDecision) {
+	c.authDecisions = append(c.authDecisions, decision)
+}
+
 func (c *bcontext) EnqueueEvents(events ...*elemental.Event) {
 
 	c.eventsLock.Lock()
@@ -171,9 +248,12 @@ func (c *bcontext) Duplicate() Context {
 	c2.count = c.count
 	c2.statusCode = c.statusCode
 	c2.outputData = c.outputData
+	c2.previousData = c.previousData
+	c2.attributeChanges = append(c2.attributeChanges, c.attributeChanges...)
 	c2.claims = append(c2.claims, c.claims...)
 	c2.redirect = c.redirect
 	c2.messages = append(c2.messages, c.messages...)
+	c2.authDecisions = append(c2.authDecisions, c.authDecisions...)
 
 	for k, v := range c.claimsMap {
 		c2.claimsMap[k] = v
@@ -90,6 +90,47 @@ func TestContext_Identifier(t *testing.T) {
 			})
 		})
 	})
+
+	Convey("Given I have a context for a request with a request ID", t, func() {
+
+		req := elemental.NewRequest()
+		req.RequestID = "rid"
+		ctx := newContext(context.TODO(), req)
+
+		Convey("Then its Identifier should not be the request ID", func() {
+			So(ctx.Identifier(), ShouldNotEqual, "rid")
+			So(len(ctx.Identifier()), ShouldEqual, 36)
+			So(ctx.Request().RequestID, ShouldEqual, "rid")
+		})
+	})
+}
+
+func TestContext_ClientIP(t *testing.T) {
+
+	Convey("Given I have a context", t, func() {
+
+		req := elemental.NewRequest()
+		req.ClientIP = "1.2.3.4"
+		ctx := newContext(context.TODO(), req)
+
+		Convey("When I get its ClientIP", func() {
+
+			ip := ctx.ClientIP()
+
+			Convey("Then the ip should be correct", func() {
+				So(ip, ShouldEqual, "1.2.3.4")
+			})
+		})
+	})
+
+	Convey("Given I have a context without request", t, func() {
+
+		ctx := newContext(context.TODO(), nil)
+
+		Convey("Then ClientIP should be empty", func() {
+			So(ctx.ClientIP(), ShouldBeEmpty)
+		})
+	})
 }
 
 func TestContext_Events(t *testing.T) {
@@ -135,6 +176,8 @@ func TestContext_Duplicate(t *testing.T) {
 		ctx.AddMessage("b")
 		ctx.SetMetadata("hello", "world")
 		ctx.SetClaims([]string{"ouais=yes"})
+		ctx.SetPreviousData("previous")
+		ctx.attributeChanges = AttributeChanges{{Attribute: "name"}}
 
 		Convey("When I call the Duplicate method", func() {
 
@@ -152,6 +195,8 @@ func TestContext_Duplicate(t *testing.T) {
 				So(ctx.claimsMap, ShouldResemble, ctx2.ClaimsMap())
 				So(ctx.redirect, ShouldResemble, ctx2.Redirect())
 				So(ctx.messages, ShouldResemble, ctx2.(*bcontext).messages)
+				So(ctx.previousData, ShouldEqual, ctx2.PreviousData())
+				So(ctx.attributeChanges, ShouldResemble, ctx2.AttributeChanges())
 			})
 		})
 	})
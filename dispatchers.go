@@ -63,13 +63,13 @@ func dispatchRetrieveManyOperation(
 		return err
 	}
 
-	if err = proc.(RetrieveManyProcessor).ProcessRetrieveMany(ctx); err != nil {
+	if err = runProcessor(ctx, proc, func() error { return proc.(RetrieveManyProcessor).ProcessRetrieveMany(ctx) }); err != nil {
 		audit(auditer, ctx, err)
 		return err
 	}
 
 	if len(ctx.events) > 0 {
-		pusher(ctx.events...)
+		pusher(ctx.ctx, ctx.events...)
 	}
 
 	audit(auditer, ctx, nil)
@@ -104,13 +104,13 @@ func dispatchRetrieveOperation(
 		return err
 	}
 
-	if err = proc.(RetrieveProcessor).ProcessRetrieve(ctx); err != nil {
+	if err = runProcessor(ctx, proc, func() error { return proc.(RetrieveProcessor).ProcessRetrieve(ctx) }); err != nil {
 		audit(auditer, ctx, err)
 		return err
 	}
 
 	if len(ctx.events) > 0 {
-		pusher(ctx.events...)
+		pusher(ctx.ctx, ctx.events...)
 	}
 
 	audit(auditer, ctx, nil)
@@ -143,6 +143,7 @@ func dispatchCreateOperation(
 
 	if readOnlyMode {
 		if err = makeReadOnlyError(ctx.request.Identity, readOnlyExclusion); err != nil {
+			audit(auditer, ctx, err)
 			return err
 		}
 	}
@@ -178,18 +179,18 @@ func dispatchCreateOperation(
 
 	ctx.inputData = obj
 
-	if err = proc.(CreateProcessor).ProcessCreate(ctx); err != nil {
+	if err = runProcessor(ctx, proc, func() error { return proc.(CreateProcessor).ProcessCreate(ctx) }); err != nil {
 		audit(auditer, ctx, err)
 		return err
 	}
 
 	if len(ctx.events) > 0 {
-		pusher(ctx.events...)
+		pusher(ctx.ctx, ctx.events...)
 	}
 
 	if ctx.outputData != nil {
 		evt := elemental.NewEvent(elemental.EventCreate, ctx.outputData.(elemental.Identifiable))
-		pusher(evt)
+		pusher(ctx.ctx, evt)
 	}
 
 	audit(auditer, ctx, nil)
@@ -222,6 +223,7 @@ func dispatchUpdateOperation(
 
 	if readOnlyMode {
 		if err = makeReadOnlyError(ctx.request.Identity, readOnlyExclusion); err != nil {
+			audit(auditer, ctx, err)
 			return err
 		}
 	}
@@ -257,18 +259,22 @@ func dispatchUpdateOperation(
 
 	ctx.inputData = obj
 
-	if err = proc.(UpdateProcessor).ProcessUpdate(ctx); err != nil {
+	if err = runProcessor(ctx, proc, func() error { return proc.(UpdateProcessor).ProcessUpdate(ctx) }); err != nil {
 		audit(auditer, ctx, err)
 		return err
 	}
 
+	if ctx.previousData != nil {
+		ctx.attributeChanges = computeAttributeChanges(ctx.previousData, ctx.outputData)
+	}
+
 	if len(ctx.events) > 0 {
-		pusher(ctx.events...)
+		pusher(ctx.ctx, ctx.events...)
 	}
 
 	if ctx.outputData != nil {
 		evt := elemental.NewEvent(elemental.EventUpdate, ctx.outputData.(elemental.Identifiable))
-		pusher(evt)
+		pusher(contextWithAttributeChanges(ctx.ctx, ctx.attributeChanges), evt)
 	}
 
 	audit(auditer, ctx, nil)
@@ -299,6 +305,7 @@ func dispatchDeleteOperation(
 
 	if readOnlyMode {
 		if err = makeReadOnlyError(ctx.request.Identity, readOnlyExclusion); err != nil {
+			audit(auditer, ctx, err)
 			return err
 		}
 	}
@@ -311,18 +318,18 @@ func dispatchDeleteOperation(
 		return err
 	}
 
-	if err = proc.(DeleteProcessor).ProcessDelete(ctx); err != nil {
+	if err = runProcessor(ctx, proc, func() error { return proc.(DeleteProcessor).ProcessDelete(ctx) }); err != nil {
 		audit(auditer, ctx, err)
 		return err
 	}
 
 	if len(ctx.events) > 0 {
-		pusher(ctx.events...)
+		pusher(ctx.ctx, ctx.events...)
 	}
 
 	if ctx.outputData != nil {
 		evt := elemental.NewEvent(elemental.EventDelete, ctx.outputData.(elemental.Identifiable))
-		pusher(evt)
+		pusher(ctx.ctx, evt)
 	}
 
 	audit(auditer, ctx, nil)
@@ -355,6 +362,7 @@ func dispatchPatchOperation(
 
 	if readOnlyMode {
 		if err = makeReadOnlyError(ctx.request.Identity, readOnlyExclusion); err != nil {
+			audit(auditer, ctx, err)
 			return err
 		}
 	}
@@ -383,18 +391,22 @@ func dispatchPatchOperation(
 
 	ctx.inputData = sparse
 
-	if err = proc.(PatchProcessor).ProcessPatch(ctx); err != nil {
+	if err = runProcessor(ctx, proc, func() error { return proc.(PatchProcessor).ProcessPatch(ctx) }); err != nil {
 		audit(auditer, ctx, err)
 		return err
 	}
 
+	if ctx.previousData != nil {
+		ctx.attributeChanges = computeAttributeChanges(ctx.previousData, ctx.outputData)
+	}
+
 	if len(ctx.events) > 0 {
-		pusher(ctx.events...)
+		pusher(ctx.ctx, ctx.events...)
 	}
 
 	if ctx.outputData != nil {
 		evt := elemental.NewEvent(elemental.EventUpdate, ctx.outputData.(elemental.Identifiable))
-		pusher(evt)
+		pusher(contextWithAttributeChanges(ctx.ctx, ctx.attributeChanges), evt)
 	}
 
 	audit(auditer, ctx, nil)
@@ -429,13 +441,13 @@ func dispatchInfoOperation(
 		return err
 	}
 
-	if err = proc.(InfoProcessor).ProcessInfo(ctx); err != nil {
+	if err = runProcessor(ctx, proc, func() error { return proc.(InfoProcessor).ProcessInfo(ctx) }); err != nil {
 		audit(auditer, ctx, err)
 		return err
 	}
 
 	if len(ctx.events) > 0 {
-		pusher(ctx.events...)
+		pusher(ctx.ctx, ctx.events...)
 	}
 
 	audit(auditer, ctx, nil)
@@ -369,6 +369,7 @@ func TestDispatchers_dispatchCreateOperation(t *testing.T) {
 		Convey("Then I should have a 423 error and context should be nil", func() {
 			So(err, ShouldNotBeNil)
 			So(err.(elemental.Error).Code, ShouldEqual, http.StatusLocked)
+			So(auditer.GetCallCount(), ShouldEqual, 1)
 		})
 	})
 
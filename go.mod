@@ -1 +1,10 @@
 module go.aporeto.io/bahamut
+
+go 1.21
+
+require (
+	github.com/prometheus/client_golang v1.11.1
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/sdk v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+)
@@ -36,6 +36,7 @@ func makeResponse(ctx *bcontext, response *elemental.Response, cleaner TraceClea
 			span.LogFields(fields...)
 			span.SetTag("status.code", response.StatusCode)
 		}
+		setOTelStatusCode(ctx.ctx, response.StatusCode)
 	}()
 
 	response.StatusCode = ctx.statusCode
@@ -86,12 +87,16 @@ func makeResponse(ctx *bcontext, response *elemental.Response, cleaner TraceClea
 		panic(fmt.Errorf("unable to encode output data: %s", err))
 	}
 
-	data := response.Data[:]
-	if cleaner != nil {
-		data = cleaner(response.Request.Identity, data)
-	}
+	// The payload is only cleaned if there is a span to log it.
+	if opentracing.SpanFromContext(ctx.ctx) != nil {
+
+		data := response.Data[:]
+		if cleaner != nil {
+			data = cleaner(response.Request.Identity, data)
+		}
 
-	fields = append(fields, log.Object("response", string(data)))
+		fields = append(fields, log.Object("response", string(data)))
+	}
 
 	return response
 }
@@ -112,6 +117,15 @@ func makeErrorResponse(ctx context.Context, response *elemental.Response, err er
 	return response
 }
 
+// makeContextErrorResponse keeps the given error in the
+// given context, and returns the error response.
+func makeContextErrorResponse(ctx *bcontext, response *elemental.Response, err error) *elemental.Response {
+
+	ctx.err = err
+
+	return makeErrorResponse(ctx.ctx, response, err)
+}
+
 func handleEventualPanic(ctx context.Context, c chan error, disablePanicRecovery bool) {
 
 	if err := handleRecoveredPanic(ctx, recover(), disablePanicRecovery); err != nil {
@@ -122,8 +136,10 @@ func handleEventualPanic(ctx context.Context, c chan error, disablePanicRecovery
 func runDispatcher(ctx *bcontext, r *elemental.Response, d func() error, disablePanicRecovery bool, traceCleaner TraceCleaner) *elemental.Response {
 
 	e := make(chan error)
+	ctx.dispatched = make(chan struct{})
 
 	go func() {
+		defer close(ctx.dispatched)
 		defer handleEventualPanic(ctx.ctx, e, disablePanicRecovery)
 		select {
 		case e <- d():
@@ -134,11 +150,11 @@ func runDispatcher(ctx *bcontext, r *elemental.Response, d func() error, disable
 	select {
 
 	case <-ctx.ctx.Done():
-		return makeErrorResponse(ctx.ctx, r, ctx.ctx.Err())
+		return makeContextErrorResponse(ctx, r, ctx.ctx.Err())
 
 	case err := <-e:
 		if err != nil {
-			return makeErrorResponse(ctx.ctx, r, err)
+			return makeContextErrorResponse(ctx, r, err)
 		}
 
 		return makeResponse(ctx, r, traceCleaner)
@@ -155,8 +171,8 @@ func handleRetrieveMany(ctx *bcontext, cfg config, processorFinder processorFind
 		ctx.request.ParentIdentity,
 		elemental.OperationRetrieveMany,
 	) {
-		return makeErrorResponse(
-			ctx.ctx,
+		return makeContextErrorResponse(
+			ctx,
 			response,
 			elemental.NewError(
 				"Not allowed",
@@ -181,7 +197,7 @@ func handleRetrieveMany(ctx *bcontext, cfg config, processorFinder processorFind
 			)
 		},
 		cfg.general.panicRecoveryDisabled,
-		cfg.opentracing.traceCleaner,
+		cfg.traceCleaner(ctx.request.Version, ctx.request.Accept),
 	)
 }
 
@@ -195,8 +211,8 @@ func handleRetrieve(ctx *bcontext, cfg config, processorFinder processorFinderFu
 		ctx.request.ParentIdentity,
 		elemental.OperationRetrieve,
 	) {
-		return makeErrorResponse(
-			ctx.ctx,
+		return makeContextErrorResponse(
+			ctx,
 			response,
 			elemental.NewError(
 				"Not allowed",
@@ -220,7 +236,7 @@ func handleRetrieve(ctx *bcontext, cfg config, processorFinder processorFinderFu
 			)
 		},
 		cfg.general.panicRecoveryDisabled,
-		cfg.opentracing.traceCleaner,
+		cfg.traceCleaner(ctx.request.Version, ctx.request.Accept),
 	)
 }
 
@@ -234,8 +250,8 @@ func handleCreate(ctx *bcontext, cfg config, processorFinder processorFinderFunc
 		ctx.request.ParentIdentity,
 		elemental.OperationCreate,
 	) {
-		return makeErrorResponse(
-			ctx.ctx,
+		return makeContextErrorResponse(
+			ctx,
 			response,
 			elemental.NewError(
 				"Not allowed",
@@ -263,7 +279,7 @@ func handleCreate(ctx *bcontext, cfg config, processorFinder processorFinderFunc
 			)
 		},
 		cfg.general.panicRecoveryDisabled,
-		cfg.opentracing.traceCleaner,
+		cfg.traceCleaner(ctx.request.Version, ctx.request.Accept),
 	)
 }
 
@@ -277,8 +293,8 @@ func handleUpdate(ctx *bcontext, cfg config, processorFinder processorFinderFunc
 		ctx.request.ParentIdentity,
 		elemental.OperationUpdate,
 	) {
-		return makeErrorResponse(
-			ctx.ctx,
+		return makeContextErrorResponse(
+			ctx,
 			response,
 			elemental.NewError(
 				"Not allowed",
@@ -306,7 +322,7 @@ func handleUpdate(ctx *bcontext, cfg config, processorFinder processorFinderFunc
 			)
 		},
 		cfg.general.panicRecoveryDisabled,
-		cfg.opentracing.traceCleaner,
+		cfg.traceCleaner(ctx.request.Version, ctx.request.Accept),
 	)
 }
 
@@ -320,8 +336,8 @@ func handleDelete(ctx *bcontext, cfg config, processorFinder processorFinderFunc
 		ctx.request.ParentIdentity,
 		elemental.OperationDelete,
 	) {
-		return makeErrorResponse(
-			ctx.ctx,
+		return makeContextErrorResponse(
+			ctx,
 			response,
 			elemental.NewError(
 				"Not allowed",
@@ -347,7 +363,7 @@ func handleDelete(ctx *bcontext, cfg config, processorFinder processorFinderFunc
 			)
 		},
 		cfg.general.panicRecoveryDisabled,
-		cfg.opentracing.traceCleaner,
+		cfg.traceCleaner(ctx.request.Version, ctx.request.Accept),
 	)
 }
 
@@ -361,8 +377,8 @@ func handleInfo(ctx *bcontext, cfg config, processorFinder processorFinderFunc,
 		ctx.request.ParentIdentity,
 		elemental.OperationInfo,
 	) {
-		return makeErrorResponse(
-			ctx.ctx,
+		return makeContextErrorResponse(
+			ctx,
 			response,
 			elemental.NewError(
 				"Not allowed",
@@ -386,7 +402,7 @@ func handleInfo(ctx *bcontext, cfg config, processorFinder processorFinderFunc,
 			)
 		},
 		cfg.general.panicRecoveryDisabled,
-		cfg.opentracing.traceCleaner,
+		cfg.traceCleaner(ctx.request.Version, ctx.request.Accept),
 	)
 }
 
@@ -400,8 +416,8 @@ func handlePatch(ctx *bcontext, cfg config, processorFinder processorFinderFunc,
 		ctx.request.ParentIdentity,
 		elemental.OperationPatch,
 	) {
-		return makeErrorResponse(
-			ctx.ctx,
+		return makeContextErrorResponse(
+			ctx,
 			response,
 			elemental.NewError(
 				"Not allowed",
@@ -429,6 +445,6 @@ func handlePatch(ctx *bcontext, cfg config, processorFinder processorFinderFunc,
 			)
 		},
 		cfg.general.panicRecoveryDisabled,
-		cfg.opentracing.traceCleaner,
+		cfg.traceCleaner(ctx.request.Version, ctx.request.Accept),
 	)
 }
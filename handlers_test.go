@@ -222,6 +222,28 @@ func TestHandlers_makeResponse(t *testing.T) {
 		})
 	})
 
+	Convey("Given I have context indentifiable output data, a cleaner func and no span", t, func() {
+
+		req := elemental.NewRequest()
+		ctx := newContext(context.Background(), req)
+
+		response := elemental.NewResponse(req)
+		ctx.outputData = &testmodel.List{Name: "the name"}
+
+		Convey("When I call makeResponse", func() {
+
+			var called bool
+			makeResponse(ctx, response, func(identity elemental.Identity, data []byte) []byte {
+				called = true
+				return data
+			})
+
+			Convey("Then the cleaner should not be called", func() {
+				So(called, ShouldBeFalse)
+			})
+		})
+	})
+
 	Convey("Given I have context with unmarshalable data and a response", t, func() {
 
 		ctx := newContext(context.TODO(), elemental.NewRequest())
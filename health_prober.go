@@ -0,0 +1,318 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A HealthStatusChange describes the change of the
+// status of a Pinger probed by a HealthProber.
+type HealthStatusChange struct {
+	Name     string
+	Previous string
+	Current  string
+	Result   PingResult
+}
+
+// A HealthProber pings Pingers in the background at a regular interval and
+// caches their status. The status of a Pinger only changes after a given
+// number of consecutive failures or successes, so a single failed ping
+// does not make it unhealthy.
+type HealthProber interface {
+
+	// Start starts probing in the background until the given context
+	// is canceled. Calling it again has no effect.
+	Start(ctx context.Context)
+
+	// RetrievePingResults returns the cached results of the Pingers,
+	// sorted by name, like RetrievePingResults.
+	RetrievePingResults() []PingResult
+
+	// RetrieveHealthStatus returns the error of the first unhealthy Pinger
+	// in the order of their names, like RetrieveHealthStatus. A Pinger that
+	// has not been probed yet is unhealthy. It can be used as HealthServerFunc.
+	RetrieveHealthStatus() error
+}
+
+// A HealthProberOption represents an option to the HealthProber.
+type HealthProberOption func(*healthProber)
+
+// HealthProberOptInterval sets the interval between two probes.
+// The default, used if the given interval is not positive, is 10s.
+func HealthProberOptInterval(interval time.Duration) HealthProberOption {
+	return func(p *healthProber) {
+		p.interval = interval
+	}
+}
+
+// HealthProberOptTimeout sets the timeout given to the Pingers.
+// The default, used if the given timeout is not positive, is 5s.
+func HealthProberOptTimeout(timeout time.Duration) HealthProberOption {
+	return func(p *healthProber) {
+		p.timeout = timeout
+	}
+}
+
+// HealthProberOptThresholds sets the number of consecutive failures needed
+// for a healthy Pinger to become unhealthy, and the number of consecutive
+// successes needed for an unhealthy Pinger to become healthy again. The
+// defaults are 3 and 1. Values lower than 1 are considered as 1.
+func HealthProberOptThresholds(failure int, success int) HealthProberOption {
+	return func(p *healthProber) {
+		p.failureThreshold = failure
+		p.successThreshold = success
+	}
+}
+
+// HealthProberOptStatusChangeHandler sets the function called every time the
+// status of a Pinger changes, including when it is probed for the first time.
+// It is called from the probing goroutine, one change at a time.
+func HealthProberOptStatusChangeHandler(handler func(HealthStatusChange)) HealthProberOption {
+	return func(p *healthProber) {
+		p.onChange = handler
+	}
+}
+
+// HealthProberOptLogger sets the logger to use. By default, the logger set
+// by OptLogger is used when the prober is started by the server, and the
+// global zap logger otherwise.
+func HealthProberOptLogger(logger *zap.Logger) HealthProberOption {
+	return func(p *healthProber) {
+		p.logger = logger
+	}
+}
+
+// A probeState holds the cached status of a Pinger.
+type probeState struct {
+	status    string
+	result    PingResult
+	failures  int
+	successes int
+}
+
+type healthProber struct {
+	pingers          map[string]Pinger
+	names            []string
+	interval         time.Duration
+	timeout          time.Duration
+	failureThreshold int
+	successThreshold int
+	onChange         func(HealthStatusChange)
+	logger           *zap.Logger
+	states           map[string]*probeState
+	lock             sync.RWMutex
+	startOnce        sync.Once
+}
+
+// NewHealthProber returns a new HealthProber probing the given Pingers.
+func NewHealthProber(pingers map[string]Pinger, options ...HealthProberOption) HealthProber {
+
+	p := &healthProber{
+		pingers:          pingers,
+		interval:         10 * time.Second,
+		timeout:          5 * time.Second,
+		failureThreshold: 3,
+		successThreshold: 1,
+		states:           make(map[string]*probeState, len(pingers)),
+	}
+
+	for _, opt := range options {
+		opt(p)
+	}
+
+	if p.interval <= 0 {
+		p.interval = 10 * time.Second
+	}
+
+	if p.timeout <= 0 {
+		p.timeout = 5 * time.Second
+	}
+
+	if p.failureThreshold < 1 {
+		p.failureThreshold = 1
+	}
+
+	if p.successThreshold < 1 {
+		p.successThreshold = 1
+	}
+
+	for name := range pingers {
+
+		err := fmt.Errorf("%s has not been probed yet", name)
+
+		p.states[name] = &probeState{
+			status: PingStatusUnknown,
+			result: PingResult{Name: name, Status: PingStatusUnknown, Error: err.Error(), err: err},
+		}
+
+		p.names = append(p.names, name)
+	}
+
+	sort.Strings(p.names)
+
+	return p
+}
+
+func (p *healthProber) Start(ctx context.Context) {
+
+	p.start(ctx, zap.L())
+}
+
+// start starts probing like Start, using the given
+// logger if none is set by HealthProberOptLogger.
+func (p *healthProber) start(ctx context.Context, logger *zap.Logger) {
+
+	p.startOnce.Do(func() {
+
+		if p.logger == nil {
+			p.logger = logger
+		}
+
+		go p.run(ctx)
+	})
+}
+
+// run probes the Pingers at every interval
+// until the given context is canceled.
+func (p *healthProber) run(ctx context.Context) {
+
+	p.probe()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.probe()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *healthProber) RetrievePingResults() []PingResult {
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	results := make([]PingResult, 0, len(p.states))
+	for _, name := range p.names {
+		results = append(results, p.states[name].result)
+	}
+
+	return results
+}
+
+func (p *healthProber) RetrieveHealthStatus() error {
+
+	for _, result := range p.RetrievePingResults() {
+		if result.err != nil {
+			return result.err
+		}
+	}
+
+	return nil
+}
+
+// probe pings all the Pingers and updates their status.
+func (p *healthProber) probe() {
+
+	logger := p.logger
+	if logger == nil {
+		logger = zap.L()
+	}
+
+	for _, result := range pingAll(p.timeout, p.pingers) {
+
+		change, changed := p.update(result)
+		if !changed {
+			continue
+		}
+
+		logger.Info("Health status changed",
+			zap.String("service", change.Name),
+			zap.String("previous", change.Previous),
+			zap.String("current", change.Current),
+			zap.Error(result.err),
+		)
+
+		if p.onChange != nil {
+			p.onChange(change)
+		}
+	}
+}
+
+// update updates the cached status of a Pinger with the given result, and
+// returns the change of its status, if any. The first result sets the
+// status. Then a healthy Pinger becomes unhealthy after failureThreshold
+// consecutive failures, and an unhealthy Pinger becomes healthy again after
+// successThreshold consecutive successes.
+func (p *healthProber) update(result PingResult) (HealthStatusChange, bool) {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	state := p.states[result.Name]
+
+	if result.err == nil {
+		state.successes++
+		state.failures = 0
+	} else {
+		state.failures++
+		state.successes = 0
+	}
+
+	previous := state.status
+
+	switch {
+	case previous == PingStatusUnknown:
+		state.status = result.Status
+	case result.err == nil && previous != PingStatusOK && state.successes >= p.successThreshold:
+		state.status = PingStatusOK
+	case result.err != nil && previous == PingStatusOK && state.failures >= p.failureThreshold:
+		state.status = result.Status
+	case result.err != nil && previous != PingStatusOK:
+		state.status = result.Status
+	}
+
+	// The cached result holds the status, and the error of
+	// the last ping only if the Pinger is unhealthy.
+	state.result = result
+	state.result.Status = state.status
+
+	if state.status == PingStatusOK {
+		state.result.Error = ""
+		state.result.err = nil
+	} else if result.err == nil {
+		state.result.err = fmt.Errorf("%s is unhealthy", result.Name)
+		state.result.Error = state.result.err.Error()
+	}
+
+	if state.status == previous {
+		return HealthStatusChange{}, false
+	}
+
+	return HealthStatusChange{
+		Name:     result.Name,
+		Previous: previous,
+		Current:  state.status,
+		Result:   state.result,
+	}, true
+}
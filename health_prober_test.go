@@ -0,0 +1,374 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+)
+
+// A switchPinger is a Pinger whose error can be changed.
+type switchPinger struct {
+	err  error
+	lock sync.Mutex
+}
+
+func (p *switchPinger) Ping(time.Duration) error {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.err
+}
+
+func (p *switchPinger) set(err error) {
+
+	p.lock.Lock()
+	p.err = err
+	p.lock.Unlock()
+}
+
+// A pingerFunc is a function used as a Pinger.
+type pingerFunc func(time.Duration) error
+
+func (f pingerFunc) Ping(timeout time.Duration) error {
+	return f(timeout)
+}
+
+func TestHealthProber_NewHealthProber(t *testing.T) {
+
+	Convey("Given I create a new HealthProber with options", t, func() {
+
+		handler := func(HealthStatusChange) {}
+		logger := zap.NewNop()
+
+		p := NewHealthProber(
+			map[string]Pinger{"b": MockPinger{}, "a": MockPinger{}},
+			HealthProberOptInterval(time.Second),
+			HealthProberOptTimeout(2*time.Second),
+			HealthProberOptThresholds(0, 2),
+			HealthProberOptStatusChangeHandler(handler),
+			HealthProberOptLogger(logger),
+		).(*healthProber)
+
+		Convey("Then it should be correctly configured", func() {
+			So(p.interval, ShouldEqual, time.Second)
+			So(p.timeout, ShouldEqual, 2*time.Second)
+			So(p.failureThreshold, ShouldEqual, 1)
+			So(p.successThreshold, ShouldEqual, 2)
+			So(p.onChange, ShouldNotBeNil)
+			So(p.logger, ShouldEqual, logger)
+			So(p.names, ShouldResemble, []string{"a", "b"})
+		})
+
+		Convey("Then the pingers should not be probed yet", func() {
+			results := p.RetrievePingResults()
+			So(len(results), ShouldEqual, 2)
+			So(results[0].Name, ShouldEqual, "a")
+			So(results[0].Status, ShouldEqual, PingStatusUnknown)
+			So(results[0].Error, ShouldEqual, "a has not been probed yet")
+			So(p.RetrieveHealthStatus(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestHealthProber_update(t *testing.T) {
+
+	Convey("Given I have a HealthProber with thresholds", t, func() {
+
+		p := NewHealthProber(
+			map[string]Pinger{"db": MockPinger{}},
+			HealthProberOptThresholds(3, 2),
+		).(*healthProber)
+
+		ok := PingResult{Name: "db", Status: PingStatusOK}
+		ko := PingResult{Name: "db", Status: PingStatusTimeout, Error: PingStatusTimeout, err: errors.New(PingStatusTimeout)}
+
+		Convey("When the first ping succeeds", func() {
+
+			change, changed := p.update(ok)
+
+			Convey("Then the status should change right away", func() {
+				So(changed, ShouldBeTrue)
+				So(change.Previous, ShouldEqual, PingStatusUnknown)
+				So(change.Current, ShouldEqual, PingStatusOK)
+				So(p.RetrieveHealthStatus(), ShouldBeNil)
+			})
+
+			Convey("When the next pings fail less than the failure threshold", func() {
+
+				_, changed1 := p.update(ko)
+				_, changed2 := p.update(ko)
+
+				Convey("Then the status should not change", func() {
+					So(changed1, ShouldBeFalse)
+					So(changed2, ShouldBeFalse)
+					So(p.RetrievePingResults()[0].Status, ShouldEqual, PingStatusOK)
+					So(p.RetrievePingResults()[0].Error, ShouldBeEmpty)
+					So(p.RetrieveHealthStatus(), ShouldBeNil)
+				})
+
+				Convey("When a ping succeeds then fails again", func() {
+
+					p.update(ok)
+					_, changed := p.update(ko)
+
+					Convey("Then the failures should have been reset", func() {
+						So(changed, ShouldBeFalse)
+						So(p.RetrieveHealthStatus(), ShouldBeNil)
+					})
+				})
+
+				Convey("When the next ping fails too", func() {
+
+					change, changed := p.update(ko)
+
+					Convey("Then the status should change", func() {
+						So(changed, ShouldBeTrue)
+						So(change.Previous, ShouldEqual, PingStatusOK)
+						So(change.Current, ShouldEqual, PingStatusTimeout)
+						So(change.Result.Error, ShouldEqual, PingStatusTimeout)
+						So(p.RetrieveHealthStatus().Error(), ShouldEqual, PingStatusTimeout)
+					})
+
+					Convey("When the next ping succeeds once", func() {
+
+						_, changed := p.update(ok)
+
+						Convey("Then the status should not change", func() {
+							So(changed, ShouldBeFalse)
+							So(p.RetrievePingResults()[0].Status, ShouldEqual, PingStatusTimeout)
+							So(p.RetrieveHealthStatus().Error(), ShouldEqual, "db is unhealthy")
+						})
+
+						Convey("When the next ping succeeds again", func() {
+
+							change, changed := p.update(ok)
+
+							Convey("Then the status should change back", func() {
+								So(changed, ShouldBeTrue)
+								So(change.Previous, ShouldEqual, PingStatusTimeout)
+								So(change.Current, ShouldEqual, PingStatusOK)
+								So(p.RetrieveHealthStatus(), ShouldBeNil)
+							})
+						})
+					})
+
+					Convey("When the next ping fails with an error", func() {
+
+						change, changed := p.update(PingResult{Name: "db", Status: PingStatusError, Error: "boom", err: errors.New("boom")})
+
+						Convey("Then the status should change to the new failure", func() {
+							So(changed, ShouldBeTrue)
+							So(change.Previous, ShouldEqual, PingStatusTimeout)
+							So(change.Current, ShouldEqual, PingStatusError)
+						})
+					})
+				})
+			})
+		})
+
+		Convey("When the first ping fails", func() {
+
+			change, changed := p.update(ko)
+
+			Convey("Then the status should change right away", func() {
+				So(changed, ShouldBeTrue)
+				So(change.Current, ShouldEqual, PingStatusTimeout)
+				So(p.RetrieveHealthStatus(), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestHealthProber_StartTwice(t *testing.T) {
+
+	Convey("Given I have a HealthProber", t, func() {
+
+		var pings int32
+		pinger := pingerFunc(func(time.Duration) error {
+			atomic.AddInt32(&pings, 1)
+			return nil
+		})
+
+		p := NewHealthProber(map[string]Pinger{"db": pinger}, HealthProberOptInterval(time.Hour))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		Convey("When I start it twice", func() {
+
+			p.Start(ctx)
+			p.Start(ctx)
+
+			time.Sleep(30 * time.Millisecond)
+
+			Convey("Then only one probing loop should run", func() {
+				So(atomic.LoadInt32(&pings), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestHealthProber_NewHealthProberDefaults(t *testing.T) {
+
+	Convey("Given I create a new HealthProber with an invalid interval and timeout", t, func() {
+
+		p := NewHealthProber(
+			map[string]Pinger{"a": MockPinger{}},
+			HealthProberOptInterval(0),
+			HealthProberOptTimeout(-time.Second),
+		).(*healthProber)
+
+		Convey("Then the defaults should be used", func() {
+			So(p.interval, ShouldEqual, 10*time.Second)
+			So(p.timeout, ShouldEqual, 5*time.Second)
+		})
+	})
+}
+
+func TestHealthProber_Start(t *testing.T) {
+
+	Convey("Given I have a HealthProber with a status change handler", t, func() {
+
+		pinger := &switchPinger{}
+
+		changes := make(chan HealthStatusChange, 10)
+
+		p := NewHealthProber(
+			map[string]Pinger{"db": pinger},
+			HealthProberOptInterval(20*time.Millisecond),
+			HealthProberOptThresholds(2, 1),
+			HealthProberOptStatusChangeHandler(func(c HealthStatusChange) { changes <- c }),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		Convey("When I start it", func() {
+
+			p.Start(ctx)
+
+			first := <-changes
+
+			Convey("Then the first probe should set the status", func() {
+				So(first.Name, ShouldEqual, "db")
+				So(first.Previous, ShouldEqual, PingStatusUnknown)
+				So(first.Current, ShouldEqual, PingStatusOK)
+				So(p.RetrieveHealthStatus(), ShouldBeNil)
+			})
+
+			Convey("When the pinger starts failing", func() {
+
+				pinger.set(errors.New("boom"))
+
+				var change HealthStatusChange
+				select {
+				case change = <-changes:
+				case <-time.After(2 * time.Second):
+				}
+
+				Convey("Then the status should change after the failure threshold", func() {
+					So(change.Previous, ShouldEqual, PingStatusOK)
+					So(change.Current, ShouldEqual, PingStatusError)
+					So(p.RetrieveHealthStatus().Error(), ShouldEqual, "boom")
+				})
+			})
+		})
+
+		Convey("When the server starts it", func() {
+
+			logger := zap.NewNop()
+			p.(*healthProber).start(ctx, logger)
+
+			<-changes
+
+			Convey("Then the logger of the server should be used", func() {
+				So(p.(*healthProber).logger, ShouldEqual, logger)
+			})
+		})
+	})
+}
+
+func TestHealthProber_healthServer(t *testing.T) {
+
+	Convey("Given I have a health server with a prober", t, func() {
+
+		pinger := &switchPinger{}
+		prober := NewHealthProber(map[string]Pinger{"db": pinger}, HealthProberOptThresholds(1, 1)).(*healthProber)
+
+		cfg := config{}
+		cfg.healthServer.prober = prober
+		cfg.healthServer.pingers = map[string]Pinger{"other": MockPinger{}}
+
+		hs := newHealthServer(cfg)
+		hs.setStatus(HealthStatusOK)
+
+		get := func(path string) int {
+			w := httptest.NewRecorder()
+			hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			return w.Code
+		}
+
+		Convey("When the prober has not probed yet", func() {
+
+			Convey("Then the server should be unhealthy and not ready", func() {
+				So(get("/"), ShouldEqual, http.StatusInternalServerError)
+				So(get("/ready"), ShouldEqual, http.StatusServiceUnavailable)
+			})
+		})
+
+		Convey("When the prober has probed", func() {
+
+			prober.probe()
+
+			Convey("Then the server should be healthy and ready", func() {
+				So(get("/"), ShouldEqual, http.StatusNoContent)
+				So(get("/ready"), ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then the cached results of the prober should be reported", func() {
+				report := hs.readiness()
+				So(len(report.Pingers), ShouldEqual, 1)
+				So(report.Pingers[0].Name, ShouldEqual, "db")
+			})
+
+			Convey("When the pinger fails without probing", func() {
+
+				pinger.set(errors.New("boom"))
+
+				Convey("Then the cached status should be served", func() {
+					So(get("/"), ShouldEqual, http.StatusNoContent)
+					So(get("/ready"), ShouldEqual, http.StatusOK)
+				})
+			})
+		})
+
+		Convey("When a health handler is set", func() {
+
+			hs.cfg.healthServer.healthHandler = func() error { return nil }
+
+			Convey("Then / should use it", func() {
+				So(get("/"), ShouldEqual, http.StatusNoContent)
+			})
+		})
+	})
+}
@@ -13,17 +13,43 @@ package bahamut
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+const (
+	// HealthStatusOK represents the status of a live or ready server.
+	HealthStatusOK = "ok"
+	// HealthStatusStarting represents the status of a server
+	// that is not ready as it is still starting.
+	HealthStatusStarting = "starting"
+	// HealthStatusDraining represents the status of a server
+	// that is not ready as it is stopping.
+	HealthStatusDraining = "draining"
+	// HealthStatusError represents the status of a server
+	// that is not ready as its health checks are failing.
+	HealthStatusError = "error"
+)
+
+// A HealthReport is the JSON report returned by the
+// liveness and readiness endpoints of the health server.
+type HealthReport struct {
+	Status  string       `json:"status"`
+	Error   string       `json:"error,omitempty"`
+	Pingers []PingResult `json:"pingers,omitempty"`
+}
+
 // an healthServer is the structure serving the health check endpoint.
 type healthServer struct {
 	cfg    config
 	server *http.Server
+	status string
+	lock   sync.RWMutex
 }
 
 // newHealthServer returns a new healthServer.
@@ -32,6 +58,7 @@ func newHealthServer(cfg config) *healthServer {
 	s := &healthServer{
 		cfg:    cfg,
 		server: &http.Server{Addr: cfg.healthServer.listenAddress},
+		status: HealthStatusStarting,
 	}
 
 	s.server.Handler = s
@@ -39,6 +66,22 @@ func newHealthServer(cfg config) *healthServer {
 	return s
 }
 
+// setStatus sets the readiness status of the server.
+func (s *healthServer) setStatus(status string) {
+
+	s.lock.Lock()
+	s.status = status
+	s.lock.Unlock()
+}
+
+func (s *healthServer) getStatus() string {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.status
+}
+
 func (s *healthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodGet {
@@ -50,18 +93,44 @@ func (s *healthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	case "/":
 
-		if s.cfg.healthServer.healthHandler == nil {
+		if s.getStatus() == HealthStatusDraining {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		healthHandler := s.healthHandler()
+		if healthHandler == nil {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
-		if err := s.cfg.healthServer.healthHandler(); err != nil {
+		if err := healthHandler(); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
 		w.WriteHeader(http.StatusNoContent)
 
+	case "/live":
+
+		s.writeHealthReport(w, http.StatusOK, HealthReport{Status: HealthStatusOK})
+
+	case "/ready":
+
+		report := s.readiness()
+
+		code := http.StatusOK
+		if report.Status != HealthStatusOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		if _, verbose := r.URL.Query()["verbose"]; !verbose {
+			report.Error = ""
+			report.Pingers = nil
+		}
+
+		s.writeHealthReport(w, code, report)
+
 	case "/metrics":
 		if s.cfg.healthServer.metricsManager == nil {
 			w.WriteHeader(http.StatusNotImplemented)
@@ -87,20 +156,77 @@ func (s *healthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// healthHandler returns the HealthServerFunc to use, falling back
+// on the HealthProber, if any.
+func (s *healthServer) healthHandler() HealthServerFunc {
+
+	if s.cfg.healthServer.healthHandler == nil && s.cfg.healthServer.prober != nil {
+		return s.cfg.healthServer.prober.RetrieveHealthStatus
+	}
+
+	return s.cfg.healthServer.healthHandler
+}
+
+// readiness returns the readiness report of the server. The server is not
+// ready while it is starting or draining, or if the health handler or one
+// of the pingers returns an error. If there is a HealthProber, the cached
+// results of its pingers are used.
+func (s *healthServer) readiness() HealthReport {
+
+	if status := s.getStatus(); status != HealthStatusOK {
+		return HealthReport{Status: status}
+	}
+
+	report := HealthReport{Status: HealthStatusOK}
+
+	if s.cfg.healthServer.healthHandler != nil {
+		if err := s.cfg.healthServer.healthHandler(); err != nil {
+			report.Status = HealthStatusError
+			report.Error = err.Error()
+		}
+	}
+
+	switch {
+	case s.cfg.healthServer.prober != nil:
+		report.Pingers = s.cfg.healthServer.prober.RetrievePingResults()
+	case len(s.cfg.healthServer.pingers) > 0:
+		report.Pingers = retrievePingResults(s.cfg.healthServer.pingTimeout, s.cfg.healthServer.pingers, s.cfg.logger())
+	}
+
+	for _, result := range report.Pingers {
+		if result.Err() != nil {
+			report.Status = HealthStatusError
+		}
+	}
+
+	return report
+}
+
+// writeHealthReport writes the given report with the given status code.
+func (s *healthServer) writeHealthReport(w http.ResponseWriter, code int, report HealthReport) {
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.cfg.logger().Error("Unable to encode health report", zap.Error(err))
+	}
+}
+
 func (s *healthServer) start(ctx context.Context) {
 
-	zap.L().Debug("Health server enabled", zap.String("listen", s.cfg.healthServer.listenAddress))
+	s.cfg.logger().Debug("Health server enabled", zap.String("listen", s.cfg.healthServer.listenAddress))
 
 	go func() {
 		if err := s.server.ListenAndServe(); err != nil {
 			if err == http.ErrServerClosed {
 				return
 			}
-			zap.L().Fatal("Unable to start health server", zap.Error(err))
+			s.cfg.logger().Fatal("Unable to start health server", zap.Error(err))
 		}
 	}()
 
-	zap.L().Info("Health server started", zap.String("address", s.cfg.healthServer.listenAddress))
+	s.cfg.logger().Info("Health server started", zap.String("address", s.cfg.healthServer.listenAddress))
 
 	<-ctx.Done()
 }
@@ -112,9 +238,9 @@ func (s *healthServer) stop() context.Context {
 	go func() {
 		defer cancel()
 		if err := s.server.Shutdown(ctx); err != nil {
-			zap.L().Error("Could not gracefully stop health server", zap.Error(err))
+			s.cfg.logger().Error("Could not gracefully stop health server", zap.Error(err))
 		} else {
-			zap.L().Debug("Health server stopped")
+			s.cfg.logger().Debug("Health server stopped")
 		}
 	}()
 
@@ -13,13 +13,17 @@ package bahamut
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
 )
 
 func freePort() (port int) {
@@ -37,7 +41,7 @@ func freePort() (port int) {
 // A MetricsManager handles Prometheus Metrics Management
 type testMetricsManager struct{}
 
-func (m *testMetricsManager) MeasureRequest(method string, url string) FinishMeasurementFunc {
+func (m *testMetricsManager) MeasureRequest(string, string, *elemental.Request) FinishMeasurementFunc {
 	return nil
 }
 func (m *testMetricsManager) RegisterWSConnection()   {}
@@ -219,3 +223,119 @@ func TestHealthServerWithCustomHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestHealthServer_liveAndReady(t *testing.T) {
+
+	get := func(hs *healthServer, path string) (int, HealthReport) {
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		report := HealthReport{}
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			panic(err)
+		}
+		return w.Code, report
+	}
+
+	Convey("Given I have a health server with pingers", t, func() {
+
+		var handlerErr error
+
+		cfg := config{}
+		cfg.healthServer.healthHandler = func() error { return handlerErr }
+		cfg.healthServer.pingTimeout = time.Second
+		cfg.healthServer.pingers = map[string]Pinger{
+			"a": MockPinger{},
+			"b": MockPinger{},
+		}
+
+		hs := newHealthServer(cfg)
+
+		Convey("When it is starting", func() {
+
+			Convey("Then it should be live", func() {
+				code, report := get(hs, "/live")
+				So(code, ShouldEqual, http.StatusOK)
+				So(report.Status, ShouldEqual, HealthStatusOK)
+			})
+
+			Convey("Then it should not be ready", func() {
+				code, report := get(hs, "/ready?verbose")
+				So(code, ShouldEqual, http.StatusServiceUnavailable)
+				So(report.Status, ShouldEqual, HealthStatusStarting)
+				So(report.Pingers, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When it is started", func() {
+
+			hs.setStatus(HealthStatusOK)
+
+			Convey("Then it should be ready", func() {
+				code, report := get(hs, "/ready")
+				So(code, ShouldEqual, http.StatusOK)
+				So(report, ShouldResemble, HealthReport{Status: HealthStatusOK})
+			})
+
+			Convey("Then the verbose report should list the pingers", func() {
+				code, report := get(hs, "/ready?verbose")
+				So(code, ShouldEqual, http.StatusOK)
+				So(len(report.Pingers), ShouldEqual, 2)
+				So(report.Pingers[0].Name, ShouldEqual, "a")
+				So(report.Pingers[0].Status, ShouldEqual, PingStatusOK)
+				So(report.Pingers[1].Name, ShouldEqual, "b")
+			})
+
+			Convey("When a pinger fails", func() {
+
+				cfg.healthServer.pingers["b"] = MockPinger{PingStatus: errors.New(PingStatusTimeout)}
+				hs.cfg = cfg
+
+				Convey("Then it should not be ready", func() {
+					code, report := get(hs, "/ready")
+					So(code, ShouldEqual, http.StatusServiceUnavailable)
+					So(report, ShouldResemble, HealthReport{Status: HealthStatusError})
+				})
+
+				Convey("Then the verbose report should give the error", func() {
+					_, report := get(hs, "/ready?verbose=true")
+					So(report.Pingers[1].Status, ShouldEqual, PingStatusTimeout)
+					So(report.Pingers[1].Error, ShouldEqual, PingStatusTimeout)
+				})
+			})
+
+			Convey("When the health handler fails", func() {
+
+				handlerErr = errors.New("boom")
+
+				Convey("Then it should not be ready", func() {
+					code, report := get(hs, "/ready?verbose")
+					So(code, ShouldEqual, http.StatusServiceUnavailable)
+					So(report.Status, ShouldEqual, HealthStatusError)
+					So(report.Error, ShouldEqual, "boom")
+				})
+			})
+		})
+
+		Convey("When it is draining", func() {
+
+			hs.setStatus(HealthStatusDraining)
+
+			Convey("Then it should still be live", func() {
+				code, _ := get(hs, "/live")
+				So(code, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then it should not be ready", func() {
+				code, report := get(hs, "/ready")
+				So(code, ShouldEqual, http.StatusServiceUnavailable)
+				So(report.Status, ShouldEqual, HealthStatusDraining)
+			})
+
+			Convey("Then / should be unhealthy", func() {
+				w := httptest.NewRecorder()
+				hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+	})
+}
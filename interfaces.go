@@ -17,11 +17,12 @@ import (
 	"net/http"
 
 	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
 )
 
 type processorFinderFunc func(identity elemental.Identity) (Processor, error)
 
-type eventPusherFunc func(...*elemental.Event)
+type eventPusherFunc func(context.Context, ...*elemental.Event)
 
 // AuthAction is the type of action an Authenticator or an Authorizer can return.
 type AuthAction int
@@ -84,6 +85,7 @@ type Server interface {
 type Context interface {
 
 	// Identifier returns the internal unique identifier of the context.
+	// The request ID sent by the client is in Request().RequestID.
 	Identifier() string
 
 	// Context returns the underlying context.Context.
@@ -92,6 +94,10 @@ type Context interface {
 	// Request returns the underlying *elemental.Request.
 	Request() *elemental.Request
 
+	// ClientIP returns the IP of the client, resolved from
+	// the trusted proxies headers if any.
+	ClientIP() string
+
 	// InputData returns the data sent by the client
 	InputData() interface{}
 
@@ -104,6 +110,18 @@ type Context interface {
 	// SetOutputData sets the data that will be returned to the client.
 	SetOutputData(interface{})
 
+	// SetPreviousData sets the version of the object before the
+	// update or patch operation. If set, the AttributeChanges
+	// between it and the output data are computed.
+	SetPreviousData(interface{})
+
+	// PreviousData returns the data set by SetPreviousData.
+	PreviousData() interface{}
+
+	// AttributeChanges returns the attributes modified by the
+	// update or patch operation, if the previous data is set.
+	AttributeChanges() AttributeChanges
+
 	// Set count sets the count.
 	SetCount(int)
 
@@ -134,6 +152,10 @@ type Context interface {
 	// Claims returns claims in a map.
 	ClaimsMap() map[string]string
 
+	// AuthDecisions returns the decisions made by the
+	// authenticators and authorizers, in order.
+	AuthDecisions() AuthDecisions
+
 	// Duplicate creates a copy of the Context.
 	Duplicate() Context
 
@@ -152,6 +174,10 @@ type Context interface {
 
 	// Metadata returns the opaque data set by using SetMetadata().
 	Metadata(key interface{}) interface{}
+
+	// Logger returns a logger carrying the request ID, the identity,
+	// the operation, the trace ID and the subject of the claims.
+	Logger() *zap.Logger
 }
 
 // Processor is the interface for a Processor Unit
@@ -233,7 +259,11 @@ type PushPublishHandler interface {
 }
 
 // Auditer is the interface an object must implement in order to handle
-// audit traces.
+// audit traces. The decisions of the authenticators and authorizers
+// can be retrieved from the Context using AuthDecisions().
+//
+// Deprecated: implement AuditRecorder instead. It receives the final
+// status code and is also called for the rejected requests.
 type Auditer interface {
 	Audit(Context, error)
 }
@@ -254,9 +284,11 @@ type Session interface {
 	ClaimsMap() map[string]string
 	Token() string
 	TLSConnectionState() *tls.ConnectionState
+	ClientIP() string
 	Metadata() interface{}
 	SetMetadata(interface{})
 	Context() context.Context
+	Logger() *zap.Logger
 }
 
 // PushSession is a Push Session
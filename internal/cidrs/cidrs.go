@@ -0,0 +1,51 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cidrs contains the helpers used by bahamut
+// and its authorizers to handle lists of networks.
+package cidrs // import "go.aporeto.io/bahamut/internal/cidrs"
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Parse parses the given list of CIDRs or IPs. An IP
+// is returned as a network containing only this IP.
+func Parse(cidrs []string) ([]*net.IPNet, error) {
+
+	networks := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip '%s'", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr '%s': %s", cidr, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cidrs
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+
+	Convey("Given I have valid cidrs", t, func() {
+
+		networks, err := Parse([]string{"10.0.0.0/8", "1.2.3.4", "2001:db8::1", "fd00::/8"})
+
+		Convey("Then they should be parsed", func() {
+			So(err, ShouldBeNil)
+			So(len(networks), ShouldEqual, 4)
+			So(networks[1].String(), ShouldEqual, "1.2.3.4/32")
+			So(networks[2].String(), ShouldEqual, "2001:db8::1/128")
+		})
+	})
+
+	Convey("Given I have invalid cidrs", t, func() {
+
+		_, err1 := Parse([]string{"10.0.0.0/33"})
+		_, err2 := Parse([]string{"not-an-ip"})
+
+		Convey("Then I should get errors", func() {
+			So(err1, ShouldNotBeNil)
+			So(err2, ShouldNotBeNil)
+			So(err2.Error(), ShouldEqual, "invalid ip 'not-an-ip'")
+		})
+	})
+}
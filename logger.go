@@ -0,0 +1,145 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// subjectClaimKey is the key of the claim
+// added to the scoped loggers.
+const subjectClaimKey = "@auth:subject"
+
+type loggerContextKey struct{}
+
+type scopedLoggerContextKey struct{}
+
+// A loggerProvider returns a logger. It is implemented by the
+// Contexts, so the logger of a request carries the subject of
+// its claims once it is authenticated.
+type loggerProvider interface {
+	Logger() *zap.Logger
+}
+
+// logger returns the logger set by OptLogger,
+// or the global zap logger.
+func (c config) logger() *zap.Logger {
+
+	if c.general.logger != nil {
+		return c.general.logger
+	}
+
+	return zap.L()
+}
+
+// contextLogger returns the logger of the request the given
+// context.Context belongs to, or the logger set by OptLogger.
+func (c config) contextLogger(ctx context.Context) *zap.Logger {
+
+	if l := lookupLogger(ctx); l != nil {
+		return l
+	}
+
+	return c.logger()
+}
+
+// loggerFromContext returns the logger of the request the given
+// context.Context belongs to, or the global zap logger.
+func loggerFromContext(ctx context.Context) *zap.Logger {
+
+	if l := lookupLogger(ctx); l != nil {
+		return l
+	}
+
+	return zap.L()
+}
+
+// lookupLogger returns the logger of the request scoped Context stored
+// in the given context.Context, or the base logger stored in it, or nil.
+func lookupLogger(ctx context.Context) *zap.Logger {
+
+	if ctx == nil {
+		return nil
+	}
+
+	if p, ok := ctx.Value(scopedLoggerContextKey{}).(loggerProvider); ok {
+		return p.Logger()
+	}
+
+	return baseLoggerFromContext(ctx)
+}
+
+// baseLoggerFromContext returns the base logger stored
+// in the given context.Context, or nil.
+func baseLoggerFromContext(ctx context.Context) *zap.Logger {
+
+	if ctx == nil {
+		return nil
+	}
+
+	l, _ := ctx.Value(loggerContextKey{}).(*zap.Logger)
+
+	return l
+}
+
+// contextWithLogger returns a copy of the given context.Context
+// holding the given base logger, from which the loggers of the
+// Contexts are derived.
+func contextWithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// contextWithScopedLogger returns a copy of the given context.Context
+// holding the given loggerProvider, used to log on behalf of a request.
+func contextWithScopedLogger(ctx context.Context, provider loggerProvider) context.Context {
+	return context.WithValue(ctx, scopedLoggerContextKey{}, provider)
+}
+
+// makeRequestLogger returns a logger with the fields
+// of the given request and the given context's span.
+func makeRequestLogger(ctx context.Context, base *zap.Logger, request *elemental.Request) *zap.Logger {
+
+	fields := []zap.Field{
+		zap.String("request-id", request.RequestID),
+	}
+
+	if request.Identity.Name != "" {
+		fields = append(fields, zap.String("identity", request.Identity.Name))
+	}
+
+	if request.Operation != "" {
+		fields = append(fields, zap.String("operation", string(request.Operation)))
+	}
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		fields = append(fields, zap.String("trace-id", extractSpanID(span)))
+	} else if id, ok := otelTraceID(ctx); ok {
+		fields = append(fields, zap.String("trace-id", id))
+	}
+
+	return base.With(fields...)
+}
+
+// withSubject returns the given logger with the subject
+// found in the given claims map, if any.
+func withSubject(logger *zap.Logger, claimsMap map[string]string) *zap.Logger {
+
+	if subject, ok := claimsMap[subjectClaimKey]; ok {
+		return logger.With(zap.String("subject", subject))
+	}
+
+	return logger
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger_config(t *testing.T) {
+
+	Convey("Given I have a config without logger", t, func() {
+
+		cfg := config{}
+
+		Convey("Then the global logger should be used", func() {
+			So(cfg.logger(), ShouldEqual, zap.L())
+		})
+	})
+
+	Convey("Given I have a config with a logger", t, func() {
+
+		logger := zap.NewNop()
+		cfg := config{}
+		OptLogger(logger)(&cfg)
+
+		Convey("Then it should be used", func() {
+			So(cfg.logger(), ShouldEqual, logger)
+		})
+	})
+}
+
+func TestLogger_loggerFromContext(t *testing.T) {
+
+	Convey("Given I have a context with a logger", t, func() {
+
+		logger := zap.NewNop()
+		ctx := contextWithLogger(context.Background(), logger)
+
+		Convey("Then I should retrieve it", func() {
+			So(loggerFromContext(ctx), ShouldEqual, logger)
+		})
+	})
+
+	Convey("Given I have a context without logger", t, func() {
+
+		Convey("Then I should get the global logger", func() {
+			So(loggerFromContext(context.Background()), ShouldEqual, zap.L())
+			So(loggerFromContext(nil), ShouldEqual, zap.L()) // nolint
+		})
+	})
+}
+
+func TestLogger_ContextLogger(t *testing.T) {
+
+	Convey("Given I have a context with a logger and a span", t, func() {
+
+		core, logs := observer.New(zap.DebugLevel)
+
+		tracer := mocktracer.New()
+		span := tracer.StartSpan("test")
+
+		ctx := contextWithLogger(context.Background(), zap.New(core))
+		ctx = opentracing.ContextWithSpan(ctx, span)
+
+		req := elemental.NewRequest()
+		req.RequestID = "rid"
+		req.Identity = elemental.MakeIdentity("list", "lists")
+		req.Operation = elemental.OperationCreate
+
+		bctx := newContext(ctx, req)
+
+		Convey("When I log something before authentication", func() {
+
+			bctx.Logger().Info("hello")
+
+			Convey("Then the request fields should be set", func() {
+				So(logs.Len(), ShouldEqual, 1)
+				fields := logs.All()[0].ContextMap()
+				So(fields["request-id"], ShouldEqual, "rid")
+				So(fields["identity"], ShouldEqual, "list")
+				So(fields["operation"], ShouldEqual, string(elemental.OperationCreate))
+				So(fields["trace-id"], ShouldEqual, extractSpanID(span))
+				So(fields, ShouldNotContainKey, "subject")
+			})
+		})
+
+		Convey("When I log something after authentication", func() {
+
+			bctx.SetClaims([]string{"@auth:subject=bob"})
+			bctx.Logger().Info("hello")
+
+			Convey("Then the subject should be set", func() {
+				So(logs.All()[0].ContextMap()["subject"], ShouldEqual, "bob")
+			})
+		})
+
+		Convey("When I log something using the request scoped context", func() {
+
+			bctx.ctx = contextWithScopedLogger(bctx.ctx, bctx)
+			bctx.SetClaims([]string{"@auth:subject=bob"})
+			loggerFromContext(bctx.Context()).Info("hello")
+			config{}.contextLogger(bctx.Context()).Info("hello")
+
+			Convey("Then the request fields and the subject should be set", func() {
+				So(logs.Len(), ShouldEqual, 2)
+				for _, entry := range logs.All() {
+					So(entry.ContextMap()["request-id"], ShouldEqual, "rid")
+					So(entry.ContextMap()["subject"], ShouldEqual, "bob")
+				}
+			})
+		})
+	})
+}
+
+func TestLogger_SessionLogger(t *testing.T) {
+
+	Convey("Given I have a push session", t, func() {
+
+		core, logs := observer.New(zap.DebugLevel)
+
+		cfg := config{}
+		cfg.general.logger = zap.New(core)
+
+		u, _ := url.Parse("http://localhost/events")
+		req := &http.Request{URL: u, Header: http.Header{}}
+		req.Header.Set(RequestIDHeader, "rid")
+		req = req.WithContext(context.Background())
+
+		s := newWSPushSession(req, cfg, nil, elemental.EncodingTypeJSON, elemental.EncodingTypeJSON)
+		s.SetClaims([]string{"@auth:subject=bob"})
+
+		Convey("When I log something", func() {
+
+			s.Logger().Info("hello")
+
+			Convey("Then the session fields should be set", func() {
+				fields := logs.All()[0].ContextMap()
+				So(fields["session"], ShouldEqual, s.Identifier())
+				So(fields["request-id"], ShouldEqual, "rid")
+				So(fields["subject"], ShouldEqual, "bob")
+			})
+		})
+	})
+}
@@ -12,18 +12,103 @@
 package bahamut
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
+	"go.aporeto.io/elemental"
 )
 
+// A MeasurementResult holds the outcome of a request measured
+// by a MetricsManager.
+type MeasurementResult struct {
+
+	// Code is the status code of the response.
+	Code int
+
+	// ResponseSize is the size of the body of the response in bytes.
+	ResponseSize int
+
+	// Panicked is true if the processing of the request panicked.
+	Panicked bool
+
+	// Span is the span of the request, if any.
+	Span opentracing.Span
+
+	// TraceID is the ID of the trace of the request, if it is traced.
+	TraceID string
+
+	// Start is the time the server received the request. If it is zero,
+	// the duration is measured from the call to MeasureRequest.
+	Start time.Time
+}
+
 // FinishMeasurementFunc is the kind of functinon returned by MetricsManager.MeasureRequest().
-type FinishMeasurementFunc func(code int, span opentracing.Span)
+type FinishMeasurementFunc func(result MeasurementResult)
 
 // A MetricsManager handles Prometheus Metrics Management
 type MetricsManager interface {
-	MeasureRequest(method string, url string) FinishMeasurementFunc
+	MeasureRequest(method string, url string, request *elemental.Request) FinishMeasurementFunc
 	RegisterWSConnection()
 	UnregisterWSConnection()
 	Write(w http.ResponseWriter, r *http.Request)
 }
+
+// A TLSMetricsManager is a MetricsManager that can also record
+// the expiration of the TLS certificate of the server. If the
+// MetricsManager implements it, the expiration is recorded each
+// time the certificate is loaded.
+type TLSMetricsManager interface {
+	SetTLSCertificateExpiration(notAfter time.Time)
+}
+
+// A CacheMetricsManager is a MetricsManager that can also record
+// the lookups of the caches, like the ones of the authorizer/cache
+// package. The lookups are only recorded if the MetricsManager
+// implements it.
+type CacheMetricsManager interface {
+	RegisterCacheLookup(cache string, hit bool)
+}
+
+type measurePanicContextKey struct{}
+
+// A measurePanic records whether the processing
+// of a measured request panicked.
+type measurePanic struct {
+	panicked bool
+	lock     sync.Mutex
+}
+
+// contextWithMeasurePanic returns a copy of the given context holding
+// a new measurePanic, and the measurePanic.
+func contextWithMeasurePanic(ctx context.Context) (context.Context, *measurePanic) {
+
+	p := &measurePanic{}
+
+	return context.WithValue(ctx, measurePanicContextKey{}, p), p
+}
+
+// setMeasurePanicked records that the processing of the request
+// measured with the given context panicked.
+func setMeasurePanicked(ctx context.Context) {
+
+	if p, ok := ctx.Value(measurePanicContextKey{}).(*measurePanic); ok {
+		p.lock.Lock()
+		p.panicked = true
+		p.lock.Unlock()
+	}
+}
+
+func (p *measurePanic) hasPanicked() bool {
+
+	if p == nil {
+		return false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.panicked
+}
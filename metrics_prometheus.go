@@ -16,10 +16,11 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
-	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.aporeto.io/elemental"
 )
 
 var vregexp = regexp.MustCompile(`^/v/\d+`)
@@ -38,38 +39,88 @@ func sanitizeURL(url string) string {
 	return strings.Join(parts, "/")
 }
 
+// statusClass returns the class of the given status code, like 2xx.
+func statusClass(code int) string {
+
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// sizeBuckets are the buckets of the request and response size histograms,
+// from 64 bytes to 16 megabytes.
+var sizeBuckets = prometheus.ExponentialBuckets(64, 4, 10)
+
 type prometheusMetricsManager struct {
-	reqDurationMetric   *prometheus.SummaryVec
+	reqDurationMetric   *prometheus.HistogramVec
 	reqTotalMetric      *prometheus.CounterVec
+	reqSizeMetric       *prometheus.HistogramVec
+	respSizeMetric      *prometheus.HistogramVec
+	reqInFlightMetric   *prometheus.GaugeVec
 	errorMetric         *prometheus.CounterVec
+	panicMetric         *prometheus.CounterVec
+	deniedMetric        *prometheus.CounterVec
 	wsConnTotalMetric   prometheus.Counter
 	wsConnCurrentMetric prometheus.Gauge
+	tlsExpirationMetric prometheus.Gauge
+	cacheLookupMetric   *prometheus.CounterVec
 
 	handler http.Handler
 }
 
 // NewPrometheusMetricsManager returns a new MetricManager using the prometheus format.
+// The OpenMetrics format is served to the clients negotiating it, with the
+// IDs of the traces attached as exemplars to the request duration histogram.
 func NewPrometheusMetricsManager() MetricsManager {
 
-	return newPrometheusMetricsManager(prometheus.DefaultRegisterer)
+	return newPrometheusMetricsManager(prometheus.DefaultRegisterer, prometheus.DefaultGatherer)
 }
 
-func newPrometheusMetricsManager(registerer prometheus.Registerer) MetricsManager {
+func newPrometheusMetricsManager(registerer prometheus.Registerer, gatherer prometheus.Gatherer) MetricsManager {
 	mc := &prometheusMetricsManager{
-		handler: promhttp.Handler(),
+		handler: promhttp.InstrumentMetricHandler(
+			registerer,
+			promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+		),
 		reqTotalMetric: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "http_requests_total",
 				Help: "The total number of requests.",
 			},
-			[]string{"method"},
+			[]string{"method", "identity", "operation", "version", "status"},
 		),
-		reqDurationMetric: prometheus.NewSummaryVec(
-			prometheus.SummaryOpts{
-				Name: "http_requests_duration_seconds",
-				Help: "The average duration of the requests",
+		reqDurationMetric: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "The duration of the requests.",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"method", "identity", "operation", "version", "status"},
+		),
+		reqSizeMetric: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_size_bytes",
+				Help:    "The size of the body of the requests.",
+				Buckets: sizeBuckets,
+			},
+			[]string{"method", "identity", "operation", "version"},
+		),
+		respSizeMetric: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_response_size_bytes",
+				Help:    "The size of the body of the responses.",
+				Buckets: sizeBuckets,
+			},
+			[]string{"method", "identity", "operation", "version", "status"},
+		),
+		reqInFlightMetric: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "http_requests_in_flight",
+				Help: "The current number of requests being processed.",
 			},
-			[]string{"method", "url"},
+			[]string{"identity", "operation"},
 		),
 		wsConnTotalMetric: prometheus.NewCounter(
 			prometheus.CounterOpts{
@@ -83,6 +134,19 @@ func newPrometheusMetricsManager(registerer prometheus.Registerer) MetricsManage
 				Help: "The current number of ws connection.",
 			},
 		),
+		tlsExpirationMetric: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "tls_certificate_expiration_timestamp_seconds",
+				Help: "The expiration date of the active server certificate as a unix timestamp.",
+			},
+		),
+		cacheLookupMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cache_lookups_total",
+				Help: "The total number of cache lookups.",
+			},
+			[]string{"cache", "result"},
+		),
 		errorMetric: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "http_errors_5xx_total",
@@ -90,51 +154,132 @@ func newPrometheusMetricsManager(registerer prometheus.Registerer) MetricsManage
 			},
 			[]string{"trace", "method", "url", "code"},
 		),
+		panicMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_panics_total",
+				Help: "The total number of requests whose processing panicked.",
+			},
+			[]string{"identity", "operation", "version"},
+		),
+		deniedMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_requests_denied_total",
+				Help: "The total number of requests denied with a 401 or a 403.",
+			},
+			[]string{"identity", "operation", "version", "code"},
+		),
 	}
 
 	registerer.MustRegister(mc.reqTotalMetric)
 	registerer.MustRegister(mc.reqDurationMetric)
+	registerer.MustRegister(mc.reqSizeMetric)
+	registerer.MustRegister(mc.respSizeMetric)
+	registerer.MustRegister(mc.reqInFlightMetric)
 	registerer.MustRegister(mc.wsConnTotalMetric)
 	registerer.MustRegister(mc.wsConnCurrentMetric)
 	registerer.MustRegister(mc.errorMetric)
+	registerer.MustRegister(mc.panicMetric)
+	registerer.MustRegister(mc.deniedMetric)
+	registerer.MustRegister(mc.tlsExpirationMetric)
+	registerer.MustRegister(mc.cacheLookupMetric)
 
 	return mc
 }
 
-func (c *prometheusMetricsManager) MeasureRequest(method string, url string) FinishMeasurementFunc {
+func (c *prometheusMetricsManager) MeasureRequest(method string, url string, request *elemental.Request) FinishMeasurementFunc {
 
-	c.reqTotalMetric.With(prometheus.Labels{
-		"method": method,
-	}).Inc()
+	if request == nil {
+		request = elemental.NewRequest()
+	}
 
 	surl := sanitizeURL(url)
+	identity := request.Identity.Name
+	operation := string(request.Operation)
+	version := strconv.Itoa(request.Version)
 
-	timer := prometheus.NewTimer(
-		prometheus.ObserverFunc(
-			func(v float64) {
-				c.reqDurationMetric.With(
-					prometheus.Labels{
-						"method": method,
-						"url":    surl,
-					},
-				).Observe(v)
-			},
-		),
-	)
+	c.reqSizeMetric.With(prometheus.Labels{
+		"method":    method,
+		"identity":  identity,
+		"operation": operation,
+		"version":   version,
+	}).Observe(float64(len(request.Data)))
+
+	inFlight := c.reqInFlightMetric.With(prometheus.Labels{
+		"identity":  identity,
+		"operation": operation,
+	})
+	inFlight.Inc()
+
+	start := time.Now()
+
+	return func(result MeasurementResult) {
+
+		inFlight.Dec()
+
+		status := statusClass(result.Code)
 
-	return func(code int, span opentracing.Span) {
+		c.reqTotalMetric.With(prometheus.Labels{
+			"method":    method,
+			"identity":  identity,
+			"operation": operation,
+			"version":   version,
+			"status":    status,
+		}).Inc()
 
-		if code >= http.StatusInternalServerError {
+		duration := c.reqDurationMetric.With(prometheus.Labels{
+			"method":    method,
+			"identity":  identity,
+			"operation": operation,
+			"version":   version,
+			"status":    status,
+		})
+
+		from := start
+		if !result.Start.IsZero() {
+			from = result.Start
+		}
+
+		elapsed := time.Since(from).Seconds()
+		if eo, ok := duration.(prometheus.ExemplarObserver); ok && result.TraceID != "" {
+			eo.ObserveWithExemplar(elapsed, prometheus.Labels{"trace_id": result.TraceID})
+		} else {
+			duration.Observe(elapsed)
+		}
+
+		c.respSizeMetric.With(prometheus.Labels{
+			"method":    method,
+			"identity":  identity,
+			"operation": operation,
+			"version":   version,
+			"status":    status,
+		}).Observe(float64(result.ResponseSize))
+
+		if result.Panicked {
+			c.panicMetric.With(prometheus.Labels{
+				"identity":  identity,
+				"operation": operation,
+				"version":   version,
+			}).Inc()
+		}
+
+		if result.Code == http.StatusUnauthorized || result.Code == http.StatusForbidden {
+			c.deniedMetric.With(prometheus.Labels{
+				"identity":  identity,
+				"operation": operation,
+				"version":   version,
+				"code":      strconv.Itoa(result.Code),
+			}).Inc()
+		}
+
+		if result.Code >= http.StatusInternalServerError {
 
 			c.errorMetric.With(prometheus.Labels{
-				"trace":  extractSpanID(span),
+				"trace":  extractSpanID(result.Span),
 				"method": method,
 				"url":    surl,
-				"code":   strconv.Itoa(code),
+				"code":   strconv.Itoa(result.Code),
 			}).Inc()
 		}
-
-		timer.ObserveDuration()
 	}
 }
 
@@ -147,6 +292,20 @@ func (c *prometheusMetricsManager) UnregisterWSConnection() {
 	c.wsConnCurrentMetric.Dec()
 }
 
+func (c *prometheusMetricsManager) SetTLSCertificateExpiration(notAfter time.Time) {
+	c.tlsExpirationMetric.Set(float64(notAfter.Unix()))
+}
+
+func (c *prometheusMetricsManager) RegisterCacheLookup(cache string, hit bool) {
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	c.cacheLookupMetric.With(prometheus.Labels{"cache": cache, "result": result}).Inc()
+}
+
 func (c *prometheusMetricsManager) Write(w http.ResponseWriter, r *http.Request) {
 	c.handler.ServeHTTP(w, r)
 }
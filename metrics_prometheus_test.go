@@ -12,10 +12,15 @@
 package bahamut
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
 )
 
 func Test_sanitizeURL(t *testing.T) {
@@ -79,40 +84,236 @@ func Test_sanitizeURL(t *testing.T) {
 	}
 }
 
+// gatheredMetric returns the metric family with the given name
+// gathered from the given registry, or nil.
+func gatheredMetric(r *prometheus.Registry, name string) *dto.MetricFamily {
+
+	data, _ := r.Gather()
+	for _, mf := range data {
+		if mf.GetName() == name {
+			return mf
+		}
+	}
+
+	return nil
+}
+
+// metricLabels returns the labels of the given metric as a map.
+func metricLabels(m *dto.Metric) map[string]string {
+
+	out := map[string]string{}
+	for _, l := range m.GetLabel() {
+		out[l.GetName()] = l.GetValue()
+	}
+
+	return out
+}
+
+func Test_statusClass(t *testing.T) {
+
+	Convey("Given I have some status codes", t, func() {
+
+		Convey("Then their class should be correct", func() {
+			So(statusClass(200), ShouldEqual, "2xx")
+			So(statusClass(404), ShouldEqual, "4xx")
+			So(statusClass(503), ShouldEqual, "5xx")
+			So(statusClass(0), ShouldEqual, "unknown")
+			So(statusClass(600), ShouldEqual, "unknown")
+		})
+	})
+}
+
 func TestMeasureRequest(t *testing.T) {
 
 	Convey("Given I have a PrometheusMetricsManager", t, func() {
 
 		r := prometheus.NewRegistry()
-		pmm := newPrometheusMetricsManager(r).(*prometheusMetricsManager)
+		pmm := newPrometheusMetricsManager(r, r).(*prometheusMetricsManager)
+
+		request := elemental.NewRequest()
+		request.Identity = elemental.MakeIdentity("list", "lists")
+		request.Operation = elemental.OperationCreate
+		request.Version = 1
+		request.Data = []byte(`{"name":"hello"}`)
 
 		Convey("When I call measure a valid request", func() {
 
-			f := pmm.MeasureRequest("GET", "http://toto.com/id/toto")
-			f(200, nil)
+			f := pmm.MeasureRequest("GET", "http://toto.com/id/toto", request)
 
-			data, _ := r.Gather()
+			inFlight := gatheredMetric(r, "http_requests_in_flight")
 
-			Convey("Then the data should collected", func() {
-				So(data[1].GetName(), ShouldEqual, "http_requests_total")
-				So(data[1].GetMetric()[0].Counter.String(), ShouldEqual, "value:1 ")
-				So(data[1].GetMetric()[0].Label[0].String(), ShouldEqual, `name:"method" value:"GET" `)
+			f(MeasurementResult{Code: 200, ResponseSize: 42})
+
+			Convey("Then the request should be counted with its labels", func() {
+				mf := gatheredMetric(r, "http_requests_total")
+				So(mf, ShouldNotBeNil)
+				So(mf.GetMetric()[0].GetCounter().GetValue(), ShouldEqual, 1)
+				So(metricLabels(mf.GetMetric()[0]), ShouldResemble, map[string]string{
+					"method":    "GET",
+					"identity":  "list",
+					"operation": "create",
+					"version":   "1",
+					"status":    "2xx",
+				})
+			})
+
+			Convey("Then the duration should be observed", func() {
+				mf := gatheredMetric(r, "http_request_duration_seconds")
+				So(mf, ShouldNotBeNil)
+				So(mf.GetMetric()[0].GetHistogram().GetSampleCount(), ShouldEqual, 1)
+				So(metricLabels(mf.GetMetric()[0]), ShouldResemble, map[string]string{
+					"method":    "GET",
+					"identity":  "list",
+					"operation": "create",
+					"version":   "1",
+					"status":    "2xx",
+				})
+			})
+
+			Convey("Then the sizes should be observed", func() {
+				req := gatheredMetric(r, "http_request_size_bytes")
+				So(req, ShouldNotBeNil)
+				So(req.GetMetric()[0].GetHistogram().GetSampleSum(), ShouldEqual, 16)
+
+				resp := gatheredMetric(r, "http_response_size_bytes")
+				So(resp, ShouldNotBeNil)
+				So(resp.GetMetric()[0].GetHistogram().GetSampleSum(), ShouldEqual, 42)
+				So(metricLabels(resp.GetMetric()[0])["status"], ShouldEqual, "2xx")
+			})
+
+			Convey("Then the in flight gauge should have been incremented then decremented", func() {
+				So(inFlight.GetMetric()[0].GetGauge().GetValue(), ShouldEqual, 1)
+				So(gatheredMetric(r, "http_requests_in_flight").GetMetric()[0].GetGauge().GetValue(), ShouldEqual, 0)
+			})
+
+			Convey("Then no panic, denial or error should be counted", func() {
+				So(gatheredMetric(r, "http_panics_total"), ShouldBeNil)
+				So(gatheredMetric(r, "http_requests_denied_total"), ShouldBeNil)
+				So(gatheredMetric(r, "http_errors_5xx_total"), ShouldBeNil)
 			})
 		})
 
-		Convey("When I call measure a 502 request", func() {
+		Convey("When I call measure a request with the time it was received", func() {
 
-			f := pmm.MeasureRequest("GET", "http://toto.com/id/toto")
-			f(502, nil)
+			f := pmm.MeasureRequest("GET", "/lists", request)
+			f(MeasurementResult{Code: 400, Start: time.Now().Add(-2 * time.Second)})
 
-			data, _ := r.Gather()
+			Convey("Then the duration should be measured from that time", func() {
+				mf := gatheredMetric(r, "http_request_duration_seconds")
+				So(mf, ShouldNotBeNil)
+				So(mf.GetMetric()[0].GetHistogram().GetSampleSum(), ShouldBeGreaterThanOrEqualTo, 2)
+			})
+		})
+
+		Convey("When I call measure a traced request", func() {
+
+			f := pmm.MeasureRequest("GET", "/lists", request)
+			f(MeasurementResult{Code: 200, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"})
+
+			Convey("Then the trace should be attached as exemplar of the duration", func() {
+				mf := gatheredMetric(r, "http_request_duration_seconds")
+				So(mf, ShouldNotBeNil)
+
+				var exemplars []*dto.Exemplar
+				for _, b := range mf.GetMetric()[0].GetHistogram().GetBucket() {
+					if b.GetExemplar() != nil {
+						exemplars = append(exemplars, b.GetExemplar())
+					}
+				}
+
+				So(len(exemplars), ShouldEqual, 1)
+				So(exemplars[0].GetLabel()[0].GetName(), ShouldEqual, "trace_id")
+				So(exemplars[0].GetLabel()[0].GetValue(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			})
+
+			Convey("When I write the metrics negotiating OpenMetrics", func() {
+
+				req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+				w := httptest.NewRecorder()
+				pmm.Write(w, req)
+
+				Convey("Then the OpenMetrics format should be served with the exemplar", func() {
+					So(w.Header().Get("Content-Type"), ShouldStartWith, "application/openmetrics-text")
+					So(w.Body.String(), ShouldContainSubstring, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`)
+					So(w.Body.String(), ShouldEndWith, "# EOF\n")
+				})
+			})
+
+			Convey("When I write the metrics without negotiation", func() {
+
+				w := httptest.NewRecorder()
+				pmm.Write(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+				Convey("Then the text format should be served without exemplar", func() {
+					So(w.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+					So(w.Body.String(), ShouldContainSubstring, "http_request_duration_seconds_bucket")
+					So(w.Body.String(), ShouldNotContainSubstring, "trace_id")
+				})
+			})
+		})
+
+		Convey("When I call measure a 502 request", func() {
+
+			f := pmm.MeasureRequest("GET", "http://toto.com/id/toto", request)
+			f(MeasurementResult{Code: 502})
 
 			Convey("Then the data should collected", func() {
-				So(data[0].GetName(), ShouldEqual, "http_errors_5xx_total")
-				So(data[0].GetMetric()[0].Label[0].String(), ShouldEqual, `name:"code" value:"502" `)
-				So(data[0].GetMetric()[0].Label[1].String(), ShouldEqual, `name:"method" value:"GET" `)
-				So(data[0].GetMetric()[0].Label[2].String(), ShouldEqual, `name:"trace" value:"unknown" `)
-				So(data[0].GetMetric()[0].Label[3].String(), ShouldEqual, `name:"url" value:"http://:id/id/toto" `)
+				mf := gatheredMetric(r, "http_errors_5xx_total")
+				So(mf, ShouldNotBeNil)
+				So(metricLabels(mf.GetMetric()[0]), ShouldResemble, map[string]string{
+					"code":   "502",
+					"method": "GET",
+					"trace":  "unknown",
+					"url":    "http://:id/id/toto",
+				})
+			})
+		})
+
+		Convey("When I call measure a request that panicked", func() {
+
+			f := pmm.MeasureRequest("POST", "/lists", request)
+			f(MeasurementResult{Code: 500, Panicked: true})
+
+			Convey("Then the panic should be counted", func() {
+				mf := gatheredMetric(r, "http_panics_total")
+				So(mf, ShouldNotBeNil)
+				So(mf.GetMetric()[0].GetCounter().GetValue(), ShouldEqual, 1)
+				So(metricLabels(mf.GetMetric()[0]), ShouldResemble, map[string]string{
+					"identity":  "list",
+					"operation": "create",
+					"version":   "1",
+				})
+			})
+		})
+
+		Convey("When I call measure requests that are denied", func() {
+
+			pmm.MeasureRequest("POST", "/lists", request)(MeasurementResult{Code: 401})
+			pmm.MeasureRequest("POST", "/lists", request)(MeasurementResult{Code: 403})
+			pmm.MeasureRequest("POST", "/lists", request)(MeasurementResult{Code: 403})
+
+			Convey("Then the denials should be counted by code", func() {
+				mf := gatheredMetric(r, "http_requests_denied_total")
+				So(mf, ShouldNotBeNil)
+				So(len(mf.GetMetric()), ShouldEqual, 2)
+				So(metricLabels(mf.GetMetric()[0])["code"], ShouldEqual, "401")
+				So(mf.GetMetric()[0].GetCounter().GetValue(), ShouldEqual, 1)
+				So(metricLabels(mf.GetMetric()[1])["code"], ShouldEqual, "403")
+				So(mf.GetMetric()[1].GetCounter().GetValue(), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I call measure with no request", func() {
+
+			f := pmm.MeasureRequest("GET", "/", nil)
+			f(MeasurementResult{Code: 400})
+
+			Convey("Then the request should be counted with empty labels", func() {
+				mf := gatheredMetric(r, "http_requests_total")
+				So(mf, ShouldNotBeNil)
+				So(metricLabels(mf.GetMetric()[0])["identity"], ShouldEqual, "")
+				So(metricLabels(mf.GetMetric()[0])["status"], ShouldEqual, "4xx")
 			})
 		})
 	})
@@ -123,7 +324,7 @@ func TestRegisterWSConnection(t *testing.T) {
 	Convey("Given I have a PrometheusMetricsManager", t, func() {
 
 		r := prometheus.NewRegistry()
-		pmm := newPrometheusMetricsManager(r).(*prometheusMetricsManager)
+		pmm := newPrometheusMetricsManager(r, r).(*prometheusMetricsManager)
 
 		Convey("When I call RegisterWSConnection twice", func() {
 
@@ -155,3 +356,52 @@ func TestRegisterWSConnection(t *testing.T) {
 		})
 	})
 }
+
+func TestSetTLSCertificateExpiration(t *testing.T) {
+
+	Convey("Given I have a PrometheusMetricsManager", t, func() {
+
+		r := prometheus.NewRegistry()
+		pmm := newPrometheusMetricsManager(r, r).(*prometheusMetricsManager)
+
+		Convey("When I call SetTLSCertificateExpiration", func() {
+
+			pmm.SetTLSCertificateExpiration(time.Unix(1600000000, 0))
+
+			data, _ := r.Gather()
+			last := data[len(data)-1]
+
+			Convey("Then the gauge should be set", func() {
+				So(last.GetName(), ShouldEqual, "tls_certificate_expiration_timestamp_seconds")
+				So(last.GetMetric()[0].GetGauge().GetValue(), ShouldEqual, 1600000000)
+			})
+		})
+	})
+}
+
+func TestRegisterCacheLookup(t *testing.T) {
+
+	Convey("Given I have a PrometheusMetricsManager", t, func() {
+
+		r := prometheus.NewRegistry()
+		pmm := newPrometheusMetricsManager(r, r).(*prometheusMetricsManager)
+
+		Convey("When I call RegisterCacheLookup", func() {
+
+			pmm.RegisterCacheLookup("authorizer", true)
+			pmm.RegisterCacheLookup("authorizer", true)
+			pmm.RegisterCacheLookup("authorizer", false)
+
+			data, _ := r.Gather()
+
+			Convey("Then the counters should be correct", func() {
+				So(data[0].GetName(), ShouldEqual, "cache_lookups_total")
+				So(len(data[0].GetMetric()), ShouldEqual, 2)
+				So(data[0].GetMetric()[0].GetLabel()[1].GetValue(), ShouldEqual, "hit")
+				So(data[0].GetMetric()[0].GetCounter().GetValue(), ShouldEqual, 2)
+				So(data[0].GetMetric()[1].GetLabel()[1].GetValue(), ShouldEqual, "miss")
+				So(data[0].GetMetric()[1].GetCounter().GetValue(), ShouldEqual, 1)
+			})
+		})
+	})
+}
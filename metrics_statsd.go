@@ -0,0 +1,537 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+const (
+	statsdCounter   = "c"
+	statsdGauge     = "g"
+	statsdTiming    = "ms"
+	statsdHistogram = "h"
+)
+
+// statsdMaxSamples is the maximum number of samples of a timing or a
+// histogram kept between two flushes. Past it, the samples are randomly
+// replaced and sent with the corresponding sample rate.
+const statsdMaxSamples = 1000
+
+// A StatsDOption represents an option to the MetricsManager using the StatsD format.
+type StatsDOption func(*statsdMetricsManager)
+
+// StatsDOptPrefix sets the prefix to add to the name of all metrics,
+// for instance "myservice.".
+func StatsDOptPrefix(prefix string) StatsDOption {
+	return func(m *statsdMetricsManager) {
+		m.prefix = prefix
+	}
+}
+
+// StatsDOptTags sets the tags to add to all metrics, for instance "env:prod".
+func StatsDOptTags(tags ...string) StatsDOption {
+	return func(m *statsdMetricsManager) {
+		m.tags = append(m.tags, tags...)
+	}
+}
+
+// StatsDOptFlushInterval sets the interval at which the aggregated
+// metrics are sent. The default, used if the given interval is not
+// positive, is 10s.
+func StatsDOptFlushInterval(interval time.Duration) StatsDOption {
+	return func(m *statsdMetricsManager) {
+		m.flushInterval = interval
+	}
+}
+
+// StatsDOptMaxPacketSize sets the maximum size of the UDP packets sent.
+// Several metrics are sent in the same packet, up to this size. The
+// default is 1432 bytes, which fits in the MTU of most networks.
+func StatsDOptMaxPacketSize(size int) StatsDOption {
+	return func(m *statsdMetricsManager) {
+		m.maxPacketSize = size
+	}
+}
+
+// StatsDOptDisableTags disables the DogStatsD tags, for the servers that
+// only support the plain StatsD format. The tags of the metrics are then
+// added to their names, the tags set by StatsDOptTags are ignored and the
+// histograms are sent as timings, one sample per line.
+func StatsDOptDisableTags() StatsDOption {
+	return func(m *statsdMetricsManager) {
+		m.disableTags = true
+	}
+}
+
+// StatsDOptLogger sets the logger to use. By default, the global zap logger is used.
+func StatsDOptLogger(logger *zap.Logger) StatsDOption {
+	return func(m *statsdMetricsManager) {
+		m.logger = logger
+	}
+}
+
+// A statsdMetric holds the aggregated values of a metric with a given set of tags.
+type statsdMetric struct {
+	name string
+	tags []string
+	kind string
+
+	// value is the value not flushed yet for a counter,
+	// and the current value for a gauge.
+	value float64
+
+	// samples are the values not flushed yet for a timing or a histogram,
+	// and seen is the number of values recorded since the last flush.
+	samples []float64
+	seen    int
+
+	// total, count, min and max are the cumulative values
+	// since the creation of the manager.
+	total float64
+	count int64
+	min   float64
+	max   float64
+}
+
+type statsdMetricsManager struct {
+	conn          net.Conn
+	prefix        string
+	tags          []string
+	flushInterval time.Duration
+	maxPacketSize int
+	disableTags   bool
+	logger        *zap.Logger
+	metrics       map[string]*statsdMetric
+	lock          sync.Mutex
+}
+
+// NewStatsDMetricsManager returns a new MetricsManager sending the metrics over
+// UDP to the given address using the StatsD format, with the DogStatsD tags.
+// Counters and gauges are aggregated locally and sent, with the collected
+// timings and histograms, at every flush interval until the given context is
+// canceled. The samples of a timing or a histogram are packed in as few lines
+// as possible, and sampled past a thousand samples per flush. As there is
+// nothing to scrape, Write returns a JSON snapshot of the cumulative values
+// of the metrics.
+func NewStatsDMetricsManager(ctx context.Context, address string, options ...StatsDOption) (MetricsManager, error) {
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &statsdMetricsManager{
+		conn:          conn,
+		flushInterval: 10 * time.Second,
+		maxPacketSize: 1432,
+		logger:        zap.L(),
+		metrics:       map[string]*statsdMetric{},
+	}
+
+	for _, opt := range options {
+		opt(m)
+	}
+
+	if m.flushInterval <= 0 {
+		m.flushInterval = 10 * time.Second
+	}
+
+	go m.run(ctx)
+
+	return m, nil
+}
+
+func (m *statsdMetricsManager) MeasureRequest(method string, url string, request *elemental.Request) FinishMeasurementFunc {
+
+	if request == nil {
+		request = elemental.NewRequest()
+	}
+
+	surl := sanitizeURL(url)
+	identity := "identity:" + request.Identity.Name
+	operation := "operation:" + string(request.Operation)
+	version := "version:" + strconv.Itoa(request.Version)
+
+	m.record("http.request.size", m.histogramKind(), float64(len(request.Data)), "method:"+method, identity, operation, version)
+	m.add("http.requests.in_flight", statsdGauge, 1, identity, operation)
+
+	start := time.Now()
+
+	return func(result MeasurementResult) {
+
+		m.add("http.requests.in_flight", statsdGauge, -1, identity, operation)
+
+		from := start
+		if !result.Start.IsZero() {
+			from = result.Start
+		}
+
+		status := "status:" + statusClass(result.Code)
+
+		m.add("http.requests", statsdCounter, 1, "method:"+method, identity, operation, version, status)
+		m.record("http.requests.duration", statsdTiming, float64(time.Since(from))/float64(time.Millisecond), "method:"+method, "url:"+surl, identity, operation, version, status)
+		m.record("http.response.size", m.histogramKind(), float64(result.ResponseSize), "method:"+method, identity, operation, version, status)
+
+		if result.Panicked {
+			m.add("http.panics", statsdCounter, 1, identity, operation, version)
+		}
+
+		if result.Code == http.StatusUnauthorized || result.Code == http.StatusForbidden {
+			m.add("http.requests.denied", statsdCounter, 1, identity, operation, version, "code:"+strconv.Itoa(result.Code))
+		}
+
+		// Unlike with Prometheus, the trace is not used as a tag
+		// as StatsD backends do not cope well with such cardinality.
+		if result.Code >= http.StatusInternalServerError {
+			m.add("http.errors.5xx", statsdCounter, 1, "method:"+method, "url:"+surl, "code:"+strconv.Itoa(result.Code))
+		}
+	}
+}
+
+func (m *statsdMetricsManager) RegisterWSConnection() {
+	m.add("http.ws.connections", statsdCounter, 1)
+	m.add("http.ws.connections.current", statsdGauge, 1)
+}
+
+func (m *statsdMetricsManager) UnregisterWSConnection() {
+	m.add("http.ws.connections.current", statsdGauge, -1)
+}
+
+func (m *statsdMetricsManager) SetTLSCertificateExpiration(notAfter time.Time) {
+	m.set("tls.certificate.expiration", float64(notAfter.Unix()))
+}
+
+func (m *statsdMetricsManager) RegisterCacheLookup(cache string, hit bool) {
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	m.add("cache.lookups", statsdCounter, 1, "cache:"+cache, "result:"+result)
+}
+
+// Write writes a JSON snapshot of the cumulative values of the metrics.
+func (m *statsdMetricsManager) Write(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if err := json.NewEncoder(w).Encode(m.snapshot()); err != nil {
+		m.logger.Error("Unable to encode metrics snapshot", zap.Error(err))
+	}
+}
+
+func (m *statsdMetricsManager) histogramKind() string {
+
+	if m.disableTags {
+		return statsdTiming
+	}
+
+	return statsdHistogram
+}
+
+// metric returns the metric with the given name and tags, creating it if
+// needed. It must be called with the lock held.
+func (m *statsdMetricsManager) metric(name string, kind string, tags []string) *statsdMetric {
+
+	key := name + "|" + strings.Join(tags, ",")
+
+	metric, ok := m.metrics[key]
+	if !ok {
+		metric = &statsdMetric{name: name, kind: kind, tags: tags}
+		m.metrics[key] = metric
+	}
+
+	return metric
+}
+
+// add adds the given delta to the counter or gauge with the given name and tags.
+func (m *statsdMetricsManager) add(name string, kind string, delta float64, tags ...string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	metric := m.metric(name, kind, tags)
+	metric.value += delta
+	metric.total += delta
+}
+
+// set sets the value of the gauge with the given name.
+func (m *statsdMetricsManager) set(name string, value float64, tags ...string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	metric := m.metric(name, statsdGauge, tags)
+	metric.value = value
+	metric.total = value
+}
+
+// record records a sample of the timing or histogram with the given name and tags.
+func (m *statsdMetricsManager) record(name string, kind string, value float64, tags ...string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	metric := m.metric(name, kind, tags)
+
+	// Past the maximum number of samples, each value
+	// replaces a random one with an equal probability.
+	metric.seen++
+	if len(metric.samples) < statsdMaxSamples {
+		metric.samples = append(metric.samples, value)
+	} else if i := rand.Intn(metric.seen); i < statsdMaxSamples {
+		metric.samples[i] = value
+	}
+
+	if metric.count == 0 || value < metric.min {
+		metric.min = value
+	}
+	if metric.count == 0 || value > metric.max {
+		metric.max = value
+	}
+
+	metric.count++
+	metric.total += value
+}
+
+// run flushes the metrics at every flush interval until the given
+// context is canceled, then flushes them one last time.
+func (m *statsdMetricsManager) run(ctx context.Context) {
+
+	ticker := time.NewTicker(m.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.flush()
+		case <-ctx.Done():
+			m.flush()
+			m.conn.Close() // nolint: errcheck
+			return
+		}
+	}
+}
+
+// flush sends the metrics aggregated since the last flush.
+func (m *statsdMetricsManager) flush() {
+
+	for _, packet := range m.packets(m.lines()) {
+		if _, err := m.conn.Write(packet); err != nil {
+			m.logger.Debug("Unable to send statsd metrics", zap.Error(err))
+		}
+	}
+}
+
+// lines returns the lines to send for the metrics aggregated since
+// the last flush, and resets them. Gauges are always sent.
+func (m *statsdMetricsManager) lines() []string {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var lines []string
+
+	for _, metric := range m.metrics {
+
+		switch metric.kind {
+
+		case statsdCounter:
+			if metric.value == 0 {
+				continue
+			}
+			lines = append(lines, m.line(metric, 1, metric.value))
+			metric.value = 0
+
+		case statsdGauge:
+			lines = append(lines, m.line(metric, 1, metric.value))
+
+		default:
+			if len(metric.samples) == 0 {
+				continue
+			}
+			lines = append(lines, m.sampleLines(metric, float64(len(metric.samples))/float64(metric.seen))...)
+			metric.samples = nil
+			metric.seen = 0
+		}
+	}
+
+	sort.Strings(lines)
+
+	return lines
+}
+
+// sampleLines returns the lines of the samples of the given timing or
+// histogram, sampled at the given rate. With the DogStatsD tags, the
+// samples are packed in lines fitting in the maximum packet size.
+func (m *statsdMetricsManager) sampleLines(metric *statsdMetric, rate float64) []string {
+
+	lines := make([]string, 0, 1)
+
+	if m.disableTags {
+		for _, sample := range metric.samples {
+			lines = append(lines, m.line(metric, rate, sample))
+		}
+		return lines
+	}
+
+	base := len(m.line(metric, rate))
+
+	var values []float64
+	size := base
+
+	for _, sample := range metric.samples {
+
+		n := len(formatStatsDValue(sample))
+		if len(values) > 0 {
+			n++
+		}
+
+		if len(values) > 0 && size+n > m.maxPacketSize {
+			lines = append(lines, m.line(metric, rate, values...))
+			values = nil
+			size = base
+			n--
+		}
+
+		values = append(values, sample)
+		size += n
+	}
+
+	return append(lines, m.line(metric, rate, values...))
+}
+
+// line returns the StatsD line of the given metric with the given values,
+// sampled at the given rate. Several values are separated by colons.
+func (m *statsdMetricsManager) line(metric *statsdMetric, rate float64, values ...float64) string {
+
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = formatStatsDValue(v)
+	}
+
+	name := m.prefix + metric.name
+	if m.disableTags {
+		for _, t := range metric.tags {
+			name += "." + statsdNameReplacer.Replace(t)
+		}
+	}
+
+	line := name + ":" + strings.Join(formatted, ":") + "|" + metric.kind
+
+	if rate < 1 {
+		line += "|@" + strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+
+	if m.disableTags || len(m.tags)+len(metric.tags) == 0 {
+		return line
+	}
+
+	tags := make([]string, 0, len(m.tags)+len(metric.tags))
+	for _, t := range append(append([]string{}, m.tags...), metric.tags...) {
+		tags = append(tags, statsdTagReplacer.Replace(t))
+	}
+
+	return line + "|#" + strings.Join(tags, ",")
+}
+
+var statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+var statsdNameReplacer = strings.NewReplacer(":", "_", ".", "_", "/", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+func formatStatsDValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// packets packs the given lines into packets of at most the maximum packet
+// size. A line larger than the maximum packet size is sent alone.
+func (m *statsdMetricsManager) packets(lines []string) [][]byte {
+
+	var packets [][]byte
+	var current []byte
+
+	for _, line := range lines {
+
+		if len(current) > 0 && len(current)+1+len(line) > m.maxPacketSize {
+			packets = append(packets, current)
+			current = nil
+		}
+
+		if len(current) > 0 {
+			current = append(current, '\n')
+		}
+		current = append(current, line...)
+	}
+
+	if len(current) > 0 {
+		packets = append(packets, current)
+	}
+
+	return packets
+}
+
+// A statsdSnapshotEntry is the JSON representation
+// of a metric written by statsdMetricsManager.Write.
+type statsdSnapshotEntry struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	Tags  []string `json:"tags,omitempty"`
+	Value float64  `json:"value"`
+	Count int64    `json:"count,omitempty"`
+	Min   float64  `json:"min,omitempty"`
+	Max   float64  `json:"max,omitempty"`
+}
+
+// snapshot returns the cumulative values of the metrics, sorted by name
+// and tags. For the timings and histograms, the value is the sum of the samples.
+func (m *statsdMetricsManager) snapshot() []statsdSnapshotEntry {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	out := make([]statsdSnapshotEntry, 0, len(m.metrics))
+
+	for _, metric := range m.metrics {
+		out = append(out, statsdSnapshotEntry{
+			Name:  m.prefix + metric.name,
+			Type:  metric.kind,
+			Tags:  metric.tags,
+			Value: metric.total,
+			Count: metric.count,
+			Min:   metric.min,
+			Max:   metric.max,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return strings.Join(out[i].Tags, ",") < strings.Join(out[j].Tags, ",")
+	})
+
+	return out
+}
@@ -0,0 +1,377 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// listenStatsD starts a local UDP listener and returns it
+// with a channel receiving the lines of the packets.
+func listenStatsD() (net.PacketConn, chan []string) {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	packets := make(chan []string, 100)
+
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packets <- strings.Split(string(buf[:n]), "\n")
+		}
+	}()
+
+	return conn, packets
+}
+
+// receiveStatsD returns all the lines received during
+// a time long enough to receive a few flushes.
+func receiveStatsD(packets chan []string) []string {
+
+	var lines []string
+
+	timeout := time.After(250 * time.Millisecond)
+
+	for {
+		select {
+		case p := <-packets:
+			lines = append(lines, p...)
+		case <-timeout:
+			return lines
+		}
+	}
+}
+
+func TestStatsD_NewStatsDMetricsManager(t *testing.T) {
+
+	Convey("Given I create a new StatsD metrics manager with options", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		logger := zap.NewNop()
+
+		m, err := NewStatsDMetricsManager(
+			ctx,
+			"127.0.0.1:8125",
+			StatsDOptPrefix("test."),
+			StatsDOptTags("env:test"),
+			StatsDOptFlushInterval(time.Second),
+			StatsDOptMaxPacketSize(512),
+			StatsDOptDisableTags(),
+			StatsDOptLogger(logger),
+		)
+
+		Convey("Then it should be correctly configured", func() {
+			So(err, ShouldBeNil)
+			sm := m.(*statsdMetricsManager)
+			So(sm.prefix, ShouldEqual, "test.")
+			So(sm.tags, ShouldResemble, []string{"env:test"})
+			So(sm.flushInterval, ShouldEqual, time.Second)
+			So(sm.maxPacketSize, ShouldEqual, 512)
+			So(sm.disableTags, ShouldBeTrue)
+			So(sm.logger, ShouldEqual, logger)
+		})
+	})
+
+	Convey("Given I create a new StatsD metrics manager with an invalid flush interval", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		m, err := NewStatsDMetricsManager(ctx, "127.0.0.1:8125", StatsDOptFlushInterval(0))
+
+		Convey("Then the default flush interval should be used", func() {
+			So(err, ShouldBeNil)
+			So(m.(*statsdMetricsManager).flushInterval, ShouldEqual, 10*time.Second)
+		})
+	})
+
+	Convey("Given I create a new StatsD metrics manager with an invalid address", t, func() {
+
+		m, err := NewStatsDMetricsManager(context.Background(), "not an address")
+
+		Convey("Then it should fail", func() {
+			So(err, ShouldNotBeNil)
+			So(m, ShouldBeNil)
+		})
+	})
+}
+
+func TestStatsD_flush(t *testing.T) {
+
+	Convey("Given I have a StatsD metrics manager sending to a local listener", t, func() {
+
+		conn, packets := listenStatsD()
+		defer conn.Close() // nolint
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		m, err := NewStatsDMetricsManager(
+			ctx,
+			conn.LocalAddr().String(),
+			StatsDOptPrefix("bahamut."),
+			StatsDOptTags("env:test"),
+			StatsDOptFlushInterval(100*time.Millisecond),
+		)
+		So(err, ShouldBeNil)
+
+		request := elemental.NewRequest()
+		request.Identity = elemental.MakeIdentity("list", "lists")
+		request.Operation = elemental.OperationCreate
+		request.Version = 1
+		request.Data = []byte(`{"name":"hello"}`)
+
+		Convey("When I measure some requests", func() {
+
+			m.MeasureRequest("POST", "/v/1/lists", request)(MeasurementResult{Code: 200, ResponseSize: 42})
+			m.MeasureRequest("POST", "/v/1/lists", request)(MeasurementResult{Code: 200, ResponseSize: 42})
+			m.MeasureRequest("POST", "/v/1/lists", request)(MeasurementResult{Code: 403})
+			m.MeasureRequest("POST", "/v/1/lists", request)(MeasurementResult{Code: 500, Panicked: true})
+
+			lines := receiveStatsD(packets)
+
+			Convey("Then the counters should be aggregated", func() {
+				So(lines, ShouldContain, "bahamut.http.requests:2|c|#env:test,method:POST,identity:list,operation:create,version:1,status:2xx")
+				So(lines, ShouldContain, "bahamut.http.requests:1|c|#env:test,method:POST,identity:list,operation:create,version:1,status:4xx")
+				So(lines, ShouldContain, "bahamut.http.requests.denied:1|c|#env:test,identity:list,operation:create,version:1,code:403")
+				So(lines, ShouldContain, "bahamut.http.panics:1|c|#env:test,identity:list,operation:create,version:1")
+				So(lines, ShouldContain, "bahamut.http.errors.5xx:1|c|#env:test,method:POST,url:/lists,code:500")
+			})
+
+			Convey("Then the samples should be packed by line", func() {
+				var durations int
+				for _, l := range lines {
+					if strings.HasPrefix(l, "bahamut.http.requests.duration:") && strings.Contains(l, "|ms|") {
+						durations += strings.Count(strings.SplitN(l, "|", 2)[0], ":")
+					}
+				}
+				So(durations, ShouldEqual, 4)
+				So(lines, ShouldContain, "bahamut.http.request.size:16:16:16:16|h|#env:test,method:POST,identity:list,operation:create,version:1")
+				So(lines, ShouldContain, "bahamut.http.response.size:42:42|h|#env:test,method:POST,identity:list,operation:create,version:1,status:2xx")
+			})
+
+			Convey("Then the in flight gauge should be sent", func() {
+				So(lines, ShouldContain, "bahamut.http.requests.in_flight:0|g|#env:test,identity:list,operation:create")
+			})
+
+			Convey("When the next flush happens", func() {
+
+				lines := receiveStatsD(packets)
+
+				Convey("Then only the gauges should be sent again", func() {
+					So(lines, ShouldContain, "bahamut.http.requests.in_flight:0|g|#env:test,identity:list,operation:create")
+					for _, l := range lines {
+						So(l, ShouldEndWith, "identity:list,operation:create")
+					}
+				})
+			})
+		})
+
+		Convey("When I register websocket connections, cache lookups and a certificate expiration", func() {
+
+			m.RegisterWSConnection()
+			m.RegisterWSConnection()
+			m.UnregisterWSConnection()
+			m.(CacheMetricsManager).RegisterCacheLookup("authorizer", true)
+			m.(TLSMetricsManager).SetTLSCertificateExpiration(time.Unix(1600000000, 0))
+
+			lines := receiveStatsD(packets)
+
+			Convey("Then the metrics should be sent", func() {
+				So(lines, ShouldContain, "bahamut.http.ws.connections:2|c|#env:test")
+				So(lines, ShouldContain, "bahamut.http.ws.connections.current:1|g|#env:test")
+				So(lines, ShouldContain, "bahamut.cache.lookups:1|c|#env:test,cache:authorizer,result:hit")
+				So(lines, ShouldContain, "bahamut.tls.certificate.expiration:1600000000|g|#env:test")
+			})
+		})
+
+		Convey("When I cancel the context", func() {
+
+			m.(CacheMetricsManager).RegisterCacheLookup("authorizer", false)
+			cancel()
+
+			lines := receiveStatsD(packets)
+
+			Convey("Then the metrics should be flushed one last time", func() {
+				So(lines, ShouldContain, "bahamut.cache.lookups:1|c|#env:test,cache:authorizer,result:miss")
+			})
+		})
+	})
+}
+
+func TestStatsD_line(t *testing.T) {
+
+	Convey("Given I have a StatsD metrics manager", t, func() {
+
+		m := &statsdMetricsManager{prefix: "p.", tags: []string{"env:test"}, maxPacketSize: 40}
+		metric := &statsdMetric{name: "m", kind: statsdCounter, tags: []string{"url:/a,b|c#d"}}
+
+		Convey("Then the tags should be sanitized", func() {
+			So(m.line(metric, 1, 1.5), ShouldEqual, "p.m:1.5|c|#env:test,url:/a_b_c_d")
+		})
+
+		Convey("Then the sample rate should be sent", func() {
+			So(m.line(metric, 0.5, 1.5), ShouldEqual, "p.m:1.5|c|@0.5|#env:test,url:/a_b_c_d")
+		})
+
+		Convey("Then the samples should be packed in lines fitting in a packet", func() {
+			metric.kind = statsdHistogram
+			metric.samples = []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+			So(m.sampleLines(metric, 1), ShouldResemble, []string{
+				"p.m:1:2:3:4:5:6|h|#env:test,url:/a_b_c_d",
+				"p.m:7:8:9:10:11|h|#env:test,url:/a_b_c_d",
+				"p.m:12|h|#env:test,url:/a_b_c_d",
+			})
+		})
+
+		Convey("When the tags are disabled", func() {
+
+			m.disableTags = true
+
+			Convey("Then the tags should be added to the name", func() {
+				So(m.line(metric, 1, 2), ShouldEqual, "p.m.url__a_b_c_d:2|c")
+				So(m.histogramKind(), ShouldEqual, statsdTiming)
+			})
+
+			Convey("Then the samples should be sent one per line", func() {
+				metric.kind = statsdTiming
+				metric.samples = []float64{1, 2}
+				So(m.sampleLines(metric, 1), ShouldResemble, []string{"p.m.url__a_b_c_d:1|ms", "p.m.url__a_b_c_d:2|ms"})
+			})
+		})
+	})
+}
+
+func TestStatsD_record(t *testing.T) {
+
+	Convey("Given I have a StatsD metrics manager", t, func() {
+
+		m := &statsdMetricsManager{metrics: map[string]*statsdMetric{}, maxPacketSize: 1432}
+
+		Convey("When I record more samples than kept between two flushes", func() {
+
+			for i := 0; i < 2*statsdMaxSamples; i++ {
+				m.record("m", statsdHistogram, 1)
+			}
+
+			lines := m.lines()
+
+			Convey("Then the samples should be sent with their sample rate", func() {
+				var count int
+				for _, l := range lines {
+					So(l, ShouldEndWith, "|h|@0.5")
+					count += strings.Count(l, ":")
+				}
+				So(count, ShouldEqual, statsdMaxSamples)
+			})
+
+			Convey("Then the cumulative values should count all the samples", func() {
+				So(m.snapshot()[0].Count, ShouldEqual, 2*statsdMaxSamples)
+			})
+		})
+	})
+}
+
+func TestStatsD_packets(t *testing.T) {
+
+	Convey("Given I have a StatsD metrics manager with a small packet size", t, func() {
+
+		m := &statsdMetricsManager{maxPacketSize: 10}
+
+		Convey("When I pack some lines", func() {
+
+			packets := m.packets([]string{"a:1|c", "b:1|c", "a-very-long:1|c", "c:1|c"})
+
+			Convey("Then the lines should be packed up to the maximum size", func() {
+				So(len(packets), ShouldEqual, 4)
+				So(string(packets[0]), ShouldEqual, "a:1|c")
+				So(string(packets[1]), ShouldEqual, "b:1|c")
+				So(string(packets[2]), ShouldEqual, "a-very-long:1|c")
+				So(string(packets[3]), ShouldEqual, "c:1|c")
+			})
+		})
+
+		Convey("When I pack some lines with a larger packet size", func() {
+
+			m.maxPacketSize = 11
+			packets := m.packets([]string{"a:1|c", "b:1|c", "c:1|c"})
+
+			Convey("Then the lines should be packed together", func() {
+				So(len(packets), ShouldEqual, 2)
+				So(string(packets[0]), ShouldEqual, "a:1|c\nb:1|c")
+				So(string(packets[1]), ShouldEqual, "c:1|c")
+			})
+		})
+	})
+}
+
+func TestStatsD_Write(t *testing.T) {
+
+	Convey("Given I have a StatsD metrics manager with some metrics", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		m, _ := NewStatsDMetricsManager(ctx, "127.0.0.1:8125", StatsDOptFlushInterval(time.Hour))
+		m.(CacheMetricsManager).RegisterCacheLookup("authorizer", true)
+		m.(CacheMetricsManager).RegisterCacheLookup("authorizer", true)
+		m.MeasureRequest("GET", "/lists", nil)(MeasurementResult{Code: 200, ResponseSize: 10})
+		m.MeasureRequest("GET", "/lists", nil)(MeasurementResult{Code: 200, ResponseSize: 30})
+
+		Convey("When I call Write", func() {
+
+			w := httptest.NewRecorder()
+			m.Write(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+			var snapshot []statsdSnapshotEntry
+			err := json.Unmarshal(w.Body.Bytes(), &snapshot)
+
+			Convey("Then I should get a JSON snapshot of the metrics", func() {
+				So(err, ShouldBeNil)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=UTF-8")
+
+				entries := map[string]statsdSnapshotEntry{}
+				for _, e := range snapshot {
+					entries[e.Name] = e
+				}
+
+				So(entries["cache.lookups"].Value, ShouldEqual, 2)
+				So(entries["cache.lookups"].Type, ShouldEqual, statsdCounter)
+				So(entries["http.requests.in_flight"].Value, ShouldEqual, 0)
+				So(entries["http.response.size"].Count, ShouldEqual, 2)
+				So(entries["http.response.size"].Value, ShouldEqual, 40)
+				So(entries["http.response.size"].Min, ShouldEqual, 10)
+				So(entries["http.response.size"].Max, ShouldEqual, 30)
+			})
+		})
+	})
+}
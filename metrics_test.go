@@ -0,0 +1,64 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics_measurePanic(t *testing.T) {
+
+	Convey("Given I have a context with a measurePanic", t, func() {
+
+		ctx, p := contextWithMeasurePanic(context.Background())
+
+		Convey("Then it should not have panicked", func() {
+			So(p.hasPanicked(), ShouldBeFalse)
+		})
+
+		Convey("When I set it as panicked", func() {
+
+			setMeasurePanicked(ctx)
+
+			Convey("Then it should have panicked", func() {
+				So(p.hasPanicked(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a panic is recovered with the context", func() {
+
+			func() {
+				defer func() { _ = handleRecoveredPanic(ctx, recover(), false) }()
+				panic("boom")
+			}()
+
+			Convey("Then it should have panicked", func() {
+				So(p.hasPanicked(), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given I have a context without measurePanic", t, func() {
+
+		Convey("Then setting it as panicked should not panic", func() {
+			So(func() { setMeasurePanicked(context.Background()) }, ShouldNotPanic)
+		})
+
+		Convey("Then a nil measurePanic should not have panicked", func() {
+			var p *measurePanic
+			So(p.hasPanicked(), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,266 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelInstrumentationName is the name of the OpenTelemetry
+// instrumentation scope used by bahamut.
+const otelInstrumentationName = "go.aporeto.io/bahamut"
+
+// otelPropagator propagates the trace context using the W3C Trace Context
+// and Baggage headers.
+var otelPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+type otelSpanContextKey struct{}
+
+// contextWithOTelSpan returns a copy of the given context holding the given
+// span as the current span and as the span of the request.
+func contextWithOTelSpan(ctx context.Context, span trace.Span) context.Context {
+	return context.WithValue(trace.ContextWithSpan(ctx, span), otelSpanContextKey{}, span)
+}
+
+// otelSpanFromContext returns the span of the request
+// started by bahamut, or nil if the request is not traced.
+func otelSpanFromContext(ctx context.Context) trace.Span {
+
+	span, _ := ctx.Value(otelSpanContextKey{}).(trace.Span)
+
+	return span
+}
+
+// otelTraceID returns the trace ID of the OpenTelemetry
+// span found in the given context, if any.
+func otelTraceID(ctx context.Context) (string, bool) {
+
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", false
+	}
+
+	return sc.TraceID().String(), true
+}
+
+// traceRequestOTel starts the OpenTelemetry server span of the request.
+func traceRequestOTel(
+	ctx context.Context,
+	req *http.Request,
+	r *elemental.Request,
+	tracer trace.Tracer,
+	exludedIdentities map[string]struct{},
+	cleaner TraceCleaner,
+) context.Context {
+
+	if tracer == nil {
+		return ctx
+	}
+
+	if _, ok := exludedIdentities[r.Identity.Name]; ok {
+		return ctx
+	}
+
+	ctx = otelPropagator.Extract(ctx, propagation.HeaderCarrier(r.Headers))
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLPath(req.URL.Path),
+		semconv.URLScheme(scheme),
+		semconv.HTTPRoute(sanitizeURL(req.URL.Path)),
+		semconv.ServerAddress(req.Host),
+		semconv.NetworkProtocolVersion(fmt.Sprintf("%d.%d", req.ProtoMajor, req.ProtoMinor)),
+		attribute.Int("bahamut.api_version", r.Version),
+		attribute.String("bahamut.request_id", r.RequestID),
+		attribute.String("bahamut.identity", r.Identity.Name),
+		attribute.String("bahamut.operation", string(r.Operation)),
+		attribute.Bool("bahamut.recursive", r.Recursive),
+		attribute.Bool("bahamut.override_protection", r.OverrideProtection),
+	}
+
+	if r.ClientIP != "" {
+		attrs = append(attrs, semconv.ClientAddress(r.ClientIP))
+	}
+
+	if ua := req.UserAgent(); ua != "" {
+		attrs = append(attrs, semconv.UserAgentOriginal(ua))
+	}
+
+	if r.ExternalTrackingID != "" {
+		attrs = append(attrs, attribute.String("bahamut.external_tracking_id", r.ExternalTrackingID))
+	}
+
+	if r.ExternalTrackingType != "" {
+		attrs = append(attrs, attribute.String("bahamut.external_tracking_type", r.ExternalTrackingType))
+	}
+
+	if r.Namespace != "" {
+		attrs = append(attrs, attribute.String("bahamut.namespace", r.Namespace))
+	}
+
+	if r.ObjectID != "" {
+		attrs = append(attrs, attribute.String("bahamut.object.id", r.ObjectID))
+	}
+
+	if r.ParentID != "" {
+		attrs = append(attrs, attribute.String("bahamut.parent.id", r.ParentID))
+	}
+
+	if !r.ParentIdentity.IsEmpty() {
+		attrs = append(attrs, attribute.String("bahamut.parent.identity", r.ParentIdentity.Name))
+	}
+
+	for k, v := range safeHeaders(r.Headers) {
+		attrs = append(attrs, attribute.StringSlice("http.request.header."+strings.ToLower(k), v))
+	}
+
+	ctx, span := tracer.Start(
+		ctx,
+		tracingName(r),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+
+	data := r.Data
+	if cleaner != nil {
+		data = cleaner(r.Identity, r.Data[:])
+	}
+
+	eventAttrs := []attribute.KeyValue{
+		attribute.Int("bahamut.page.number", r.Page),
+		attribute.Int("bahamut.page.size", r.PageSize),
+		attribute.String("bahamut.claims", extractClaims(r)),
+		attribute.StringSlice("bahamut.order_by", r.Order),
+		attribute.String("bahamut.payload", string(data)),
+	}
+
+	for k, v := range safeParameters(r.Parameters) {
+		eventAttrs = append(eventAttrs, attribute.StringSlice("bahamut.parameters."+k, v))
+	}
+
+	span.AddEvent("request", trace.WithAttributes(eventAttrs...))
+
+	return contextWithOTelSpan(ctx, span)
+}
+
+// setOTelStatusCode sets the status code of the request span.
+// Following the semantic conventions of the server spans, only the
+// 5xx status codes set the status of the span to error.
+func setOTelStatusCode(ctx context.Context, code int) {
+
+	span := otelSpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+
+	if code >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(code))
+	}
+}
+
+// recordOTelError records the given errors in the request span.
+func recordOTelError(ctx context.Context, errs elemental.Errors) {
+
+	span := otelSpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	span.RecordError(errs)
+	setOTelStatusCode(ctx, errs.Code())
+}
+
+// recordOTelPanic records the given panic in the request span.
+func recordOTelPanic(ctx context.Context, err error, stack string) {
+
+	span := otelSpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	span.RecordError(err, trace.WithAttributes(
+		semconv.ExceptionStacktrace(stack),
+		attribute.Bool("bahamut.panic", true),
+	))
+	span.SetStatus(codes.Error, "panic")
+}
+
+// finishOTelTracing ends the request span, if any.
+func finishOTelTracing(ctx context.Context) {
+
+	span := otelSpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	span.End()
+}
+
+// runProcessor calls the given function in a child span of the request
+// span named after the operation, if the request is traced. While the
+// function runs, the Context() of the given bcontext holds the child span.
+func runProcessor(ctx *bcontext, proc Processor, f func() error) error {
+
+	parent := otelSpanFromContext(ctx.ctx)
+	if parent == nil {
+		return f()
+	}
+
+	defer func() { ctx.processorCtx = nil }()
+
+	var span trace.Span
+	ctx.processorCtx, span = parent.TracerProvider().Tracer(otelInstrumentationName).Start(
+		ctx.ctx,
+		processorTracingName(ctx.request),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			semconv.CodeFunction(fmt.Sprintf("%T", proc)),
+			attribute.String("bahamut.identity", ctx.request.Identity.Name),
+			attribute.String("bahamut.operation", string(ctx.request.Operation)),
+		),
+	)
+	defer finishSpan(ctx.ctx, func(t time.Time) { span.End(trace.WithTimestamp(t)) })
+
+	err := f()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+func processorTracingName(r *elemental.Request) string {
+
+	return fmt.Sprintf("bahamut.process.%s.%s", strings.Replace(string(r.Operation), "-", "_", -1), r.Identity.Category)
+}
@@ -0,0 +1,296 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracer() (trace.Tracer, *tracetest.InMemoryExporter) {
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	return provider.Tracer(otelInstrumentationName), exporter
+}
+
+func otelAttribute(span tracetest.SpanStub, key string) attribute.Value {
+
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+
+	return attribute.Value{}
+}
+
+func TestOpenTelemetry_traceRequestOTel(t *testing.T) {
+
+	makeRequests := func() (*http.Request, *elemental.Request) {
+
+		req, _ := http.NewRequest(http.MethodPost, "http://server/lists?q=1", nil)
+		req.Header.Set("User-Agent", "test")
+
+		r := elemental.NewRequest()
+		r.Identity = elemental.MakeIdentity("list", "lists")
+		r.Operation = elemental.OperationCreate
+		r.RequestID = "rid"
+		r.Namespace = "/ns"
+		r.ClientIP = "10.0.0.1"
+		r.Data = []byte("payload")
+		r.Headers = http.Header{}
+		r.Headers.Set("Authorization", "secret")
+		r.Headers.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+		return req, r
+	}
+
+	Convey("Given I have a tracer and a request", t, func() {
+
+		tracer, exporter := newTestTracer()
+		req, r := makeRequests()
+
+		Convey("When I trace the request and finish the tracing", func() {
+
+			ctx := traceRequestOTel(context.Background(), req, r, tracer, nil, func(elemental.Identity, []byte) []byte { return []byte("clean") })
+			setOTelStatusCode(ctx, http.StatusCreated)
+			finishTracing(ctx)
+
+			spans := exporter.GetSpans()
+
+			Convey("Then the span should be correct", func() {
+
+				So(len(spans), ShouldEqual, 1)
+
+				span := spans[0]
+				So(span.Name, ShouldEqual, "bahamut.handle.create.lists")
+				So(span.SpanKind, ShouldEqual, trace.SpanKindServer)
+				So(span.Parent.TraceID().String(), ShouldEqual, "0af7651916cd43dd8448eb211c80319c")
+				So(span.Parent.SpanID().String(), ShouldEqual, "b7ad6b7169203331")
+				So(span.Parent.IsRemote(), ShouldBeTrue)
+				So(span.Status.Code, ShouldEqual, codes.Unset)
+
+				So(otelAttribute(span, "http.request.method").AsString(), ShouldEqual, "POST")
+				So(otelAttribute(span, "url.path").AsString(), ShouldEqual, "/lists")
+				So(otelAttribute(span, "http.response.status_code").AsInt64(), ShouldEqual, 201)
+				So(otelAttribute(span, "client.address").AsString(), ShouldEqual, "10.0.0.1")
+				So(otelAttribute(span, "user_agent.original").AsString(), ShouldEqual, "test")
+				So(otelAttribute(span, "bahamut.identity").AsString(), ShouldEqual, "list")
+				So(otelAttribute(span, "bahamut.request_id").AsString(), ShouldEqual, "rid")
+				So(otelAttribute(span, "bahamut.namespace").AsString(), ShouldEqual, "/ns")
+				So(otelAttribute(span, "http.request.header.authorization").AsStringSlice(), ShouldResemble, []string{"[snip]"})
+
+				So(len(span.Events), ShouldEqual, 1)
+				So(span.Events[0].Name, ShouldEqual, "request")
+				So(span.Events[0].Attributes, ShouldContain, attribute.String("bahamut.payload", "clean"))
+			})
+		})
+
+		Convey("When I trace a request that fails", func() {
+
+			ctx := traceRequestOTel(context.Background(), req, r, tracer, nil, nil)
+			errs := processError(ctx, elemental.NewError("boom", "boom", "test", http.StatusInternalServerError))
+			finishTracing(ctx)
+
+			spans := exporter.GetSpans()
+
+			Convey("Then the error should be traced", func() {
+				So(len(spans), ShouldEqual, 1)
+				So(spans[0].Status.Code, ShouldEqual, codes.Error)
+				So(otelAttribute(spans[0], "http.response.status_code").AsInt64(), ShouldEqual, 500)
+				So(spans[0].Events[len(spans[0].Events)-1].Name, ShouldEqual, "exception")
+			})
+
+			Convey("Then the error should carry the trace ID", func() {
+				So(errs[0].Trace, ShouldEqual, spans[0].SpanContext.TraceID().String())
+			})
+		})
+
+		Convey("When I trace a request that is rejected", func() {
+
+			ctx := traceRequestOTel(context.Background(), req, r, tracer, nil, nil)
+			processError(ctx, elemental.NewError("nope", "nope", "test", http.StatusForbidden))
+			finishTracing(ctx)
+
+			Convey("Then the status of the span should not be an error", func() {
+				spans := exporter.GetSpans()
+				So(spans[0].Status.Code, ShouldEqual, codes.Unset)
+				So(otelAttribute(spans[0], "http.response.status_code").AsInt64(), ShouldEqual, 403)
+			})
+		})
+
+		Convey("When I trace a request on an excluded identity", func() {
+
+			ctx := traceRequestOTel(context.Background(), req, r, tracer, map[string]struct{}{"list": {}}, nil)
+			finishTracing(ctx)
+
+			Convey("Then no span should be recorded", func() {
+				So(otelSpanFromContext(ctx), ShouldBeNil)
+				So(len(exporter.GetSpans()), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given I have no tracer", t, func() {
+
+		req, r := makeRequests()
+
+		Convey("When I trace the request", func() {
+
+			ctx := traceRequestOTel(context.Background(), req, r, nil, nil, nil)
+
+			Convey("Then the context should not be traced", func() {
+				So(otelSpanFromContext(ctx), ShouldBeNil)
+				So(func() { finishTracing(ctx) }, ShouldNotPanic)
+			})
+		})
+	})
+}
+
+func TestOpenTelemetry_runProcessor(t *testing.T) {
+
+	Convey("Given I have a traced request", t, func() {
+
+		tracer, exporter := newTestTracer()
+		req, _ := http.NewRequest(http.MethodGet, "http://server/lists", nil)
+
+		r := elemental.NewRequest()
+		r.Identity = elemental.MakeIdentity("list", "lists")
+		r.Operation = elemental.OperationRetrieveMany
+
+		bctx := newContext(traceRequestOTel(context.Background(), req, r, tracer, nil, nil), r)
+
+		Convey("When I run a processor that succeeds", func() {
+
+			var processorSpan trace.SpanContext
+			err := runProcessor(bctx, &struct{}{}, func() error {
+				processorSpan = trace.SpanContextFromContext(bctx.Context())
+				return nil
+			})
+			finishTracing(bctx.ctx)
+
+			spans := exporter.GetSpans()
+
+			Convey("Then the processor span should be a child of the request span", func() {
+				So(err, ShouldBeNil)
+				So(len(spans), ShouldEqual, 2)
+				So(spans[0].Name, ShouldEqual, "bahamut.process.retrieve_many.lists")
+				So(spans[0].SpanKind, ShouldEqual, trace.SpanKindInternal)
+				So(spans[0].Parent.SpanID(), ShouldEqual, spans[1].SpanContext.SpanID())
+				So(spans[0].SpanContext.SpanID(), ShouldEqual, processorSpan.SpanID())
+				So(otelAttribute(spans[0], "code.function").AsString(), ShouldEqual, "*struct {}")
+			})
+
+			Convey("Then the context should be restored", func() {
+				So(bctx.Context(), ShouldEqual, bctx.ctx)
+			})
+		})
+
+		Convey("When I run a processor that fails", func() {
+
+			err := runProcessor(bctx, nil, func() error { return errors.New("oops") })
+
+			Convey("Then the processor span should be in error", func() {
+				spans := exporter.GetSpans()
+				So(err, ShouldNotBeNil)
+				So(len(spans), ShouldEqual, 1)
+				So(spans[0].Status.Code, ShouldEqual, codes.Error)
+				So(spans[0].Status.Description, ShouldEqual, "oops")
+			})
+		})
+	})
+
+	Convey("Given I have a request that is not traced", t, func() {
+
+		bctx := newContext(context.Background(), elemental.NewRequest())
+
+		Convey("When I run a processor", func() {
+
+			var called bool
+			err := runProcessor(bctx, nil, func() error { called = true; return nil })
+
+			Convey("Then the function should be called", func() {
+				So(err, ShouldBeNil)
+				So(called, ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestOpenTelemetry_Publication(t *testing.T) {
+
+	Convey("Given I have a tracer and a traced context", t, func() {
+
+		tracer, exporter := newTestTracer()
+		ctx, parent := tracer.Start(context.Background(), "parent")
+
+		Convey("When I start a producer span on a publication", func() {
+
+			pub := NewPublication("topic")
+			pctx := pub.StartOpenTelemetrySpan(ctx, tracer, "publish")
+
+			Convey("Then the W3C trace context should be in the tracking data", func() {
+				So(pub.TrackingData["traceparent"], ShouldStartWith, "00-"+parent.SpanContext().TraceID().String())
+				So(pub.OpenTelemetrySpan(), ShouldNotBeNil)
+				So(trace.SpanContextFromContext(pctx).SpanID(), ShouldEqual, pub.OpenTelemetrySpan().SpanContext().SpanID())
+			})
+
+			Convey("When I start a consumer span from the received publication", func() {
+
+				received := NewPublication("topic")
+				received.TrackingData = pub.TrackingData
+				received.StartOpenTelemetryTracing(context.Background(), tracer, "receive")
+
+				_ = received.Decode(&struct{}{})
+				received.OpenTelemetrySpan().End()
+				pub.OpenTelemetrySpan().End()
+
+				spans := exporter.GetSpans()
+
+				Convey("Then the consumer span should be a child of the producer span", func() {
+					So(len(spans), ShouldEqual, 2)
+					So(spans[0].Name, ShouldEqual, "receive")
+					So(spans[0].SpanKind, ShouldEqual, trace.SpanKindConsumer)
+					So(spans[0].SpanContext.TraceID(), ShouldEqual, parent.SpanContext().TraceID())
+					So(spans[0].Parent.SpanID(), ShouldEqual, spans[1].SpanContext.SpanID())
+					So(otelAttribute(spans[0], "messaging.destination.name").AsString(), ShouldEqual, "topic")
+					So(spans[0].Events[0].Name, ShouldEqual, "payload")
+					So(spans[1].SpanKind, ShouldEqual, trace.SpanKindProducer)
+				})
+			})
+		})
+
+		Convey("When I start tracing a publication without a tracer", func() {
+
+			pub := NewPublication("topic")
+			pctx := pub.StartOpenTelemetrySpan(ctx, nil, "publish")
+
+			Convey("Then nothing should happen", func() {
+				So(pctx, ShouldEqual, ctx)
+				So(pub.OpenTelemetrySpan(), ShouldBeNil)
+				So(len(pub.TrackingData), ShouldEqual, 0)
+			})
+		})
+	})
+}
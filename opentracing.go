@@ -42,6 +42,40 @@ func extractClaims(r *elemental.Request) string {
 	return string(identity)
 }
 
+// safeParameters returns a copy of the given parameters
+// with the sensitive information removed.
+func safeParameters(parameters elemental.Parameters) url.Values {
+
+	safe := url.Values{}
+	for k, p := range parameters {
+		lk := strings.ToLower(k)
+		if lk == "token" || lk == "password" {
+			safe[k] = snipSlice
+			continue
+		}
+		safe[k] = []string{fmt.Sprintf("%v", p.Values())}
+	}
+
+	return safe
+}
+
+// safeHeaders returns a copy of the given headers
+// with the sensitive information removed.
+func safeHeaders(headers http.Header) http.Header {
+
+	safe := http.Header{}
+	for k, v := range headers {
+		lk := strings.ToLower(k)
+		if lk == "authorization" {
+			safe[k] = snipSlice
+			continue
+		}
+		safe[k] = v
+	}
+
+	return safe
+}
+
 func tracingName(r *elemental.Request) string {
 
 	switch r.Operation {
@@ -86,28 +120,6 @@ func traceRequest(ctx context.Context, r *elemental.Request, tracer opentracing.
 	span := tracer.StartSpan(tracingName(r), ext.RPCServerOption(spanContext))
 	trackingCtx := opentracing.ContextWithSpan(ctx, span)
 
-	// Remove sensitive information from parameters.
-	safeParameters := url.Values{}
-	for k, p := range r.Parameters {
-		lk := strings.ToLower(k)
-		if lk == "token" || lk == "password" {
-			safeParameters[k] = snipSlice
-			continue
-		}
-		safeParameters[k] = []string{fmt.Sprintf("%v", p.Values())}
-	}
-
-	// Remove sensitive information from headers.
-	safeHeaders := http.Header{}
-	for k, v := range r.Headers {
-		lk := strings.ToLower(k)
-		if lk == "authorization" {
-			safeHeaders[k] = snipSlice
-			continue
-		}
-		safeHeaders[k] = v
-	}
-
 	span.SetTag("req.api_version", r.Version)
 	span.SetTag("req.id", r.RequestID)
 	span.SetTag("req.identity", r.Identity.Name)
@@ -147,10 +159,10 @@ func traceRequest(ctx context.Context, r *elemental.Request, tracer opentracing.
 	span.LogFields(
 		log.Int("req.page.number", r.Page),
 		log.Int("req.page.size", r.PageSize),
-		log.Object("req.headers", safeHeaders),
+		log.Object("req.headers", safeHeaders(r.Headers)),
 		log.Object("req.claims", extractClaims(r)),
 		log.Object("req.client_ip", r.ClientIP),
-		log.Object("req.parameters", safeParameters),
+		log.Object("req.parameters", safeParameters(r.Parameters)),
 		log.Object("req.order_by", r.Order),
 		log.String("req.payload", string(data)),
 	)
@@ -160,6 +172,20 @@ func traceRequest(ctx context.Context, r *elemental.Request, tracer opentracing.
 
 func finishTracing(ctx context.Context) {
 
+	if tail := traceTailFromContext(ctx); tail != nil {
+
+		keep, reason := tail.decide()
+		if !keep {
+			tail.drop()
+			return
+		}
+
+		setSamplingReason(ctx, reason)
+		tail.flush()
+	}
+
+	finishOTelTracing(ctx)
+
 	span := opentracing.SpanFromContext(ctx)
 	if span == nil {
 		return
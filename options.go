@@ -15,13 +15,18 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
+	"go.aporeto.io/bahamut/internal/cidrs"
 	"go.aporeto.io/elemental"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
 
@@ -35,6 +40,15 @@ func OptDisablePanicRecovery() Option {
 	}
 }
 
+// OptLogger sets the logger used by bahamut, and from which the loggers
+// returned by Context.Logger() and Session.Logger() are derived.
+// By default, the global zap logger is used.
+func OptLogger(logger *zap.Logger) Option {
+	return func(c *config) {
+		c.general.logger = logger
+	}
+}
+
 // OptRestServer configures the listening address of the server.
 //
 // listen is the general listening address for the API server as
@@ -52,6 +66,55 @@ func OptCustomListener(listener net.Listener) Option {
 	}
 }
 
+// OptUnixSocket configures the api server to listen on a unix socket
+// at the given path instead of the tcp listen address.
+//
+// Mode is the file mode of the socket. If it is zero, the mode is
+// left to the default. Uid and gid set the ownership of the socket.
+// Use -1 to leave them unchanged.
+//
+// The kernel credentials of the client are available through
+// PeerCredentialsFromRequest. This option has no effect if
+// OptCustomListener is set.
+func OptUnixSocket(path string, mode os.FileMode, uid int, gid int) Option {
+	return func(c *config) {
+		c.restServer.unixSocketPath = path
+		c.restServer.unixSocketMode = mode
+		c.restServer.unixSocketUID = uid
+		c.restServer.unixSocketGID = gid
+	}
+}
+
+// OptTrustedProxies sets the list of networks of the proxies and load
+// balancers in front of the api server. The client IP is resolved from the
+// Forwarded or X-Forwarded-For headers only when the request comes from one
+// of these networks. Cidrs can be given as CIDRs or single IPs.
+//
+// It will panic if any of the cidrs is invalid.
+func OptTrustedProxies(cidrs ...string) Option {
+
+	networks, err := cidrs.Parse(cidrs)
+	if err != nil {
+		panic(fmt.Sprintf("invalid trusted proxies: %s", err))
+	}
+
+	return func(c *config) {
+		c.restServer.trustedProxies = networks
+	}
+}
+
+// OptProxyProtocol enables the support of the PROXY protocol v1 and v2
+// on the api server listener, including the one set by OptCustomListener.
+//
+// The header is optional, and is only read from the networks set by
+// OptTrustedProxies. If no trusted proxies are set, the header is never
+// read, so the clients cannot spoof their addresses.
+func OptProxyProtocol() Option {
+	return func(c *config) {
+		c.restServer.proxyProtocolEnabled = true
+	}
+}
+
 // OptTimeouts configures the timeouts of the server.
 func OptTimeouts(read, write, idle time.Duration) Option {
 	return func(c *config) {
@@ -126,6 +189,44 @@ func OptPushPublishHandler(publishHandler PushPublishHandler) Option {
 	}
 }
 
+// OptPushTokenCookie sets the name of the cookie push sessions
+// can read their token from, if it is not given as a query parameter.
+//
+// As browsers send the cookie with the requests of any page, the sessions
+// using it are refused unless their Origin header matches the host of the
+// request or one of the origins set by OptPushAllowedOrigins.
+//
+// This option has no effect if OptPushServer is not set.
+func OptPushTokenCookie(name string) Option {
+	return func(c *config) {
+		c.pushServer.tokenCookie = name
+	}
+}
+
+// OptPushAllowedOrigins sets the origins, like https://app.example.com,
+// allowed to start push sessions using the token cookie set by
+// OptPushTokenCookie, in addition to the host of the server.
+func OptPushAllowedOrigins(origins ...string) Option {
+	return func(c *config) {
+		c.pushServer.allowedOrigins = origins
+	}
+}
+
+// OptPushTokenSubprotocol allows push sessions to send their token as a
+// Sec-WebSocket-Protocol entry, in the form <prefix><token>, if it is not
+// given as a query parameter, in the Authorization header or in the cookie.
+//
+// During the upgrade, the server echoes the first other subprotocol offered
+// by the client, or the token entry if there is none, so browsers accept
+// the handshake.
+//
+// This option has no effect if OptPushServer is not set.
+func OptPushTokenSubprotocol(prefix string) Option {
+	return func(c *config) {
+		c.pushServer.tokenProtocol = prefix
+	}
+}
+
 // OptHealthServer enables and configures the health server.
 //
 // ListenAddress is the general listening address for the health server.
@@ -147,6 +248,30 @@ func OptHealthServerMetricsManager(manager MetricsManager) Option {
 	}
 }
 
+// OptHealthServerPingers sets the Pingers checked by the readiness
+// endpoint of the health server, using the given timeout.
+//
+// This option has no effect if the health server is not enabled.
+func OptHealthServerPingers(timeout time.Duration, pingers map[string]Pinger) Option {
+	return func(c *config) {
+		c.healthServer.pingTimeout = timeout
+		c.healthServer.pingers = pingers
+	}
+}
+
+// OptHealthServerProber sets the HealthProber whose cached results are
+// served by the health server, instead of pinging the Pingers on every
+// request. The prober is started when the server runs. If no HealthServerFunc
+// is set, the prober is also used to check the health of the server.
+//
+// This option has no effect if the health server is not enabled, and takes
+// precedence over OptHealthServerPingers.
+func OptHealthServerProber(prober HealthProber) Option {
+	return func(c *config) {
+		c.healthServer.prober = prober
+	}
+}
+
 // OptHealthCustomStats configures additional stats handler.
 //
 // The healt server must be enabled using OptHealthServer or this option
@@ -228,6 +353,48 @@ func OptTLS(certs []tls.Certificate, certRetriever func(*tls.ClientHelloInfo) (*
 	}
 }
 
+// OptTLSReloader configures the server to load its TLS certificate, key and
+// client CA pool from the given files, and to reload them when they change on disk.
+//
+// CertFile and keyFile are the PEM encoded server certificate and key.
+// ClientCAFile is the PEM encoded client CA bundle. It can be empty, in which
+// case the pool given to OptMTLS is used.
+// Interval defines how often the files are checked for changes. If it is zero,
+// it defaults to one minute.
+// - If you set this, the values given to OptTLS will be ignored.
+// - The client auth type is still configured using OptMTLS.
+func OptTLSReloader(certFile string, keyFile string, clientCAFile string, interval time.Duration) Option {
+	return func(c *config) {
+		c.tls.reloadCertFile = certFile
+		c.tls.reloadKeyFile = keyFile
+		c.tls.reloadClientCAFile = clientCAFile
+		c.tls.reloadInterval = interval
+	}
+}
+
+// OptTLSExpirationWarning sets how long before the expiration of the active
+// TLS certificate the server starts to log warnings. The default is 7 days.
+//
+// This option has no effect if OptTLSReloader is not set.
+func OptTLSExpirationWarning(before time.Duration) Option {
+	return func(c *config) {
+		c.tls.expirationWarning = before
+	}
+}
+
+// OptTLSProfile sets the TLS profile to use for the secure servers.
+//
+// You can pass one of the presets TLSProfileModern, TLSProfileIntermediate
+// or TLSProfileFIPS, eventually modified to override the versions, curves,
+// cipher suites, session tickets or ALPN protocols. If this option is not
+// set, TLS 1.2 and above is used with a restricted set of cipher suites and
+// session tickets disabled.
+func OptTLSProfile(profile TLSProfile) Option {
+	return func(c *config) {
+		c.tls.profile = &profile
+	}
+}
+
 // OptMTLS configures the tls client authentication mechanism.
 //
 // ClientCAPool is the *x509.CertPool to use for the authentifying client.
@@ -272,13 +439,39 @@ func OptAuthorizers(authorizers []Authorizer) Option {
 // OptAuditer configures the auditor to use to audit the requests.
 //
 // The Audit() method will be run in a go routine so there is no
-// need to deal with it in your implementation.
+// need to deal with it in your implementation. It is called before
+// the response is sent, so the final status code is not known.
+//
+// Deprecated: use OptAuditRecorder. Both can be set during the
+// migration, in which case both are called for each request.
 func OptAuditer(auditer Auditer) Option {
 	return func(c *config) {
 		c.security.auditer = auditer
 	}
 }
 
+// OptAuditRecorder configures the recorder receiving an AuditRecord
+// for every request handled by the api server, including the ones
+// rejected before reaching the processors.
+//
+// See the audit package for the built-in recorders.
+func OptAuditRecorder(recorder AuditRecorder) Option {
+	return func(c *config) {
+		c.security.auditRecorder = recorder
+	}
+}
+
+// OptAuthDecisionsHeader adds the decisions of the authenticators
+// and authorizers to the responses in the X-Bahamut-Auth-Decisions header.
+//
+// This leaks information about the security configuration
+// and should only be used for debugging.
+func OptAuthDecisionsHeader() Option {
+	return func(c *config) {
+		c.security.authDecisionsHeader = true
+	}
+}
+
 // OptRateLimiting configures the rate limiting.
 func OptRateLimiting(limit float64, burst int) Option {
 	return func(c *config) {
@@ -286,6 +479,15 @@ func OptRateLimiting(limit float64, burst int) Option {
 	}
 }
 
+// OptClientRateLimiting configures a rate limiting applied to each
+// client IP, as returned by Context.ClientIP. It is applied in
+// addition to the one set by OptRateLimiting.
+func OptClientRateLimiting(limit float64, burst int) Option {
+	return func(c *config) {
+		c.rateLimiting.clientRateLimiter = newClientRateLimiter(limit, burst)
+	}
+}
+
 // OptModel configures the elemental Model for the server.
 //
 // modelManagers is a map of version to elemental.ModelManager.
@@ -342,6 +544,81 @@ func OptDisableMetaRoutes() Option {
 	}
 }
 
+// OptAccessLog enables the access log of the api and push servers,
+// writing the entries in the given format to the given writer.
+// Push sessions are logged when the websocket upgrade is done.
+func OptAccessLog(writer io.Writer, format AccessLogFormat) Option {
+	return func(c *config) {
+		c.accessLog.writer = &syncWriter{w: writer}
+		c.accessLog.format = format
+	}
+}
+
+// OptAccessLogSampling sets the rate, between 0 and 1, of the requests
+// written in the access log for the given status classes (2 for 2xx, 4 for
+// 4xx etc). If no status class is given, the rate applies to all the classes
+// that have no rate set.
+func OptAccessLogSampling(rate float64, statusClasses ...int) Option {
+	return func(c *config) {
+
+		if c.accessLog.sampling == nil {
+			c.accessLog.sampling = map[int]float64{}
+		}
+
+		if len(statusClasses) == 0 {
+			c.accessLog.sampling[0] = rate
+			return
+		}
+
+		for _, class := range statusClasses {
+			c.accessLog.sampling[class] = rate
+		}
+	}
+}
+
+// OptAccessLogIdentitySampling sets the rate, between 0 and 1, of the
+// requests on the given identities written in the access log. The rate
+// of an identity takes precedence over the rates of the status classes.
+func OptAccessLogIdentitySampling(rates map[elemental.Identity]float64) Option {
+	return func(c *config) {
+
+		c.accessLog.identitySampling = make(map[string]float64, len(rates))
+		for i, rate := range rates {
+			c.accessLog.identitySampling[i.Name] = rate
+		}
+	}
+}
+
+// OptAccessLogExclusions excludes the requests on the given identities
+// and the responses of the given status classes (2 for 2xx, 4 for 4xx etc)
+// from the access log.
+func OptAccessLogExclusions(identities []elemental.Identity, statusClasses ...int) Option {
+	return func(c *config) {
+
+		c.accessLog.excludedIdentities = map[string]struct{}{}
+		for _, i := range identities {
+			c.accessLog.excludedIdentities[i.Name] = struct{}{}
+		}
+
+		c.accessLog.excludedStatusClasses = map[int]struct{}{}
+		for _, class := range statusClasses {
+			c.accessLog.excludedStatusClasses[class] = struct{}{}
+		}
+	}
+}
+
+// OptRedactedFields redacts the fields whose names match one of the given
+// patterns from the payloads logged in traces and from the attribute changes
+// of the audit records, in addition to the secret attributes of the model and
+// the attributes marked as sensitive by a SensitiveAttributesSpecifier. The
+// patterns are matched case insensitively using the path.Match syntax,
+// for instance "*token*" or "email".
+func OptRedactedFields(patterns ...string) Option {
+	return func(c *config) {
+		c.redaction.fields = append(c.redaction.fields, patterns...)
+	}
+}
+
 // OptOpentracingTracer sets the opentracing.Tracer to use.
 func OptOpentracingTracer(tracer opentracing.Tracer) Option {
 	return func(c *config) {
@@ -349,6 +626,54 @@ func OptOpentracingTracer(tracer opentracing.Tracer) Option {
 	}
 }
 
+// OptOpenTelemetryTracer sets the trace.TracerProvider used to create the
+// OpenTelemetry spans of the requests, the processors and the publications.
+// The trace context is propagated using the W3C Trace Context headers.
+//
+// It can be used alongside OptOpentracingTracer. The identities excluded
+// with OptOpentracingExcludedIdentities and the TraceCleaner set with
+// OptTraceCleaner apply to both.
+func OptOpenTelemetryTracer(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.opentelemetry.tracer = provider.Tracer(otelInstrumentationName)
+	}
+}
+
+// OptTraceSamplingPolicy sets the TraceSamplingPolicy deciding which requests
+// are traced. Identities excluded with OptOpentracingExcludedIdentities are
+// never traced. By default, all requests are traced.
+func OptTraceSamplingPolicy(policy TraceSamplingPolicy) Option {
+	return func(c *config) {
+		c.opentracing.samplingPolicy = &policy
+	}
+}
+
+// OptPushAttributeChanges adds the AttributeChanges of the update and patch
+// operations to the update events they push, in their changes field. The
+// values of the secret attributes and of the attributes redacted by
+// OptRedactedFields are removed before the events are published.
+//
+// This option has no effect if OptPushServer is not set.
+func OptPushAttributeChanges() Option {
+	return func(c *config) {
+		c.pushServer.changesEnabled = true
+	}
+}
+
+// OptPushTracingSampling sets the rate, between 0 and 1, of the published
+// events that are traced, and the rate of the dispatches to the push
+// sessions that are traced for each traced event. Publications are only
+// traced when they are made while handling a traced request. By default,
+// all publications and dispatches are traced.
+func OptPushTracingSampling(publishRate float64, dispatchRate float64) Option {
+	return func(c *config) {
+		c.pushServer.tracingSampling = &pushTracingSampling{
+			publish:  publishRate,
+			dispatch: dispatchRate,
+		}
+	}
+}
+
 // OptOpentracingExcludedIdentities excludes the given identity from being traced.
 func OptOpentracingExcludedIdentities(identities []elemental.Identity) Option {
 	return func(c *config) {
@@ -375,7 +700,8 @@ func OptPreStopHook(hook func(Server) error) Option {
 
 // OptTraceCleaner registers a trace cleaner that will be called to
 // let a chance to clean up various sensitive information before
-// sending the trace to the OpenTracing server.
+// sending the trace to the OpenTracing server. The cleaner gets the
+// payloads in their original encoding, once redacted.
 func OptTraceCleaner(cleaner TraceCleaner) Option {
 	return func(c *config) {
 		c.opentracing.traceCleaner = cleaner
@@ -12,16 +12,19 @@
 package bahamut
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"net"
 	"net/http"
+	"os"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
 	testmodel "go.aporeto.io/elemental/test/model"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/time/rate"
 )
 
@@ -108,6 +111,19 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.healthServer.metricsManager, ShouldEqual, pmm)
 	})
 
+	Convey("Calling OptHealthServerPingers should work", t, func() {
+		pingers := map[string]Pinger{"a": MockPinger{}}
+		OptHealthServerPingers(3*time.Second, pingers)(&c)
+		So(c.healthServer.pingTimeout, ShouldEqual, 3*time.Second)
+		So(c.healthServer.pingers, ShouldResemble, pingers)
+	})
+
+	Convey("Calling OptHealthServerProber should work", t, func() {
+		prober := NewHealthProber(nil)
+		OptHealthServerProber(prober)(&c)
+		So(c.healthServer.prober, ShouldEqual, prober)
+	})
+
 	Convey("Calling OptHealthServerTimeouts should work", t, func() {
 		OptHealthServerTimeouts(1*time.Second, 2*time.Second, 3*time.Second)(&c)
 		So(c.healthServer.readTimeout, ShouldEqual, 1*time.Second)
@@ -165,6 +181,71 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.tls.serverCertificatesRetrieverFunc, ShouldEqual, r)
 	})
 
+	Convey("Calling OptUnixSocket should work", t, func() {
+		OptUnixSocket("/var/run/api.sock", 0660, 1000, -1)(&c)
+		So(c.restServer.unixSocketPath, ShouldEqual, "/var/run/api.sock")
+		So(c.restServer.unixSocketMode, ShouldEqual, os.FileMode(0660))
+		So(c.restServer.unixSocketUID, ShouldEqual, 1000)
+		So(c.restServer.unixSocketGID, ShouldEqual, -1)
+	})
+
+	Convey("Calling OptPushTokenCookie should work", t, func() {
+		OptPushTokenCookie("session")(&c)
+		So(c.pushServer.tokenCookie, ShouldEqual, "session")
+	})
+
+	Convey("Calling OptPushAttributeChanges should work", t, func() {
+		OptPushAttributeChanges()(&c)
+		So(c.pushServer.changesEnabled, ShouldBeTrue)
+	})
+
+	Convey("Calling OptPushAllowedOrigins should work", t, func() {
+		OptPushAllowedOrigins("https://app.example.com")(&c)
+		So(c.pushServer.allowedOrigins, ShouldResemble, []string{"https://app.example.com"})
+	})
+
+	Convey("Calling OptPushTokenSubprotocol should work", t, func() {
+		OptPushTokenSubprotocol("token.")(&c)
+		So(c.pushServer.tokenProtocol, ShouldEqual, "token.")
+	})
+
+	Convey("Calling OptTrustedProxies should work", t, func() {
+		OptTrustedProxies("10.0.0.0/8", "1.2.3.4")(&c)
+		So(len(c.restServer.trustedProxies), ShouldEqual, 2)
+		So(c.restServer.trustedProxies[0].String(), ShouldEqual, "10.0.0.0/8")
+		So(c.restServer.trustedProxies[1].String(), ShouldEqual, "1.2.3.4/32")
+	})
+
+	Convey("Calling OptTrustedProxies with an invalid cidr should panic", t, func() {
+		So(func() { OptTrustedProxies("nope") }, ShouldPanicWith, "invalid trusted proxies: invalid ip 'nope'")
+	})
+
+	Convey("Calling OptProxyProtocol should work", t, func() {
+		OptProxyProtocol()(&c)
+		So(c.restServer.proxyProtocolEnabled, ShouldBeTrue)
+	})
+
+	Convey("Calling OptTLSReloader should work", t, func() {
+		OptTLSReloader("cert.pem", "key.pem", "ca.pem", time.Hour)(&c)
+		So(c.tls.reloadCertFile, ShouldEqual, "cert.pem")
+		So(c.tls.reloadKeyFile, ShouldEqual, "key.pem")
+		So(c.tls.reloadClientCAFile, ShouldEqual, "ca.pem")
+		So(c.tls.reloadInterval, ShouldEqual, time.Hour)
+	})
+
+	Convey("Calling OptTLSExpirationWarning should work", t, func() {
+		OptTLSExpirationWarning(time.Hour)(&c)
+		So(c.tls.expirationWarning, ShouldEqual, time.Hour)
+	})
+
+	Convey("Calling OptTLSProfile should work", t, func() {
+		p := TLSProfileModern()
+		p.NextProtos = []string{"h2"}
+		OptTLSProfile(p)(&c)
+		So(c.tls.profile, ShouldNotBeNil)
+		So(*c.tls.profile, ShouldResemble, p)
+	})
+
 	Convey("Calling OptMTLS should work", t, func() {
 		pool := x509.NewCertPool()
 		authType := tls.RequestClientCert
@@ -193,12 +274,30 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.security.auditer, ShouldEqual, a)
 	})
 
+	Convey("Calling OptAuditRecorder should work", t, func() {
+		r := &mockAuditRecorder{}
+		OptAuditRecorder(r)(&c)
+		So(c.security.auditRecorder, ShouldEqual, r)
+	})
+
+	Convey("Calling OptAuthDecisionsHeader should work", t, func() {
+		OptAuthDecisionsHeader()(&c)
+		So(c.security.authDecisionsHeader, ShouldBeTrue)
+	})
+
 	Convey("Calling OptRateLimiting should work", t, func() {
 		rlm := rate.NewLimiter(rate.Limit(10), 20)
 		OptRateLimiting(10, 20)(&c)
 		So(c.rateLimiting.rateLimiter, ShouldResemble, rlm)
 	})
 
+	Convey("Calling OptClientRateLimiting should work", t, func() {
+		OptClientRateLimiting(10, 20)(&c)
+		So(c.rateLimiting.clientRateLimiter, ShouldNotBeNil)
+		So(c.rateLimiting.clientRateLimiter.limit, ShouldEqual, rate.Limit(10))
+		So(c.rateLimiting.clientRateLimiter.burst, ShouldEqual, 20)
+	})
+
 	Convey("Calling OptModel should work", t, func() {
 		m := map[int]elemental.ModelManager{0: testmodel.Manager()}
 		OptModel(m)(&c)
@@ -231,6 +330,51 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.meta.disableMetaRoute, ShouldEqual, true)
 	})
 
+	Convey("Calling OptAccessLog should work", t, func() {
+		buf := &bytes.Buffer{}
+		OptAccessLog(buf, AccessLogFormatCLF)(&c)
+		So(c.accessLog.writer, ShouldResemble, &syncWriter{w: buf})
+		So(c.accessLog.format, ShouldEqual, AccessLogFormatCLF)
+	})
+
+	Convey("Calling OptAccessLogSampling should work", t, func() {
+		OptAccessLogSampling(0.5)(&c)
+		OptAccessLogSampling(0.1, 2, 3)(&c)
+		So(c.accessLog.sampling, ShouldResemble, map[int]float64{0: 0.5, 2: 0.1, 3: 0.1})
+	})
+
+	Convey("Calling OptAccessLogIdentitySampling should work", t, func() {
+		OptAccessLogIdentitySampling(map[elemental.Identity]float64{testmodel.ListIdentity: 0.1})(&c)
+		So(c.accessLog.identitySampling, ShouldResemble, map[string]float64{"list": 0.1})
+	})
+
+	Convey("Calling OptAccessLogExclusions should work", t, func() {
+		OptAccessLogExclusions([]elemental.Identity{testmodel.ListIdentity}, 2)(&c)
+		So(c.accessLog.excludedIdentities, ShouldResemble, map[string]struct{}{"list": {}})
+		So(c.accessLog.excludedStatusClasses, ShouldResemble, map[int]struct{}{2: {}})
+	})
+
+	Convey("Calling OptOpenTelemetryTracer should work", t, func() {
+		OptOpenTelemetryTracer(sdktrace.NewTracerProvider())(&c)
+		So(c.opentelemetry.tracer, ShouldNotBeNil)
+	})
+
+	Convey("Calling OptPushTracingSampling should work", t, func() {
+		OptPushTracingSampling(0.1, 0.01)(&c)
+		So(c.pushServer.tracingSampling, ShouldResemble, &pushTracingSampling{publish: 0.1, dispatch: 0.01})
+	})
+
+	Convey("Calling OptTraceSamplingPolicy should work", t, func() {
+		p := TraceSamplingPolicy{DefaultProbability: 0.5, SampleErrors: true}
+		OptTraceSamplingPolicy(p)(&c)
+		So(c.opentracing.samplingPolicy, ShouldResemble, &p)
+	})
+
+	Convey("Calling OptRedactedFields should work", t, func() {
+		OptRedactedFields("*token*", "email")(&c)
+		So(c.redaction.fields, ShouldResemble, []string{"*token*", "email"})
+	})
+
 	Convey("Calling OptOpentracingTracer should work", t, func() {
 		tracer := &mockTracer{}
 		OptOpentracingTracer(tracer)(&c)
@@ -0,0 +1,134 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// PeerCredentialsMetadataKey is the key of the elemental.Request
+// Metadata holding the PeerCredentials of the client, when the
+// request has been received through a unix socket.
+const PeerCredentialsMetadataKey = "bahamut.peercredentials"
+
+// PeerCredentials contains the kernel credentials of the process
+// on the other side of a unix socket connection.
+type PeerCredentials struct {
+	UID int
+	GID int
+	PID int
+}
+
+func (c PeerCredentials) String() string {
+	return fmt.Sprintf("uid=%d gid=%d pid=%d", c.UID, c.GID, c.PID)
+}
+
+// PeerCredentialsFromRequest returns the PeerCredentials stored in the
+// given elemental.Request. The boolean is false if the request
+// did not come from a unix socket.
+func PeerCredentialsFromRequest(req *elemental.Request) (PeerCredentials, bool) {
+
+	if req == nil || req.Metadata == nil {
+		return PeerCredentials{}, false
+	}
+
+	creds, ok := req.Metadata[PeerCredentialsMetadataKey].(PeerCredentials)
+
+	return creds, ok
+}
+
+type peerCredentialsContextKey struct{}
+
+// contextWithPeerCredentials stores the PeerCredentials of unix socket
+// connections in the given connection context. The given logger is used
+// to report the failures.
+func contextWithPeerCredentials(ctx context.Context, conn net.Conn, logger *zap.Logger) context.Context {
+
+	for {
+		nc, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = nc.NetConn()
+	}
+
+	uconn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	creds, err := readPeerCredentials(uconn)
+	if err != nil {
+		logger.Debug("Unable to read peer credentials", zap.Error(err))
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerCredentialsContextKey{}, creds)
+}
+
+// setRequestPeerCredentials copies the PeerCredentials found in
+// the given context into the Metadata of the given elemental.Request.
+func setRequestPeerCredentials(ctx context.Context, req *elemental.Request) {
+
+	creds, ok := ctx.Value(peerCredentialsContextKey{}).(PeerCredentials)
+	if !ok {
+		return
+	}
+
+	if req.Metadata == nil {
+		req.Metadata = map[string]interface{}{}
+	}
+
+	req.Metadata[PeerCredentialsMetadataKey] = creds
+}
+
+// listenUnixSocket creates a unix socket listener at the given path
+// and applies the given mode and ownership to the socket file.
+// A uid or gid of -1 leaves the value unchanged.
+func listenUnixSocket(path string, mode os.FileMode, uid int, gid int) (net.Listener, error) {
+
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("unable to listen on %s: file exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("unable to remove stale socket %s: %s", path, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close() // nolint: errcheck
+			return nil, fmt.Errorf("unable to set mode of socket %s: %s", path, err)
+		}
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			listener.Close() // nolint: errcheck
+			return nil, fmt.Errorf("unable to set ownership of socket %s: %s", path, err)
+		}
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+func TestPeerCredentials_listenUnixSocket(t *testing.T) {
+
+	Convey("Given I have a temporary folder", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut-unix-socket")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "api.sock")
+
+		Convey("When I listen on a new socket with a mode", func() {
+
+			l, err := listenUnixSocket(path, 0600, -1, -1)
+			So(err, ShouldBeNil)
+			defer l.Close() // nolint
+
+			info, _ := os.Stat(path)
+
+			Convey("Then the socket should have the correct mode", func() {
+				So(info.Mode()&os.ModeSocket, ShouldNotEqual, 0)
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+			})
+		})
+
+		Convey("When I listen on a stale socket", func() {
+
+			l1, err := listenUnixSocket(path, 0, -1, -1)
+			So(err, ShouldBeNil)
+			l1.(*net.UnixListener).SetUnlinkOnClose(false)
+			l1.Close() // nolint
+
+			l2, err := listenUnixSocket(path, 0, -1, -1)
+
+			Convey("Then it should be replaced", func() {
+				So(err, ShouldBeNil)
+				So(l2, ShouldNotBeNil)
+				l2.Close() // nolint
+			})
+		})
+
+		Convey("When I listen on a path that is a regular file", func() {
+
+			So(ioutil.WriteFile(path, []byte("hello"), 0600), ShouldBeNil)
+
+			l, err := listenUnixSocket(path, 0, -1, -1)
+
+			Convey("Then I should get an error", func() {
+				So(l, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEndWith, "file exists and is not a socket")
+			})
+		})
+	})
+}
+
+func TestPeerCredentials_contextWithPeerCredentials(t *testing.T) {
+
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	Convey("Given I have a unix socket connection", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut-unix-socket")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		l, err := listenUnixSocket(filepath.Join(dir, "api.sock"), 0, -1, -1)
+		So(err, ShouldBeNil)
+		defer l.Close() // nolint
+
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			c, _ := l.Accept()
+			accepted <- c
+		}()
+
+		client, err := net.Dial("unix", l.Addr().String())
+		So(err, ShouldBeNil)
+		defer client.Close() // nolint
+
+		server := <-accepted
+		defer server.Close() // nolint
+
+		Convey("When I call contextWithPeerCredentials and set the request", func() {
+
+			ctx := contextWithPeerCredentials(context.Background(), server, zap.NewNop())
+			req := elemental.NewRequest()
+			setRequestPeerCredentials(ctx, req)
+
+			creds, ok := PeerCredentialsFromRequest(req)
+
+			Convey("Then the request should have the credentials of the current process", func() {
+				So(ok, ShouldBeTrue)
+				So(creds.UID, ShouldEqual, os.Getuid())
+				So(creds.GID, ShouldEqual, os.Getgid())
+				So(creds.PID, ShouldEqual, os.Getpid())
+			})
+		})
+	})
+
+	Convey("Given I have a tcp connection", t, func() {
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close() // nolint
+
+		go func() {
+			c, _ := l.Accept()
+			if c != nil {
+				c.Close() // nolint
+			}
+		}()
+
+		client, err := net.Dial("tcp", l.Addr().String())
+		So(err, ShouldBeNil)
+		defer client.Close() // nolint
+
+		Convey("When I call contextWithPeerCredentials and set the request", func() {
+
+			ctx := contextWithPeerCredentials(context.Background(), client, zap.NewNop())
+			req := elemental.NewRequest()
+			setRequestPeerCredentials(ctx, req)
+
+			_, ok := PeerCredentialsFromRequest(req)
+
+			Convey("Then the request should not have credentials", func() {
+				So(ok, ShouldBeFalse)
+				So(req.Metadata, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -12,6 +12,7 @@
 package bahamut
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -25,6 +26,9 @@ const (
 	PingStatusTimeout = "timeout"
 	// PingStatusError represents the status "error"
 	PingStatusError = "error"
+	// PingStatusUnknown represents the status "unknown", of a
+	// Pinger that has not been pinged yet by a HealthProber.
+	PingStatusUnknown = "unknown"
 )
 
 // A Pinger is an interface for objects that implements a Ping method
@@ -32,10 +36,53 @@ type Pinger interface {
 	Ping(timeout time.Duration) error
 }
 
-// RetrieveHealthStatus returns the status for each Pinger.
-func RetrieveHealthStatus(timeout time.Duration, pingers map[string]Pinger) error {
+// A PingResult is the result of the ping of a Pinger.
+type PingResult struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency"`
+	Error   string  `json:"error,omitempty"`
+
+	err      error
+	duration time.Duration
+}
+
+// Err returns the error returned by the Pinger, if any.
+func (r PingResult) Err() error {
+	return r.err
+}
+
+// RetrievePingResults pings all the given Pingers concurrently and returns
+// their results sorted by name. The latency is in milliseconds. The results
+// are logged using the global zap logger.
+func RetrievePingResults(timeout time.Duration, pingers map[string]Pinger) []PingResult {
+
+	return retrievePingResults(timeout, pingers, zap.L())
+}
 
-	var firstError error
+// retrievePingResults works like RetrievePingResults
+// but logs the results using the given logger.
+func retrievePingResults(timeout time.Duration, pingers map[string]Pinger, logger *zap.Logger) []PingResult {
+
+	results := pingAll(timeout, pingers)
+
+	for _, result := range results {
+		logger.Info("Ping",
+			zap.String("service", result.Name),
+			zap.String("status", result.Status),
+			zap.String("duration", result.duration.String()),
+			zap.Error(result.err),
+		)
+	}
+
+	return results
+}
+
+// pingAll pings all the given Pingers concurrently and
+// returns their results sorted by name.
+func pingAll(timeout time.Duration, pingers map[string]Pinger) []PingResult {
+
+	results := make([]PingResult, 0, len(pingers))
 
 	var wg sync.WaitGroup
 	wg.Add(len(pingers))
@@ -44,29 +91,54 @@ func RetrieveHealthStatus(timeout time.Duration, pingers map[string]Pinger) erro
 		go func(name string, pinger Pinger) {
 			defer wg.Done()
 
-			start := time.Now()
-			err := pinger.Ping(timeout)
-			status := stringifyStatus(err)
-			duration := time.Since(start)
-
-			zap.L().Info("Ping",
-				zap.String("service", name),
-				zap.String("status", status),
-				zap.String("duration", duration.String()),
-				zap.Error(err),
-			)
+			result := ping(name, pinger, timeout)
 
 			m.Lock()
-			if err != nil && firstError == nil {
-				firstError = err
-			}
+			results = append(results, result)
 			m.Unlock()
 		}(name, pinger)
 	}
 
 	wg.Wait()
 
-	return firstError
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	return results
+}
+
+// ping pings the given Pinger and returns its result.
+func ping(name string, pinger Pinger, timeout time.Duration) PingResult {
+
+	start := time.Now()
+	err := pinger.Ping(timeout)
+	duration := time.Since(start)
+
+	result := PingResult{
+		Name:     name,
+		Status:   stringifyStatus(err),
+		Latency:  duration.Seconds() * 1000,
+		err:      err,
+		duration: duration,
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// RetrieveHealthStatus pings all the given Pingers and returns the
+// error of the first one failing, in the order of their names.
+func RetrieveHealthStatus(timeout time.Duration, pingers map[string]Pinger) error {
+
+	for _, result := range RetrievePingResults(timeout, pingers) {
+		if result.err != nil {
+			return result.err
+		}
+	}
+
+	return nil
 }
 
 // stringify status output
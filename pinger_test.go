@@ -56,6 +56,40 @@ func Test_RetrieveHealthStatus(t *testing.T) {
 	})
 }
 
+func Test_RetrievePingResults(t *testing.T) {
+
+	Convey("Given the following pingers", t, func() {
+		pingers := map[string]Pinger{
+			"p3": MockPinger{PingStatus: fmt.Errorf("Another status")},
+			"p1": MockPinger{PingStatus: nil},
+			"p2": MockPinger{PingStatus: fmt.Errorf(PingStatusTimeout)},
+		}
+		results := RetrievePingResults(time.Second, pingers)
+
+		Convey("Then I should have the results sorted by name", func() {
+			So(len(results), ShouldEqual, 3)
+
+			So(results[0].Name, ShouldEqual, "p1")
+			So(results[0].Status, ShouldEqual, PingStatusOK)
+			So(results[0].Error, ShouldBeEmpty)
+			So(results[0].Err(), ShouldBeNil)
+
+			So(results[1].Name, ShouldEqual, "p2")
+			So(results[1].Status, ShouldEqual, PingStatusTimeout)
+			So(results[1].Error, ShouldEqual, PingStatusTimeout)
+
+			So(results[2].Name, ShouldEqual, "p3")
+			So(results[2].Status, ShouldEqual, PingStatusError)
+			So(results[2].Err().Error(), ShouldEqual, "Another status")
+			So(results[2].Latency, ShouldBeGreaterThanOrEqualTo, 0)
+		})
+
+		Convey("Then RetrieveHealthStatus should return the error of the first failing one", func() {
+			So(RetrieveHealthStatus(time.Second, pingers).Error(), ShouldEqual, PingStatusTimeout)
+		})
+	})
+}
+
 func Test_stringifyStatus(t *testing.T) {
 
 	Convey("Given the stringifyStatus method", t, func() {
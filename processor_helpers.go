@@ -21,6 +21,8 @@ import (
 //
 // If it is not authenticated it stops the normal processing execution flow, and will write the Unauthorized response to the given writer.
 // If not Authenticator is set, then it will always return true.
+// The decision of each authenticator is recorded in the Context and
+// can be retrieved using Context.AuthDecisions().
 //
 // This is mostly used by autogenerated code, and you should not need to use it manually.
 func CheckAuthentication(authenticators []RequestAuthenticator, ctx Context) (err error) {
@@ -33,6 +35,7 @@ func CheckAuthentication(authenticators []RequestAuthenticator, ctx Context) (er
 	for _, authenticator := range authenticators {
 
 		action, err = authenticator.AuthenticateRequest(ctx)
+		recordAuthDecision(ctx, AuthStageAuthentication, authenticator, action, err)
 		if err != nil {
 			return err
 		}
@@ -54,6 +57,8 @@ func CheckAuthentication(authenticators []RequestAuthenticator, ctx Context) (er
 //
 // If it is not authorized it stops the normal processing execution flow, and will write the Unauthorized response to the given writer.
 // If not Authorizer is set, then it will always return true.
+// The decision of each authorizer is recorded in the Context and
+// can be retrieved using Context.AuthDecisions().
 //
 // This is mostly used by autogenerated code, and you should not need to use it manually.
 func CheckAuthorization(authorizers []Authorizer, ctx Context) (err error) {
@@ -66,6 +71,7 @@ func CheckAuthorization(authorizers []Authorizer, ctx Context) (err error) {
 	for _, authorizer := range authorizers {
 
 		action, err = authorizer.IsAuthorized(ctx)
+		recordAuthDecision(ctx, AuthStageAuthorization, authorizer, action, err)
 		if err != nil {
 			return err
 		}
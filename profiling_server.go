@@ -50,7 +50,7 @@ func (s *profilingServer) start(ctx context.Context) {
 			ServiceVersion: s.cfg.meta.serviceVersion,
 			ProjectID:      s.cfg.profilingServer.gcpProjectID,
 		}); err != nil {
-			zap.L().Fatal("Unable to start gcp profile server", zap.Error(err))
+			s.cfg.logger().Fatal("Unable to start gcp profile server", zap.Error(err))
 		}
 
 		projectID := s.cfg.profilingServer.gcpProjectID
@@ -58,7 +58,7 @@ func (s *profilingServer) start(ctx context.Context) {
 			projectID = "auto"
 		}
 
-		zap.L().Info("GCP profiler started",
+		s.cfg.logger().Info("GCP profiler started",
 			zap.String("service", name),
 			zap.String("project-id", projectID),
 		)
@@ -81,11 +81,11 @@ func (s *profilingServer) start(ctx context.Context) {
 				if err == http.ErrServerClosed {
 					return
 				}
-				zap.L().Fatal("Unable to start profiling server", zap.Error(err))
+				s.cfg.logger().Fatal("Unable to start profiling server", zap.Error(err))
 			}
 		}()
 
-		zap.L().Info("Profiler profiler started", zap.String("address", s.cfg.profilingServer.listenAddress))
+		s.cfg.logger().Info("Profiler profiler started", zap.String("address", s.cfg.profilingServer.listenAddress))
 	}
 
 	<-ctx.Done()
@@ -103,11 +103,11 @@ func (s *profilingServer) stop() {
 	go func() {
 		defer cancel()
 		if err := s.server.Shutdown(ctx); err != nil {
-			zap.L().Error("Could not gracefully stop profiling server", zap.Error(err))
+			s.cfg.logger().Error("Could not gracefully stop profiling server", zap.Error(err))
 		} else {
-			zap.L().Debug("Profiling server stopped")
+			s.cfg.logger().Debug("Profiling server stopped")
 		}
 	}()
 
-	zap.L().Debug("Profile server stopped")
+	s.cfg.logger().Debug("Profile server stopped")
 }
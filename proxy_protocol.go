@@ -0,0 +1,260 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	proxyProtocolHeaderTimeout = 5 * time.Second
+	proxyProtocolV1MaxLength   = 107
+)
+
+var proxyProtocolV1Signature = []byte("PROXY ")
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// a proxyProtocolListener is a net.Listener that reads
+// the PROXY protocol v1 or v2 header sent by the trusted
+// load balancers and exposes the original client address as the
+// remote address of the connections. If no load balancers are
+// trusted, the headers are never read.
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func newProxyProtocolListener(listener net.Listener, trusted []*net.IPNet) net.Listener {
+
+	return &proxyProtocolListener{
+		Listener: listener,
+		trusted:  trusted,
+	}
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ipInNetworks(hostIP(conn.RemoteAddr().String()), l.trusted) {
+		return conn, nil
+	}
+
+	return &proxyProtocolConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}, nil
+}
+
+// a proxyProtocolConn is a net.Conn that lazily parses
+// the PROXY protocol header on first use.
+type proxyProtocolConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	err        error
+	once       sync.Once
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+
+	c.once.Do(c.readHeader)
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+
+	c.once.Do(c.readHeader)
+
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+
+	c.once.Do(c.readHeader)
+
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// NetConn returns the underlying net.Conn.
+func (c *proxyProtocolConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *proxyProtocolConn) readHeader() {
+
+	c.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout)) // nolint: errcheck
+	defer c.Conn.SetReadDeadline(time.Time{})                          // nolint: errcheck
+
+	c.remoteAddr, c.localAddr, c.err = readProxyProtocolHeader(c.reader)
+}
+
+// readProxyProtocolHeader reads a PROXY protocol v1 or v2 header from the
+// given reader. If the data does not start with a PROXY protocol
+// signature, nothing is consumed and the returned addresses are nil.
+func readProxyProtocolHeader(r *bufio.Reader) (remote net.Addr, local net.Addr, err error) {
+
+	sig, err := r.Peek(len(proxyProtocolV1Signature))
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if bytes.Equal(sig, proxyProtocolV1Signature) {
+		return readProxyProtocolV1(r)
+	}
+
+	if !bytes.HasPrefix(proxyProtocolV2Signature, sig) {
+		return nil, nil, nil
+	}
+
+	sig, err = r.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(sig, proxyProtocolV2Signature) {
+		return nil, nil, nil
+	}
+
+	return readProxyProtocolV2(r)
+}
+
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLength {
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read proxy protocol header: %s", err)
+		}
+
+		line = append(line, b)
+
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("invalid proxy protocol header: header too long")
+	}
+
+	parts := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid proxy protocol header: '%s'", strings.TrimSpace(string(line)))
+	}
+
+	srcIP := net.ParseIP(parts[2])
+	dstIP := net.ParseIP(parts[3])
+	if srcIP == nil || dstIP == nil {
+		return nil, nil, fmt.Errorf("invalid proxy protocol header: invalid address in '%s'", strings.TrimSpace(string(line)))
+	}
+
+	srcPort, err1 := strconv.ParseUint(parts[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(parts[5], 10, 16)
+	if err1 != nil || err2 != nil {
+		return nil, nil, fmt.Errorf("invalid proxy protocol header: invalid port in '%s'", strings.TrimSpace(string(line)))
+	}
+
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("unable to read proxy protocol header: %s", err)
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("invalid proxy protocol header: unsupported version %d", header[12]>>4)
+	}
+
+	command := header[12] & 0x0F
+	family := header[13] >> 4
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("unable to read proxy protocol addresses: %s", err)
+	}
+
+	switch command {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("invalid proxy protocol header: unsupported command %d", command)
+	}
+
+	switch family {
+
+	case 0x1: // AF_INET
+		if length < 12 {
+			return nil, nil, errors.New("invalid proxy protocol header: address block too short")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))},
+			&net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))},
+			nil
+
+	case 0x2: // AF_INET6
+		if length < 36 {
+			return nil, nil, errors.New("invalid proxy protocol header: address block too short")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))},
+			&net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))},
+			nil
+
+	default:
+		// AF_UNSPEC and AF_UNIX: keep the connection addresses.
+		return nil, nil, nil
+	}
+}
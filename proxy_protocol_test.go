@@ -0,0 +1,297 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut/internal/cidrs"
+)
+
+func makeProxyProtocolV2Header(command byte, family byte, addresses []byte) []byte {
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(proxyProtocolV2Signature)
+	buf.WriteByte(0x20 | command)
+	buf.WriteByte(family<<4 | 0x1)
+	binary.Write(buf, binary.BigEndian, uint16(len(addresses))) // nolint: errcheck
+	buf.Write(addresses)
+
+	return buf.Bytes()
+}
+
+func TestProxyProtocol_readProxyProtocolHeader(t *testing.T) {
+
+	Convey("Given I have a v1 TCP4 header", t, func() {
+
+		r := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+
+		Convey("When I read the header", func() {
+
+			remote, local, err := readProxyProtocolHeader(r)
+			rest, _ := ioutil.ReadAll(r)
+
+			Convey("Then the addresses should be correct", func() {
+				So(err, ShouldBeNil)
+				So(remote.String(), ShouldEqual, "192.168.0.1:56324")
+				So(local.String(), ShouldEqual, "10.0.0.1:443")
+				So(string(rest), ShouldEqual, "GET / HTTP/1.1\r\n")
+			})
+		})
+	})
+
+	Convey("Given I have a v1 TCP6 header", t, func() {
+
+		r := bufio.NewReader(bytes.NewBufferString("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"))
+
+		Convey("When I read the header", func() {
+
+			remote, local, err := readProxyProtocolHeader(r)
+
+			Convey("Then the addresses should be correct", func() {
+				So(err, ShouldBeNil)
+				So(remote.String(), ShouldEqual, "[2001:db8::1]:56324")
+				So(local.String(), ShouldEqual, "[2001:db8::2]:443")
+			})
+		})
+	})
+
+	Convey("Given I have a v1 UNKNOWN header", t, func() {
+
+		r := bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN\r\nhello"))
+
+		Convey("When I read the header", func() {
+
+			remote, local, err := readProxyProtocolHeader(r)
+			rest, _ := ioutil.ReadAll(r)
+
+			Convey("Then the addresses should be nil", func() {
+				So(err, ShouldBeNil)
+				So(remote, ShouldBeNil)
+				So(local, ShouldBeNil)
+				So(string(rest), ShouldEqual, "hello")
+			})
+		})
+	})
+
+	Convey("Given I have invalid v1 headers", t, func() {
+
+		for _, h := range []string{
+			"PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n",
+			"PROXY UDP4 192.168.0.1 10.0.0.1 56324 443\r\n",
+			"PROXY TCP4 not-an-ip 10.0.0.1 56324 443\r\n",
+			"PROXY TCP4 192.168.0.1 10.0.0.1 99999 443\r\n",
+			"PROXY TCP4 " + string(bytes.Repeat([]byte("1"), 200)),
+		} {
+
+			_, _, err := readProxyProtocolHeader(bufio.NewReader(bytes.NewBufferString(h)))
+
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Given I have a v2 IPv4 header", t, func() {
+
+		addrs := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xDC, 0x04, 0x01, 0xBB}
+		data := append(makeProxyProtocolV2Header(0x1, 0x1, addrs), []byte("hello")...)
+		r := bufio.NewReader(bytes.NewBuffer(data))
+
+		Convey("When I read the header", func() {
+
+			remote, local, err := readProxyProtocolHeader(r)
+			rest, _ := ioutil.ReadAll(r)
+
+			Convey("Then the addresses should be correct", func() {
+				So(err, ShouldBeNil)
+				So(remote.String(), ShouldEqual, "192.168.0.1:56324")
+				So(local.String(), ShouldEqual, "10.0.0.1:443")
+				So(string(rest), ShouldEqual, "hello")
+			})
+		})
+	})
+
+	Convey("Given I have a v2 IPv6 header", t, func() {
+
+		addrs := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0xDC, 0x04, 0x01, 0xBB)
+		r := bufio.NewReader(bytes.NewBuffer(makeProxyProtocolV2Header(0x1, 0x2, addrs)))
+
+		Convey("When I read the header", func() {
+
+			remote, local, err := readProxyProtocolHeader(r)
+
+			Convey("Then the addresses should be correct", func() {
+				So(err, ShouldBeNil)
+				So(remote.String(), ShouldEqual, "[2001:db8::1]:56324")
+				So(local.String(), ShouldEqual, "[2001:db8::2]:443")
+			})
+		})
+	})
+
+	Convey("Given I have a v2 LOCAL header", t, func() {
+
+		r := bufio.NewReader(bytes.NewBuffer(append(makeProxyProtocolV2Header(0x0, 0x0, nil), []byte("hello")...)))
+
+		Convey("When I read the header", func() {
+
+			remote, local, err := readProxyProtocolHeader(r)
+			rest, _ := ioutil.ReadAll(r)
+
+			Convey("Then the addresses should be nil", func() {
+				So(err, ShouldBeNil)
+				So(remote, ShouldBeNil)
+				So(local, ShouldBeNil)
+				So(string(rest), ShouldEqual, "hello")
+			})
+		})
+	})
+
+	Convey("Given I have a v2 header with a truncated address block", t, func() {
+
+		r := bufio.NewReader(bytes.NewBuffer(makeProxyProtocolV2Header(0x1, 0x1, []byte{1, 2, 3})))
+
+		Convey("When I read the header", func() {
+
+			_, _, err := readProxyProtocolHeader(r)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "invalid proxy protocol header: address block too short")
+			})
+		})
+	})
+
+	Convey("Given I have data without header", t, func() {
+
+		r := bufio.NewReader(bytes.NewBufferString("GET / HTTP/1.1\r\n"))
+
+		Convey("When I read the header", func() {
+
+			remote, local, err := readProxyProtocolHeader(r)
+			rest, _ := ioutil.ReadAll(r)
+
+			Convey("Then nothing should be consumed", func() {
+				So(err, ShouldBeNil)
+				So(remote, ShouldBeNil)
+				So(local, ShouldBeNil)
+				So(string(rest), ShouldEqual, "GET / HTTP/1.1\r\n")
+			})
+		})
+	})
+}
+
+func TestProxyProtocol_listener(t *testing.T) {
+
+	Convey("Given I have a proxy protocol listener", t, func() {
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+
+		trusted, _ := cidrs.Parse([]string{"127.0.0.0/8"})
+		pl := newProxyProtocolListener(l, trusted)
+		defer pl.Close() // nolint
+
+		Convey("When I connect and send a header", func() {
+
+			go func() {
+				c, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					return
+				}
+				c.Write([]byte("PROXY TCP4 203.0.113.4 10.0.0.1 56324 443\r\nhello")) // nolint: errcheck
+				c.Close()                                                             // nolint: errcheck
+			}()
+
+			conn, err := pl.Accept()
+			So(err, ShouldBeNil)
+			defer conn.Close() // nolint
+
+			data, _ := ioutil.ReadAll(conn)
+
+			Convey("Then the remote address should be the one from the header", func() {
+				So(conn.RemoteAddr().String(), ShouldEqual, "203.0.113.4:56324")
+				So(conn.LocalAddr().String(), ShouldEqual, "10.0.0.1:443")
+				So(string(data), ShouldEqual, "hello")
+			})
+		})
+	})
+
+	Convey("Given I have a proxy protocol listener that does not trust the client", t, func() {
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+
+		trusted, _ := cidrs.Parse([]string{"10.0.0.0/8"})
+		pl := newProxyProtocolListener(l, trusted)
+		defer pl.Close() // nolint
+
+		Convey("When I connect and send a header", func() {
+
+			go func() {
+				c, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					return
+				}
+				c.Write([]byte("PROXY TCP4 203.0.113.4 10.0.0.1 56324 443\r\n")) // nolint: errcheck
+				c.Close()                                                        // nolint: errcheck
+			}()
+
+			conn, err := pl.Accept()
+			So(err, ShouldBeNil)
+			defer conn.Close() // nolint
+
+			data, _ := ioutil.ReadAll(conn)
+
+			Convey("Then the header should be ignored", func() {
+				So(hostIP(conn.RemoteAddr().String()), ShouldEqual, "127.0.0.1")
+				So(string(data), ShouldEqual, "PROXY TCP4 203.0.113.4 10.0.0.1 56324 443\r\n")
+			})
+		})
+	})
+
+	Convey("Given I have a proxy protocol listener without trusted proxies", t, func() {
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+
+		pl := newProxyProtocolListener(l, nil)
+		defer pl.Close() // nolint
+
+		Convey("When I connect and send a header", func() {
+
+			go func() {
+				c, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					return
+				}
+				c.Write([]byte("PROXY TCP4 203.0.113.4 10.0.0.1 56324 443\r\n")) // nolint: errcheck
+				c.Close()                                                        // nolint: errcheck
+			}()
+
+			conn, err := pl.Accept()
+			So(err, ShouldBeNil)
+			defer conn.Close() // nolint
+
+			data, _ := ioutil.ReadAll(conn)
+
+			Convey("Then the header should be ignored", func() {
+				So(hostIP(conn.RemoteAddr().String()), ShouldEqual, "127.0.0.1")
+				So(string(data), ShouldEqual, "PROXY TCP4 203.0.113.4 10.0.0.1 56324 443\r\n")
+			})
+		})
+	})
+}
@@ -12,10 +12,17 @@
 package bahamut
 
 import (
+	"context"
+	"strconv"
+
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
 	"go.aporeto.io/elemental"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Publication is a structure that can be published to a PublishServer.
@@ -26,8 +33,11 @@ type Publication struct {
 	TrackingName string                     `msgpack:"trackingName,omitempty" json:"trackingName,omitempty"`
 	TrackingData opentracing.TextMapCarrier `msgpack:"trackingData,omitempty" json:"trackingData,omitempty"`
 	Encoding     elemental.EncodingType     `msgpack:"encoding,omitempty" json:"encoding,omitempty"`
+	RequestID    string                     `msgpack:"requestID,omitempty" json:"requestID,omitempty"`
+	Changes      AttributeChanges           `msgpack:"changes,omitempty" json:"changes,omitempty"`
 
-	span opentracing.Span
+	span     opentracing.Span
+	otelSpan trace.Span
 }
 
 // NewPublication returns a new Publication.
@@ -55,9 +65,7 @@ func (p *Publication) EncodeWithEncoding(o interface{}, encoding elemental.Encod
 	p.Data = data
 	p.Encoding = encoding
 
-	if p.span != nil {
-		p.span.LogFields(log.Object("payload", string(p.Data)))
-	}
+	p.tracePayload()
 
 	return nil
 }
@@ -65,9 +73,7 @@ func (p *Publication) EncodeWithEncoding(o interface{}, encoding elemental.Encod
 // Decode decodes the data into the given dest.
 func (p *Publication) Decode(dest interface{}) error {
 
-	if p.span != nil {
-		p.span.LogFields(log.Object("payload", string(p.Data)))
-	}
+	p.tracePayload()
 
 	return elemental.Decode(p.Encoding, p.Data, dest)
 }
@@ -80,7 +86,7 @@ func (p *Publication) StartTracingFromSpan(span opentracing.Span, name string) e
 		return nil
 	}
 
-	p.span = opentracing.StartSpan(name, opentracing.ChildOf(span.Context()))
+	p.span = tracer.StartSpan(name, opentracing.ChildOf(span.Context()))
 	p.span.SetTag("topic", p.Topic)
 	p.span.SetTag("partition", p.Partition)
 
@@ -96,12 +102,65 @@ func (p *Publication) StartTracing(tracer opentracing.Tracer, name string) {
 
 	wireContext, _ := tracer.Extract(opentracing.TextMap, p.TrackingData)
 
-	p.span = opentracing.StartSpan(name, ext.RPCServerOption(wireContext))
+	p.span = tracer.StartSpan(name, ext.RPCServerOption(wireContext))
 	p.span.SetTag("topic", p.Topic)
 	p.span.SetTag("partition", p.Partition)
 
 }
 
+// StartOpenTelemetrySpan starts a new OpenTelemetry producer span, child of the
+// span found in the given context, and writes its trace context in the
+// TrackingData as W3C Trace Context headers. It returns a copy of the given
+// context holding the new span.
+func (p *Publication) StartOpenTelemetrySpan(ctx context.Context, tracer trace.Tracer, name string) context.Context {
+
+	if tracer == nil {
+		return ctx
+	}
+
+	ctx, p.otelSpan = tracer.Start(
+		ctx,
+		name,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(p.otelAttributes(semconv.MessagingOperationTypePublish)...),
+	)
+
+	if p.TrackingData == nil {
+		p.TrackingData = opentracing.TextMapCarrier{}
+	}
+
+	otelPropagator.Inject(ctx, propagation.MapCarrier(p.TrackingData))
+
+	return ctx
+}
+
+// StartOpenTelemetryTracing starts a new OpenTelemetry consumer span using
+// the W3C Trace Context headers found in the TrackingData, if any, as remote
+// parent. It returns a copy of the given context holding the new span.
+func (p *Publication) StartOpenTelemetryTracing(ctx context.Context, tracer trace.Tracer, name string) context.Context {
+
+	if tracer == nil {
+		return ctx
+	}
+
+	ctx = otelPropagator.Extract(ctx, propagation.MapCarrier(p.TrackingData))
+
+	ctx, p.otelSpan = tracer.Start(
+		ctx,
+		name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(p.otelAttributes(semconv.MessagingOperationTypeDeliver)...),
+	)
+
+	return ctx
+}
+
+// OpenTelemetrySpan returns the current OpenTelemetry span, if any.
+func (p *Publication) OpenTelemetrySpan() trace.Span {
+
+	return p.otelSpan
+}
+
 // Span returns the current tracking span.
 func (p *Publication) Span() opentracing.Span {
 
@@ -117,7 +176,29 @@ func (p *Publication) Duplicate() *Publication {
 	pub.TrackingName = p.TrackingName
 	pub.TrackingData = p.TrackingData
 	pub.span = p.span
+	pub.otelSpan = p.otelSpan
 	pub.Encoding = p.Encoding
+	pub.RequestID = p.RequestID
 
 	return pub
 }
+
+func (p *Publication) otelAttributes(operation attribute.KeyValue) []attribute.KeyValue {
+
+	return []attribute.KeyValue{
+		operation,
+		semconv.MessagingDestinationName(p.Topic),
+		semconv.MessagingDestinationPartitionID(strconv.Itoa(int(p.Partition))),
+	}
+}
+
+func (p *Publication) tracePayload() {
+
+	if p.span != nil {
+		p.span.LogFields(log.Object("payload", string(p.Data)))
+	}
+
+	if p.otelSpan != nil {
+		p.otelSpan.AddEvent("payload", trace.WithAttributes(attribute.String("payload", string(p.Data))))
+	}
+}
@@ -14,6 +14,7 @@ package bahamut
 import (
 	"testing"
 
+	"github.com/opentracing/opentracing-go/mocktracer"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
 	testmodel "go.aporeto.io/elemental/test/model"
@@ -192,6 +193,39 @@ func TestPublicationTracing(t *testing.T) {
 	})
 }
 
+func TestPublication_TracingUsesGivenTracer(t *testing.T) {
+
+	Convey("Given I have a mock tracer that is not the global tracer", t, func() {
+
+		tracer := mocktracer.New()
+
+		Convey("When I call StartTracingFromSpan", func() {
+
+			parent := tracer.StartSpan("parent")
+			publication := NewPublication("topic")
+			err := publication.StartTracingFromSpan(parent, "test")
+
+			Convey("Then the span should come from the tracer of the parent", func() {
+				So(err, ShouldBeNil)
+				span, ok := publication.Span().(*mocktracer.MockSpan)
+				So(ok, ShouldBeTrue)
+				So(span.ParentID, ShouldEqual, parent.Context().(mocktracer.MockSpanContext).SpanID)
+			})
+		})
+
+		Convey("When I call StartTracing", func() {
+
+			publication := NewPublication("topic")
+			publication.StartTracing(tracer, "test")
+
+			Convey("Then the span should come from the given tracer", func() {
+				_, ok := publication.Span().(*mocktracer.MockSpan)
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+}
+
 func TestPublication_Duplicate(t *testing.T) {
 
 	Convey("Given I have a publication", t, func() {
@@ -200,6 +234,7 @@ func TestPublication_Duplicate(t *testing.T) {
 		pub.Data = []byte("data")
 		pub.Partition = 12
 		pub.TrackingName = "TrackingName"
+		pub.RequestID = "rid"
 
 		Convey("When I call duplicate", func() {
 
@@ -212,6 +247,7 @@ func TestPublication_Duplicate(t *testing.T) {
 				So(dup.TrackingName, ShouldEqual, pub.TrackingName)
 				So(dup.Topic, ShouldEqual, pub.Topic)
 				So(dup.Encoding, ShouldEqual, pub.Encoding)
+				So(dup.RequestID, ShouldEqual, pub.RequestID)
 			})
 		})
 	})
@@ -34,6 +34,7 @@ type natsPubSub struct {
 	password       string
 	username       string
 	tlsConfig      *tls.Config
+	logger         *zap.Logger
 }
 
 // NewNATSPubSubClient returns a new PubSubClient backend by Nats.
@@ -46,6 +47,7 @@ func NewNATSPubSubClient(natsURL string, options ...NATSOption) PubSubClient {
 		retryNumber:    5,
 		clientID:       uuid.Must(uuid.NewV4()).String(),
 		clusterID:      "test-cluster",
+		logger:         zap.L(),
 	}
 
 	for _, opt := range options {
@@ -97,7 +99,7 @@ func (p *natsPubSub) Subscribe(pubs chan *Publication, errors chan error, topic
 		publication := NewPublication(topic)
 
 		if e := elemental.Decode(elemental.EncodingTypeMSGPACK, m.Data, publication); e != nil {
-			zap.L().Error("Unable to decode publication envelope. Message dropped.", zap.Error(e))
+			p.logger.Error("Unable to decode publication envelope. Message dropped.", zap.Error(e))
 			return
 		}
 
@@ -109,7 +111,7 @@ func (p *natsPubSub) Subscribe(pubs chan *Publication, errors chan error, topic
 			}
 
 			if err := p.client.Publish(m.Reply, resp); err != nil {
-				zap.L().Error("Unable to send requested reply", zap.Error(err))
+				p.logger.Error("Unable to send requested reply", zap.Error(err))
 				return
 			}
 		}
@@ -153,7 +155,7 @@ func (p *natsPubSub) Connect() Waiter {
 				break
 			}
 
-			zap.L().Warn("Unable to connect to nats cluster. Retrying",
+			p.logger.Warn("Unable to connect to nats cluster. Retrying",
 				zap.String("url", p.natsURL),
 				zap.Duration("retry", p.retryInterval),
 				zap.Error(err),
@@ -18,6 +18,7 @@ import (
 	"fmt"
 
 	nats "github.com/nats-io/go-nats"
+	"go.uber.org/zap"
 )
 
 // A NATSOption represents an option to the pubsub backed by nats
@@ -52,6 +53,13 @@ func NATSOptTLS(tlsConfig *tls.Config) NATSOption {
 	}
 }
 
+// NATSOptLogger sets the logger to use. By default, the global zap logger is used.
+func NATSOptLogger(logger *zap.Logger) NATSOption {
+	return func(n *natsPubSub) {
+		n.logger = logger
+	}
+}
+
 var ackMessage = []byte("ack")
 
 type natsSubscribeConfig struct {
@@ -16,6 +16,7 @@ import (
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
 )
 
 func TestNats_NewPubSubServer(t *testing.T) {
@@ -31,12 +32,14 @@ func TestNats_NewPubSubServer(t *testing.T) {
 			So(ps.username, ShouldEqual, "")
 			So(ps.password, ShouldEqual, "")
 			So(ps.tlsConfig, ShouldEqual, nil)
+			So(ps.logger, ShouldEqual, zap.L())
 		})
 	})
 
 	Convey("Given I create a new PubSubServer with all options", t, func() {
 
 		tlsconfig := &tls.Config{}
+		logger := zap.NewNop()
 
 		ps := NewNATSPubSubClient(
 			"nats://localhost:4222",
@@ -44,6 +47,7 @@ func TestNats_NewPubSubServer(t *testing.T) {
 			NATSOptClientID("id"),
 			NATSOptCredentials("username", "password"),
 			NATSOptTLS(tlsconfig),
+			NATSOptLogger(logger),
 		).(*natsPubSub)
 
 		Convey("Then the PubSubServer should be correctly initialized", func() {
@@ -53,6 +57,7 @@ func TestNats_NewPubSubServer(t *testing.T) {
 			So(ps.username, ShouldEqual, "username")
 			So(ps.password, ShouldEqual, "password")
 			So(ps.tlsConfig, ShouldEqual, tlsconfig)
+			So(ps.logger, ShouldEqual, logger)
 		})
 	})
 }
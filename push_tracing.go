@@ -0,0 +1,285 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"go.aporeto.io/elemental"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Various span names of the push pipeline.
+const (
+	pushPublishSpanName  = "bahamut.push.publish"
+	pushReceiveSpanName  = "bahamut.push.receive"
+	pushDispatchSpanName = "bahamut.push.dispatch"
+)
+
+// pushTracingSampling holds the sampling rates of the push pipeline.
+type pushTracingSampling struct {
+	publish  float64
+	dispatch float64
+}
+
+// A pushSpan traces a step of the push pipeline with the
+// OpenTracing span and the OpenTelemetry span it holds, if any.
+// The zero value is a valid pushSpan that does nothing.
+type pushSpan struct {
+	ot   opentracing.Span
+	otel trace.Span
+}
+
+func (s pushSpan) isZero() bool {
+	return s.ot == nil && s.otel == nil
+}
+
+func (s pushSpan) setTag(key string, value interface{}) {
+
+	if s.ot != nil {
+		s.ot.SetTag(key, value)
+	}
+
+	if s.otel != nil {
+		s.otel.SetAttributes(otelAttributeOf(key, value))
+	}
+}
+
+func (s pushSpan) setError(err error) {
+
+	if s.ot != nil {
+		ext.Error.Set(s.ot, true)
+		s.ot.SetTag("error.message", err.Error())
+	}
+
+	if s.otel != nil {
+		s.otel.RecordError(err)
+		s.otel.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// logPayload logs the given payload in the span.
+func (s pushSpan) logPayload(payload []byte) {
+
+	if s.ot != nil {
+		s.ot.LogFields(log.Object("payload", string(payload)))
+	}
+
+	if s.otel != nil {
+		s.otel.AddEvent("payload", trace.WithAttributes(attribute.String("payload", string(payload))))
+	}
+}
+
+func (s pushSpan) finish() {
+	s.finishAt(time.Now())
+}
+
+func (s pushSpan) finishAt(t time.Time) {
+
+	if s.ot != nil {
+		s.ot.FinishWithOptions(opentracing.FinishOptions{FinishTime: t})
+	}
+
+	if s.otel != nil {
+		s.otel.End(trace.WithTimestamp(t))
+	}
+}
+
+// child starts a new pushSpan child of the receiver.
+func (s pushSpan) child(name string) pushSpan {
+
+	var c pushSpan
+
+	if s.ot != nil {
+		c.ot = s.ot.Tracer().StartSpan(name, opentracing.ChildOf(s.ot.Context()))
+	}
+
+	if s.otel != nil {
+		_, c.otel = s.otel.TracerProvider().Tracer(otelInstrumentationName).Start(
+			trace.ContextWithSpan(context.Background(), s.otel),
+			name,
+			trace.WithSpanKind(trace.SpanKindInternal),
+		)
+	}
+
+	return c
+}
+
+func otelAttributeOf(key string, value interface{}) attribute.KeyValue {
+
+	switch v := value.(type) {
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case string:
+		return attribute.String(key, v)
+	case time.Duration:
+		return attribute.Float64(key, v.Seconds()*1000)
+	default:
+		return attribute.String(key, fmt.Sprintf("%v", v))
+	}
+}
+
+// A pushTracer starts the spans of the push pipeline.
+type pushTracer struct {
+	otTracer   opentracing.Tracer
+	otelTracer trace.Tracer
+	sampling   pushTracingSampling
+	random     func() float64
+	redactor   *redactor
+	cleaner    TraceCleaner
+}
+
+func newPushTracer(cfg config) *pushTracer {
+
+	sampling := pushTracingSampling{publish: 1, dispatch: 1}
+	if cfg.pushServer.tracingSampling != nil {
+		sampling = *cfg.pushServer.tracingSampling
+	}
+
+	return &pushTracer{
+		otTracer:   cfg.opentracing.tracer,
+		otelTracer: cfg.opentelemetry.tracer,
+		sampling:   sampling,
+		random:     rand.Float64,
+		redactor:   cfg.redaction.redactor,
+		cleaner:    cfg.opentracing.traceCleaner,
+	}
+}
+
+func (t *pushTracer) sample(rate float64) bool {
+	return rate >= 1 || t.random() < rate
+}
+
+// startPublish starts the span of the publication of the given event, child
+// of the span of the request found in the given context, if any. The trace
+// context is written in the tracking data of the publication, unless the
+// trace may still be dropped by the tail-based sampling.
+func (t *pushTracer) startPublish(ctx context.Context, publication *Publication, event *elemental.Event) pushSpan {
+
+	var s pushSpan
+
+	parent := opentracing.SpanFromContext(ctx)
+	hasOTelParent := t.otelTracer != nil && trace.SpanContextFromContext(ctx).IsValid()
+
+	if (parent == nil && !hasOTelParent) || !t.sample(t.sampling.publish) {
+		return s
+	}
+
+	if parent != nil {
+		if err := publication.StartTracingFromSpan(parent, pushPublishSpanName); err == nil {
+			s.ot = publication.Span()
+		}
+	}
+
+	if hasOTelParent {
+		publication.StartOpenTelemetrySpan(ctx, t.otelTracer, pushPublishSpanName)
+		s.otel = publication.OpenTelemetrySpan()
+	}
+
+	// The trace of the request may still be dropped, so the
+	// receiving servers must not continue it.
+	if !traceKept(ctx) {
+		publication.TrackingData = opentracing.TextMapCarrier{}
+	}
+
+	s.setTag("event.identity", event.Identity)
+	s.setTag("event.type", string(event.Type))
+
+	return s
+}
+
+// startReceive starts the span of the reception of the given publication,
+// continuing the trace found in its tracking data. If the publication
+// carries no trace context, it returns a zero pushSpan.
+func (t *pushTracer) startReceive(publication *Publication) pushSpan {
+
+	var s pushSpan
+
+	if len(publication.TrackingData) == 0 {
+		return s
+	}
+
+	if t.otTracer != nil {
+		if _, err := t.otTracer.Extract(opentracing.TextMap, publication.TrackingData); err == nil {
+			publication.StartTracing(t.otTracer, pushReceiveSpanName)
+			s.ot = publication.Span()
+		}
+	}
+
+	if t.otelTracer != nil {
+		ctx := otelPropagator.Extract(context.Background(), propagation.MapCarrier(publication.TrackingData))
+		if trace.SpanContextFromContext(ctx).IsValid() {
+			publication.StartOpenTelemetryTracing(context.Background(), t.otelTracer, pushReceiveSpanName)
+			s.otel = publication.OpenTelemetrySpan()
+		}
+	}
+
+	return s
+}
+
+// logPayload logs the payload of the given publication, holding the given
+// event, in the given span, after redaction and cleaning.
+func (t *pushTracer) logPayload(span pushSpan, publication *Publication, event *elemental.Event) {
+
+	if span.isZero() {
+		return
+	}
+
+	identity := elemental.MakeIdentity(event.Identity, "")
+
+	// The configured TraceCleaner gets the event in its
+	// original encoding, otherwise it is logged as JSON.
+	outEncoding := elemental.EncodingTypeJSON
+	if t.cleaner != nil {
+		outEncoding = publication.Encoding
+	}
+
+	data := publication.Data
+	if t.redactor != nil {
+		data = t.redactor.redactEvent(identity, publication.Encoding, data, outEncoding)
+	}
+
+	if t.cleaner != nil {
+		data = t.cleaner(identity, data)
+	}
+
+	span.logPayload(data)
+}
+
+// startDispatch starts the span of the dispatch of an event to
+// the given session, child of the given receive span, if sampled.
+func (t *pushTracer) startDispatch(parent pushSpan, session PushSession) pushSpan {
+
+	if parent.isZero() || !t.sample(t.sampling.dispatch) {
+		return pushSpan{}
+	}
+
+	s := parent.child(pushDispatchSpanName)
+	s.setTag("session.id", session.Identifier())
+
+	return s
+}
@@ -0,0 +1,358 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/wsc"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPushTracing_pushEvents(t *testing.T) {
+
+	Convey("Given I have a push server with an OpenTelemetry tracer", t, func() {
+
+		tracer, exporter := newTestTracer()
+		pubsub := &mockPubSubServer{}
+
+		cfg := config{}
+		cfg.pushServer.service = pubsub
+		cfg.pushServer.enabled = true
+		cfg.opentelemetry.tracer = tracer
+
+		srv := newPushServer(cfg, nil, nil)
+		evt := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+
+		Convey("When I push an event while handling a traced request", func() {
+
+			ctx, parent := tracer.Start(context.Background(), "request")
+			srv.pushEvents(ctx, evt)
+			parent.End()
+
+			spans := exporter.GetSpans()
+
+			Convey("Then the publish span should be a child of the request span", func() {
+				So(len(spans), ShouldEqual, 2)
+				So(spans[0].Name, ShouldEqual, pushPublishSpanName)
+				So(spans[0].SpanKind, ShouldEqual, trace.SpanKindProducer)
+				So(spans[0].Parent.SpanID(), ShouldEqual, parent.SpanContext().SpanID())
+				So(otelAttribute(spans[0], "event.type").AsString(), ShouldEqual, "create")
+				So(otelAttribute(spans[0], "publish.attempts").AsInt64(), ShouldEqual, 1)
+			})
+
+			Convey("Then the publication should carry the trace context", func() {
+				So(len(pubsub.publications), ShouldEqual, 1)
+				So(pubsub.publications[0].TrackingData["traceparent"], ShouldContainSubstring, spans[0].SpanContext.SpanID().String())
+			})
+		})
+
+		Convey("When I push an event while handling a request traced with tail-based sampling", func() {
+
+			tail := &traceTail{start: time.Now(), sampleErrors: true}
+			ctx := context.WithValue(context.Background(), traceTailContextKey{}, tail)
+			ctx, parent := tracer.Start(ctx, "request")
+			srv.pushEvents(ctx, evt)
+
+			Convey("Then the publication should not carry the trace context", func() {
+				So(len(pubsub.publications[0].TrackingData), ShouldEqual, 0)
+			})
+
+			Convey("Then the publish span should be held until the decision", func() {
+				So(len(exporter.GetSpans()), ShouldEqual, 0)
+				tail.flush()
+				parent.End()
+				So(len(exporter.GetSpans()), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I push an event that cannot be published", func() {
+
+			pubsub.PublishErr = errors.New("nope")
+
+			ctx, parent := tracer.Start(context.Background(), "request")
+			srv.pushEvents(ctx, evt)
+			parent.End()
+
+			Convey("Then the publish span should be in error", func() {
+				spans := exporter.GetSpans()
+				So(spans[0].Status.Code, ShouldEqual, codes.Error)
+				So(otelAttribute(spans[0], "publish.attempts").AsInt64(), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When I push an event without a traced request", func() {
+
+			srv.pushEvents(context.Background(), evt)
+
+			Convey("Then nothing should be traced", func() {
+				So(len(exporter.GetSpans()), ShouldEqual, 0)
+				So(len(pubsub.publications[0].TrackingData), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I push an event with a publish sampling of 0", func() {
+
+			srv.tracer.sampling.publish = 0
+
+			ctx, parent := tracer.Start(context.Background(), "request")
+			srv.pushEvents(ctx, evt)
+			parent.End()
+
+			Convey("Then only the request should be traced", func() {
+				So(len(exporter.GetSpans()), ShouldEqual, 1)
+				So(len(pubsub.publications[0].TrackingData), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given I have a push server with an OpenTracing tracer", t, func() {
+
+		tracer := mocktracer.New()
+		pubsub := &mockPubSubServer{}
+
+		cfg := config{}
+		cfg.pushServer.service = pubsub
+		cfg.pushServer.enabled = true
+		cfg.opentracing.tracer = tracer
+
+		srv := newPushServer(cfg, nil, nil)
+
+		Convey("When I push an event while handling a traced request", func() {
+
+			parent := tracer.StartSpan("request")
+			srv.pushEvents(opentracing.ContextWithSpan(context.Background(), parent), elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
+			parent.Finish()
+
+			spans := tracer.FinishedSpans()
+
+			Convey("Then the publish span should be a child of the request span", func() {
+				So(len(spans), ShouldEqual, 2)
+				So(spans[0].OperationName, ShouldEqual, pushPublishSpanName)
+				So(spans[0].ParentID, ShouldEqual, parent.Context().(mocktracer.MockSpanContext).SpanID)
+				So(spans[0].Tag("publish.attempts"), ShouldEqual, 1)
+			})
+
+			Convey("Then the publication should carry the trace context", func() {
+				So(len(pubsub.publications[0].TrackingData), ShouldBeGreaterThan, 0)
+			})
+		})
+	})
+}
+
+func TestPushTracing_receiveAndDispatch(t *testing.T) {
+
+	Convey("Given I have a push tracer and a traced publication", t, func() {
+
+		otelTracer, exporter := newTestTracer()
+
+		cfg := config{}
+		cfg.opentelemetry.tracer = otelTracer
+		pt := newPushTracer(cfg)
+
+		ctx, parent := otelTracer.Start(context.Background(), "request")
+		publication := NewPublication("topic")
+		publish := pt.startPublish(ctx, publication, elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
+		publish.finish()
+		parent.End()
+
+		received := NewPublication("topic")
+		received.TrackingData = publication.TrackingData
+
+		session := newWSPushSession(&http.Request{URL: &url.URL{}, Header: http.Header{}}, config{}, nil, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
+
+		Convey("When I start the receive and dispatch spans", func() {
+
+			receive := pt.startReceive(received)
+			dispatch := pt.startDispatch(receive, session)
+			dispatch.setTag("dispatch.should_dispatch", true)
+			dispatch.finish()
+			receive.finish()
+
+			spans := exporter.GetSpans()
+
+			Convey("Then the spans should be linked", func() {
+				So(len(spans), ShouldEqual, 4)
+
+				d, r, p := spans[2], spans[3], spans[0]
+				So(r.Name, ShouldEqual, pushReceiveSpanName)
+				So(r.SpanKind, ShouldEqual, trace.SpanKindConsumer)
+				So(r.Parent.SpanID(), ShouldEqual, p.SpanContext.SpanID())
+				So(r.SpanContext.TraceID(), ShouldEqual, parent.SpanContext().TraceID())
+
+				So(d.Name, ShouldEqual, pushDispatchSpanName)
+				So(d.Parent.SpanID(), ShouldEqual, r.SpanContext.SpanID())
+				So(otelAttribute(d, "session.id").AsString(), ShouldEqual, session.Identifier())
+				So(otelAttribute(d, "dispatch.should_dispatch").AsBool(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I start the dispatch span with a dispatch sampling of 0", func() {
+
+			pt.sampling.dispatch = 0
+			receive := pt.startReceive(received)
+			dispatch := pt.startDispatch(receive, session)
+
+			Convey("Then the dispatch span should be empty", func() {
+				So(receive.isZero(), ShouldBeFalse)
+				So(dispatch.isZero(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I push an event older than the session with a dispatch span", func() {
+
+			receive := pt.startReceive(received)
+			dispatch := pt.startDispatch(receive, session)
+
+			evt := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+			evt.Timestamp = time.Now().Add(-time.Hour)
+			session.pushEvent(evt, "", nil, dispatch)
+
+			Convey("Then the dispatch span should be finished as filtered", func() {
+				spans := exporter.GetSpans()
+				d := spans[len(spans)-1]
+				So(d.Name, ShouldEqual, pushDispatchSpanName)
+				So(otelAttribute(d, "dispatch.filtered").AsBool(), ShouldBeTrue)
+				So(otelAttribute(d, "dispatch.filter_reason").AsString(), ShouldEqual, "before_session_start")
+			})
+		})
+	})
+
+	Convey("Given I have a push tracer and a publication that is not traced", t, func() {
+
+		otelTracer, _ := newTestTracer()
+
+		cfg := config{}
+		cfg.opentelemetry.tracer = otelTracer
+		cfg.opentracing.tracer = mocktracer.New()
+		pt := newPushTracer(cfg)
+
+		Convey("When I start the receive span", func() {
+
+			receive := pt.startReceive(NewPublication("topic"))
+
+			Convey("Then the span should be empty", func() {
+				So(receive.isZero(), ShouldBeTrue)
+				So(pt.startDispatch(receive, nil).isZero(), ShouldBeTrue)
+				So(func() { receive.setTag("a", 1); receive.finish() }, ShouldNotPanic)
+			})
+		})
+	})
+}
+
+func TestPushTracing_logPayload(t *testing.T) {
+
+	Convey("Given I have a push tracer with a redactor and a traced publication", t, func() {
+
+		otelTracer, exporter := newTestTracer()
+
+		cfg := config{}
+		cfg.opentelemetry.tracer = otelTracer
+		cfg.redaction.redactor = newRedactor(nil, []string{"*token*"})
+		pt := newPushTracer(cfg)
+
+		event := &elemental.Event{Identity: "list"}
+		publication := NewPublication("topic")
+		publication.Encoding = elemental.EncodingTypeJSON
+		publication.Data = []byte(`{"identity":"list","entity":{"name":"a","token":"t"}}`)
+
+		ctx, parent := otelTracer.Start(context.Background(), "request")
+
+		Convey("When I log the payload of the publication", func() {
+
+			span := pt.startPublish(ctx, publication, event)
+			pt.logPayload(span, publication, event)
+			span.finish()
+			parent.End()
+
+			Convey("Then the payload should be redacted", func() {
+				spans := exporter.GetSpans()
+				So(len(spans[0].Events), ShouldEqual, 1)
+				So(spans[0].Events[0].Name, ShouldEqual, "payload")
+				So(spans[0].Events[0].Attributes[0].Value.AsString(), ShouldEqual, `{"entity":{"name":"a","token":"[redacted]"},"identity":"list"}`)
+			})
+		})
+	})
+}
+
+func TestPushTracing_listen(t *testing.T) {
+
+	Convey("Given I have a push session and a dispatch span", t, func() {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{}}).WithContext(ctx),
+			config{},
+			func(i *wsPushSession) {},
+			elemental.EncodingTypeMSGPACK,
+			elemental.EncodingTypeMSGPACK,
+		)
+
+		conn := wsc.NewMockWebsocket(ctx)
+		s.setConn(conn)
+
+		tracer, exporter := newTestTracer()
+		_, otelSpan := tracer.Start(context.Background(), pushDispatchSpanName)
+		span := pushSpan{otel: otelSpan}
+
+		Convey("When I push an event that is written", func() {
+
+			go s.listen()
+			s.pushEvent(elemental.NewEvent(elemental.EventUpdate, testmodel.NewList()), "", nil, span)
+
+			select {
+			case <-conn.LastWrite():
+			case <-ctx.Done():
+				panic("test: did not receive data in time")
+			}
+
+			Convey("Then the dispatch span should be finished with the enqueue latency", func() {
+				So(len(exporter.GetSpans()), ShouldEqual, 1)
+				d := exporter.GetSpans()[0]
+				So(otelAttribute(d, "dispatch.filtered").AsBool(), ShouldBeFalse)
+				So(otelAttribute(d, "dispatch.enqueue_latency").AsFloat64(), ShouldBeGreaterThanOrEqualTo, 0)
+			})
+		})
+
+		Convey("When I push an event that is filtered out", func() {
+
+			go s.listen()
+
+			f := elemental.NewPushFilter()
+			f.FilterIdentity("not-list")
+			s.setCurrentFilter(f)
+
+			s.pushEvent(elemental.NewEvent(elemental.EventUpdate, testmodel.NewList()), "", nil, span)
+			<-time.After(300 * time.Millisecond)
+
+			Convey("Then the dispatch span should be finished as filtered", func() {
+				So(len(exporter.GetSpans()), ShouldEqual, 1)
+				d := exporter.GetSpans()[0]
+				So(otelAttribute(d, "dispatch.filtered").AsBool(), ShouldBeTrue)
+				So(otelAttribute(d, "dispatch.filter_reason").AsString(), ShouldEqual, "push_filter")
+			})
+		})
+	})
+}
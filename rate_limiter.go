@@ -0,0 +1,92 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// clientRateLimiterSweepSize is the number of clients
+// above which the idle limiters are removed.
+const clientRateLimiterSweepSize = 10000
+
+// a clientRateLimiter holds one rate.Limiter per client IP.
+type clientRateLimiter struct {
+	limit     rate.Limit
+	burst     int
+	idle      time.Duration
+	limiters  map[string]*clientLimiter
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newClientRateLimiter(limit float64, burst int) *clientRateLimiter {
+
+	// A limiter that has not been used for the time needed
+	// to refill all its tokens is the same as a new one.
+	idle := time.Minute
+	if limit > 0 {
+		idle = time.Duration(float64(burst) / limit * float64(time.Second))
+	}
+
+	return &clientRateLimiter{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		idle:     idle,
+		limiters: map[string]*clientLimiter{},
+	}
+}
+
+// limiter returns the rate.Limiter of the client with the given IP.
+func (l *clientRateLimiter) limiter(clientIP string, now time.Time) *rate.Limiter {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if cl, ok := l.limiters[clientIP]; ok {
+		cl.lastSeen = now
+		return cl.limiter
+	}
+
+	if len(l.limiters) >= clientRateLimiterSweepSize && now.Sub(l.lastSweep) >= l.idle {
+		l.sweep(now)
+	}
+
+	cl := &clientLimiter{
+		limiter:  rate.NewLimiter(l.limit, l.burst),
+		lastSeen: now,
+	}
+	l.limiters[clientIP] = cl
+
+	return cl.limiter
+}
+
+// sweep removes the limiters that have been idle long enough
+// to be full again.
+func (l *clientRateLimiter) sweep(now time.Time) {
+
+	for ip, cl := range l.limiters {
+		if now.Sub(cl.lastSeen) >= l.idle {
+			delete(l.limiters, ip)
+		}
+	}
+
+	l.lastSweep = now
+}
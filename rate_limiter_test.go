@@ -0,0 +1,77 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/time/rate"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+
+	Convey("Given I have a client rate limiter", t, func() {
+
+		l := newClientRateLimiter(10, 20)
+		now := time.Now()
+
+		Convey("Then it should be correctly initialized", func() {
+			So(l.limit, ShouldEqual, rate.Limit(10))
+			So(l.burst, ShouldEqual, 20)
+			So(l.idle, ShouldEqual, 2*time.Second)
+		})
+
+		Convey("When I retrieve the limiters of two clients", func() {
+
+			l1 := l.limiter("10.0.0.1", now)
+			l2 := l.limiter("10.0.0.2", now)
+
+			Convey("Then each client should have its own limiter", func() {
+				So(l1, ShouldNotPointTo, l2)
+				So(l.limiter("10.0.0.1", now), ShouldPointTo, l1)
+			})
+
+			Convey("When I exhaust the limiter of the first client", func() {
+
+				for i := 0; i < 20; i++ {
+					l1.AllowN(now, 1)
+				}
+
+				Convey("Then the second client should not be limited", func() {
+					So(l1.AllowN(now, 1), ShouldBeFalse)
+					So(l2.AllowN(now, 1), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("When I retrieve the limiters of too many clients", func() {
+
+			for i := 0; i < clientRateLimiterSweepSize; i++ {
+				l.limiter(fmt.Sprintf("10.0.%d.%d", i/256, i%256), now)
+			}
+			active := l.limiter("10.0.0.1", now.Add(3*time.Second))
+
+			Convey("When I retrieve a new limiter after they have been idle", func() {
+
+				l.limiter("192.168.0.1", now.Add(3*time.Second))
+
+				Convey("Then the idle limiters should have been removed", func() {
+					So(len(l.limiters), ShouldEqual, 2)
+					So(l.limiter("10.0.0.1", now.Add(3*time.Second)), ShouldPointTo, active)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,330 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.aporeto.io/elemental"
+)
+
+// RedactedValue is the value replacing the redacted
+// attributes in traces and audit records.
+const RedactedValue = "[redacted]"
+
+// A SensitiveAttributesSpecifier can be implemented by the identifiables of
+// a model to mark some of their attributes as sensitive, for instance because
+// they hold personal identifiable information. Like the secret attributes,
+// the sensitive attributes are redacted from traces and audit records.
+type SensitiveAttributesSpecifier interface {
+
+	// SensitiveAttributes returns the names of the sensitive
+	// attributes, as set in their specifications.
+	SensitiveAttributes() []string
+}
+
+// redactedAttributesOf returns the names of the secret and
+// sensitive attributes of the given object.
+func redactedAttributesOf(obj interface{}) map[string]struct{} {
+
+	out := map[string]struct{}{}
+
+	if specifiable, ok := obj.(elemental.AttributeSpecifiable); ok {
+		for _, spec := range specifiable.AttributeSpecifications() {
+			if spec.Secret {
+				out[spec.Name] = struct{}{}
+			}
+		}
+	}
+
+	if specifier, ok := obj.(SensitiveAttributesSpecifier); ok {
+		for _, name := range specifier.SensitiveAttributes() {
+			out[name] = struct{}{}
+		}
+	}
+
+	return out
+}
+
+// A redactor redacts the secret and sensitive attributes of the model,
+// and the fields matching the configured patterns, from the payloads
+// and the attribute changes.
+type redactor struct {
+	modelManagers map[int]elemental.ModelManager
+	patterns      []string
+	cache         map[string]map[string]struct{}
+	cacheLock     sync.RWMutex
+}
+
+// newRedactor returns a new redactor using the given model managers. The
+// given patterns are matched case insensitively against the field names
+// using the path.Match syntax.
+func newRedactor(modelManagers map[int]elemental.ModelManager, patterns []string) *redactor {
+
+	lowered := make([]string, len(patterns))
+	for i, p := range patterns {
+		lowered[i] = strings.ToLower(p)
+	}
+
+	return &redactor{
+		modelManagers: modelManagers,
+		patterns:      lowered,
+		cache:         map[string]map[string]struct{}{},
+	}
+}
+
+// matches returns true if the given field name matches one of the patterns.
+func (r *redactor) matches(name string) bool {
+
+	if r == nil {
+		return false
+	}
+
+	name = strings.ToLower(name)
+	for _, p := range r.patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attributes returns the names of the secret and sensitive
+// attributes of the given identity in the given version of the model.
+func (r *redactor) attributes(identity elemental.Identity, version int) map[string]struct{} {
+
+	key := strconv.Itoa(version) + "/" + identity.Name
+
+	r.cacheLock.RLock()
+	attrs, ok := r.cache[key]
+	r.cacheLock.RUnlock()
+
+	if ok {
+		return attrs
+	}
+
+	attrs = map[string]struct{}{}
+	if manager, ok := r.modelManagers[version]; ok && !identity.IsEmpty() {
+		if obj := manager.Identifiable(identity); obj != nil {
+			attrs = redactedAttributesOf(obj)
+		}
+	}
+
+	r.cacheLock.Lock()
+	r.cache[key] = attrs
+	r.cacheLock.Unlock()
+
+	return attrs
+}
+
+// hasRedactedAttributes returns true if one of the identities
+// of the given model managers has secret or sensitive attributes.
+func hasRedactedAttributes(modelManagers map[int]elemental.ModelManager) bool {
+
+	for _, manager := range modelManagers {
+		for identity := range manager.Relationships() {
+			if obj := manager.Identifiable(identity); obj != nil && len(redactedAttributesOf(obj)) > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// encodeRedacted encodes the given redacted value with the given encoding.
+func encodeRedacted(encoding elemental.EncodingType, value interface{}) ([]byte, error) {
+
+	if encoding == elemental.EncodingTypeMSGPACK {
+		return elemental.Encode(encoding, value)
+	}
+
+	return json.Marshal(value)
+}
+
+// redactPayload returns the given payload of the given identity, encoded
+// with the given encoding, with the redacted attributes and fields replaced
+// by RedactedValue, encoded with the given output encoding. If the payload
+// cannot be decoded, it is entirely redacted.
+func (r *redactor) redactPayload(identity elemental.Identity, version int, encoding elemental.EncodingType, data []byte, outEncoding elemental.EncodingType) []byte {
+
+	if r == nil || len(data) == 0 {
+		return data
+	}
+
+	var payload interface{}
+	if err := elemental.Decode(encoding, data, &payload); err != nil {
+		return []byte(RedactedValue)
+	}
+
+	out, err := encodeRedacted(outEncoding, r.redactValue(payload, r.attributes(identity, version)))
+	if err != nil {
+		return []byte(RedactedValue)
+	}
+
+	return out
+}
+
+// redactEvent returns the given elemental.Event, encoded with the given
+// encoding, with the redacted attributes of its entity and the redacted
+// fields replaced by RedactedValue, encoded with the given output encoding.
+// As events do not carry the version of the model, the latest one is used.
+// If the event cannot be decoded, it is entirely redacted.
+func (r *redactor) redactEvent(identity elemental.Identity, encoding elemental.EncodingType, data []byte, outEncoding elemental.EncodingType) []byte {
+
+	if r == nil || len(data) == 0 {
+		return data
+	}
+
+	var payload map[string]interface{}
+	if err := elemental.Decode(encoding, data, &payload); err != nil {
+		return []byte(RedactedValue)
+	}
+
+	version := -1
+	for v := range r.modelManagers {
+		if v > version {
+			version = v
+		}
+	}
+
+	for k, v := range payload {
+
+		if k != "entity" {
+			payload[k] = r.redactValue(v, nil)
+			continue
+		}
+
+		// With msgpack, the entity is encoded separately
+		// using the encoding of the event.
+		raw, ok := v.([]byte)
+		if !ok {
+			payload[k] = r.redactValue(v, r.attributes(identity, version))
+			continue
+		}
+
+		entityEncoding, _ := payload["encoding"].(string)
+		if err := elemental.Decode(elemental.EncodingType(entityEncoding), raw, &v); err != nil {
+			payload[k] = RedactedValue
+			continue
+		}
+
+		v = r.redactValue(v, r.attributes(identity, version))
+		if outEncoding != elemental.EncodingTypeMSGPACK {
+			payload[k] = v
+			continue
+		}
+
+		// The entity is encoded back separately.
+		encoded, err := encodeRedacted(elemental.EncodingType(entityEncoding), v)
+		if err != nil {
+			payload[k] = RedactedValue
+			continue
+		}
+
+		payload[k] = encoded
+	}
+
+	out, err := encodeRedacted(outEncoding, payload)
+	if err != nil {
+		return []byte(RedactedValue)
+	}
+
+	return out
+}
+
+// redactValue redacts the given decoded value. The given attributes are
+// only redacted at the top level of the objects, while the patterns
+// apply at any depth.
+func (r *redactor) redactValue(value interface{}, attributes map[string]struct{}) interface{} {
+
+	switch v := value.(type) {
+
+	case map[string]interface{}:
+		for k, item := range v {
+			if _, ok := attributes[k]; ok || r.matches(k) {
+				v[k] = RedactedValue
+				continue
+			}
+			v[k] = r.redactValue(item, nil)
+		}
+		return v
+
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprintf("%v", k)] = item
+		}
+		return r.redactValue(m, attributes)
+
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.redactValue(item, attributes)
+		}
+		return v
+
+	default:
+		return v
+	}
+}
+
+// redactChanges returns a copy of the given changes where the
+// values of the attributes matching the patterns are removed.
+func (r *redactor) redactChanges(changes AttributeChanges) AttributeChanges {
+
+	if r == nil || len(changes) == 0 || len(r.patterns) == 0 {
+		return changes
+	}
+
+	out := make(AttributeChanges, len(changes))
+	for i, change := range changes {
+		if !change.Secret && !change.Redacted && r.matches(change.Attribute) {
+			change.Previous = nil
+			change.Current = nil
+			change.Redacted = true
+		}
+		out[i] = change
+	}
+
+	return out
+}
+
+// traceCleaner returns the TraceCleaner to use for the payloads encoded with
+// the given encoding in the given version of the model. It redacts the
+// payload, then calls the configured TraceCleaner, if any, on the result.
+// The configured TraceCleaner gets the payload in its original encoding,
+// otherwise the payload is logged as JSON.
+func (c *config) traceCleaner(version int, encoding elemental.EncodingType) TraceCleaner {
+
+	r := c.redaction.redactor
+	cleaner := c.opentracing.traceCleaner
+
+	if r == nil {
+		return cleaner
+	}
+
+	if cleaner == nil {
+		return func(identity elemental.Identity, data []byte) []byte {
+			return r.redactPayload(identity, version, encoding, data, elemental.EncodingTypeJSON)
+		}
+	}
+
+	return func(identity elemental.Identity, data []byte) []byte {
+		return cleaner(identity, r.redactPayload(identity, version, encoding, data, encoding))
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+type sensitiveObject struct {
+	specifiableObject
+}
+
+func (o *sensitiveObject) SensitiveAttributes() []string {
+	return []string{"name"}
+}
+
+func TestRedaction_redactedAttributesOf(t *testing.T) {
+
+	Convey("Given I have a specifiable object", t, func() {
+
+		Convey("When I retrieve its redacted attributes", func() {
+
+			attrs := redactedAttributesOf(&specifiableObject{})
+
+			Convey("Then only the secret attributes should be returned", func() {
+				So(attrs, ShouldResemble, map[string]struct{}{"password": {}})
+			})
+		})
+
+		Convey("When I retrieve the redacted attributes of a sensitive attributes specifier", func() {
+
+			attrs := redactedAttributesOf(&sensitiveObject{})
+
+			Convey("Then the secret and sensitive attributes should be returned", func() {
+				So(attrs, ShouldResemble, map[string]struct{}{"password": {}, "name": {}})
+			})
+		})
+
+		Convey("When I retrieve the redacted attributes of something else", func() {
+
+			Convey("Then nothing should be returned", func() {
+				So(redactedAttributesOf("hello"), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestRedaction_matches(t *testing.T) {
+
+	Convey("Given I have a redactor with some patterns", t, func() {
+
+		r := newRedactor(nil, []string{"*Token*", "email"})
+
+		Convey("Then the field names should be matched case insensitively", func() {
+			So(r.matches("accessToken"), ShouldBeTrue)
+			So(r.matches("TOKEN"), ShouldBeTrue)
+			So(r.matches("Email"), ShouldBeTrue)
+			So(r.matches("emails"), ShouldBeFalse)
+			So(r.matches("name"), ShouldBeFalse)
+		})
+
+		Convey("Then a nil redactor should match nothing", func() {
+			var nr *redactor
+			So(nr.matches("token"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestRedaction_redactPayload(t *testing.T) {
+
+	Convey("Given I have a redactor knowing the redacted attributes of an identity", t, func() {
+
+		identity := elemental.MakeIdentity("list", "lists")
+
+		r := newRedactor(nil, []string{"*token*"})
+		r.cache["1/list"] = map[string]struct{}{"password": {}}
+
+		Convey("When I redact a payload", func() {
+
+			out := r.redactPayload(identity, 1, elemental.EncodingTypeJSON, []byte(`{
+				"name": "a",
+				"password": "secret",
+				"apiToken": "t",
+				"nested": {"password": "visible", "refreshToken": "t"},
+				"list": [{"token": "t", "name": "b"}]
+			}`), elemental.EncodingTypeJSON)
+
+			Convey("Then the attributes and fields should be redacted", func() {
+				var payload map[string]interface{}
+				So(json.Unmarshal(out, &payload), ShouldBeNil)
+				So(payload, ShouldResemble, map[string]interface{}{
+					"name":     "a",
+					"password": RedactedValue,
+					"apiToken": RedactedValue,
+					"nested":   map[string]interface{}{"password": "visible", "refreshToken": RedactedValue},
+					"list":     []interface{}{map[string]interface{}{"token": RedactedValue, "name": "b"}},
+				})
+			})
+		})
+
+		Convey("When I redact a list of objects", func() {
+
+			out := r.redactPayload(identity, 1, elemental.EncodingTypeJSON, []byte(`[{"name": "a", "password": "secret"}]`), elemental.EncodingTypeJSON)
+
+			Convey("Then the attributes of each object should be redacted", func() {
+				So(string(out), ShouldEqual, `[{"name":"a","password":"[redacted]"}]`)
+			})
+		})
+
+		Convey("When I redact a payload of another version", func() {
+
+			out := r.redactPayload(identity, 2, elemental.EncodingTypeJSON, []byte(`{"password":"secret"}`), elemental.EncodingTypeJSON)
+
+			Convey("Then the attributes should not be redacted", func() {
+				So(string(out), ShouldEqual, `{"password":"secret"}`)
+			})
+		})
+
+		Convey("When I redact a payload that cannot be decoded", func() {
+
+			out := r.redactPayload(identity, 1, elemental.EncodingTypeJSON, []byte(`{"password":`), elemental.EncodingTypeJSON)
+
+			Convey("Then the whole payload should be redacted", func() {
+				So(string(out), ShouldEqual, RedactedValue)
+			})
+		})
+
+		Convey("When I redact an empty payload", func() {
+
+			Convey("Then it should be returned as is", func() {
+				So(r.redactPayload(identity, 1, elemental.EncodingTypeJSON, nil, elemental.EncodingTypeJSON), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestRedaction_redactEvent(t *testing.T) {
+
+	Convey("Given I have a redactor knowing the redacted attributes of an identity", t, func() {
+
+		r := newRedactor(map[int]elemental.ModelManager{0: nil, 1: nil}, []string{"*token*"})
+		r.cache["1/list"] = map[string]struct{}{"password": {}}
+
+		Convey("When I redact an event", func() {
+
+			out := r.redactEvent(
+				elemental.MakeIdentity("list", "lists"),
+				elemental.EncodingTypeJSON,
+				[]byte(`{"type":"create","entity":{"name":"a","password":"secret","token":"t"}}`),
+				elemental.EncodingTypeJSON,
+			)
+
+			Convey("Then the attributes of the entity should be redacted using the latest version", func() {
+				var payload map[string]interface{}
+				So(json.Unmarshal(out, &payload), ShouldBeNil)
+				So(payload, ShouldResemble, map[string]interface{}{
+					"type":   "create",
+					"entity": map[string]interface{}{"name": "a", "password": RedactedValue, "token": RedactedValue},
+				})
+			})
+		})
+
+		Convey("When I redact an event that cannot be decoded", func() {
+
+			out := r.redactEvent(elemental.MakeIdentity("list", "lists"), elemental.EncodingTypeJSON, []byte(`nope`), elemental.EncodingTypeJSON)
+
+			Convey("Then the whole event should be redacted", func() {
+				So(string(out), ShouldEqual, RedactedValue)
+			})
+		})
+	})
+}
+
+func TestRedaction_redactChanges(t *testing.T) {
+
+	Convey("Given I have a redactor with some patterns", t, func() {
+
+		r := newRedactor(nil, []string{"email"})
+
+		changes := AttributeChanges{
+			{Attribute: "name", Previous: "a", Current: "b"},
+			{Attribute: "email", Previous: "a@b.c", Current: "d@e.f"},
+			{Attribute: "password", Secret: true},
+		}
+
+		Convey("When I redact the changes", func() {
+
+			out := r.redactChanges(changes)
+
+			Convey("Then the matching attributes should be redacted", func() {
+				So(out, ShouldResemble, AttributeChanges{
+					{Attribute: "name", Previous: "a", Current: "b"},
+					{Attribute: "email", Redacted: true},
+					{Attribute: "password", Secret: true},
+				})
+			})
+
+			Convey("Then the original changes should be left untouched", func() {
+				So(changes[1].Previous, ShouldEqual, "a@b.c")
+			})
+		})
+
+		Convey("When I redact the changes with a nil redactor", func() {
+
+			var nr *redactor
+
+			Convey("Then the changes should be returned as is", func() {
+				So(nr.redactChanges(changes), ShouldResemble, changes)
+			})
+		})
+	})
+}
+
+func TestRedaction_traceCleaner(t *testing.T) {
+
+	Convey("Given I have a config with a redactor and a trace cleaner", t, func() {
+
+		cfg := config{}
+		cfg.redaction.redactor = newRedactor(nil, []string{"token"})
+		cfg.opentracing.traceCleaner = func(identity elemental.Identity, data []byte) []byte {
+			return append([]byte(identity.Name+":"), data...)
+		}
+
+		Convey("When I clean a payload", func() {
+
+			out := cfg.traceCleaner(0, elemental.EncodingTypeJSON)(elemental.MakeIdentity("list", "lists"), []byte(`{"token":"t"}`))
+
+			Convey("Then it should be redacted then cleaned", func() {
+				So(string(out), ShouldEqual, `list:{"token":"[redacted]"}`)
+			})
+		})
+
+		Convey("When I clean a msgpack payload", func() {
+
+			var received []byte
+			cfg.opentracing.traceCleaner = func(identity elemental.Identity, data []byte) []byte {
+				received = data
+				return data
+			}
+
+			data, _ := elemental.Encode(elemental.EncodingTypeMSGPACK, map[string]interface{}{"token": "t"})
+			cfg.traceCleaner(0, elemental.EncodingTypeMSGPACK)(elemental.MakeIdentity("list", "lists"), data)
+
+			Convey("Then the cleaner should get the redacted payload as msgpack", func() {
+				payload := map[string]interface{}{}
+				So(elemental.Decode(elemental.EncodingTypeMSGPACK, received, &payload), ShouldBeNil)
+				So(payload["token"], ShouldEqual, RedactedValue)
+			})
+		})
+	})
+
+	Convey("Given I have a config with a redactor and no trace cleaner", t, func() {
+
+		cfg := config{}
+		cfg.redaction.redactor = newRedactor(nil, []string{"token"})
+
+		Convey("When I clean a msgpack payload", func() {
+
+			data, _ := elemental.Encode(elemental.EncodingTypeMSGPACK, map[string]interface{}{"token": "t"})
+			out := cfg.traceCleaner(0, elemental.EncodingTypeMSGPACK)(elemental.MakeIdentity("list", "lists"), data)
+
+			Convey("Then it should be redacted as JSON", func() {
+				So(string(out), ShouldEqual, `{"token":"[redacted]"}`)
+			})
+		})
+	})
+
+	Convey("Given I have a config without redactor", t, func() {
+
+		cfg := config{}
+
+		Convey("Then the trace cleaner should be the configured one", func() {
+			So(cfg.traceCleaner(0, elemental.EncodingTypeJSON), ShouldBeNil)
+		})
+
+		Convey("Then there should be nothing to redact without model nor fields", func() {
+			So(hasRedactedAttributes(nil), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// RequestIDHeader is the name of the header holding
+// the correlation ID of a request.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of an
+// incoming request ID to be accepted.
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the request ID stored in
+// the given context.Context, or an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+
+	return id
+}
+
+func contextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// requestIDFromHeaders returns the request ID from the given
+// headers if it is valid, or generates a new one.
+func requestIDFromHeaders(headers http.Header) string {
+
+	if id := headers.Get(RequestIDHeader); isValidRequestID(id) {
+		return id
+	}
+
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+// isValidRequestID returns true if the given id is not empty,
+// not too long and only contains letters, digits and -_.:=+/@
+// so it can be safely echoed in headers and logs.
+func isValidRequestID(id string) bool {
+
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("-_.:=+/@", c) >= 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
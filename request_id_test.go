@@ -0,0 +1,75 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestID_requestIDFromHeaders(t *testing.T) {
+
+	Convey("Given I have headers with a valid request ID", t, func() {
+
+		h := http.Header{}
+		h.Set(RequestIDHeader, "abc-123_4.5:6=7+8/9@x")
+
+		Convey("Then it should be used", func() {
+			So(requestIDFromHeaders(h), ShouldEqual, "abc-123_4.5:6=7+8/9@x")
+		})
+	})
+
+	Convey("Given I have headers with no request ID", t, func() {
+
+		Convey("Then a new one should be generated", func() {
+			id1 := requestIDFromHeaders(http.Header{})
+			id2 := requestIDFromHeaders(http.Header{})
+			So(len(id1), ShouldEqual, 36)
+			So(id1, ShouldNotEqual, id2)
+		})
+	})
+
+	Convey("Given I have headers with invalid request IDs", t, func() {
+
+		for _, id := range []string{"a b", "a\nb", "é", "a\"b", "<a>", "a;b", "a,b", strings.Repeat("a", 129)} {
+
+			h := http.Header{}
+			h.Set(RequestIDHeader, id)
+
+			So(requestIDFromHeaders(h), ShouldNotEqual, id)
+		}
+	})
+}
+
+func TestRequestID_RequestIDFromContext(t *testing.T) {
+
+	Convey("Given I have a context with a request ID", t, func() {
+
+		ctx := contextWithRequestID(context.Background(), "abc")
+
+		Convey("Then I should retrieve it", func() {
+			So(RequestIDFromContext(ctx), ShouldEqual, "abc")
+		})
+	})
+
+	Convey("Given I have a context without request ID", t, func() {
+
+		Convey("Then I should get an empty string", func() {
+			So(RequestIDFromContext(context.Background()), ShouldEqual, "")
+			So(RequestIDFromContext(nil), ShouldEqual, "") // nolint
+		})
+	})
+}
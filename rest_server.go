@@ -13,7 +13,6 @@ package bahamut
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -34,17 +33,28 @@ type restServer struct {
 	server          *http.Server
 	processorFinder processorFinderFunc
 	pusher          eventPusherFunc
+	tlsReloader     *tlsReloader
+	accessLogger    *accessLogger
+	traceSampler    *traceSampler
 }
 
 // newRestServer returns a new apiServer.
 func newRestServer(cfg config, multiplexer *bone.Mux, processorFinder processorFinderFunc, pusher eventPusherFunc) *restServer {
 
-	return &restServer{
+	srv := &restServer{
 		cfg:             cfg,
 		multiplexer:     multiplexer,
 		processorFinder: processorFinder,
 		pusher:          pusher,
+		accessLogger:    newAccessLogger(cfg),
+		traceSampler:    newTraceSampler(cfg),
 	}
+
+	if cfg.tls.reloadCertFile != "" {
+		srv.tlsReloader = newTLSReloader(cfg)
+	}
+
+	return srv
 }
 
 // createSecureHTTPServer returns the main HTTP Server.
@@ -52,22 +62,12 @@ func newRestServer(cfg config, multiplexer *bone.Mux, processorFinder processorF
 // It will return an error if any.
 func (a *restServer) createSecureHTTPServer(address string) *http.Server {
 
-	tlsConfig := &tls.Config{
-		ClientAuth:               a.cfg.tls.authType,
-		ClientCAs:                a.cfg.tls.clientCAPool,
-		MinVersion:               tls.VersionTLS12,
-		SessionTicketsDisabled:   true,
-		PreferServerCipherSuites: true,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		},
-	}
+	tlsConfig := makeServerTLSConfig(a.cfg)
 
-	if a.cfg.tls.serverCertificatesRetrieverFunc != nil {
+	if a.tlsReloader != nil {
+		tlsConfig.GetCertificate = a.tlsReloader.getCertificate
+		tlsConfig.GetConfigForClient = a.tlsReloader.makeGetConfigForClient(tlsConfig)
+	} else if a.cfg.tls.serverCertificatesRetrieverFunc != nil {
 		tlsConfig.GetCertificate = a.cfg.tls.serverCertificatesRetrieverFunc
 	} else {
 		tlsConfig.Certificates = a.cfg.tls.serverCertificates
@@ -81,6 +81,7 @@ func (a *restServer) createSecureHTTPServer(address string) *http.Server {
 		ReadTimeout:  a.cfg.restServer.readTimeout,
 		WriteTimeout: a.cfg.restServer.writeTimeout,
 		IdleTimeout:  a.cfg.restServer.idleTimeout,
+		ConnContext:  a.connContext,
 	}
 
 	server.SetKeepAlivesEnabled(!a.cfg.restServer.disableKeepalive)
@@ -94,15 +95,22 @@ func (a *restServer) createSecureHTTPServer(address string) *http.Server {
 func (a *restServer) createUnsecureHTTPServer(address string) *http.Server {
 
 	return &http.Server{
-		Addr: address,
+		Addr:        address,
+		ConnContext: a.connContext,
 	}
 }
 
+// connContext is the http.Server.ConnContext function
+// storing the peer credentials of the connections.
+func (a *restServer) connContext(ctx context.Context, conn net.Conn) context.Context {
+	return contextWithPeerCredentials(ctx, conn, a.cfg.logger())
+}
+
 // installRoutes installs all the routes declared in the APIServerConfig.
 func (a *restServer) installRoutes(routesInfo map[int][]RouteInfo) {
 
 	a.multiplexer.Options("*", http.HandlerFunc(corsHandler))
-	a.multiplexer.NotFound(http.HandlerFunc(notFoundHandler))
+	a.multiplexer.NotFound(makeNotFoundHandler(a.cfg.logger()))
 
 	if a.cfg.restServer.customRootHandlerFunc != nil {
 		a.multiplexer.Handle("/", a.cfg.restServer.customRootHandlerFunc)
@@ -177,7 +185,14 @@ func (a *restServer) start(ctx context.Context, routesInfo map[int][]RouteInfo)
 	a.installRoutes(routesInfo)
 
 	var err error
-	if a.cfg.tls.serverCertificates != nil || a.cfg.tls.serverCertificatesRetrieverFunc != nil {
+	if a.tlsReloader != nil {
+		if err = a.tlsReloader.load(); err != nil {
+			a.cfg.logger().Fatal("Unable to load tls certificate", zap.Error(err))
+		}
+		go a.tlsReloader.watch(ctx)
+	}
+
+	if a.isSecure() {
 		a.server = a.createSecureHTTPServer(a.cfg.restServer.listenAddress)
 	} else {
 		a.server = a.createUnsecureHTTPServer(a.cfg.restServer.listenAddress)
@@ -188,14 +203,33 @@ func (a *restServer) start(ctx context.Context, routesInfo map[int][]RouteInfo)
 	go func() {
 
 		listener := a.cfg.restServer.customListener
+		if listener == nil && a.cfg.restServer.unixSocketPath != "" {
+			listener, err = listenUnixSocket(
+				a.cfg.restServer.unixSocketPath,
+				a.cfg.restServer.unixSocketMode,
+				a.cfg.restServer.unixSocketUID,
+				a.cfg.restServer.unixSocketGID,
+			)
+			if err != nil {
+				a.cfg.logger().Fatal("Unable to listen on unix socket", zap.Error(err))
+			}
+		}
+
 		if listener == nil {
 			listener, err = net.Listen("tcp", a.server.Addr)
 			if err != nil {
-				zap.L().Fatal("Unable to dial", zap.Error(err))
+				a.cfg.logger().Fatal("Unable to dial", zap.Error(err))
+			}
+		}
+
+		if a.cfg.restServer.proxyProtocolEnabled {
+			if len(a.cfg.restServer.trustedProxies) == 0 {
+				a.cfg.logger().Warn("PROXY protocol enabled without trusted proxies. The headers will be ignored")
 			}
+			listener = newProxyProtocolListener(listener, a.cfg.restServer.trustedProxies)
 		}
 
-		if a.cfg.tls.serverCertificates != nil || a.cfg.tls.serverCertificatesRetrieverFunc != nil {
+		if a.isSecure() {
 			err = a.server.ServeTLS(listener, "", "")
 		} else {
 			err = a.server.Serve(listener)
@@ -205,15 +239,25 @@ func (a *restServer) start(ctx context.Context, routesInfo map[int][]RouteInfo)
 			if err == http.ErrServerClosed {
 				return
 			}
-			zap.L().Fatal("Unable to start api server", zap.Error(err))
+			a.cfg.logger().Fatal("Unable to start api server", zap.Error(err))
 		}
 	}()
 
-	zap.L().Info("API server started", zap.String("address", a.cfg.restServer.listenAddress))
+	if a.cfg.restServer.unixSocketPath != "" && a.cfg.restServer.customListener == nil {
+		a.cfg.logger().Info("API server started", zap.String("socket", a.cfg.restServer.unixSocketPath))
+	} else {
+		a.cfg.logger().Info("API server started", zap.String("address", a.cfg.restServer.listenAddress))
+	}
 
 	<-ctx.Done()
 }
 
+// isSecure returns true if the server is configured to use TLS.
+func (a *restServer) isSecure() bool {
+
+	return a.tlsReloader != nil || a.cfg.tls.serverCertificates != nil || a.cfg.tls.serverCertificatesRetrieverFunc != nil
+}
+
 func (a *restServer) stop() context.Context {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
@@ -221,9 +265,9 @@ func (a *restServer) stop() context.Context {
 	go func() {
 		defer cancel()
 		if err := a.server.Shutdown(ctx); err != nil {
-			zap.L().Error("Could not gracefully stop API server", zap.Error(err))
+			a.cfg.logger().Error("Could not gracefully stop API server", zap.Error(err))
 		} else {
-			zap.L().Debug("API server stopped")
+			a.cfg.logger().Debug("API server stopped")
 		}
 	}()
 
@@ -235,41 +279,183 @@ func (a *restServer) makeHandler(handler handlerFunc) http.HandlerFunc {
 	return gziphandler.GzipHandler(
 		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 
-			var measure FinishMeasurementFunc
-			if a.cfg.healthServer.metricsManager != nil {
-				measure = a.cfg.healthServer.metricsManager.MeasureRequest(req.Method, req.URL.Path)
-			}
+			start := time.Now()
+
+			requestID := requestIDFromHeaders(req.Header)
+			w.Header().Set(RequestIDHeader, requestID)
 
 			request, err := elemental.NewRequestFromHTTPRequest(req, a.cfg.model.modelManagers[0])
 			if err != nil {
-				code := writeHTTPResponse(w, makeErrorResponse(req.Context(), elemental.NewResponse(elemental.NewRequest()), err))
+				var measure FinishMeasurementFunc
+				if a.cfg.healthServer.metricsManager != nil {
+					measure = a.cfg.healthServer.metricsManager.MeasureRequest(req.Method, req.URL.Path, elemental.NewRequest())
+				}
+
+				response := makeErrorResponse(req.Context(), elemental.NewResponse(elemental.NewRequest()), err)
+				code := writeHTTPResponse(w, response, a.cfg.logger())
 				if measure != nil {
-					measure(code, nil)
+					measure(MeasurementResult{Code: code, ResponseSize: responseSize(response), Start: start})
 				}
+
+				errRequest := elemental.NewRequest()
+				errRequest.RequestID = requestID
+				bctx := newContext(req.Context(), errRequest)
+				bctx.request.ClientIP = resolveClientIP(req.RemoteAddr, req.Header, a.cfg.restServer.trustedProxies)
+				bctx.err = err
+				a.completeRequest(req, bctx, start, code, response)
+
 				return
 			}
 
+			request.RequestID = requestID
+			setRequestPeerCredentials(req.Context(), request)
+			setRequestHTTPInfo(req, request)
+			request.ClientIP = resolveClientIP(req.RemoteAddr, req.Header, a.cfg.restServer.trustedProxies)
+
 			setCommonHeader(w, req.Header.Get("Origin"), request.Accept)
 
-			ctx := traceRequest(req.Context(), request, a.cfg.opentracing.tracer, a.cfg.opentracing.excludedIdentities, a.cfg.opentracing.traceCleaner)
-			defer finishTracing(ctx)
-
-			if a.cfg.rateLimiting.rateLimiter != nil {
-				rctx, cancel := context.WithTimeout(req.Context(), 1*time.Second)
-				defer cancel()
-				if err = a.cfg.rateLimiting.rateLimiter.Wait(rctx); err != nil {
-					code := writeHTTPResponse(w, makeErrorResponse(ctx, elemental.NewResponse(request), ErrRateLimit))
-					if measure != nil {
-						measure(code, opentracing.SpanFromContext(ctx))
-					}
-					return
+			ctx := contextWithLogger(contextWithRequestID(req.Context(), requestID), a.cfg.logger())
+
+			var measure FinishMeasurementFunc
+			var measurePanic *measurePanic
+			if a.cfg.healthServer.metricsManager != nil {
+				measure = a.cfg.healthServer.metricsManager.MeasureRequest(req.Method, req.URL.Path, request)
+				ctx, measurePanic = contextWithMeasurePanic(ctx)
+			}
+
+			ctx, sampled, samplingReason := a.traceSampler.sample(ctx, request, start)
+			if sampled {
+				cleaner := a.cfg.traceCleaner(request.Version, request.ContentType)
+				ctx = traceRequest(ctx, request, a.cfg.opentracing.tracer, a.cfg.opentracing.excludedIdentities, cleaner)
+				ctx = traceRequestOTel(ctx, req, request, a.cfg.opentelemetry.tracer, a.cfg.opentracing.excludedIdentities, cleaner)
+				setSamplingReason(ctx, samplingReason)
+				defer finishTracing(ctx)
+			}
+
+			bctx := newContext(ctx, request)
+			bctx.ctx = contextWithScopedLogger(bctx.ctx, bctx)
+
+			if err = a.waitRateLimit(req.Context(), request.ClientIP); err != nil {
+				response := makeContextErrorResponse(bctx, elemental.NewResponse(request), ErrRateLimit)
+				code := writeHTTPResponse(w, response, bctx.Logger())
+				if measure != nil {
+					measure(MeasurementResult{Code: code, ResponseSize: responseSize(response), Span: opentracing.SpanFromContext(ctx), TraceID: keptTraceID(ctx, code), Start: start})
 				}
+				a.completeRequest(req, bctx, start, code, response)
+				return
 			}
 
-			code := writeHTTPResponse(w, handler(newContext(ctx, request), a.cfg, a.processorFinder, a.pusher))
+			response := handler(bctx, a.cfg, a.processorFinder, a.pusher)
+
+			// The decisions are incomplete, and still being recorded,
+			// if the request timed out during the dispatch.
+			if a.cfg.security.authDecisionsHeader && bctx.pendingDispatch() == nil {
+				setAuthDecisionsHeader(w, bctx.AuthDecisions())
+			}
+
+			code := writeHTTPResponse(w, response, bctx.Logger())
 			if measure != nil {
-				measure(code, opentracing.SpanFromContext(ctx))
+				measure(MeasurementResult{
+					Code:         code,
+					ResponseSize: responseSize(response),
+					Panicked:     measurePanic.hasPanicked(),
+					Span:         opentracing.SpanFromContext(ctx),
+					TraceID:      keptTraceID(ctx, code),
+					Start:        start,
+				})
 			}
+
+			a.completeRequest(req, bctx, start, code, response)
 		}),
 	).(http.HandlerFunc)
 }
+
+// waitRateLimit waits for the rate limiter of the given client IP and
+// for the global rate limiter, if any. It returns an error if the wait
+// for both exceeds one second.
+func (a *restServer) waitRateLimit(ctx context.Context, clientIP string) error {
+
+	if a.cfg.rateLimiting.clientRateLimiter == nil && a.cfg.rateLimiting.rateLimiter == nil {
+		return nil
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	if a.cfg.rateLimiting.clientRateLimiter != nil {
+		if err := a.cfg.rateLimiting.clientRateLimiter.limiter(clientIP, time.Now()).Wait(rctx); err != nil {
+			return err
+		}
+	}
+
+	if a.cfg.rateLimiting.rateLimiter != nil {
+		return a.cfg.rateLimiting.rateLimiter.Wait(rctx)
+	}
+
+	return nil
+}
+
+// completeRequest records the audit and writes the access
+// log entry of the given request once the response is sent.
+func (a *restServer) completeRequest(req *http.Request, ctx *bcontext, start time.Time, code int, response *elemental.Response) {
+
+	setTraceStatusCode(ctx.ctx, code)
+
+	end := time.Now()
+
+	// When the request timed out, the dispatcher may still be running
+	// and modifying the context. The audit record and the access log
+	// entry are then built once it returns.
+	if done := ctx.pendingDispatch(); done != nil {
+		go func() {
+			<-done
+			a.recordAudit(ctx, start, end, code, response)
+			a.logAccess(req, ctx, start, end, code, response)
+		}()
+		return
+	}
+
+	a.recordAudit(ctx, start, end, code, response)
+	a.logAccess(req, ctx, start, end, code, response)
+}
+
+// logAccess writes the access log entry of the given request.
+func (a *restServer) logAccess(req *http.Request, ctx *bcontext, start time.Time, end time.Time, code int, response *elemental.Response) {
+
+	if a.accessLogger == nil {
+		return
+	}
+
+	entry := newAccessLogEntry(req, start, end, code, responseSize(response))
+	entry.Identity = ctx.request.Identity.Name
+	entry.Operation = string(ctx.request.Operation)
+	entry.ClientIP = ctx.request.ClientIP
+	entry.RequestID = ctx.request.RequestID
+	entry.Subject = ctx.claimsMap[subjectClaimKey]
+
+	a.accessLogger.log(entry)
+}
+
+// recordAudit sends the AuditRecord of the given context
+// to the configured AuditRecorder, if any.
+func (a *restServer) recordAudit(ctx *bcontext, start time.Time, end time.Time, code int, response *elemental.Response) {
+
+	if a.cfg.security.auditRecorder == nil {
+		return
+	}
+
+	record := newAuditRecord(ctx, start, end, code, responseSize(response))
+	record.Changes = a.cfg.redaction.redactor.redactChanges(record.Changes)
+
+	a.cfg.security.auditRecorder.Record(record)
+}
+
+// responseSize returns the size of the data of the given response.
+func responseSize(response *elemental.Response) int {
+
+	if response == nil {
+		return 0
+	}
+
+	return len(response.Data)
+}
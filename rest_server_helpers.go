@@ -26,6 +26,27 @@ var (
 	ErrRateLimit = elemental.NewError("Rate Limit", "You have exceeded your rate limit", "bahamut", http.StatusTooManyRequests)
 )
 
+// Keys of the elemental.Request Metadata holding information
+// about the original http request.
+const (
+	HTTPMethodMetadataKey = "bahamut.http.method"
+	HTTPURLMetadataKey    = "bahamut.http.url"
+	HTTPHostMetadataKey   = "bahamut.http.host"
+)
+
+// setRequestHTTPInfo stores the method, the request URI and the host of
+// the given http.Request into the Metadata of the given elemental.Request.
+func setRequestHTTPInfo(req *http.Request, request *elemental.Request) {
+
+	if request.Metadata == nil {
+		request.Metadata = map[string]interface{}{}
+	}
+
+	request.Metadata[HTTPMethodMetadataKey] = req.Method
+	request.Metadata[HTTPURLMetadataKey] = req.URL.RequestURI()
+	request.Metadata[HTTPHostMetadataKey] = req.Host
+}
+
 func setCommonHeader(w http.ResponseWriter, origin string, encoding elemental.EncodingType) {
 
 	if origin == "" {
@@ -38,9 +59,9 @@ func setCommonHeader(w http.ResponseWriter, origin string, encoding elemental.En
 	w.Header().Set("Cache-control", "private, no-transform")
 	w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
 	w.Header().Set("Access-Control-Allow-Origin", origin)
-	w.Header().Set("Access-Control-Expose-Headers", "X-Requested-With, X-Count-Total, X-Namespace, X-Messages, X-Fields")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Requested-With, X-Count-Total, X-Namespace, X-Messages, X-Fields, X-Request-ID")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Accept, Content-Type, Cache-Control, If-Modified-Since, X-Requested-With, X-Count-Total, X-Namespace, X-External-Tracking-Type, X-External-Tracking-ID, X-TLS-Client-Certificate, Accept-Encoding, X-Fields, X-Read-Consistency, X-Write-Consistency")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Accept, Content-Type, Cache-Control, If-Modified-Since, X-Requested-With, X-Count-Total, X-Namespace, X-External-Tracking-Type, X-External-Tracking-ID, X-TLS-Client-Certificate, Accept-Encoding, X-Fields, X-Read-Consistency, X-Write-Consistency, X-Request-ID")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 }
 
@@ -50,14 +71,20 @@ func corsHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func notFoundHandler(w http.ResponseWriter, r *http.Request) {
-	_, writeEncoding, _ := elemental.EncodingFromHeaders(r.Header)
-	setCommonHeader(w, r.Header.Get("Origin"), writeEncoding)
-	writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), ErrNotFound))
+// makeNotFoundHandler returns the handler of the unknown routes.
+// The given logger is used to report the write failures.
+func makeNotFoundHandler(logger *zap.Logger) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, writeEncoding, _ := elemental.EncodingFromHeaders(r.Header)
+		setCommonHeader(w, r.Header.Get("Origin"), writeEncoding)
+		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), ErrNotFound), logger)
+	}
 }
 
 // writeHTTPResponse writes the response into the given http.ResponseWriter.
-func writeHTTPResponse(w http.ResponseWriter, r *elemental.Response) int {
+// The given logger is used to report the write failures.
+func writeHTTPResponse(w http.ResponseWriter, r *elemental.Response, logger *zap.Logger) int {
 
 	// If r is nil, we simply stop.
 	// It mostly means the client closed the connection and
@@ -83,7 +110,7 @@ func writeHTTPResponse(w http.ResponseWriter, r *elemental.Response) int {
 	if r.Data != nil {
 
 		if _, err := w.Write(r.Data); err != nil {
-			zap.L().Debug("Unable to send http response to client", zap.Error(err))
+			logger.Debug("Unable to send http response to client", zap.Error(err))
 		}
 	}
 
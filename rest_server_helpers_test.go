@@ -19,6 +19,7 @@ import (
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
 )
 
 func TestRestServerHelpers_commonHeaders(t *testing.T) {
@@ -35,9 +36,9 @@ func TestRestServerHelpers_commonHeaders(t *testing.T) {
 				So(w.Header().Get("Accept"), ShouldEqual, "application/msgpack,application/json")
 				So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
 				So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "http://toto.com:8443")
-				So(w.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, "X-Requested-With, X-Count-Total, X-Namespace, X-Messages, X-Fields")
+				So(w.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, "X-Requested-With, X-Count-Total, X-Namespace, X-Messages, X-Fields, X-Request-ID")
 				So(w.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS")
-				So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Authorization, Accept, Content-Type, Cache-Control, If-Modified-Since, X-Requested-With, X-Count-Total, X-Namespace, X-External-Tracking-Type, X-External-Tracking-ID, X-TLS-Client-Certificate, Accept-Encoding, X-Fields, X-Read-Consistency, X-Write-Consistency")
+				So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Authorization, Accept, Content-Type, Cache-Control, If-Modified-Since, X-Requested-With, X-Count-Total, X-Namespace, X-External-Tracking-Type, X-External-Tracking-ID, X-TLS-Client-Certificate, Accept-Encoding, X-Fields, X-Read-Consistency, X-Write-Consistency, X-Request-ID")
 				So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
 			})
 		})
@@ -50,15 +51,35 @@ func TestRestServerHelpers_commonHeaders(t *testing.T) {
 				So(w.Header().Get("Accept"), ShouldEqual, "application/msgpack,application/json")
 				So(w.Header().Get("Content-Type"), ShouldEqual, "application/msgpack")
 				So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
-				So(w.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, "X-Requested-With, X-Count-Total, X-Namespace, X-Messages, X-Fields")
+				So(w.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, "X-Requested-With, X-Count-Total, X-Namespace, X-Messages, X-Fields, X-Request-ID")
 				So(w.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS")
-				So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Authorization, Accept, Content-Type, Cache-Control, If-Modified-Since, X-Requested-With, X-Count-Total, X-Namespace, X-External-Tracking-Type, X-External-Tracking-ID, X-TLS-Client-Certificate, Accept-Encoding, X-Fields, X-Read-Consistency, X-Write-Consistency")
+				So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Authorization, Accept, Content-Type, Cache-Control, If-Modified-Since, X-Requested-With, X-Count-Total, X-Namespace, X-External-Tracking-Type, X-External-Tracking-ID, X-TLS-Client-Certificate, Accept-Encoding, X-Fields, X-Read-Consistency, X-Write-Consistency, X-Request-ID")
 				So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
 			})
 		})
 	})
 }
 
+func TestRestServerHelper_setRequestHTTPInfo(t *testing.T) {
+
+	Convey("Given I have a http request and an elemental request", t, func() {
+
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/lists/xx?a=b", nil)
+		request := elemental.NewRequest()
+
+		Convey("When I call setRequestHTTPInfo", func() {
+
+			setRequestHTTPInfo(req, request)
+
+			Convey("Then the metadata should be set", func() {
+				So(request.Metadata[HTTPMethodMetadataKey], ShouldEqual, http.MethodPost)
+				So(request.Metadata[HTTPURLMetadataKey], ShouldEqual, "/lists/xx?a=b")
+				So(request.Metadata[HTTPHostMetadataKey], ShouldEqual, "example.com")
+			})
+		})
+	})
+}
+
 func TestRestServerHelper_corsHandler(t *testing.T) {
 
 	Convey("Given I call the corsHandler", t, func() {
@@ -83,7 +104,7 @@ func TestRestServerHelper_notFoundHandler(t *testing.T) {
 		h.Add("Origin", "toto")
 
 		w := httptest.NewRecorder()
-		notFoundHandler(w, &http.Request{Header: h, URL: &url.URL{Path: "/path"}})
+		makeNotFoundHandler(zap.NewNop())(w, &http.Request{Header: h, URL: &url.URL{Path: "/path"}})
 
 		Convey("Then the response should be correct", func() {
 			So(w.Code, ShouldEqual, http.StatusNotFound)
@@ -99,7 +120,7 @@ func TestRestServerHelper_writeHTTPResponse(t *testing.T) {
 
 		Convey("When I call writeHTTPResponse", func() {
 
-			code := writeHTTPResponse(w, nil)
+			code := writeHTTPResponse(w, nil, zap.NewNop())
 
 			Convey("Then the code should be 0", func() {
 				So(code, ShouldEqual, 0)
@@ -115,7 +136,7 @@ func TestRestServerHelper_writeHTTPResponse(t *testing.T) {
 
 		Convey("When I call writeHTTPResponse", func() {
 
-			code := writeHTTPResponse(w, r)
+			code := writeHTTPResponse(w, r, zap.NewNop())
 
 			Convey("Then the should header Location should be set", func() {
 				So(w.Header().Get("location"), ShouldEqual, "https://la.bas")
@@ -136,7 +157,7 @@ func TestRestServerHelper_writeHTTPResponse(t *testing.T) {
 
 		Convey("When I call writeHTTPResponse", func() {
 
-			code := writeHTTPResponse(w, r)
+			code := writeHTTPResponse(w, r, zap.NewNop())
 
 			Convey("Then the should headers should be correct", func() {
 				So(w.Header().Get("X-Count-Total"), ShouldEqual, "0")
@@ -163,7 +184,7 @@ func TestRestServerHelper_writeHTTPResponse(t *testing.T) {
 
 		Convey("When I call writeHTTPResponse", func() {
 
-			code := writeHTTPResponse(w, r)
+			code := writeHTTPResponse(w, r, zap.NewNop())
 
 			Convey("Then the should header message should be set", func() {
 				So(w.Header().Get("X-Messages"), ShouldEqual, "msg1;msg2")
@@ -185,7 +206,7 @@ func TestRestServerHelper_writeHTTPResponse(t *testing.T) {
 
 		Convey("When I call writeHTTPResponse", func() {
 
-			code := writeHTTPResponse(w, r)
+			code := writeHTTPResponse(w, r, zap.NewNop())
 
 			Convey("Then the body should be correct", func() {
 				So(w.Header().Get("X-Count-Total"), ShouldEqual, "0")
@@ -94,6 +94,29 @@ func TestServer_createSecureHTTPServer(t *testing.T) {
 			})
 		})
 	})
+
+	Convey("Given I create a new api server with a tls reloader", t, func() {
+
+		cfg := config{}
+		cfg.restServer.listenAddress = "address:80"
+		cfg.tls.reloadCertFile = "fixtures/certs/server-cert.pem"
+		cfg.tls.reloadKeyFile = "fixtures/certs/server-key.pem"
+		cfg.tls.reloadClientCAFile = "fixtures/certs/ca-cert.pem"
+		cfg.tls.authType = tls.RequireAndVerifyClientCert
+		c := newRestServer(cfg, bone.New(), nil, nil)
+
+		Convey("When I make a secure server", func() {
+			srv := c.createSecureHTTPServer(cfg.restServer.listenAddress)
+
+			Convey("Then the server should use the reloader", func() {
+				So(c.tlsReloader, ShouldNotBeNil)
+				So(c.isSecure(), ShouldBeTrue)
+				So(srv.TLSConfig.GetCertificate, ShouldNotBeNil)
+				So(srv.TLSConfig.GetConfigForClient, ShouldNotBeNil)
+				So(srv.TLSConfig.Certificates, ShouldBeNil)
+			})
+		})
+	})
 }
 
 func TestServer_createUnsecureHTTPServer(t *testing.T) {
@@ -233,3 +256,54 @@ func TestServer_Start(t *testing.T) {
 		})
 	})
 }
+
+func TestServer_waitRateLimit(t *testing.T) {
+
+	Convey("Given I have a server without rate limiting", t, func() {
+
+		c := newRestServer(config{}, bone.New(), nil, nil)
+
+		Convey("Then waitRateLimit should return nil", func() {
+			So(c.waitRateLimit(context.Background(), "10.0.0.1"), ShouldBeNil)
+		})
+	})
+
+	Convey("Given I have a server with a client rate limiting", t, func() {
+
+		cfg := config{}
+		OptClientRateLimiting(0.001, 1)(&cfg)
+		c := newRestServer(cfg, bone.New(), nil, nil)
+
+		Convey("When a client exceeds its rate", func() {
+
+			So(c.waitRateLimit(context.Background(), "10.0.0.1"), ShouldBeNil)
+			err := c.waitRateLimit(context.Background(), "10.0.0.1")
+
+			Convey("Then it should be limited", func() {
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the other clients should not be limited", func() {
+				So(c.waitRateLimit(context.Background(), "10.0.0.2"), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a server with a global and a client rate limiting", t, func() {
+
+		cfg := config{}
+		OptRateLimiting(0.001, 1)(&cfg)
+		OptClientRateLimiting(10, 10)(&cfg)
+		c := newRestServer(cfg, bone.New(), nil, nil)
+
+		Convey("When two clients send requests", func() {
+
+			So(c.waitRateLimit(context.Background(), "10.0.0.1"), ShouldBeNil)
+			err := c.waitRateLimit(context.Background(), "10.0.0.2")
+
+			Convey("Then the global rate should be enforced", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
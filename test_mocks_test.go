@@ -12,6 +12,7 @@
 package bahamut
 
 import (
+	"context"
 	"net/http"
 	"sync"
 	"time"
@@ -68,6 +69,20 @@ func (p *mockAuditer) GetCallCount() int {
 	return p.nbCalls
 }
 
+// A mockAuditRecorder is a mockable audit recorder
+type mockAuditRecorder struct {
+	records []*AuditRecord
+
+	sync.Mutex
+}
+
+func (p *mockAuditRecorder) Record(r *AuditRecord) {
+
+	p.Lock()
+	p.records = append(p.records, r)
+	p.Unlock()
+}
+
 // A mockAuth is a mockable Authorizer or Authenticator.
 type mockAuth struct {
 	action  AuthAction
@@ -171,7 +186,7 @@ type mockPusher struct {
 	sync.Mutex
 }
 
-func (f *mockPusher) Push(evt ...*elemental.Event) {
+func (f *mockPusher) Push(ctx context.Context, evt ...*elemental.Event) {
 
 	f.Lock()
 	defer f.Unlock()
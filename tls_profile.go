@@ -0,0 +1,165 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"crypto/tls"
+)
+
+// A TLSProfile describes the TLS parameters used by the servers.
+//
+// You can use one of the presets (TLSProfileModern, TLSProfileIntermediate,
+// TLSProfileFIPS) and override any of its fields before passing it to
+// OptTLSProfile.
+type TLSProfile struct {
+
+	// MinVersion is the minimum TLS version to accept.
+	MinVersion uint16
+
+	// MaxVersion is the maximum TLS version to accept.
+	// If it is zero, the maximum version supported by Go is used.
+	MaxVersion uint16
+
+	// CipherSuites is the list of enabled cipher suites for
+	// TLS 1.2 and below. TLS 1.3 cipher suites are not configurable.
+	// If it is empty, Go's default list is used.
+	CipherSuites []uint16
+
+	// CurvePreferences is the list of elliptic curves used
+	// during ECDHE handshakes, in preference order.
+	// If it is empty, Go's default list is used.
+	CurvePreferences []tls.CurveID
+
+	// SessionTicketsEnabled enables TLS session tickets.
+	SessionTicketsEnabled bool
+
+	// NextProtos is the list of supported ALPN protocols, in
+	// preference order. If it is empty, the http server will
+	// advertise h2 and http/1.1.
+	NextProtos []string
+}
+
+// TLSProfileModern returns a profile only accepting TLS 1.3.
+func TLSProfileModern() TLSProfile {
+
+	return TLSProfile{
+		MinVersion: tls.VersionTLS13,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+			tls.CurveP384,
+		},
+	}
+}
+
+// TLSProfileIntermediate returns a profile accepting TLS 1.2 and TLS 1.3
+// with a broad set of forward secret AEAD cipher suites, suitable
+// for general purpose servers talking to older clients.
+func TLSProfileIntermediate() TLSProfile {
+
+	return TLSProfile{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+			tls.CurveP384,
+		},
+	}
+}
+
+// TLSProfileFIPS returns a profile restricted to FIPS 140-2 approved
+// algorithms: TLS 1.2, AES-GCM cipher suites and NIST curves.
+//
+// TLS 1.3 is disabled, as its cipher suites cannot be restricted and
+// always include TLS_CHACHA20_POLY1305_SHA256, which is not approved.
+// Note that this does not make the underlying crypto implementation
+// FIPS validated.
+func TLSProfileFIPS() TLSProfile {
+
+	return TLSProfile{
+		MinVersion: tls.VersionTLS12,
+		MaxVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{
+			tls.CurveP256,
+			tls.CurveP384,
+		},
+	}
+}
+
+// defaultTLSProfile returns the profile used when
+// OptTLSProfile is not set.
+func defaultTLSProfile() TLSProfile {
+
+	return TLSProfile{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+	}
+}
+
+// apply sets the parameters of the profile to the given tls.Config.
+func (p TLSProfile) apply(tlsConfig *tls.Config) {
+
+	tlsConfig.MinVersion = p.MinVersion
+	tlsConfig.MaxVersion = p.MaxVersion
+	tlsConfig.SessionTicketsDisabled = !p.SessionTicketsEnabled
+	tlsConfig.PreferServerCipherSuites = true
+
+	if len(p.CipherSuites) > 0 {
+		tlsConfig.CipherSuites = append([]uint16{}, p.CipherSuites...)
+	}
+
+	if len(p.CurvePreferences) > 0 {
+		tlsConfig.CurvePreferences = append([]tls.CurveID{}, p.CurvePreferences...)
+	}
+
+	if len(p.NextProtos) > 0 {
+		tlsConfig.NextProtos = append([]string{}, p.NextProtos...)
+	}
+}
+
+// makeServerTLSConfig returns a new server *tls.Config configured
+// according to the tls settings of the given config.
+func makeServerTLSConfig(cfg config) *tls.Config {
+
+	tlsConfig := &tls.Config{
+		ClientAuth: cfg.tls.authType,
+		ClientCAs:  cfg.tls.clientCAPool,
+	}
+
+	if cfg.tls.profile != nil {
+		cfg.tls.profile.apply(tlsConfig)
+	} else {
+		defaultTLSProfile().apply(tlsConfig)
+	}
+
+	return tlsConfig
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTLSProfile_presets(t *testing.T) {
+
+	Convey("Given I retrieve the modern profile", t, func() {
+
+		p := TLSProfileModern()
+
+		Convey("Then it should only accept TLS 1.3", func() {
+			So(p.MinVersion, ShouldEqual, tls.VersionTLS13)
+			So(p.MaxVersion, ShouldEqual, 0)
+			So(p.CipherSuites, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given I retrieve the intermediate profile", t, func() {
+
+		p := TLSProfileIntermediate()
+
+		Convey("Then it should accept TLS 1.2", func() {
+			So(p.MinVersion, ShouldEqual, tls.VersionTLS12)
+			So(p.CipherSuites, ShouldContain, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+			So(p.CipherSuites, ShouldContain, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305)
+		})
+	})
+
+	Convey("Given I retrieve the FIPS profile", t, func() {
+
+		p := TLSProfileFIPS()
+
+		Convey("Then it should only use approved algorithms", func() {
+			So(p.MinVersion, ShouldEqual, tls.VersionTLS12)
+			So(p.MaxVersion, ShouldEqual, tls.VersionTLS12)
+			So(p.CipherSuites, ShouldNotContain, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305)
+			So(p.CipherSuites, ShouldNotContain, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305)
+			So(p.CurvePreferences, ShouldResemble, []tls.CurveID{tls.CurveP256, tls.CurveP384})
+		})
+	})
+
+	Convey("Given I modify a preset", t, func() {
+
+		p := TLSProfileIntermediate()
+		p.CipherSuites[0] = 0
+
+		Convey("Then the preset should not be modified", func() {
+			So(TLSProfileIntermediate().CipherSuites[0], ShouldNotEqual, 0)
+		})
+	})
+}
+
+func TestTLSProfile_makeServerTLSConfig(t *testing.T) {
+
+	Convey("Given I have a config without profile", t, func() {
+
+		cfg := config{}
+		cfg.tls.authType = tls.RequireAndVerifyClientCert
+
+		Convey("When I call makeServerTLSConfig", func() {
+
+			tlsConfig := makeServerTLSConfig(cfg)
+
+			Convey("Then the default profile should be used", func() {
+				So(tlsConfig.ClientAuth, ShouldEqual, tls.RequireAndVerifyClientCert)
+				So(tlsConfig.MinVersion, ShouldEqual, tls.VersionTLS12)
+				So(tlsConfig.SessionTicketsDisabled, ShouldBeTrue)
+				So(tlsConfig.CipherSuites, ShouldResemble, defaultTLSProfile().CipherSuites)
+				So(tlsConfig.CurvePreferences, ShouldBeNil)
+				So(tlsConfig.NextProtos, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a config with a customized profile", t, func() {
+
+		p := TLSProfileModern()
+		p.MaxVersion = tls.VersionTLS13
+		p.CurvePreferences = []tls.CurveID{tls.X25519}
+		p.SessionTicketsEnabled = true
+		p.NextProtos = []string{"http/1.1"}
+
+		cfg := config{}
+		cfg.tls.profile = &p
+
+		Convey("When I call makeServerTLSConfig", func() {
+
+			tlsConfig := makeServerTLSConfig(cfg)
+
+			Convey("Then the profile should be used", func() {
+				So(tlsConfig.MinVersion, ShouldEqual, tls.VersionTLS13)
+				So(tlsConfig.MaxVersion, ShouldEqual, tls.VersionTLS13)
+				So(tlsConfig.SessionTicketsDisabled, ShouldBeFalse)
+				So(tlsConfig.CipherSuites, ShouldBeNil)
+				So(tlsConfig.CurvePreferences, ShouldResemble, []tls.CurveID{tls.X25519})
+				So(tlsConfig.NextProtos, ShouldResemble, []string{"http/1.1"})
+			})
+		})
+	})
+}
+
+func TestTLSProfile_FIPSHandshake(t *testing.T) {
+
+	// handshake runs a handshake between a server using the FIPS
+	// profile and a client using the given config and returns the
+	// error of the client and the negotiated state.
+	handshake := func(clientConfig *tls.Config) (tls.ConnectionState, error) {
+
+		cert, err := tls.LoadX509KeyPair("fixtures/certs/server-cert.pem", "fixtures/certs/server-key.pem")
+		if err != nil {
+			panic(err)
+		}
+
+		p := TLSProfileFIPS()
+		cfg := config{}
+		cfg.tls.profile = &p
+
+		serverConfig := makeServerTLSConfig(cfg)
+		serverConfig.Certificates = []tls.Certificate{cert}
+
+		clientConfig.InsecureSkipVerify = true
+
+		sconn, cconn := net.Pipe()
+		defer sconn.Close() // nolint
+		defer cconn.Close() // nolint
+
+		server := tls.Server(sconn, serverConfig)
+		go func() {
+			_ = server.Handshake()
+			_ = sconn.Close()
+		}()
+
+		client := tls.Client(cconn, clientConfig)
+		err = client.Handshake()
+
+		return client.ConnectionState(), err
+	}
+
+	Convey("Given I have a client using AES-GCM and TLS 1.2", t, func() {
+
+		state, err := handshake(&tls.Config{
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		})
+
+		Convey("Then the handshake should succeed", func() {
+			So(err, ShouldBeNil)
+			So(state.Version, ShouldEqual, tls.VersionTLS12)
+			So(state.CipherSuite, ShouldEqual, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+		})
+	})
+
+	Convey("Given I have a client only using ChaCha20 and TLS 1.3", t, func() {
+
+		_, err := handshake(&tls.Config{
+			MinVersion: tls.VersionTLS13,
+			CipherSuites: []uint16{
+				tls.TLS_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			},
+		})
+
+		Convey("Then the handshake should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given I have a client only using ChaCha20", t, func() {
+
+		_, err := handshake(&tls.Config{
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305},
+		})
+
+		Convey("Then the handshake should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
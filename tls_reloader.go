@@ -0,0 +1,253 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// a tlsReloader loads the server certificate, key and client CA pool
+// from disk and reloads them when they change.
+type tlsReloader struct {
+	certFile          string
+	keyFile           string
+	clientCAFile      string
+	interval          time.Duration
+	expirationWarning time.Duration
+	metricsManager    MetricsManager
+	logger            *zap.Logger
+
+	certificate  atomic.Value
+	clientCAPool atomic.Value
+	signature    string
+	warned       bool
+}
+
+// newTLSReloader returns a new tlsReloader.
+func newTLSReloader(cfg config) *tlsReloader {
+
+	interval := cfg.tls.reloadInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	expirationWarning := cfg.tls.expirationWarning
+	if expirationWarning <= 0 {
+		expirationWarning = 7 * 24 * time.Hour
+	}
+
+	return &tlsReloader{
+		certFile:          cfg.tls.reloadCertFile,
+		keyFile:           cfg.tls.reloadKeyFile,
+		clientCAFile:      cfg.tls.reloadClientCAFile,
+		interval:          interval,
+		expirationWarning: expirationWarning,
+		metricsManager:    cfg.healthServer.metricsManager,
+		logger:            cfg.logger(),
+	}
+}
+
+// load loads the files and atomically replaces the active
+// certificate and client CA pool. If anything fails,
+// the current ones are left untouched.
+func (r *tlsReloader) load() error {
+
+	signature, err := r.fileSignature()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load server certificate: %s", err)
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return fmt.Errorf("unable to parse server certificate: %s", err)
+	}
+
+	var pool *x509.CertPool
+	if r.clientCAFile != "" {
+
+		data, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read client CA file: %s", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("unable to find any valid certificate in client CA file")
+		}
+	}
+
+	r.certificate.Store(&cert)
+	if pool != nil {
+		r.clientCAPool.Store(pool)
+	}
+	r.signature = signature
+	r.warned = false
+
+	r.logger.Info("TLS certificate loaded",
+		zap.String("cert", r.certFile),
+		zap.String("client-ca", r.clientCAFile),
+		zap.String("common-name", cert.Leaf.Subject.CommonName),
+		zap.Time("expiration", cert.Leaf.NotAfter),
+	)
+
+	if m, ok := r.metricsManager.(TLSMetricsManager); ok {
+		m.SetTLSCertificateExpiration(cert.Leaf.NotAfter)
+	}
+
+	r.checkExpiration(time.Now())
+
+	return nil
+}
+
+// reloadIfChanged reloads the files if they changed on disk
+// since the last successful load. It returns true if they
+// have been reloaded.
+func (r *tlsReloader) reloadIfChanged() (bool, error) {
+
+	signature, err := r.fileSignature()
+	if err != nil {
+		return false, err
+	}
+
+	if signature == r.signature {
+		return false, nil
+	}
+
+	if err := r.load(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// checkExpiration logs a warning if the active certificate
+// is about to expire. The warning is logged once per loaded
+// certificate.
+func (r *tlsReloader) checkExpiration(now time.Time) {
+
+	cert := r.activeCertificate()
+	if cert == nil || cert.Leaf == nil || r.warned {
+		return
+	}
+
+	if remaining := cert.Leaf.NotAfter.Sub(now); remaining < r.expirationWarning {
+		r.warned = true
+		r.logger.Warn("TLS certificate is about to expire",
+			zap.String("cert", r.certFile),
+			zap.String("common-name", cert.Leaf.Subject.CommonName),
+			zap.Time("expiration", cert.Leaf.NotAfter),
+			zap.Duration("remaining", remaining),
+		)
+	}
+}
+
+// watch checks the files for changes until
+// the given context is canceled.
+func (r *tlsReloader) watch(ctx context.Context) {
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case now := <-ticker.C:
+
+			if _, err := r.reloadIfChanged(); err != nil {
+				r.logger.Error("Unable to reload TLS certificate. Keeping the current one", zap.Error(err))
+			}
+
+			r.checkExpiration(now)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *tlsReloader) activeCertificate() *tls.Certificate {
+
+	cert, _ := r.certificate.Load().(*tls.Certificate)
+	return cert
+}
+
+func (r *tlsReloader) activeClientCAPool() *x509.CertPool {
+
+	pool, _ := r.clientCAPool.Load().(*x509.CertPool)
+	return pool
+}
+
+// getCertificate is a tls.Config.GetCertificate function
+// returning the active certificate.
+func (r *tlsReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	cert := r.activeCertificate()
+	if cert == nil {
+		return nil, errors.New("no tls certificate loaded")
+	}
+
+	return cert, nil
+}
+
+// makeGetConfigForClient returns a tls.Config.GetConfigForClient function
+// returning a copy of the given base config using the active client CA pool.
+func (r *tlsReloader) makeGetConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+
+		pool := r.activeClientCAPool()
+		if pool == nil {
+			return nil, nil
+		}
+
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = pool
+
+		return cfg, nil
+	}
+}
+
+func (r *tlsReloader) fileSignature() (string, error) {
+
+	var signature string
+
+	for _, path := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+
+		signature += fmt.Sprintf("%s:%d-%d;", path, info.ModTime().UnixNano(), info.Size())
+	}
+
+	return signature, nil
+}
@@ -0,0 +1,304 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type tlsExpirationMetricsManager struct {
+	testMetricsManager
+	expiration time.Time
+}
+
+func (m *tlsExpirationMetricsManager) SetTLSCertificateExpiration(notAfter time.Time) {
+	m.expiration = notAfter
+}
+
+func copyFixtureFile(src string, dst string) {
+
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+		panic(err)
+	}
+}
+
+func TestTLSReloader(t *testing.T) {
+
+	Convey("Given I have a tls reloader on valid files", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut-tls-reloader")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		certFile := filepath.Join(dir, "cert.pem")
+		keyFile := filepath.Join(dir, "key.pem")
+		caFile := filepath.Join(dir, "ca.pem")
+
+		copyFixtureFile("fixtures/certs/server-cert.pem", certFile)
+		copyFixtureFile("fixtures/certs/server-key.pem", keyFile)
+		copyFixtureFile("fixtures/certs/ca-cert.pem", caFile)
+
+		mm := &tlsExpirationMetricsManager{}
+
+		cfg := config{}
+		cfg.tls.reloadCertFile = certFile
+		cfg.tls.reloadKeyFile = keyFile
+		cfg.tls.reloadClientCAFile = caFile
+		cfg.healthServer.metricsManager = mm
+
+		r := newTLSReloader(cfg)
+
+		Convey("Then the defaults should be set", func() {
+			So(r.interval, ShouldEqual, time.Minute)
+			So(r.expirationWarning, ShouldEqual, 7*24*time.Hour)
+		})
+
+		Convey("When I call getCertificate before loading", func() {
+
+			cert, err := r.getCertificate(nil)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "no tls certificate loaded")
+				So(cert, ShouldBeNil)
+			})
+		})
+
+		Convey("When I load the files", func() {
+
+			err := r.load()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the certificate should be active", func() {
+				cert, err := r.getCertificate(nil)
+				So(err, ShouldBeNil)
+				So(cert.Leaf, ShouldNotBeNil)
+				So(cert.Leaf.Subject.CommonName, ShouldEqual, "localhost")
+			})
+
+			Convey("Then the expiration should have been reported", func() {
+				So(mm.expiration, ShouldEqual, r.activeCertificate().Leaf.NotAfter)
+			})
+
+			Convey("Then getConfigForClient should use the client CA pool", func() {
+				base := &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}
+				base.GetConfigForClient = r.makeGetConfigForClient(base)
+
+				c, err := base.GetConfigForClient(nil)
+				So(err, ShouldBeNil)
+				So(c, ShouldNotBeNil)
+				So(c.ClientCAs, ShouldEqual, r.activeClientCAPool())
+				So(c.ClientAuth, ShouldEqual, tls.RequireAndVerifyClientCert)
+				So(c.GetConfigForClient, ShouldBeNil)
+			})
+
+			Convey("When I call reloadIfChanged without changes", func() {
+
+				reloaded, err := r.reloadIfChanged()
+
+				Convey("Then nothing should be reloaded", func() {
+					So(err, ShouldBeNil)
+					So(reloaded, ShouldBeFalse)
+				})
+			})
+
+			Convey("When I replace the certificate and call reloadIfChanged", func() {
+
+				previous := r.activeCertificate()
+
+				copyFixtureFile("fixtures/certs/client-cert.pem", certFile)
+				copyFixtureFile("fixtures/certs/client-key.pem", keyFile)
+				future := time.Now().Add(time.Hour)
+				So(os.Chtimes(certFile, future, future), ShouldBeNil)
+
+				reloaded, err := r.reloadIfChanged()
+
+				Convey("Then the new certificate should be active", func() {
+					So(err, ShouldBeNil)
+					So(reloaded, ShouldBeTrue)
+					So(r.activeCertificate().Leaf.Equal(previous.Leaf), ShouldBeFalse)
+				})
+			})
+
+			Convey("When I replace the certificate with garbage and call reloadIfChanged", func() {
+
+				previous := r.activeCertificate()
+
+				So(ioutil.WriteFile(certFile, []byte("not a cert"), 0600), ShouldBeNil)
+				future := time.Now().Add(time.Hour)
+				So(os.Chtimes(certFile, future, future), ShouldBeNil)
+
+				reloaded, err := r.reloadIfChanged()
+
+				Convey("Then the previous certificate should be kept", func() {
+					So(err, ShouldNotBeNil)
+					So(reloaded, ShouldBeFalse)
+					So(r.activeCertificate(), ShouldEqual, previous)
+				})
+			})
+
+			Convey("When I replace the client CA with garbage and call reloadIfChanged", func() {
+
+				previous := r.activeClientCAPool()
+
+				So(ioutil.WriteFile(caFile, []byte("not a cert"), 0600), ShouldBeNil)
+				future := time.Now().Add(time.Hour)
+				So(os.Chtimes(caFile, future, future), ShouldBeNil)
+
+				reloaded, err := r.reloadIfChanged()
+
+				Convey("Then the previous pool should be kept", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "unable to find any valid certificate in client CA file")
+					So(reloaded, ShouldBeFalse)
+					So(r.activeClientCAPool(), ShouldEqual, previous)
+				})
+			})
+
+			Convey("When I remove the key and call reloadIfChanged", func() {
+
+				So(os.Remove(keyFile), ShouldBeNil)
+
+				reloaded, err := r.reloadIfChanged()
+
+				Convey("Then I should get an error", func() {
+					So(err, ShouldNotBeNil)
+					So(reloaded, ShouldBeFalse)
+					So(r.activeCertificate(), ShouldNotBeNil)
+				})
+			})
+
+			Convey("When I watch and replace the certificate", func() {
+
+				r.interval = 10 * time.Millisecond
+				previous := r.activeCertificate()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				done := make(chan struct{})
+				go func() {
+					r.watch(ctx)
+					close(done)
+				}()
+
+				copyFixtureFile("fixtures/certs/client-cert.pem", certFile)
+				copyFixtureFile("fixtures/certs/client-key.pem", keyFile)
+				future := time.Now().Add(time.Hour)
+				So(os.Chtimes(certFile, future, future), ShouldBeNil)
+
+				Convey("Then the new certificate should eventually be active", func() {
+
+					var reloaded bool
+					for i := 0; i < 100; i++ {
+						if reloaded = r.activeCertificate() != previous; reloaded {
+							break
+						}
+						time.Sleep(10 * time.Millisecond)
+					}
+
+					cancel()
+					<-done
+
+					So(reloaded, ShouldBeTrue)
+					So(r.activeCertificate().Leaf.Equal(previous.Leaf), ShouldBeFalse)
+				})
+			})
+		})
+	})
+
+	Convey("Given I have a tls reloader without client CA", t, func() {
+
+		cfg := config{}
+		cfg.tls.reloadCertFile = "fixtures/certs/server-cert.pem"
+		cfg.tls.reloadKeyFile = "fixtures/certs/server-key.pem"
+		cfg.tls.reloadInterval = time.Second
+		cfg.tls.expirationWarning = time.Hour
+
+		r := newTLSReloader(cfg)
+
+		Convey("When I load the files", func() {
+
+			err := r.load()
+
+			Convey("Then getConfigForClient should return nil", func() {
+				So(err, ShouldBeNil)
+				So(r.interval, ShouldEqual, time.Second)
+				So(r.expirationWarning, ShouldEqual, time.Hour)
+
+				c, err := r.makeGetConfigForClient(&tls.Config{})(nil)
+				So(err, ShouldBeNil)
+				So(c, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a tls reloader on a certificate about to expire", t, func() {
+
+		core, logs := observer.New(zap.WarnLevel)
+
+		cfg := config{}
+		cfg.general.logger = zap.New(core)
+		cfg.tls.reloadCertFile = "fixtures/certs/server-cert.pem"
+		cfg.tls.reloadKeyFile = "fixtures/certs/server-key.pem"
+		cfg.tls.expirationWarning = 100 * 365 * 24 * time.Hour
+
+		r := newTLSReloader(cfg)
+
+		Convey("When I load the files", func() {
+
+			err := r.load()
+
+			Convey("Then the expiration should be reported right away", func() {
+				So(err, ShouldBeNil)
+				So(logs.FilterMessage("TLS certificate is about to expire").Len(), ShouldEqual, 1)
+			})
+
+			Convey("When I check the expiration again", func() {
+
+				r.checkExpiration(time.Now())
+				r.checkExpiration(time.Now())
+
+				Convey("Then it should not be reported again", func() {
+					So(logs.FilterMessage("TLS certificate is about to expire").Len(), ShouldEqual, 1)
+				})
+			})
+
+			Convey("When I load the files again", func() {
+
+				So(r.load(), ShouldBeNil)
+
+				Convey("Then it should be reported for the new certificate", func() {
+					So(logs.FilterMessage("TLS certificate is about to expire").Len(), ShouldEqual, 2)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,327 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.aporeto.io/elemental"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ForceTraceHeader is the default header a client can set to a
+// true value (1, t, true...) to force the tracing of its request.
+const ForceTraceHeader = "X-Force-Trace"
+
+// Various sampling reasons set in the sampling.reason
+// tag of the traced requests.
+const (
+	traceSamplingReasonForced      = "forced"
+	traceSamplingReasonProbability = "probability"
+	traceSamplingReasonError       = "error"
+	traceSamplingReasonLatency     = "latency"
+)
+
+// A TraceSamplingRule sets the probability, between 0 and 1, of tracing the
+// requests on the given identity with the given operation. An empty identity
+// matches all identities and an empty operation matches all operations, so a
+// rule with neither matches all the requests matching no other rule.
+type TraceSamplingRule struct {
+	Identity    elemental.Identity
+	Operation   elemental.Operation
+	Probability float64
+}
+
+// A TraceSamplingPolicy decides which requests are traced.
+//
+// A request is traced if the client forces it with the ForceHeader, or
+// according to the probability of the most specific matching rule, an
+// identity and operation rule being more specific than an identity rule,
+// itself more specific than an operation rule.
+//
+// If a request is not sampled that way but SampleErrors or LatencyThreshold
+// is set, the request is traced anyway and the decision is taken once it
+// is finished. The spans started by bahamut for the request are then only
+// reported if the request ended with a 5xx status code or a panic, or took
+// longer than the LatencyThreshold. Until then, the publications do not
+// carry the trace context, and the errors returned to the clients only
+// carry the trace ID if they are enough to keep the trace. Spans started
+// by the processors themselves are not affected.
+//
+// Requests on the identities excluded with OptOpentracingExcludedIdentities
+// are never traced.
+type TraceSamplingPolicy struct {
+
+	// Rules are the sampling rules.
+	Rules []TraceSamplingRule
+
+	// DefaultProbability is the probability to trace the
+	// requests that match no rule.
+	DefaultProbability float64
+
+	// SampleErrors always keeps the traces of the requests
+	// that end with a 5xx status code or a panic.
+	SampleErrors bool
+
+	// LatencyThreshold, if not zero, always keeps the traces
+	// of the requests that take longer than it.
+	LatencyThreshold time.Duration
+
+	// ForceHeader is the header the clients can set to a true value to
+	// force the tracing of their requests. It defaults to ForceTraceHeader.
+	ForceHeader string
+}
+
+// A traceSampler applies a TraceSamplingPolicy.
+type traceSampler struct {
+	policy             TraceSamplingPolicy
+	rules              map[string]float64
+	excludedIdentities map[string]struct{}
+	random             func() float64
+}
+
+// newTraceSampler returns a new traceSampler from the given
+// config, or nil if no sampling policy is configured.
+func newTraceSampler(cfg config) *traceSampler {
+
+	if cfg.opentracing.samplingPolicy == nil {
+		return nil
+	}
+
+	policy := *cfg.opentracing.samplingPolicy
+	if policy.ForceHeader == "" {
+		policy.ForceHeader = ForceTraceHeader
+	}
+
+	rules := make(map[string]float64, len(policy.Rules))
+	for _, rule := range policy.Rules {
+		rules[traceSamplingRuleKey(rule.Identity.Name, rule.Operation)] = rule.Probability
+	}
+
+	return &traceSampler{
+		policy:             policy,
+		rules:              rules,
+		excludedIdentities: cfg.opentracing.excludedIdentities,
+		random:             rand.Float64,
+	}
+}
+
+func traceSamplingRuleKey(identity string, operation elemental.Operation) string {
+	return identity + "|" + string(operation)
+}
+
+// probability returns the sampling probability of the given request.
+func (s *traceSampler) probability(r *elemental.Request) float64 {
+
+	for _, key := range []string{
+		traceSamplingRuleKey(r.Identity.Name, r.Operation),
+		traceSamplingRuleKey(r.Identity.Name, ""),
+		traceSamplingRuleKey("", r.Operation),
+		traceSamplingRuleKey("", ""),
+	} {
+		if p, ok := s.rules[key]; ok {
+			return p
+		}
+	}
+
+	return s.policy.DefaultProbability
+}
+
+// sample returns whether the given request must be traced. If the decision
+// depends on the outcome of the request, it returns true and a copy of the
+// given context holding the traceTail that takes it when tracing finishes.
+// It returns the reason of the decision when it is already taken.
+func (s *traceSampler) sample(ctx context.Context, r *elemental.Request, start time.Time) (context.Context, bool, string) {
+
+	if s == nil {
+		return ctx, true, ""
+	}
+
+	// Requests on excluded identities are never traced.
+	if _, ok := s.excludedIdentities[r.Identity.Name]; ok {
+		return ctx, false, ""
+	}
+
+	if forced, _ := strconv.ParseBool(r.Headers.Get(s.policy.ForceHeader)); forced {
+		return ctx, true, traceSamplingReasonForced
+	}
+
+	if p := s.probability(r); p > 0 && s.random() < p {
+		return ctx, true, traceSamplingReasonProbability
+	}
+
+	if !s.policy.SampleErrors && s.policy.LatencyThreshold <= 0 {
+		return ctx, false, ""
+	}
+
+	return context.WithValue(ctx, traceTailContextKey{}, &traceTail{
+		start:            start,
+		sampleErrors:     s.policy.SampleErrors,
+		latencyThreshold: s.policy.LatencyThreshold,
+	}), true, ""
+}
+
+type traceTailContextKey struct{}
+
+// A traceTail holds the tail-based sampling decision of a request.
+// Until the decision is taken, the spans of the request are not
+// finished, but their finish functions are kept with their finish time.
+// Once it is taken, the spans are finished right away if the trace is
+// kept, or dropped otherwise.
+type traceTail struct {
+	start            time.Time
+	sampleErrors     bool
+	latencyThreshold time.Duration
+	statusCode       int
+	panicked         bool
+	finishers        []func()
+	decided          bool
+	kept             bool
+	lock             sync.Mutex
+}
+
+func traceTailFromContext(ctx context.Context) *traceTail {
+
+	tail, _ := ctx.Value(traceTailContextKey{}).(*traceTail)
+
+	return tail
+}
+
+// setTraceStatusCode records the final status code of the request
+// traced with the given context for the tail-based sampling decision.
+func setTraceStatusCode(ctx context.Context, code int) {
+
+	if tail := traceTailFromContext(ctx); tail != nil {
+		tail.lock.Lock()
+		tail.statusCode = code
+		tail.lock.Unlock()
+	}
+}
+
+// setTracePanicked records that the request traced with the given
+// context panicked for the tail-based sampling decision.
+func setTracePanicked(ctx context.Context) {
+
+	if tail := traceTailFromContext(ctx); tail != nil {
+		tail.lock.Lock()
+		tail.panicked = true
+		tail.lock.Unlock()
+	}
+}
+
+// traceKept returns false if the request traced with the given context
+// uses tail-based sampling and its trace is not kept yet, in which case
+// its trace context must not be propagated.
+func traceKept(ctx context.Context) bool {
+
+	tail := traceTailFromContext(ctx)
+	if tail == nil {
+		return true
+	}
+
+	tail.lock.Lock()
+	defer tail.lock.Unlock()
+
+	return tail.decided && tail.kept
+}
+
+// finishSpan calls the given finish function with the current time, or
+// keeps it until the sampling decision of the trace is taken if the
+// request traced with the given context uses tail-based sampling.
+func finishSpan(ctx context.Context, finish func(time.Time)) {
+
+	now := time.Now()
+
+	tail := traceTailFromContext(ctx)
+	if tail == nil {
+		finish(now)
+		return
+	}
+
+	tail.lock.Lock()
+	if !tail.decided {
+		tail.finishers = append(tail.finishers, func() { finish(now) })
+		tail.lock.Unlock()
+		return
+	}
+	kept := tail.kept
+	tail.lock.Unlock()
+
+	if kept {
+		finish(now)
+	}
+}
+
+// decide returns whether the trace must be kept and why.
+func (t *traceTail) decide() (bool, string) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.sampleErrors && (t.panicked || t.statusCode >= http.StatusInternalServerError) {
+		return true, traceSamplingReasonError
+	}
+
+	if t.latencyThreshold > 0 && time.Since(t.start) > t.latencyThreshold {
+		return true, traceSamplingReasonLatency
+	}
+
+	return false, ""
+}
+
+// flush finishes the spans kept until the decision. The
+// spans finishing afterwards are finished right away.
+func (t *traceTail) flush() {
+
+	t.lock.Lock()
+	finishers := t.finishers
+	t.finishers = nil
+	t.decided = true
+	t.kept = true
+	t.lock.Unlock()
+
+	for _, finish := range finishers {
+		finish()
+	}
+}
+
+// drop drops the spans kept until the decision. The
+// spans finishing afterwards are dropped right away.
+func (t *traceTail) drop() {
+
+	t.lock.Lock()
+	t.finishers = nil
+	t.decided = true
+	t.lock.Unlock()
+}
+
+// setSamplingReason sets the sampling reason on the spans of the request.
+func setSamplingReason(ctx context.Context, reason string) {
+
+	if reason == "" {
+		return
+	}
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag("sampling.reason", reason)
+	}
+
+	if span := otelSpanFromContext(ctx); span != nil {
+		span.SetAttributes(attribute.String("sampling.reason", reason))
+	}
+}
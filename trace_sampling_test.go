@@ -0,0 +1,264 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+func TestTraceSampling_sample(t *testing.T) {
+
+	listIdentity := elemental.MakeIdentity("list", "lists")
+	taskIdentity := elemental.MakeIdentity("task", "tasks")
+
+	makeRequest := func(identity elemental.Identity, operation elemental.Operation) *elemental.Request {
+		r := elemental.NewRequest()
+		r.Identity = identity
+		r.Operation = operation
+		r.Headers = http.Header{}
+		return r
+	}
+
+	Convey("Given I have no sampling policy", t, func() {
+
+		s := newTraceSampler(config{})
+
+		Convey("Then every request should be sampled", func() {
+			ctx, sampled, reason := s.sample(context.Background(), makeRequest(listIdentity, elemental.OperationRetrieveMany), time.Now())
+			So(sampled, ShouldBeTrue)
+			So(reason, ShouldEqual, "")
+			So(traceTailFromContext(ctx), ShouldBeNil)
+		})
+	})
+
+	Convey("Given I have a sampling policy with rules", t, func() {
+
+		cfg := config{}
+		OptTraceSamplingPolicy(TraceSamplingPolicy{
+			Rules: []TraceSamplingRule{
+				{Identity: listIdentity, Operation: elemental.OperationRetrieveMany, Probability: 0.01},
+				{Identity: listIdentity, Probability: 0.5},
+				{Operation: elemental.OperationDelete, Probability: 1},
+			},
+			DefaultProbability: 0.1,
+		})(&cfg)
+
+		s := newTraceSampler(cfg)
+
+		var random float64
+		s.random = func() float64 { return random }
+
+		Convey("Then the most specific rule should apply", func() {
+
+			So(s.probability(makeRequest(listIdentity, elemental.OperationRetrieveMany)), ShouldEqual, 0.01)
+			So(s.probability(makeRequest(listIdentity, elemental.OperationDelete)), ShouldEqual, 0.5)
+			So(s.probability(makeRequest(taskIdentity, elemental.OperationDelete)), ShouldEqual, 1)
+			So(s.probability(makeRequest(taskIdentity, elemental.OperationCreate)), ShouldEqual, 0.1)
+		})
+
+		Convey("Then a rule without identity nor operation should match the other requests", func() {
+
+			cfg.opentracing.samplingPolicy.Rules = append(cfg.opentracing.samplingPolicy.Rules, TraceSamplingRule{Probability: 0.2})
+			s := newTraceSampler(cfg)
+
+			So(s.probability(makeRequest(listIdentity, elemental.OperationDelete)), ShouldEqual, 0.5)
+			So(s.probability(makeRequest(taskIdentity, elemental.OperationCreate)), ShouldEqual, 0.2)
+		})
+
+		Convey("Then the probability should decide", func() {
+
+			random = 0.05
+			_, sampled, reason := s.sample(context.Background(), makeRequest(listIdentity, elemental.OperationRetrieveMany), time.Now())
+			So(sampled, ShouldBeFalse)
+			So(reason, ShouldEqual, "")
+
+			_, sampled, reason = s.sample(context.Background(), makeRequest(taskIdentity, elemental.OperationCreate), time.Now())
+			So(sampled, ShouldBeTrue)
+			So(reason, ShouldEqual, traceSamplingReasonProbability)
+		})
+
+		Convey("Then the force header should force the sampling", func() {
+
+			random = 0.99
+			r := makeRequest(listIdentity, elemental.OperationRetrieveMany)
+			r.Headers.Set(ForceTraceHeader, "true")
+
+			_, sampled, reason := s.sample(context.Background(), r, time.Now())
+			So(sampled, ShouldBeTrue)
+			So(reason, ShouldEqual, traceSamplingReasonForced)
+
+			r.Headers.Set(ForceTraceHeader, "nope")
+			_, sampled, _ = s.sample(context.Background(), r, time.Now())
+			So(sampled, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given I have a sampling policy with tail-based sampling", t, func() {
+
+		cfg := config{}
+		OptTraceSamplingPolicy(TraceSamplingPolicy{
+			SampleErrors:     true,
+			LatencyThreshold: time.Second,
+			ForceHeader:      "X-Trace",
+		})(&cfg)
+
+		s := newTraceSampler(cfg)
+
+		Convey("When I sample a request on an excluded identity", func() {
+
+			OptOpentracingExcludedIdentities([]elemental.Identity{listIdentity})(&cfg)
+			s := newTraceSampler(cfg)
+
+			ctx, sampled, _ := s.sample(context.Background(), makeRequest(listIdentity, elemental.OperationCreate), time.Now())
+
+			Convey("Then it should not be traced", func() {
+				So(sampled, ShouldBeFalse)
+				So(traceTailFromContext(ctx), ShouldBeNil)
+			})
+		})
+
+		Convey("When I sample a request", func() {
+
+			start := time.Now()
+			ctx, sampled, reason := s.sample(context.Background(), makeRequest(listIdentity, elemental.OperationCreate), start)
+			tail := traceTailFromContext(ctx)
+
+			Convey("Then the decision should be deferred", func() {
+				So(sampled, ShouldBeTrue)
+				So(reason, ShouldEqual, "")
+				So(tail, ShouldNotBeNil)
+			})
+
+			Convey("Then it should be dropped if it is fast and successful", func() {
+				setTraceStatusCode(ctx, http.StatusOK)
+				keep, _ := tail.decide()
+				So(keep, ShouldBeFalse)
+			})
+
+			Convey("Then it should be kept if it ends with an error", func() {
+				setTraceStatusCode(ctx, http.StatusBadGateway)
+				keep, reason := tail.decide()
+				So(keep, ShouldBeTrue)
+				So(reason, ShouldEqual, traceSamplingReasonError)
+			})
+
+			Convey("Then it should be dropped if it ends with a client error", func() {
+				setTraceStatusCode(ctx, http.StatusNotFound)
+				keep, _ := tail.decide()
+				So(keep, ShouldBeFalse)
+			})
+
+			Convey("Then it should be kept if it panicked", func() {
+				setTracePanicked(ctx)
+				keep, reason := tail.decide()
+				So(keep, ShouldBeTrue)
+				So(reason, ShouldEqual, traceSamplingReasonError)
+			})
+
+			Convey("Then it should be kept if it is slow", func() {
+				tail.start = start.Add(-2 * time.Second)
+				keep, reason := tail.decide()
+				So(keep, ShouldBeTrue)
+				So(reason, ShouldEqual, traceSamplingReasonLatency)
+			})
+		})
+	})
+}
+
+func TestTraceSampling_finishTracing(t *testing.T) {
+
+	Convey("Given I have a request traced with tail-based sampling", t, func() {
+
+		otTracer := mocktracer.New()
+		otelTracer, exporter := newTestTracer()
+
+		cfg := config{}
+		OptTraceSamplingPolicy(TraceSamplingPolicy{SampleErrors: true})(&cfg)
+		s := newTraceSampler(cfg)
+
+		req, _ := http.NewRequest(http.MethodPost, "http://server/lists", nil)
+		r := elemental.NewRequest()
+		r.Identity = elemental.MakeIdentity("list", "lists")
+		r.Operation = elemental.OperationCreate
+		r.Headers = http.Header{}
+
+		ctx, _, _ := s.sample(context.Background(), r, time.Now())
+		ctx = traceRequest(ctx, r, otTracer, nil, nil)
+		ctx = traceRequestOTel(ctx, req, r, otelTracer, nil, nil)
+
+		bctx := newContext(ctx, r)
+
+		var processorEnd time.Time
+		_ = runProcessor(bctx, nil, func() error {
+			processorEnd = time.Now()
+			return errors.New("boom")
+		})
+
+		Convey("When the request succeeds", func() {
+
+			setTraceStatusCode(ctx, http.StatusCreated)
+			finishTracing(ctx)
+
+			Convey("Then no span should be reported", func() {
+				So(len(exporter.GetSpans()), ShouldEqual, 0)
+				So(len(otTracer.FinishedSpans()), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the request fails", func() {
+
+			time.Sleep(10 * time.Millisecond)
+			setTraceStatusCode(ctx, http.StatusInternalServerError)
+			finishTracing(ctx)
+
+			Convey("Then all the spans should be reported", func() {
+
+				spans := exporter.GetSpans()
+				So(len(spans), ShouldEqual, 2)
+				So(spans[0].Name, ShouldEqual, "bahamut.process.create.lists")
+				So(spans[0].EndTime.Sub(processorEnd), ShouldBeLessThan, 10*time.Millisecond)
+				So(otelAttribute(spans[1], "sampling.reason").AsString(), ShouldEqual, traceSamplingReasonError)
+
+				So(len(otTracer.FinishedSpans()), ShouldEqual, 1)
+				So(otTracer.FinishedSpans()[0].Tag("sampling.reason"), ShouldEqual, traceSamplingReasonError)
+			})
+
+			Convey("Then the spans finishing afterwards should be reported right away", func() {
+
+				_ = runProcessor(bctx, nil, func() error { return nil })
+
+				So(len(exporter.GetSpans()), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the request succeeds before the processor returns", func() {
+
+			setTraceStatusCode(ctx, http.StatusCreated)
+			finishTracing(ctx)
+
+			_ = runProcessor(bctx, nil, func() error { return nil })
+
+			Convey("Then the spans finishing afterwards should be dropped", func() {
+				So(len(exporter.GetSpans()), ShouldEqual, 0)
+				So(traceTailFromContext(ctx).finishers, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -34,7 +34,10 @@ func handleRecoveredPanic(ctx context.Context, r interface{}, disablePanicRecove
 	err := elemental.NewError("Internal Server Error", fmt.Sprintf("%v", r), "bahamut", http.StatusInternalServerError)
 
 	st := string(debug.Stack())
-	zap.L().Error("panic", zap.String("stacktrace", st))
+	loggerFromContext(ctx).Error("panic",
+		zap.String("request-id", RequestIDFromContext(ctx)),
+		zap.String("stacktrace", st),
+	)
 
 	// Print the panic as it would have happened
 	fmt.Fprintf(os.Stderr, "panic: %s\n\n%s", err, st) // nolint: errcheck
@@ -49,10 +52,12 @@ func handleRecoveredPanic(ctx context.Context, r interface{}, disablePanicRecove
 		)
 	}
 
+	recordOTelPanic(ctx, err, st)
+	setTracePanicked(ctx)
+	setMeasurePanicked(ctx)
+
 	if disablePanicRecovery {
-		if sp != nil {
-			sp.Finish()
-		}
+		finishTracing(ctx)
 		panic(err)
 	}
 
@@ -69,11 +74,57 @@ func extractSpanID(span opentracing.Span) string {
 	return spanID
 }
 
+// traceIDFromContext returns the ID of the trace of the request with
+// the given context, using the OpenTracing span, or the OpenTelemetry
+// one if there is none or if its ID cannot be extracted.
+func traceIDFromContext(ctx context.Context) (string, bool) {
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if id := extractSpanID(span); id != "unknown" {
+			return id, true
+		}
+	}
+
+	return otelTraceID(ctx)
+}
+
+// keptTraceID returns the ID of the trace of the request with the given
+// context, ended with the given status code, to attach to its measurement
+// or its errors. It returns an empty string if the request is not traced,
+// or if its trace is dropped by the tail-based sampling.
+func keptTraceID(ctx context.Context, code int) string {
+
+	// The decision taken now can only be to drop a trace that
+	// is eventually kept as the request gets slower, never the
+	// opposite, so the returned ID never points to a missing trace.
+	if tail := traceTailFromContext(ctx); tail != nil {
+		setTraceStatusCode(ctx, code)
+		if keep, _ := tail.decide(); !keep {
+			return ""
+		}
+	}
+
+	id, ok := traceIDFromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return id
+}
+
 func processError(ctx context.Context, err error) (outError elemental.Errors) {
 
 	span := opentracing.SpanFromContext(ctx)
 
-	outError = elemental.NewErrors(err).Trace(extractSpanID(span))
+	outError = elemental.NewErrors(err)
+
+	traceID := "unknown"
+	if id := keptTraceID(ctx, outError.Code()); id != "" {
+		traceID = id
+	}
+
+	outError = outError.Trace(traceID)
+	recordOTelError(ctx, outError)
 
 	if span != nil {
 		span.SetTag("error", true)
@@ -17,6 +17,7 @@ import (
 	"net/http"
 	"sync"
 	"testing"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	. "github.com/smartystreets/goconvey/convey"
@@ -353,3 +354,104 @@ func TestExtractSpanID(t *testing.T) {
 		})
 	})
 }
+
+func TestUtils_traceIDFromContext(t *testing.T) {
+
+	Convey("Given I have a context with an opentracing span", t, func() {
+
+		ctx := opentracing.ContextWithSpan(context.Background(), &mockSpan{})
+
+		Convey("Then the trace ID should be the one of the span", func() {
+			id, ok := traceIDFromContext(ctx)
+			So(ok, ShouldBeTrue)
+			So(id, ShouldEqual, "1234567890")
+		})
+	})
+
+	Convey("Given I have a context with an OpenTelemetry span", t, func() {
+
+		tracer, _ := newTestTracer()
+		ctx, span := tracer.Start(context.Background(), "test")
+		defer span.End()
+
+		Convey("Then the trace ID should be the one of the span", func() {
+			id, ok := traceIDFromContext(ctx)
+			So(ok, ShouldBeTrue)
+			So(id, ShouldEqual, span.SpanContext().TraceID().String())
+		})
+	})
+
+	Convey("Given I have a context with an opentracing span without ID and an OpenTelemetry span", t, func() {
+
+		tracer, _ := newTestTracer()
+		ctx, span := tracer.Start(context.Background(), "test")
+		defer span.End()
+
+		ctx = opentracing.ContextWithSpan(ctx, opentracing.NoopTracer{}.StartSpan("test"))
+
+		Convey("Then the trace ID should be the one of the OpenTelemetry span", func() {
+			id, ok := traceIDFromContext(ctx)
+			So(ok, ShouldBeTrue)
+			So(id, ShouldEqual, span.SpanContext().TraceID().String())
+		})
+	})
+
+	Convey("Given I have a context with an opentracing span without ID", t, func() {
+
+		ctx := opentracing.ContextWithSpan(context.Background(), opentracing.NoopTracer{}.StartSpan("test"))
+
+		Convey("Then there should be no trace ID", func() {
+			id, ok := traceIDFromContext(ctx)
+			So(ok, ShouldBeFalse)
+			So(id, ShouldBeEmpty)
+			So(keptTraceID(ctx, 200), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given I have a context without span", t, func() {
+
+		Convey("Then there should be no trace ID", func() {
+			id, ok := traceIDFromContext(context.Background())
+			So(ok, ShouldBeFalse)
+			So(id, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestUtils_keptTraceID(t *testing.T) {
+
+	Convey("Given I have a request traced with tail-based sampling", t, func() {
+
+		tracer, _ := newTestTracer()
+
+		ctx := context.WithValue(context.Background(), traceTailContextKey{}, &traceTail{start: time.Now(), sampleErrors: true})
+		ctx, span := tracer.Start(ctx, "test")
+		defer span.End()
+
+		Convey("Then the trace ID should not be attached if the trace is dropped", func() {
+			So(keptTraceID(ctx, 200), ShouldBeEmpty)
+		})
+
+		Convey("Then the trace ID should be attached if the trace is kept", func() {
+			So(keptTraceID(ctx, 500), ShouldEqual, span.SpanContext().TraceID().String())
+		})
+	})
+	Convey("Given I have an error of a request traced with tail-based sampling", t, func() {
+
+		tracer, _ := newTestTracer()
+
+		ctx := context.WithValue(context.Background(), traceTailContextKey{}, &traceTail{start: time.Now(), sampleErrors: true})
+		ctx, span := tracer.Start(ctx, "test")
+		defer span.End()
+
+		Convey("Then the trace ID should not be stamped if the trace is dropped", func() {
+			errOut := processError(ctx, elemental.NewError("boom", "blang", "sub", http.StatusNotFound))
+			So(errOut.Error(), ShouldEqual, "error 404 (sub): boom: blang [trace: unknown]")
+		})
+
+		Convey("Then the trace ID should be stamped if the trace is kept", func() {
+			errOut := processError(ctx, errors.New("boom"))
+			So(errOut.Error(), ShouldContainSubstring, "[trace: "+span.SpanContext().TraceID().String()+"]")
+		})
+	})
+}
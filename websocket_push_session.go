@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,8 +30,27 @@ import (
 
 type unregisterFunc func(*wsPushSession)
 
+// A sessionEvent is an event pushed to a session along with the
+// ID of the request that caused it, the attributes it changed and
+// the span tracing its dispatch, if any.
+type sessionEvent struct {
+	event     *elemental.Event
+	requestID string
+	changes   AttributeChanges
+	span      pushSpan
+}
+
+// A pushedEvent is an event written to a push session along with
+// the ID of the request that caused it, so the clients can match
+// the events with their own requests, and the attributes it changed.
+type pushedEvent struct {
+	*elemental.Event
+	RequestID string           `msgpack:"requestID,omitempty" json:"requestID,omitempty"`
+	Changes   AttributeChanges `msgpack:"changes,omitempty" json:"changes,omitempty"`
+}
+
 type wsPushSession struct {
-	events             chan *elemental.Event
+	events             chan sessionEvent
 	filters            chan *elemental.PushFilter
 	filter             *elemental.PushFilter
 	currentFilterLock  sync.RWMutex
@@ -52,6 +72,11 @@ type wsPushSession struct {
 	closeCh            chan struct{}
 	encodingRead       elemental.EncodingType
 	encodingWrite      elemental.EncodingType
+	token              string
+	subprotocol        string
+	tokenFromCookie    bool
+	logger             *zap.Logger
+	requestID          string
 }
 
 func newWSPushSession(
@@ -64,9 +89,11 @@ func newWSPushSession(
 
 	id := uuid.Must(uuid.NewV4()).String()
 	ctx, cancel := context.WithCancel(request.Context())
+	requestID := requestIDFromHeaders(request.Header)
+	token, subprotocol, tokenFromCookie := tokenFromRequest(request, cfg.pushServer.tokenCookie, cfg.pushServer.tokenProtocol)
 
 	return &wsPushSession{
-		events:             make(chan *elemental.Event),
+		events:             make(chan sessionEvent),
 		filters:            make(chan *elemental.PushFilter),
 		id:                 id,
 		claims:             []string{},
@@ -83,19 +110,37 @@ func newWSPushSession(
 		remoteAddr:         request.RemoteAddr,
 		encodingRead:       encodingRead,
 		encodingWrite:      encodingWrite,
+		token:              token,
+		subprotocol:        subprotocol,
+		tokenFromCookie:    tokenFromCookie,
+		logger: cfg.logger().With(
+			zap.String("session", id),
+			zap.String("request-id", requestID),
+		),
+		requestID: requestID,
 	}
 }
 
 func (s *wsPushSession) DirectPush(events ...*elemental.Event) {
 
 	for _, event := range events {
+		s.pushEvent(event, "", nil, pushSpan{})
+	}
+}
 
-		if event.Timestamp.Before(s.startTime) {
-			continue
-		}
+// pushEvent pushes the given event, caused by the request with the
+// given ID and changing the given attributes, to the session. The given
+// span is finished once the event is written or filtered out.
+func (s *wsPushSession) pushEvent(event *elemental.Event, requestID string, changes AttributeChanges, span pushSpan) {
 
-		s.events <- event
+	if event.Timestamp.Before(s.startTime) {
+		span.setTag("dispatch.filtered", true)
+		span.setTag("dispatch.filter_reason", "before_session_start")
+		span.finish()
+		return
 	}
+
+	s.events <- sessionEvent{event: event, requestID: requestID, changes: changes, span: span}
 }
 
 func (s *wsPushSession) String() string {
@@ -113,8 +158,8 @@ func (s *wsPushSession) SetClaims(claims []string) {
 func (s *wsPushSession) Identifier() string                            { return s.id }
 func (s *wsPushSession) Claims() []string                              { return s.claims }
 func (s *wsPushSession) ClaimsMap() map[string]string                  { return s.claimsMap }
-func (s *wsPushSession) Token() string                                 { return s.Parameter("token") }
 func (s *wsPushSession) Context() context.Context                      { return s.ctx }
+func (s *wsPushSession) Logger() *zap.Logger                           { return withSubject(s.logger, s.claimsMap) }
 func (s *wsPushSession) TLSConnectionState() *tls.ConnectionState      { return s.tlsConnectionState }
 func (s *wsPushSession) Metadata() interface{}                         { return s.metadata }
 func (s *wsPushSession) SetMetadata(m interface{})                     { s.metadata = m }
@@ -123,6 +168,23 @@ func (s *wsPushSession) setConn(conn wsc.Websocket)                    { s.conn
 func (s *wsPushSession) close(code int)                                { s.conn.Close(code) }
 func (s *wsPushSession) setTLSConnectionState(st *tls.ConnectionState) { s.tlsConnectionState = st }
 
+func (s *wsPushSession) ClientIP() string {
+
+	return resolveClientIP(s.remoteAddr, s.headers, s.cfg.restServer.trustedProxies)
+}
+
+// Token returns the token of the session. It is read from the token
+// query parameter, or from the Authorization header, the token cookie
+// or the token subprotocol.
+func (s *wsPushSession) Token() string {
+
+	if token := s.Parameter("token"); token != "" {
+		return token
+	}
+
+	return s.token
+}
+
 func (s *wsPushSession) Parameter(key string) string {
 
 	s.parametersLock.RLock()
@@ -174,29 +236,50 @@ func (s *wsPushSession) listen() {
 
 	for {
 		select {
-		case event := <-s.events:
+		case se := <-s.events:
+
+			event, span := se.event, se.span
 
 			f := s.currentFilter()
 			if f != nil && f.IsFilteredOut(event.Identity, event.Type) {
+				span.setTag("dispatch.filtered", true)
+				span.setTag("dispatch.filter_reason", "push_filter")
+				span.finish()
 				break
 			}
 
+			span.setTag("dispatch.filtered", false)
+
 			// We convert the inner Entity to the requested encoding. We don't need additional
 			// check as elemental.Convert will do anything if the EncodingTypes are identical.
 			if err := event.Convert(s.encodingWrite); err != nil {
-				zap.L().Error("Unable to convert event", zap.Error(err))
+				s.logger.Error("Unable to convert event", zap.Error(err))
+				span.setError(err)
+				span.finish()
 				s.close(websocket.CloseInternalServerErr)
 				return
 			}
 
-			data, err := elemental.Encode(s.encodingWrite, event)
+			var payload interface{} = event
+			if se.requestID != "" || len(se.changes) > 0 {
+				payload = &pushedEvent{Event: event, RequestID: se.requestID, Changes: se.changes}
+			}
+
+			data, err := elemental.Encode(s.encodingWrite, payload)
 			if err != nil {
-				zap.L().Error("Unable to encode event", zap.Error(err))
+				s.logger.Error("Unable to encode event", zap.Error(err))
+				span.setError(err)
+				span.finish()
 				s.close(websocket.CloseInternalServerErr)
 				return
 			}
 
+			// Write only queues the data for the writer
+			// of the websocket connection.
+			start := time.Now()
 			s.conn.Write(data)
+			span.setTag("dispatch.enqueue_latency", time.Since(start))
+			span.finish()
 
 		case data := <-s.conn.Read():
 
@@ -208,7 +291,7 @@ func (s *wsPushSession) listen() {
 			s.setCurrentFilter(filter)
 
 		case err := <-s.conn.Error():
-			zap.L().Error("Error received from websocket", zap.String("session", s.id), zap.Error(err))
+			s.logger.Error("Error received from websocket", zap.Error(err))
 
 		case <-s.conn.Done():
 			return
@@ -219,3 +302,50 @@ func (s *wsPushSession) listen() {
 		}
 	}
 }
+
+// tokenFromRequest returns the token found in the Authorization header,
+// in the cookie with the given name or in the subprotocols using the given
+// prefix, in that order. If the token comes from a subprotocol, it also
+// returns the subprotocol the server must echo during the upgrade. It
+// returns true if the token comes from the cookie.
+func tokenFromRequest(req *http.Request, cookieName string, protocolPrefix string) (token string, subprotocol string, fromCookie bool) {
+
+	if auth := req.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:]), "", false
+	}
+
+	if cookieName != "" {
+		if cookie, err := req.Cookie(cookieName); err == nil && cookie.Value != "" {
+			return cookie.Value, "", true
+		}
+	}
+
+	if protocolPrefix == "" {
+		return "", "", false
+	}
+
+	var tokenProtocol string
+	for _, p := range websocket.Subprotocols(req) {
+
+		if strings.HasPrefix(p, protocolPrefix) {
+			if tokenProtocol == "" {
+				tokenProtocol = p
+			}
+			continue
+		}
+
+		if subprotocol == "" {
+			subprotocol = p
+		}
+	}
+
+	if tokenProtocol == "" {
+		return "", "", false
+	}
+
+	if subprotocol == "" {
+		subprotocol = tokenProtocol
+	}
+
+	return strings.TrimPrefix(tokenProtocol, protocolPrefix), subprotocol, false
+}
@@ -23,6 +23,7 @@ import (
 
 	opentracing "github.com/opentracing/opentracing-go"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut/internal/cidrs"
 	"go.aporeto.io/elemental"
 	testmodel "go.aporeto.io/elemental/test/model"
 	"go.aporeto.io/wsc"
@@ -44,7 +45,7 @@ func TestWSPushSession_newPushSession(t *testing.T) {
 		s := newWSPushSession(req, conf, unregister, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
 
 		Convey("Then it should be correctly initialized", func() {
-			So(s.events, ShouldHaveSameTypeAs, make(chan *elemental.Event))
+			So(s.events, ShouldHaveSameTypeAs, make(chan sessionEvent))
 			So(s.filters, ShouldHaveSameTypeAs, make(chan *elemental.PushFilter))
 			So(s.claims, ShouldResemble, []string{})
 			So(s.claimsMap, ShouldResemble, map[string]string{})
@@ -62,6 +63,84 @@ func TestWSPushSession_newPushSession(t *testing.T) {
 	})
 }
 
+func TestWSPushSession_Token(t *testing.T) {
+
+	Convey("Given I have a push session with a token parameter and a header", t, func() {
+
+		u, _ := url.Parse("http://toto.com?token=from-query")
+		req := &http.Request{
+			Header: http.Header{"Authorization": {"Bearer from-header"}},
+			URL:    u,
+		}
+		s := newWSPushSession(req, config{}, nil, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
+
+		Convey("Then Token should return the query parameter", func() {
+			So(s.Token(), ShouldEqual, "from-query")
+		})
+	})
+
+	Convey("Given I have a push session with a token subprotocol", t, func() {
+
+		u, _ := url.Parse("http://toto.com")
+		req := &http.Request{
+			Header: http.Header{"Sec-Websocket-Protocol": {"bahamut, token.from-protocol"}},
+			URL:    u,
+		}
+		cfg := config{}
+		cfg.pushServer.tokenProtocol = "token."
+		s := newWSPushSession(req, cfg, nil, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
+
+		Convey("Then Token should return the token from the subprotocol", func() {
+			So(s.Token(), ShouldEqual, "from-protocol")
+			So(s.subprotocol, ShouldEqual, "bahamut")
+		})
+	})
+}
+
+func Test_tokenFromRequest(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		header          http.Header
+		cookie          string
+		prefix          string
+		wantToken       string
+		wantSubprotocol string
+		wantFromCookie  bool
+	}{
+		{"nothing", http.Header{}, "", "", "", "", false},
+		{"authorization", http.Header{"Authorization": {"Bearer abc"}}, "", "", "abc", "", false},
+		{"authorization lowercase", http.Header{"Authorization": {"bearer abc"}}, "", "", "abc", "", false},
+		{"authorization basic", http.Header{"Authorization": {"Basic abc"}}, "", "", "", "", false},
+		{"cookie", http.Header{"Cookie": {"other=x; session=abc"}}, "session", "", "abc", "", true},
+		{"cookie not configured", http.Header{"Cookie": {"session=abc"}}, "", "", "", "", false},
+		{"authorization before cookie", http.Header{"Authorization": {"Bearer abc"}, "Cookie": {"session=def"}}, "session", "", "abc", "", false},
+		{"subprotocol", http.Header{"Sec-Websocket-Protocol": {"token.abc, bahamut"}}, "", "token.", "abc", "bahamut", false},
+		{"subprotocol alone", http.Header{"Sec-Websocket-Protocol": {"token.abc"}}, "", "token.", "abc", "token.abc", false},
+		{"subprotocol not configured", http.Header{"Sec-Websocket-Protocol": {"token.abc, bahamut"}}, "", "", "", "", false},
+		{"subprotocol without token", http.Header{"Sec-Websocket-Protocol": {"bahamut"}}, "", "token.", "", "", false},
+		{"cookie before subprotocol", http.Header{"Cookie": {"session=def"}, "Sec-Websocket-Protocol": {"token.abc"}}, "session", "token.", "def", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := &http.Request{Header: tt.header}
+
+			token, subprotocol, fromCookie := tokenFromRequest(req, tt.cookie, tt.prefix)
+			if token != tt.wantToken {
+				t.Errorf("tokenFromRequest() token = %v, want %v", token, tt.wantToken)
+			}
+			if subprotocol != tt.wantSubprotocol {
+				t.Errorf("tokenFromRequest() subprotocol = %v, want %v", subprotocol, tt.wantSubprotocol)
+			}
+			if fromCookie != tt.wantFromCookie {
+				t.Errorf("tokenFromRequest() fromCookie = %v, want %v", fromCookie, tt.wantFromCookie)
+			}
+		})
+	}
+}
+
 func TestWSPushSession_DirectPush(t *testing.T) {
 
 	Convey("Given I have a session and an event", t, func() {
@@ -79,10 +158,10 @@ func TestWSPushSession_DirectPush(t *testing.T) {
 			evt2 := <-s.events
 
 			Convey("Then evt1 should be correct", func() {
-				So(evt1, ShouldEqual, evt)
+				So(evt1.event, ShouldEqual, evt)
 			})
 			Convey("Then evt2 should be correct", func() {
-				So(evt2, ShouldEqual, evt)
+				So(evt2.event, ShouldEqual, evt)
 			})
 		})
 	})
@@ -236,6 +315,28 @@ func TestWSPushSession_accessors(t *testing.T) {
 			})
 		})
 
+		Convey("When I call ClientIP() from an untrusted address", func() {
+
+			s.setRemoteAddress("203.0.113.4:1234")
+			s.headers = http.Header{"X-Forwarded-For": []string{"1.2.3.4"}}
+			s.cfg.restServer.trustedProxies, _ = cidrs.Parse([]string{"10.0.0.0/8"})
+
+			Convey("Then the remote address should be used", func() {
+				So(s.ClientIP(), ShouldEqual, "203.0.113.4")
+			})
+		})
+
+		Convey("When I call ClientIP() from a trusted proxy", func() {
+
+			s.setRemoteAddress("10.0.0.1:1234")
+			s.headers = http.Header{"X-Forwarded-For": []string{"1.2.3.4"}}
+			s.cfg.restServer.trustedProxies, _ = cidrs.Parse([]string{"10.0.0.0/8"})
+
+			Convey("Then the forwarded address should be used", func() {
+				So(s.ClientIP(), ShouldEqual, "1.2.3.4")
+			})
+		})
+
 		Convey("When I call setTLSConnectionState()", func() {
 
 			tcs := &tls.ConnectionState{}
@@ -300,6 +401,53 @@ func TestWSPushSession_listen(t *testing.T) {
 			})
 		})
 
+		Convey("When I simulate an incoming event caused by a request", func() {
+
+			go s.listen()
+			s.pushEvent(testEvent, "rid", nil, pushSpan{})
+
+			var data []byte
+			select {
+			case data = <-conn.LastWrite():
+			case <-ctx.Done():
+				panic("test: did not receive data in time")
+			}
+
+			Convey("Then the websocket should send the event with the request ID", func() {
+				r, _ := elemental.Encode(elemental.EncodingTypeMSGPACK, &pushedEvent{Event: testEvent, RequestID: "rid"})
+				So(data, ShouldResemble, r)
+
+				evt := &elemental.Event{}
+				So(elemental.Decode(elemental.EncodingTypeMSGPACK, data, evt), ShouldBeNil)
+				So(evt.Identity, ShouldEqual, testEvent.Identity)
+
+				m := map[string]interface{}{}
+				So(elemental.Decode(elemental.EncodingTypeMSGPACK, data, &m), ShouldBeNil)
+				So(m["requestID"], ShouldEqual, "rid")
+			})
+		})
+
+		Convey("When I simulate an incoming event with attribute changes", func() {
+
+			go s.listen()
+			s.pushEvent(testEvent, "", AttributeChanges{{Attribute: "name", Previous: "a", Current: "b"}}, pushSpan{})
+
+			var data []byte
+			select {
+			case data = <-conn.LastWrite():
+			case <-ctx.Done():
+				panic("test: did not receive data in time")
+			}
+
+			Convey("Then the websocket should send the event with the changes", func() {
+				m := map[string]interface{}{}
+				So(elemental.Decode(elemental.EncodingTypeMSGPACK, data, &m), ShouldBeNil)
+				So(m["requestID"], ShouldBeNil)
+				So(m["changes"], ShouldHaveLength, 1)
+				So(m["changes"].([]interface{})[0].(map[string]interface{})["attribute"], ShouldEqual, "name")
+			})
+		})
+
 		Convey("When I simulate an incoming event that is manually filtered out", func() {
 
 			go s.listen()
@@ -14,6 +14,8 @@ package bahamut
 import (
 	"context"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,6 +33,8 @@ type pushServer struct {
 	processorFinder processorFinderFunc
 	sessionsLock    sync.RWMutex
 	mainContext     context.Context
+	accessLogger    *accessLogger
+	tracer          *pushTracer
 }
 
 func newPushServer(cfg config, multiplexer *bone.Mux, processorFinder processorFinderFunc) *pushServer {
@@ -41,6 +45,8 @@ func newPushServer(cfg config, multiplexer *bone.Mux, processorFinder processorF
 		cfg:             cfg,
 		sessionsLock:    sync.RWMutex{},
 		processorFinder: processorFinder,
+		accessLogger:    newAccessLogger(cfg),
+		tracer:          newPushTracer(cfg),
 	}
 
 	endpoint := cfg.pushServer.endpoint
@@ -52,7 +58,7 @@ func newPushServer(cfg config, multiplexer *bone.Mux, processorFinder processorF
 	// the websocket routes.
 	if cfg.pushServer.enabled && cfg.pushServer.dispatchEnabled {
 		srv.multiplexer.Get(endpoint, http.HandlerFunc(srv.handleRequest))
-		zap.L().Debug("Websocket push handlers installed")
+		cfg.logger().Debug("Websocket push handlers installed")
 	}
 
 	return srv
@@ -142,7 +148,7 @@ func (n *pushServer) initPushSession(session *wsPushSession) error {
 	return nil
 }
 
-func (n *pushServer) pushEvents(events ...*elemental.Event) {
+func (n *pushServer) pushEvents(ctx context.Context, events ...*elemental.Event) {
 
 	// If we don't have a service or publication is explicitly disabled, we do nothing.
 	if n.cfg.pushServer.service == nil || !n.cfg.pushServer.enabled {
@@ -157,7 +163,7 @@ func (n *pushServer) pushEvents(events ...*elemental.Event) {
 			var ok bool
 			ok, err = n.cfg.pushServer.publishHandler.ShouldPublish(event)
 			if err != nil {
-				zap.L().Error("Error while calling ShouldPublish", zap.Error(err))
+				n.cfg.contextLogger(ctx).Error("Error while calling ShouldPublish", zap.Error(err))
 				continue
 			}
 
@@ -167,24 +173,44 @@ func (n *pushServer) pushEvents(events ...*elemental.Event) {
 		}
 
 		publication := NewPublication(n.cfg.pushServer.topic)
+		publication.RequestID = RequestIDFromContext(ctx)
+		if n.cfg.pushServer.changesEnabled {
+			publication.Changes = n.cfg.redaction.redactor.redactChanges(attributeChangesFromContext(ctx))
+		}
+
+		// The event is encoded before the span is started, so the
+		// publication does not log the payload before it is redacted.
 		if err = publication.Encode(event); err != nil {
-			zap.L().Error("Unable to encode event", zap.Error(err))
+			n.cfg.contextLogger(ctx).Error("Unable to encode event", zap.Error(err))
 			break
 		}
 
-		for i := 0; i < 3; i++ {
+		span := n.tracer.startPublish(ctx, publication, event)
+		n.tracer.logPayload(span, publication, event)
+
+		var attempts int
+		for attempts < 3 {
+			attempts++
 			err = n.cfg.pushServer.service.Publish(publication)
 			if err != nil {
-				zap.L().Warn("Unable to publish event", zap.String("topic", publication.Topic), zap.Stringer("event", event), zap.Error(err))
+				n.cfg.contextLogger(ctx).Warn("Unable to publish event", zap.String("topic", publication.Topic), zap.Stringer("event", event), zap.Error(err))
 				continue
 			}
 			break
 		}
+
+		span.setTag("publish.attempts", attempts)
+		if err != nil {
+			span.setError(err)
+		}
+		finishSpan(ctx, span.finishAt)
 	}
 }
 
 func (n *pushServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 
+	start := time.Now()
+
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
@@ -193,42 +219,102 @@ func (n *pushServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	readEncodingType, writeEncodingType, err := elemental.EncodingFromHeaders(r.Header)
 	if err != nil {
-		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err))
+		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err), n.cfg.logger())
 	}
 
 	session := newWSPushSession(r, n.cfg, n.unregisterSession, readEncodingType, writeEncodingType)
 	session.setTLSConnectionState(r.TLS)
 	session.setRemoteAddress(r.RemoteAddr)
 
+	// The cookie is sent by browsers with the requests of
+	// any page, so it is only used by the allowed origins.
+	if session.tokenFromCookie && !n.originAllowed(r) {
+		err := elemental.NewError("Forbidden", "Origin is not allowed to use the token cookie", "bahamut", http.StatusForbidden)
+		code := writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err), session.logger)
+		n.logAccess(r, session, start, code)
+		return
+	}
+
 	if err := n.authSession(session); err != nil {
-		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err))
+		code := writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err), session.logger)
+		n.logAccess(r, session, start, code)
 		return
 	}
 
 	if err := n.initPushSession(session); err != nil {
-		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err))
+		code := writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err), session.logger)
+		n.logAccess(r, session, start, code)
 		return
 	}
 
-	ws, err := upgrader.Upgrade(w, r, nil)
+	var responseHeader http.Header
+	if session.subprotocol != "" {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": []string{session.subprotocol}}
+	}
+
+	ws, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
-		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err))
+		code := writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err), session.logger)
+		n.logAccess(r, session, start, code)
 		return
 	}
 
 	conn, err := wsc.Accept(r.Context(), ws, wsc.Config{WriteChanSize: 1024, ReadChanSize: 512})
 	if err != nil {
-		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err))
+		code := writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err), session.logger)
+		n.logAccess(r, session, start, code)
 		return
 	}
 
 	session.setConn(conn)
 
+	// The upgrade is logged now, as the session
+	// may stay open for as long as the server runs.
+	n.logAccess(r, session, start, http.StatusSwitchingProtocols)
+
 	n.registerSession(session)
 
 	session.listen()
 }
 
+// originAllowed returns true if the given request has no Origin header,
+// or if it matches the host of the request or one of the allowed origins.
+func (n *pushServer) originAllowed(r *http.Request) bool {
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	for _, o := range n.cfg.pushServer.allowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// logAccess writes the access log entry of the given push session request.
+func (n *pushServer) logAccess(r *http.Request, session *wsPushSession, start time.Time, code int) {
+
+	if n.accessLogger == nil {
+		return
+	}
+
+	entry := newAccessLogEntry(r, start, time.Now(), code, 0)
+	entry.ClientIP = session.ClientIP()
+	entry.RequestID = session.requestID
+	entry.Subject = session.claimsMap[subjectClaimKey]
+
+	n.accessLogger.log(entry)
+}
+
 func (n *pushServer) start(ctx context.Context) {
 
 	// If dispatching of events is disabled, we sit here
@@ -247,7 +333,7 @@ func (n *pushServer) start(ctx context.Context) {
 		defer unsubscribe()
 	}
 
-	zap.L().Debug("Websocket server started",
+	n.cfg.logger().Debug("Websocket server started",
 		zap.Bool("push-enabled", n.cfg.pushServer.enabled),
 		zap.Bool("push-dispatching-enabled", n.cfg.pushServer.dispatchEnabled),
 		zap.Bool("push-publish-enabled", n.cfg.pushServer.publishEnabled),
@@ -260,12 +346,20 @@ func (n *pushServer) start(ctx context.Context) {
 
 			go func(publication *Publication) {
 
+				span := n.tracer.startReceive(publication)
+				defer span.finish()
+
+				// The data is decoded directly, so the publication
+				// does not log the payload before it is redacted.
 				event := &elemental.Event{}
-				if err := publication.Decode(event); err != nil {
-					zap.L().Error("Unable to decode event", zap.Error(err))
+				if err := elemental.Decode(publication.Encoding, publication.Data, event); err != nil {
+					n.cfg.logger().Error("Unable to decode event", zap.Error(err))
+					span.setError(err)
 					return
 				}
 
+				n.tracer.logPayload(span, publication, event)
+
 				// Keep a references to all current ready push sessions as it may change at any time, we lost 8h on this one...
 				n.sessionsLock.RLock()
 				sessions := make([]PushSession, len(n.sessions))
@@ -276,27 +370,40 @@ func (n *pushServer) start(ctx context.Context) {
 				}
 				n.sessionsLock.RUnlock()
 
+				span.setTag("push.sessions", len(sessions))
+
 				// Dispatch the event to all sessions
 				for _, session := range sessions {
 
-					go func(s PushSession, evt *elemental.Event) {
+					go func(s PushSession, evt *elemental.Event, dspan pushSpan) {
 
 						if n.cfg.pushServer.dispatchHandler != nil {
 
 							ok, err := n.cfg.pushServer.dispatchHandler.ShouldDispatch(s, evt)
 							if err != nil {
-								zap.L().Error("Error while calling SessionsHandler ShouldPush", zap.Error(err))
+								n.cfg.logger().Error("Error while calling SessionsHandler ShouldPush", zap.Error(err))
+								dspan.setError(err)
+								dspan.finish()
 								return
 							}
 
+							dspan.setTag("dispatch.should_dispatch", ok)
+
 							if !ok {
+								dspan.finish()
 								return
 							}
 						}
 
+						if ws, ok := s.(*wsPushSession); ok {
+							ws.pushEvent(evt, publication.RequestID, publication.Changes, dspan)
+							return
+						}
+
 						s.DirectPush(evt)
+						dspan.finish()
 
-					}(session, event.Duplicate())
+					}(session, event.Duplicate(), n.tracer.startDispatch(span, session))
 				}
 			}(p)
 
@@ -321,5 +428,5 @@ func (n *pushServer) stop() {
 		time.Sleep(10 * time.Millisecond)
 	}
 
-	zap.L().Info("Push server stopped")
+	n.cfg.logger().Info("Push server stopped")
 }
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/go-zoo/bone"
+	"github.com/gorilla/websocket"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
 	testmodel "go.aporeto.io/elemental/test/model"
@@ -398,7 +399,7 @@ func TestWebsocketServer_pushEvents(t *testing.T) {
 			cfg := config{}
 
 			wss := newPushServer(cfg, mux, pf)
-			wss.pushEvents(nil)
+			wss.pushEvents(context.Background(), nil)
 
 			Convey("Then nothing special should happen", func() {})
 		})
@@ -415,7 +416,7 @@ func TestWebsocketServer_pushEvents(t *testing.T) {
 
 			wss := newPushServer(cfg, mux, pf)
 			evtin := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
-			wss.pushEvents(evtin)
+			wss.pushEvents(context.Background(), evtin)
 
 			Convey("Then I should find one publication", func() {
 				evtout := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
@@ -423,6 +424,69 @@ func TestWebsocketServer_pushEvents(t *testing.T) {
 				r, _ := elemental.Encode(elemental.EncodingTypeMSGPACK, evtout)
 				So(len(srv.publications), ShouldEqual, 1)
 				So(string(srv.publications[0].Data), ShouldResemble, string(r))
+				So(srv.publications[0].RequestID, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I call pushEvents with the context of a request", func() {
+
+			srv := &mockPubSubServer{}
+
+			cfg := config{}
+			cfg.pushServer.service = srv
+			cfg.pushServer.enabled = true
+			cfg.pushServer.publishEnabled = true
+			cfg.pushServer.dispatchEnabled = true
+
+			wss := newPushServer(cfg, mux, pf)
+			wss.pushEvents(contextWithRequestID(context.Background(), "rid"), elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
+
+			Convey("Then the publication should carry the request ID", func() {
+				So(len(srv.publications), ShouldEqual, 1)
+				So(srv.publications[0].RequestID, ShouldEqual, "rid")
+			})
+		})
+
+		Convey("When I call pushEvents with attribute changes", func() {
+
+			changes := AttributeChanges{
+				{Attribute: "name", Previous: "a", Current: "b"},
+				{Attribute: "token", Previous: "x", Current: "y"},
+			}
+
+			srv := &mockPubSubServer{}
+
+			cfg := config{}
+			cfg.pushServer.service = srv
+			cfg.pushServer.enabled = true
+			cfg.redaction.redactor = newRedactor(nil, []string{"token"})
+
+			ctx := contextWithAttributeChanges(context.Background(), changes)
+
+			Convey("When the attribute changes are not enabled", func() {
+
+				wss := newPushServer(cfg, mux, pf)
+				wss.pushEvents(ctx, elemental.NewEvent(elemental.EventUpdate, testmodel.NewList()))
+
+				Convey("Then the publication should not carry them", func() {
+					So(len(srv.publications), ShouldEqual, 1)
+					So(srv.publications[0].Changes, ShouldBeNil)
+				})
+			})
+
+			Convey("When the attribute changes are enabled", func() {
+
+				OptPushAttributeChanges()(&cfg)
+				wss := newPushServer(cfg, mux, pf)
+				wss.pushEvents(ctx, elemental.NewEvent(elemental.EventUpdate, testmodel.NewList()))
+
+				Convey("Then the publication should carry the redacted changes", func() {
+					So(len(srv.publications), ShouldEqual, 1)
+					So(srv.publications[0].Changes, ShouldResemble, AttributeChanges{
+						{Attribute: "name", Previous: "a", Current: "b"},
+						{Attribute: "token", Redacted: true},
+					})
+				})
 			})
 		})
 
@@ -441,7 +505,7 @@ func TestWebsocketServer_pushEvents(t *testing.T) {
 
 			wss := newPushServer(cfg, mux, pf)
 			evtin := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
-			wss.pushEvents(evtin)
+			wss.pushEvents(context.Background(), evtin)
 
 			Convey("Then I should find one publication", func() {
 				evtout := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
@@ -466,7 +530,7 @@ func TestWebsocketServer_pushEvents(t *testing.T) {
 			cfg.pushServer.publishHandler = h
 
 			wss := newPushServer(cfg, mux, pf)
-			wss.pushEvents(elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
+			wss.pushEvents(context.Background(), elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
 
 			Convey("Then I should find one publication", func() {
 				So(len(srv.publications), ShouldEqual, 0)
@@ -488,7 +552,7 @@ func TestWebsocketServer_pushEvents(t *testing.T) {
 			cfg.pushServer.publishHandler = h
 
 			wss := newPushServer(cfg, mux, pf)
-			wss.pushEvents(elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
+			wss.pushEvents(context.Background(), elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
 
 			Convey("Then I should find one publication", func() {
 				So(len(srv.publications), ShouldEqual, 0)
@@ -848,3 +912,93 @@ func TestWebsocketServer_handleRequest(t *testing.T) {
 		})
 	})
 }
+
+func TestWebsocketServer_handleRequestWithCookie(t *testing.T) {
+
+	Convey("Given I have a webserver reading the token from a cookie", t, func() {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		pf := func(identity elemental.Identity) (Processor, error) {
+			return struct{}{}, nil
+		}
+
+		pushHandler := &mockSessionHandler{onPushSessionInitOK: true}
+		authenticator := &mockSessionAuthenticator{action: AuthActionOK}
+
+		cfg := config{}
+		cfg.pushServer.dispatchHandler = pushHandler
+		cfg.pushServer.enabled = true
+		cfg.pushServer.dispatchEnabled = true
+		cfg.security.sessionAuthenticators = []SessionAuthenticator{authenticator}
+		OptPushTokenCookie("session")(&cfg)
+		OptPushAllowedOrigins("https://app.example.com")(&cfg)
+
+		wss := newPushServer(cfg, bone.New(), pf)
+		wss.mainContext = ctx
+
+		ts := httptest.NewServer(http.HandlerFunc(wss.handleRequest))
+		defer ts.Close()
+
+		wsURL := strings.Replace(ts.URL, "http://", "ws://", 1)
+
+		connect := func(origin string, cookie string) (*http.Response, error) {
+
+			header := http.Header{}
+			if origin != "" {
+				header.Set("Origin", origin)
+			}
+			if cookie != "" {
+				header.Set("Cookie", cookie)
+			}
+
+			ws, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+			if ws != nil {
+				ws.Close() // nolint: errcheck
+			}
+
+			return resp, err
+		}
+
+		Convey("When I connect with the cookie from another origin", func() {
+
+			resp, err := connect("https://evil.example.com", "session=abc")
+
+			Convey("Then the upgrade should be refused", func() {
+				So(err, ShouldNotBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("When I connect with the cookie from the same origin", func() {
+
+			resp, err := connect(ts.URL, "session=abc")
+
+			Convey("Then the upgrade should succeed", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			})
+		})
+
+		Convey("When I connect with the cookie from an allowed origin", func() {
+
+			resp, err := connect("https://app.example.com", "session=abc")
+
+			Convey("Then the upgrade should succeed", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			})
+		})
+
+		Convey("When I connect without the cookie from another origin", func() {
+
+			resp, err := connect("https://evil.example.com", "")
+
+			Convey("Then the upgrade should succeed", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			})
+		})
+	})
+}